@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/crucial707/hci-asset/internal/config"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // TestAPI_LoginThenListAssets is an integration test: it builds the full router with a
@@ -24,12 +29,36 @@ func TestAPI_LoginThenListAssets(t *testing.T) {
 	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
 		WithArgs("integration").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "integration", nil, "viewer"))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM service_accounts`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM user_identities`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(1, "integration", "password", "success", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	// GET /assets: List(10, 0) then Count()
-	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\) FROM assets ORDER BY id LIMIT \$1 OFFSET \$2`).
+	// GET /assets: session check, then List(10, 0) and Count()
+	mock.ExpectQuery(`SELECT role, tokens_valid_after, EXISTS \(SELECT 1 FROM revoked_tokens`).
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "tokens_valid_after", "exists"}).AddRow("viewer", time.Unix(0, 0), false))
+	mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+		WithArgs("viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+	mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+		WithArgs(sqlmock.AnyArg(), "viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
 		WithArgs(10, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name"}).
-			AddRow(1, "asset1", "desc1", "{}", nil, ""))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "asset1", "desc1", "{}", nil, "", "active", "", nil, nil))
 	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM assets`).
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
@@ -88,6 +117,489 @@ func TestAPI_LoginThenListAssets(t *testing.T) {
 	}
 }
 
+// TestAPI_APIKeyScopes authenticates with a read-only API key: listing assets works, creating one is forbidden.
+func TestAPI_APIKeyScopes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	const rawKey = "hci_abcd1234_c2VjcmV0"
+	sum := sha256.Sum256([]byte(rawKey))
+	expectVerify := func() {
+		mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+			WithArgs("abcd1234").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+				AddRow(3, 9, "ci-bot", "admin", hex.EncodeToString(sum[:]), "{read}", "{}", nil, nil))
+		mock.ExpectExec(`UPDATE api_keys SET last_used_at = NOW\(\) WHERE id = \$1`).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+			WithArgs("admin").
+			WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{*}"))
+		mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+			WithArgs(sqlmock.AnyArg(), "admin").
+			WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	}
+
+	expectVerify()
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
+		WithArgs(10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM assets`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectVerify()
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/assets", nil)
+	req.Header.Set("Authorization", "Bearer "+rawKey)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("assets request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /assets with api key: got %d, want 200", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("POST", srv.URL+"/v1/assets", bytes.NewReader([]byte(`{"name":"x"}`)))
+	req.Header.Set("Authorization", "Bearer "+rawKey)
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("POST /assets with read-only key: got %d, want 403", resp.StatusCode)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_CustomRolePermissions checks that a custom role can run scans but not manage users.
+func TestAPI_CustomRolePermissions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	const rawKey = "hci_abcd1234_c2VjcmV0"
+	sum := sha256.Sum256([]byte(rawKey))
+	expectVerify := func() {
+		mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+			WithArgs("abcd1234").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+				AddRow(3, 9, "netops-bot", "netops", hex.EncodeToString(sum[:]), "{*}", "{}", nil, nil))
+		mock.ExpectExec(`UPDATE api_keys SET last_used_at = NOW\(\) WHERE id = \$1`).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+			WithArgs("netops").
+			WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{scans:run}"))
+		mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+			WithArgs(sqlmock.AnyArg(), "netops").
+			WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	}
+	expectVerify()
+	expectVerify()
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer "+rawKey)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Allowed by scans:run; the job does not exist, so the handler answers 404.
+	if code := do("POST", "/v1/scans/missing/cancel", ""); code != http.StatusNotFound {
+		t.Errorf("POST /scans/{id}/cancel: got %d, want 404", code)
+	}
+	if code := do("POST", "/v1/users", `{"username":"mallory","password":"correct horse battery"}`); code != http.StatusForbidden {
+		t.Errorf("POST /users without users:manage: got %d, want 403", code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_ViewerManagesOwnAccount checks that an account without users:manage can change its own password and
+// sign itself out everywhere, but not touch another account.
+func TestAPI_ViewerManagesOwnAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	const rawKey = "hci_abcd1234_c2VjcmV0"
+	sum := sha256.Sum256([]byte(rawKey))
+	expectVerify := func() {
+		mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+			WithArgs("abcd1234").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+				AddRow(3, 9, "alice", "viewer", hex.EncodeToString(sum[:]), "{*}", "{}", nil, nil))
+		mock.ExpectExec(`UPDATE api_keys SET last_used_at = NOW\(\) WHERE id = \$1`).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+			WithArgs("viewer").
+			WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+		mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+			WithArgs(sqlmock.AnyArg(), "viewer").
+			WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	}
+	expectRevokeAll := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE users SET tokens_valid_after`).WithArgs(9, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = NOW\(\) WHERE user_id = \$1`).WithArgs(9).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("old password here"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	userRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(9, "alice", string(hash), "viewer")
+	}
+
+	// PUT /users/9/password
+	expectVerify()
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(9).WillReturnRows(userRow())
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1 WHERE id = \$2`).WithArgs(sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRevokeAll()
+	// POST /users/9/sessions/revoke
+	expectVerify()
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(9).WillReturnRows(userRow())
+	expectRevokeAll()
+	// POST /users/10/sessions/revoke
+	expectVerify()
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer "+rawKey)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	body := `{"current_password":"old password here","new_password":"correct horse battery staple"}`
+	if code := do("PUT", "/v1/users/9/password", body); code != http.StatusNoContent {
+		t.Errorf("PUT own password: got %d, want 204", code)
+	}
+	if code := do("POST", "/v1/users/9/sessions/revoke", ""); code != http.StatusNoContent {
+		t.Errorf("POST own sessions/revoke: got %d, want 204", code)
+	}
+	if code := do("POST", "/v1/users/10/sessions/revoke", ""); code != http.StatusForbidden {
+		t.Errorf("POST another user's sessions/revoke: got %d, want 403", code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_AccessPolicyScopesAssets checks that an account matched by an access policy only lists assets
+// carrying the policy's tags.
+func TestAPI_AccessPolicyScopesAssets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	const rawKey = "hci_abcd1234_c2VjcmV0"
+	sum := sha256.Sum256([]byte(rawKey))
+	mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+		WithArgs("abcd1234").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+			AddRow(3, 9, "team-a-bot", "viewer", hex.EncodeToString(sum[:]), "{read}", "{}", nil, nil))
+	mock.ExpectExec(`UPDATE api_keys SET last_used_at = NOW\(\) WHERE id = \$1`).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+		WithArgs("viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+	mock.ExpectQuery(`SELECT tags, site_id FROM access_policies WHERE user_id = \$1 OR role = \$2`).
+		WithArgs(9, "viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}).AddRow("{team-a}", nil))
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE tags && \$1 AND deleted_at IS NULL ORDER BY id LIMIT \$2 OFFSET \$3`).
+		WithArgs("{\"team-a\"}", 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "web-a", "", "{team-a}", nil, "", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM assets WHERE tags && \$1`).
+		WithArgs("{\"team-a\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/assets", nil)
+	req.Header.Set("Authorization", "Bearer "+rawKey)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("assets request: %v", err)
+	}
+	var list struct {
+		Total int `json:"total"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || list.Total != 1 {
+		t.Fatalf("GET /assets: got %d (total %d), want 200 with 1 asset", resp.StatusCode, list.Total)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_SDTokenFollowsViewerPolicies checks that the static Prometheus SD token is restricted by the viewer
+// role's access policies.
+func TestAPI_SDTokenFollowsViewerPolicies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+		WithArgs("viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+	mock.ExpectQuery(`SELECT tags, site_id FROM access_policies WHERE user_id = \$1 OR role = \$2`).
+		WithArgs(0, "viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}).AddRow("{team-a}", nil))
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE tags && \$1 AND deleted_at IS NULL ORDER BY id LIMIT \$2 OFFSET \$3`).
+		WithArgs("{\"team-a\"}", 10000, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "web-a", "", "{team-a}", nil, "10.0.0.5", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT .+ FROM subnets`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "cidr", "vlan_id", "gateway", "description", "created_at", "updated_at"}))
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap", PrometheusSDToken: "sd-secret"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/sd/prometheus", nil)
+	req.Header.Set("Authorization", "Bearer sd-secret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("sd request: %v", err)
+	}
+	var groups []struct {
+		Targets []string `json:"targets"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&groups)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(groups) != 1 {
+		t.Fatalf("GET /sd/prometheus: got %d (%d groups), want 200 with 1 group", resp.StatusCode, len(groups))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_SiteSelection checks that /v1/sites/{site}/... and X-Site limit a request to one site and that
+// unknown sites are rejected.
+func TestAPI_SiteSelection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	const rawKey = "hci_abcd1234_c2VjcmV0"
+	sum := sha256.Sum256([]byte(rawKey))
+	expectKey := func() {
+		mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+			WithArgs("abcd1234").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+				AddRow(3, 9, "inventory-bot", "viewer", hex.EncodeToString(sum[:]), "{read}", "{}", nil, nil))
+		mock.ExpectExec(`UPDATE api_keys SET last_used_at = NOW\(\) WHERE id = \$1`).
+			WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT permissions FROM roles WHERE name = \$1`).
+			WithArgs("viewer").
+			WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+		mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+			WithArgs(9, "viewer").
+			WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	}
+
+	// GET /v1/sites/lab-east/assets
+	expectKey()
+	mock.ExpectQuery(`SELECT id FROM sites WHERE name = \$1`).
+		WithArgs("lab-east").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(`FROM assets WHERE site_id = \$1 AND deleted_at IS NULL ORDER BY id LIMIT \$2 OFFSET \$3`).
+		WithArgs(2, 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(5, "pve-east-1", "", "{}", nil, "10.0.0.5", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM assets WHERE site_id = \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	// GET /v1/assets with X-Site: nowhere
+	expectKey()
+	mock.ExpectQuery(`SELECT id FROM sites`).
+		WithArgs("nowhere").
+		WillReturnError(sql.ErrNoRows)
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	do := func(path, site string) int {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+rawKey)
+		if site != "" {
+			req.Header.Set("X-Site", site)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := do("/v1/sites/lab-east/assets", ""); code != http.StatusOK {
+		t.Errorf("GET /sites/lab-east/assets: got %d, want 200", code)
+	}
+	if code := do("/v1/assets", "nowhere"); code != http.StatusNotFound {
+		t.Errorf("GET /assets with unknown X-Site: got %d, want 404", code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// TestAPI_LogoutRevokesToken logs in, logs out, and checks that the access token is then rejected.
+func TestAPI_LogoutRevokesToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(2, "alice", nil, "viewer"))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM service_accounts`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM user_identities`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(2, "alice", "password", "success", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// POST /auth/logout
+	mock.ExpectQuery(`SELECT role, tokens_valid_after`).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "tokens_valid_after", "exists"}).AddRow("viewer", time.Unix(0, 0), false))
+	mock.ExpectQuery(`SELECT permissions FROM roles`).
+		WithArgs("viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"permissions"}).AddRow("{audit:read}"))
+	mock.ExpectQuery(`SELECT tags, site_id FROM access_policies`).
+		WithArgs(sqlmock.AnyArg(), "viewer").
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "site_id"}))
+	mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = NOW\(\) WHERE revoked_at IS NULL AND family_id`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// GET /me with the logged-out token: jti is now denylisted
+	mock.ExpectQuery(`SELECT role, tokens_valid_after`).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "tokens_valid_after", "exists"}).AddRow("viewer", time.Unix(0, 0), true))
+
+	cfg := config.Config{JWTSecret: "x", NmapPath: "nmap"}
+	r, _, _ := newRouter(db, cfg)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	loginResp, err := http.Post(srv.URL+"/v1/auth/login", "application/json", bytes.NewReader([]byte(`{"username":"alice"}`)))
+	if err != nil {
+		t.Fatalf("login request: %v", err)
+	}
+	var tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = json.NewDecoder(loginResp.Body).Decode(&tokens)
+	loginResp.Body.Close()
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("expected access and refresh tokens, got %+v", tokens)
+	}
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": tokens.RefreshToken})
+	req, _ := http.NewRequest("POST", srv.URL+"/v1/auth/logout", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("logout request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout status: got %d, want 204", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", srv.URL+"/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	resp, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("me request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET /me after logout: got %d, want 401", resp.StatusCode)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
 // TestAPI_Health is a quick smoke test for the health endpoint.
 func TestAPI_Health(t *testing.T) {
 	db, _, err := sqlmock.New()
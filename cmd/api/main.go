@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	_ "embed"
 	"fmt"
@@ -16,11 +18,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "github.com/lib/pq"
 
+	"github.com/crucial707/hci-asset/internal/audit"
+	"github.com/crucial707/hci-asset/internal/auth"
 	"github.com/crucial707/hci-asset/internal/config"
 	"github.com/crucial707/hci-asset/internal/db"
 	"github.com/crucial707/hci-asset/internal/handlers"
 	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/oidc"
 	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/crucial707/hci-asset/internal/retention"
 	"github.com/crucial707/hci-asset/internal/scheduler"
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
@@ -44,6 +51,15 @@ func main() {
 		}
 	}
 
+	if cfg.AuditCheckpointFile != "" && cfg.AuditCheckpointKey == "" {
+		log.Fatal("refusing to start: AUDIT_CHECKPOINT_FILE needs AUDIT_CHECKPOINT_KEY to sign checkpoints")
+	}
+	if cfg.AuditForwardAddr != "" {
+		if err := audit.CheckForwarding(cfg.AuditForwardProtocol, cfg.AuditForwardFormat); err != nil {
+			log.Fatalf("refusing to start: %v", err)
+		}
+	}
+
 	// Structured logging: JSON in production when LOG_FORMAT=json
 	if cfg.LogFormat == "json" {
 		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
@@ -71,8 +87,32 @@ func main() {
 		slog.Info("migrations: up to date")
 	}
 
-	r, scanHandler, scheduleRepo := newRouter(dbConn, cfg)
-	go scheduler.Run(scheduleRepo, func(target string) { scanHandler.StartScanTarget(context.Background(), target) })
+	r, sched, pruner := newRouter(dbConn, cfg)
+	if listener, err := scheduler.NewListener(dsn); err != nil {
+		slog.Warn("schedule change notifications unavailable, edits apply on the next reconcile", "error", err)
+	} else {
+		defer listener.Close()
+		sched.Listener = listener
+	}
+	schedCtx, stopScheduler := context.WithCancel(context.Background())
+	schedDone := make(chan struct{})
+	go func() {
+		sched.Run(schedCtx)
+		close(schedDone)
+	}()
+	go pruneSessions(repo.NewSessionRepo(dbConn))
+	if cfg.AuditCheckpointFile != "" {
+		checkpointer := audit.NewCheckpointer(repo.NewAuditRepo(dbConn), cfg.AuditCheckpointFile, []byte(cfg.AuditCheckpointKey), cfg.AuditCheckpointInterval)
+		go checkpointer.Run(context.Background())
+	}
+	if forwarder := newAuditForwarder(cfg, dbConn); forwarder != nil {
+		slog.Info("forwarding audit entries", "addr", cfg.AuditForwardAddr, "protocol", cfg.AuditForwardProtocol, "format", cfg.AuditForwardFormat)
+		go forwarder.Run(context.Background())
+	}
+	if pruner.Enabled() {
+		slog.Info("retention policies enabled", "interval", cfg.RetentionInterval, "dry_run", cfg.RetentionDryRun)
+		go pruner.Run(context.Background())
+	}
 
 	addr := ":" + cfg.Port
 	srv := &http.Server{Addr: addr, Handler: r}
@@ -100,35 +140,230 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("server shutdown: %v", err)
 	}
+	// Release the scheduler lease so another instance takes over without waiting for it to expire.
+	stopScheduler()
+	<-schedDone
 	slog.Info("API server stopped")
 }
 
+// pruneSessions periodically deletes expired refresh tokens and access token denylist entries.
+func pruneSessions(sessions *repo.SessionRepo) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := sessions.PruneExpired(context.Background()); err != nil {
+			slog.Warn("prune sessions failed", "error", err)
+		} else if n > 0 {
+			slog.Info("pruned expired sessions", "rows", n)
+		}
+	}
+}
+
+// newPruner returns the retention pruner configured by RETENTION_* settings.
+func newPruner(cfg config.Config, dbConn *sql.DB) *retention.Pruner {
+	policies := []models.RetentionPolicy{
+		{DataType: models.RetentionScans, MaxAgeDays: cfg.RetentionScanDays, Keep: cfg.RetentionScanKeep},
+		{DataType: models.RetentionAudit, MaxAgeDays: cfg.RetentionAuditDays},
+		{DataType: models.RetentionLoginEvents, MaxAgeDays: cfg.RetentionLoginEventDays},
+		{DataType: models.RetentionLifecycle, MaxAgeDays: cfg.RetentionLifecycleDays},
+		{DataType: models.RetentionTrash, MaxAgeDays: cfg.RetentionTrashDays},
+	}
+	return retention.NewPruner(repo.NewRetentionRepo(dbConn), repo.NewAuditRepo(dbConn), policies, cfg.RetentionBatchSize,
+		cfg.RetentionArchiveDir, cfg.RetentionDryRun, cfg.RetentionInterval)
+}
+
+// newAuditForwarder returns the syslog forwarder of the audit outbox from AUDIT_FORWARD_* settings, or nil when
+// AUDIT_FORWARD_ADDR is unset.
+func newAuditForwarder(cfg config.Config, dbConn *sql.DB) *audit.Forwarder {
+	if cfg.AuditForwardAddr == "" {
+		return nil
+	}
+	var tlsConfig *tls.Config
+	if cfg.AuditForwardProtocol == "tls" {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.AuditForwardCACert != "" {
+			pem, err := os.ReadFile(cfg.AuditForwardCACert)
+			if err != nil {
+				log.Fatalf("AUDIT_FORWARD_CA_CERT: %v", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				log.Fatalf("AUDIT_FORWARD_CA_CERT: no certificates found in %s", cfg.AuditForwardCACert)
+			}
+		}
+	}
+	return audit.NewForwarder(repo.NewAuditRepo(dbConn), cfg.AuditForwardProtocol, cfg.AuditForwardAddr,
+		cfg.AuditForwardFormat, tlsConfig, cfg.AuditForwardInterval)
+}
+
+// newLDAPProvider returns the directory login provider from LDAP_* settings, or nil when LDAP_URL is unset.
+// The repositories are filled in by the caller.
+func newLDAPProvider(cfg config.Config) *auth.LDAPProvider {
+	if cfg.LDAPURL == "" {
+		return nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.LDAPInsecureSkipVerify}
+	if cfg.LDAPCACert != "" {
+		pem, err := os.ReadFile(cfg.LDAPCACert)
+		if err != nil {
+			log.Fatalf("LDAP_CA_CERT: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("LDAP_CA_CERT: no certificates found in %s", cfg.LDAPCACert)
+		}
+	}
+	return &auth.LDAPProvider{
+		URL:          cfg.LDAPURL,
+		StartTLS:     cfg.LDAPStartTLS,
+		TLSConfig:    tlsConfig,
+		BindDN:       cfg.LDAPBindDN,
+		BindPassword: cfg.LDAPBindPassword,
+		UserBaseDN:   cfg.LDAPUserBaseDN,
+		UserFilter:   cfg.LDAPUserFilter,
+		EmailAttr:    cfg.LDAPEmailAttr,
+		GroupAttr:    cfg.LDAPGroupAttr,
+		GroupBaseDN:  cfg.LDAPGroupBaseDN,
+		GroupFilter:  cfg.LDAPGroupFilter,
+		AdminGroups:  cfg.LDAPAdminGroups,
+		ViewerGroups: cfg.LDAPViewerGroups,
+		AutoCreate:   cfg.LDAPAutoCreate,
+	}
+}
+
+// newPasswordPolicy returns the policy for new passwords from PASSWORD_* settings.
+func newPasswordPolicy(cfg config.Config) *auth.PasswordPolicy {
+	p := &auth.PasswordPolicy{MinLength: cfg.PasswordMinLength}
+	if cfg.PasswordBreachedFile != "" {
+		breached, err := auth.LoadBreachedPasswords(cfg.PasswordBreachedFile)
+		if err != nil {
+			log.Fatalf("PASSWORD_BREACHED_FILE: %v", err)
+		}
+		p.Breached = breached
+	}
+	return p
+}
+
 func serveSwaggerUI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(swaggerUIHTML)
 }
 
 // newRouter builds the HTTP router with handlers and middleware (used by main and tests).
-// Returns the router, ScanHandler (for scheduler), and ScheduleRepo (for scheduler).
-func newRouter(db *sql.DB, cfg config.Config) (*chi.Mux, *handlers.ScanHandler, *repo.ScheduleRepo) {
+// Returns the router, the scan scheduler and the retention pruner (started by main).
+func newRouter(db *sql.DB, cfg config.Config) (*chi.Mux, *scheduler.Scheduler, *retention.Pruner) {
 	assetRepo := repo.NewAssetRepo(db)
 	userRepo := repo.NewUserRepo(db)
 	auditRepo := repo.NewAuditRepo(db)
 	scheduleRepo := repo.NewScheduleRepo(db)
 	scanJobRepo := repo.NewScanJobRepo(db)
 	savedScanRepo := repo.NewSavedScanRepo(db)
+	assetServiceRepo := repo.NewAssetServiceRepo(db)
+	apiKeyRepo := repo.NewAPIKeyRepo(db)
+	serviceAccountRepo := repo.NewServiceAccountRepo(db)
+	sessionRepo := repo.NewSessionRepo(db)
+	identityRepo := repo.NewIdentityRepo(db)
+	mfaRepo := repo.NewMFARepo(db)
+	loginEventRepo := repo.NewLoginEventRepo(db)
+	roleRepo := repo.NewRoleRepo(db)
+	accessPolicyRepo := repo.NewAccessPolicyRepo(db)
+	siteRepo := repo.NewSiteRepo(db)
+	subnetRepo := repo.NewSubnetRepo(db)
+	relationshipRepo := repo.NewRelationshipRepo(db)
+	assetRouteRepo := repo.NewAssetRouteRepo(db)
+	passwordPolicy := newPasswordPolicy(cfg)
 
-	assetHandler := &handlers.AssetHandler{Repo: assetRepo, AuditRepo: auditRepo}
-	networkHandler := &handlers.NetworkHandler{Repo: assetRepo}
-	scanHandler := &handlers.ScanHandler{Repo: assetRepo, ScanJobRepo: scanJobRepo, NmapPath: cfg.NmapPath}
-	savedScanHandler := &handlers.SavedScanHandler{Repo: savedScanRepo, ScanHandler: scanHandler}
-	userHandler := &handlers.UserHandler{Repo: userRepo, AuditRepo: auditRepo}
-	auditHandler := &handlers.AuditHandler{Repo: auditRepo}
-	scheduleHandler := &handlers.ScheduleHandler{Repo: scheduleRepo}
+	assetHandler := &handlers.AssetHandler{Repo: assetRepo, AuditRepo: auditRepo, DecommissionIPGrace: cfg.DecommissionIPGrace}
+	networkHandler := &handlers.NetworkHandler{Repo: assetRepo, Subnets: subnetRepo, Relationships: relationshipRepo, Routes: assetRouteRepo}
+	inventoryHandler := &handlers.InventoryHandler{Repo: assetRepo, Subnets: subnetRepo}
+	promSDHandler := &handlers.PrometheusSDHandler{
+		Repo:        assetRepo,
+		ServiceRepo: assetServiceRepo,
+		Subnets:     subnetRepo,
+		DefaultPort: cfg.PrometheusSDDefaultPort,
+		TagPorts:    cfg.PrometheusSDTagPorts,
+	}
+	scanHandler := &handlers.ScanHandler{Repo: assetRepo, ScanJobRepo: scanJobRepo, ServiceRepo: assetServiceRepo, RouteRepo: assetRouteRepo, NmapPath: cfg.NmapPath, Traceroute: cfg.ScanTraceroute, AuditRepo: auditRepo}
+	savedScanHandler := &handlers.SavedScanHandler{Repo: savedScanRepo, ScanHandler: scanHandler, AuditRepo: auditRepo}
+	userHandler := &handlers.UserHandler{Repo: userRepo, AuditRepo: auditRepo, Sessions: sessionRepo, PasswordPolicy: passwordPolicy}
+	loginEventHandler := &handlers.LoginEventHandler{Repo: loginEventRepo, Users: userRepo, AuditRepo: auditRepo}
+	auditHandler := &handlers.AuditHandler{Repo: auditRepo, CheckpointFile: cfg.AuditCheckpointFile, CheckpointKey: []byte(cfg.AuditCheckpointKey)}
+	pruner := newPruner(cfg, db)
+	retentionHandler := &handlers.RetentionHandler{Pruner: pruner, AuditRepo: auditRepo}
+	scanHandler.ScheduleRepo = scheduleRepo
+	scanHandler.SavedScanRepo = savedScanRepo
+	scanHandler.ScheduleMaxFailures = cfg.ScheduleMaxFailures
+	sched := scheduler.New(scheduleRepo, repo.NewLeaseRepo(db), scanHandler)
+	if cfg.SchedulerInstanceID != "" {
+		sched.Instance = cfg.SchedulerInstanceID
+	}
+	sched.LeaseTTL = cfg.SchedulerLeaseTTL
+	scheduleHandler := &handlers.ScheduleHandler{Repo: scheduleRepo, Runs: scanJobRepo, Scheduler: sched, SavedScans: savedScanRepo, Runner: scanHandler, AuditRepo: auditRepo}
+	apiKeyHandler := &handlers.APIKeyHandler{Repo: apiKeyRepo, ServiceAccounts: serviceAccountRepo, AuditRepo: auditRepo}
+	roleHandler := &handlers.RoleHandler{Repo: roleRepo, AuditRepo: auditRepo}
+	accessPolicyHandler := &handlers.AccessPolicyHandler{Repo: accessPolicyRepo, AuditRepo: auditRepo}
+	siteHandler := &handlers.SiteHandler{Repo: siteRepo, AuditRepo: auditRepo}
+	subnetHandler := &handlers.SubnetHandler{Repo: subnetRepo, AuditRepo: auditRepo}
+	relationshipHandler := &handlers.RelationshipHandler{Repo: relationshipRepo, Assets: assetRepo, AuditRepo: auditRepo, OfflineAfter: cfg.AssetOfflineAfter}
 	authHandler := &handlers.AuthHandler{
-		UserRepo:    userRepo,
-		Secret:      []byte(cfg.JWTSecret),
-		ExpireHours: cfg.JWTExpireHours,
+		UserRepo:            userRepo,
+		Secret:              []byte(cfg.JWTSecret),
+		AccessTokenMinutes:  cfg.AccessTokenMinutes,
+		RefreshTokenDays:    cfg.RefreshTokenDays,
+		Sessions:            sessionRepo,
+		DisablePasswordless: cfg.DisablePasswordless,
+		MFA:                 mfaRepo,
+		MFARequiredRoles:    cfg.MFARequiredRoles,
+		Events:              loginEventRepo,
+		Lockout: auth.LockoutPolicy{
+			Threshold: cfg.LockoutThreshold,
+			Base:      cfg.LockoutDuration,
+			Max:       cfg.LockoutMaxDuration,
+			Window:    cfg.LockoutWindow,
+		},
+		PasswordPolicy:    passwordPolicy,
+		TrustProxyHeaders: cfg.TrustProxyHeaders,
+		AuditRepo:         auditRepo,
+		Providers: []auth.Provider{&auth.LocalProvider{
+			Users:               userRepo,
+			ServiceAccounts:     serviceAccountRepo,
+			Identities:          identityRepo,
+			DisablePasswordless: cfg.DisablePasswordless,
+		}},
+	}
+	if ldapProvider := newLDAPProvider(cfg); ldapProvider != nil {
+		ldapProvider.Users = userRepo
+		ldapProvider.Identities = identityRepo
+		ldapProvider.ServiceAccounts = serviceAccountRepo
+		ldapProvider.Sessions = sessionRepo
+		authHandler.Providers = append(authHandler.Providers, ldapProvider)
+	}
+	mfaHandler := &handlers.MFAHandler{
+		Repo:      mfaRepo,
+		Users:     userRepo,
+		Auth:      authHandler,
+		AuditRepo: auditRepo,
+		Sessions:  sessionRepo,
+		Issuer:    cfg.MFAIssuer,
+	}
+	var oidcHandler *handlers.OIDCHandler // nil (SSO disabled) unless OIDC_ISSUER is set
+	if cfg.OIDCIssuer != "" {
+		oidcHandler = &handlers.OIDCHandler{
+			Provider: &oidc.Provider{
+				Issuer:       cfg.OIDCIssuer,
+				ClientID:     cfg.OIDCClientID,
+				ClientSecret: cfg.OIDCClientSecret,
+				GroupsClaim:  cfg.OIDCGroupsClaim,
+			},
+			Identities:   identityRepo,
+			Users:        userRepo,
+			Auth:         authHandler,
+			AuditRepo:    auditRepo,
+			Scopes:       cfg.OIDCScopes,
+			AdminGroups:  cfg.OIDCAdminGroups,
+			ViewerGroups: cfg.OIDCViewerGroups,
+			Sessions:     sessionRepo,
+		}
 	}
 
 	r := chi.NewRouter()
@@ -137,8 +372,10 @@ func newRouter(db *sql.DB, cfg config.Config) (*chi.Mux, *handlers.ScanHandler,
 	r.Use(middleware.MaxBytes(0)) // 0 => use default 1 MiB
 	r.Use(middleware.Recoverer)
 	r.Use(chimw.RequestID)
+	r.Use(middleware.RequestClientIP(cfg.TrustProxyHeaders))
 	r.Use(middleware.RequestLog)
 	r.Use(middleware.Prometheus)
+	r.Use(middleware.SitePrefix("/v1")) // /v1/sites/{site}/... is /v1/... with X-Site: {site}
 
 	r.Handle("/metrics", promhttp.Handler())
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -167,18 +404,58 @@ func newRouter(db *sql.DB, cfg config.Config) (*chi.Mux, *handlers.ScanHandler,
 		authLimiter := middleware.AuthRateLimiter()
 		r.With(authLimiter.Middleware).Post("/auth/register", authHandler.Register)
 		r.With(authLimiter.Middleware).Post("/auth/login", authHandler.Login)
+		r.With(authLimiter.Middleware).Post("/auth/refresh", authHandler.Refresh)
+		r.With(authLimiter.Middleware).Post("/auth/mfa/login", mfaHandler.Login)
+		r.With(authLimiter.Middleware).Post("/auth/mfa/login/enroll", mfaHandler.LoginEnroll)
+		r.With(authLimiter.Middleware).Post("/auth/mfa/login/enable", mfaHandler.LoginEnable)
+		r.Get("/auth/oidc/config", oidcHandler.Config)
+		r.With(authLimiter.Middleware).Post("/auth/oidc/exchange", oidcHandler.Exchange)
 
-		jwtMiddleware := middleware.JWTMiddleware([]byte(cfg.JWTSecret))
-		adminOnly := middleware.RequireAdmin
+		// Accepts user JWTs and service-account API keys. RequirePermission checks the role's permissions and,
+		// for API keys, the scope of the same name.
+		authenticator := &middleware.Authenticator{
+			Secret:            []byte(cfg.JWTSecret),
+			APIKeys:           apiKeyRepo,
+			Sessions:          sessionRepo,
+			Roles:             roleRepo,
+			AccessPolicies:    accessPolicyRepo,
+			Sites:             siteRepo,
+			TrustProxyHeaders: cfg.TrustProxyHeaders,
+		}
+		jwtMiddleware := authenticator.Middleware
+		assetsWrite := middleware.RequirePermission(models.PermissionAssetsWrite)
+		scansRun := middleware.RequirePermission(models.PermissionScansRun)
+		schedulesWrite := middleware.RequirePermission(models.PermissionSchedulesWrite)
+		usersManage := middleware.RequirePermission(models.PermissionUsersManage)
+		sitesManage := middleware.RequirePermission(models.PermissionSitesManage)
+		ipamWrite := middleware.RequirePermission(models.PermissionIPAMWrite)
+		auditRead := middleware.RequirePermission(models.PermissionAuditRead)
+		retentionManage := middleware.RequirePermission(models.PermissionRetentionManage)
 
-		// Viewer (and admin): read-only
+		// Any role: read-only
 		r.With(jwtMiddleware).Get("/assets", assetHandler.ListAssets)
 		r.With(jwtMiddleware).Get("/assets/{id}", assetHandler.GetAsset)
+		r.With(jwtMiddleware).Get("/assets/{id}/lifecycle", assetHandler.LifecycleHistory)
+		r.With(jwtMiddleware).Get("/assets/trash", assetHandler.ListTrash)
+		r.With(jwtMiddleware).Get("/assets/{id}/relationships", relationshipHandler.ListRelationships)
+		r.With(jwtMiddleware).Get("/assets/{id}/impact", relationshipHandler.Impact)
+		r.With(jwtMiddleware).Get("/impact/offline", relationshipHandler.OutageImpact)
 		r.With(jwtMiddleware).Get("/network/graph", networkHandler.NetworkGraph)
+		r.With(jwtMiddleware).Get("/inventory/ansible", inventoryHandler.AnsibleInventory)
+		r.With(authenticator.ReadOnlyToken(cfg.PrometheusSDToken)).Get("/sd/prometheus", promSDHandler.PrometheusSD)
 		r.With(jwtMiddleware).Get("/me", userHandler.Me)
+		r.With(jwtMiddleware).Post("/auth/logout", authHandler.Logout)
+		r.With(jwtMiddleware).Get("/auth/mfa", mfaHandler.Status)
+		r.With(jwtMiddleware).Post("/auth/mfa/enroll", mfaHandler.Enroll)
+		r.With(jwtMiddleware).Post("/auth/mfa/enable", mfaHandler.Enable)
+		r.With(jwtMiddleware).Post("/auth/mfa/disable", mfaHandler.Disable)
+		r.With(jwtMiddleware).Post("/auth/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
 		r.With(jwtMiddleware).Get("/users", userHandler.ListUsers)
 		r.With(jwtMiddleware).Get("/users/{id}", userHandler.GetUser)
-		r.With(jwtMiddleware).Get("/audit", auditHandler.ListAudit)
+		r.With(jwtMiddleware, auditRead).Get("/audit", auditHandler.ListAudit)
+		r.With(jwtMiddleware, auditRead).Get("/audit/export", auditHandler.ExportAudit)
+		r.With(jwtMiddleware, auditRead).Get("/audit/verify", auditHandler.VerifyAudit)
+		r.With(jwtMiddleware, usersManage).Get("/auth/events", loginEventHandler.ListEvents)
 		r.With(jwtMiddleware).Get("/scan/{id}", scanHandler.GetScanStatus)
 		r.With(jwtMiddleware).Get("/scans", scanHandler.ListScans)
 		r.With(jwtMiddleware).Get("/scans/{id}", scanHandler.GetScanStatus)
@@ -186,30 +463,75 @@ func newRouter(db *sql.DB, cfg config.Config) (*chi.Mux, *handlers.ScanHandler,
 		r.With(jwtMiddleware).Get("/saved-scans/{id}", savedScanHandler.GetSavedScan)
 		r.With(jwtMiddleware).Get("/schedules", scheduleHandler.ListSchedules)
 		r.With(jwtMiddleware).Get("/schedules/{id}", scheduleHandler.GetSchedule)
+		r.With(jwtMiddleware).Get("/schedules/{id}/runs", scheduleHandler.ListRuns)
+		r.With(jwtMiddleware).Get("/scheduler/status", scheduleHandler.SchedulerStatus)
+		r.With(jwtMiddleware).Get("/sites", siteHandler.ListSites)
+		r.With(jwtMiddleware).Get("/sites/{id}", siteHandler.GetSite)
+		r.With(jwtMiddleware).Get("/subnets", subnetHandler.ListSubnets)
+		r.With(jwtMiddleware).Get("/subnets/{id}", subnetHandler.GetSubnet)
+		r.With(jwtMiddleware).Get("/subnets/{id}/reservations", subnetHandler.ListReservations)
+		r.With(jwtMiddleware).Get("/subnets/{id}/next-free", subnetHandler.NextFreeIP)
 
-		// Admin only: create, update, delete, scan, heartbeat
-		r.With(jwtMiddleware, adminOnly).Post("/assets", assetHandler.CreateAsset)
-		r.With(jwtMiddleware, adminOnly).Put("/assets/{id}", assetHandler.UpdateAsset)
-		r.With(jwtMiddleware, adminOnly).Post("/assets/{id}/heartbeat", assetHandler.Heartbeat)
-		r.With(jwtMiddleware, adminOnly).Delete("/assets/{id}", assetHandler.DeleteAsset)
-		r.With(jwtMiddleware, adminOnly).Post("/assets/batch-delete", assetHandler.BatchDeleteAssets)
-		r.With(jwtMiddleware, adminOnly).Post("/users", userHandler.CreateUser)
+		// Permission required: create, update, delete, scan, heartbeat
+		r.With(jwtMiddleware, assetsWrite).Post("/assets", assetHandler.CreateAsset)
+		r.With(jwtMiddleware, assetsWrite).Put("/assets/{id}", assetHandler.UpdateAsset)
+		r.With(jwtMiddleware, assetsWrite).Post("/assets/{id}/heartbeat", assetHandler.Heartbeat)
+		r.With(jwtMiddleware, assetsWrite).Delete("/assets/{id}", assetHandler.DeleteAsset)
+		r.With(jwtMiddleware, assetsWrite).Post("/assets/batch-delete", assetHandler.BatchDeleteAssets)
+		r.With(jwtMiddleware, assetsWrite).Post("/assets/{id}/decommission", assetHandler.DecommissionAsset)
+		r.With(jwtMiddleware, assetsWrite).Post("/assets/trash/{id}/restore", assetHandler.RestoreAsset)
+		r.With(jwtMiddleware, assetsWrite).Delete("/assets/trash/{id}", assetHandler.PurgeAsset)
+		r.With(jwtMiddleware, assetsWrite).Post("/assets/{id}/relationships", relationshipHandler.CreateRelationship)
+		r.With(jwtMiddleware, assetsWrite).Delete("/assets/{id}/relationships/{relationshipID}", relationshipHandler.DeleteRelationship)
+		r.With(jwtMiddleware, usersManage).Post("/users", userHandler.CreateUser)
 		r.With(jwtMiddleware).Put("/users/{id}/password", userHandler.ChangePassword)
-		r.With(jwtMiddleware, adminOnly).Put("/users/{id}", userHandler.UpdateUser)
-		r.With(jwtMiddleware, adminOnly).Delete("/users/{id}", userHandler.DeleteUser)
-		r.With(jwtMiddleware, adminOnly).Post("/scan", scanHandler.StartScan)
-		r.With(jwtMiddleware, adminOnly).Post("/scan/{id}/cancel", scanHandler.CancelScan)
-		r.With(jwtMiddleware, adminOnly).Post("/scans", scanHandler.StartScan)
-		r.With(jwtMiddleware, adminOnly).Post("/scans/{id}/cancel", scanHandler.CancelScan)
-		r.With(jwtMiddleware, adminOnly).Delete("/scans", scanHandler.ClearScans)
-		r.With(jwtMiddleware, adminOnly).Post("/saved-scans", savedScanHandler.CreateSavedScan)
-		r.With(jwtMiddleware, adminOnly).Put("/saved-scans/{id}", savedScanHandler.UpdateSavedScan)
-		r.With(jwtMiddleware, adminOnly).Delete("/saved-scans/{id}", savedScanHandler.DeleteSavedScan)
-		r.With(jwtMiddleware, adminOnly).Post("/saved-scans/{id}/run", savedScanHandler.RunSavedScan)
-		r.With(jwtMiddleware, adminOnly).Post("/schedules", scheduleHandler.CreateSchedule)
-		r.With(jwtMiddleware, adminOnly).Put("/schedules/{id}", scheduleHandler.UpdateSchedule)
-		r.With(jwtMiddleware, adminOnly).Delete("/schedules/{id}", scheduleHandler.DeleteSchedule)
+		r.With(jwtMiddleware, usersManage).Put("/users/{id}", userHandler.UpdateUser)
+		r.With(jwtMiddleware, usersManage).Delete("/users/{id}", userHandler.DeleteUser)
+		r.With(jwtMiddleware).Post("/users/{id}/sessions/revoke", userHandler.RevokeSessions)
+		r.With(jwtMiddleware, usersManage).Delete("/users/{id}/mfa", mfaHandler.Reset)
+		r.With(jwtMiddleware, usersManage).Post("/users/{id}/unlock", loginEventHandler.Unlock)
+		r.With(jwtMiddleware, scansRun).Post("/scan", scanHandler.StartScan)
+		r.With(jwtMiddleware, scansRun).Post("/scan/{id}/cancel", scanHandler.CancelScan)
+		r.With(jwtMiddleware, scansRun).Post("/scans", scanHandler.StartScan)
+		r.With(jwtMiddleware, scansRun).Post("/scans/{id}/cancel", scanHandler.CancelScan)
+		r.With(jwtMiddleware, scansRun).Delete("/scans", scanHandler.ClearScans)
+		r.With(jwtMiddleware, scansRun).Post("/saved-scans", savedScanHandler.CreateSavedScan)
+		r.With(jwtMiddleware, scansRun).Put("/saved-scans/{id}", savedScanHandler.UpdateSavedScan)
+		r.With(jwtMiddleware, scansRun).Delete("/saved-scans/{id}", savedScanHandler.DeleteSavedScan)
+		r.With(jwtMiddleware, scansRun).Post("/saved-scans/{id}/run", savedScanHandler.RunSavedScan)
+		r.With(jwtMiddleware, schedulesWrite).Post("/schedules", scheduleHandler.CreateSchedule)
+		r.With(jwtMiddleware, schedulesWrite).Put("/schedules/{id}", scheduleHandler.UpdateSchedule)
+		r.With(jwtMiddleware, schedulesWrite).Delete("/schedules/{id}", scheduleHandler.DeleteSchedule)
+		r.With(jwtMiddleware, scansRun).Post("/schedules/{id}/run", scheduleHandler.RunSchedule)
+		r.With(jwtMiddleware, sitesManage).Post("/sites", siteHandler.CreateSite)
+		r.With(jwtMiddleware, sitesManage).Put("/sites/{id}", siteHandler.UpdateSite)
+		r.With(jwtMiddleware, sitesManage).Delete("/sites/{id}", siteHandler.DeleteSite)
+		r.With(jwtMiddleware, ipamWrite).Post("/subnets", subnetHandler.CreateSubnet)
+		r.With(jwtMiddleware, ipamWrite).Put("/subnets/{id}", subnetHandler.UpdateSubnet)
+		r.With(jwtMiddleware, ipamWrite).Delete("/subnets/{id}", subnetHandler.DeleteSubnet)
+		r.With(jwtMiddleware, ipamWrite).Post("/subnets/{id}/reservations", subnetHandler.CreateReservation)
+		r.With(jwtMiddleware, ipamWrite).Delete("/subnets/{id}/reservations/{reservationID}", subnetHandler.DeleteReservation)
+		r.With(jwtMiddleware, ipamWrite).Post("/subnets/{id}/allocate", subnetHandler.AllocateIP)
+
+		// API keys, service accounts, roles and access policies (users:manage)
+		r.With(jwtMiddleware, usersManage).Get("/api-keys", apiKeyHandler.ListAPIKeys)
+		r.With(jwtMiddleware, usersManage).Post("/api-keys", apiKeyHandler.CreateAPIKey)
+		r.With(jwtMiddleware, usersManage).Delete("/api-keys/{id}", apiKeyHandler.RevokeAPIKey)
+		r.With(jwtMiddleware, usersManage).Get("/service-accounts", apiKeyHandler.ListServiceAccounts)
+		r.With(jwtMiddleware, usersManage).Post("/service-accounts", apiKeyHandler.CreateServiceAccount)
+		r.With(jwtMiddleware).Get("/roles", roleHandler.ListRoles)
+		r.With(jwtMiddleware).Get("/roles/{id}", roleHandler.GetRole)
+		r.With(jwtMiddleware, usersManage).Post("/roles", roleHandler.CreateRole)
+		r.With(jwtMiddleware, usersManage).Put("/roles/{id}", roleHandler.UpdateRole)
+		r.With(jwtMiddleware, usersManage).Delete("/roles/{id}", roleHandler.DeleteRole)
+		r.With(jwtMiddleware, usersManage).Get("/access-policies", accessPolicyHandler.ListAccessPolicies)
+		r.With(jwtMiddleware, usersManage).Get("/access-policies/{id}", accessPolicyHandler.GetAccessPolicy)
+		r.With(jwtMiddleware, usersManage).Post("/access-policies", accessPolicyHandler.CreateAccessPolicy)
+		r.With(jwtMiddleware, usersManage).Put("/access-policies/{id}", accessPolicyHandler.UpdateAccessPolicy)
+		r.With(jwtMiddleware, usersManage).Delete("/access-policies/{id}", accessPolicyHandler.DeleteAccessPolicy)
+		r.With(jwtMiddleware, retentionManage).Get("/retention", retentionHandler.Report)
+		r.With(jwtMiddleware, retentionManage).Post("/retention/run", retentionHandler.Run)
 	})
 
-	return r, scanHandler, scheduleRepo
+	return r, sched, pruner
 }
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"github.com/crucial707/hci-asset/cmd/cli/config"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// ==========================
+// Initialize Audit CLI
+// ==========================
+func InitAudit(rootCmd *cobra.Command) {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Audit log",
+		Long:  "Commands to check the audit log via the API.",
+	}
+
+	auditCmd.AddCommand(verifyCmd())
+
+	rootCmd.AddCommand(auditCmd)
+}
+
+// ==========================
+// Verify Audit Chain
+// ==========================
+func verifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log hash chain and signed checkpoints",
+		Long: `Walk the audit log hash chain on the server and report the first broken link.
+
+Exits with an error when an entry was changed, deleted or inserted, or when the chain no
+longer matches the signed checkpoints.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req, _ := http.NewRequest("GET", config.APIURL()+"/audit/verify", nil)
+			config.AddAuthHeader(req)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("API request failed: %w", err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+			}
+			var v models.AuditVerification
+			if err := json.Unmarshal(body, &v); err != nil {
+				return fmt.Errorf("failed to parse response: %w", err)
+			}
+
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				fmt.Println(string(body))
+			} else if v.Valid {
+				fmt.Printf("Audit log OK: %d entries verified (IDs %d-%d), %d checkpoints matched.\n", v.Checked, v.FirstID, v.LastID, v.Checkpoints)
+				fmt.Printf("Head: %s\n", v.LastHash)
+			} else {
+				fmt.Printf("Audit log BROKEN at entry %d: %s\n", v.BrokenLink.ID, v.BrokenLink.Reason)
+				fmt.Printf("%d entries verified before it.\n", v.Checked)
+			}
+			if !v.Valid {
+				return fmt.Errorf("audit log verification failed")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("json", "j", false, "Output raw JSON instead of formatted text")
+	return cmd
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureOutput helps capture stdout during command execution.
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	fn()
+
+	_ = w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(r)
+	return buf.String()
+}
+
+func TestAuditVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "valid",
+			body: `{"valid":true,"checked":42,"first_id":1,"last_id":42,"last_hash":"ab12","checkpoints":3}`,
+			want: "Audit log OK: 42 entries verified (IDs 1-42), 3 checkpoints matched.",
+		},
+		{
+			name:    "broken",
+			body:    `{"valid":false,"checked":6,"first_id":1,"last_id":6,"checkpoints":0,"broken_link":{"id":8,"reason":"prev_hash does not match entry 6"}}`,
+			wantErr: true,
+			want:    "Audit log BROKEN at entry 8: prev_hash does not match entry 6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/audit/verify" {
+					t.Fatalf("unexpected request: %s", r.URL.String())
+				}
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_ = os.Setenv("HCI_ASSET_API_URL", srv.URL)
+			defer os.Unsetenv("HCI_ASSET_API_URL")
+
+			cmd := verifyCmd()
+			var err error
+			out := captureOutput(t, func() {
+				err = cmd.RunE(cmd, []string{})
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunE error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Fatalf("expected %q, got: %s", tt.want, out)
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/crucial707/hci-asset/cmd/cli/config"
 	"github.com/spf13/cobra"
@@ -14,12 +17,14 @@ import (
 // InitAuth registers auth-related CLI commands (e.g., login) on the root command.
 func InitAuth(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(loginCmd())
+	rootCmd.AddCommand(logoutCmd())
 }
 
 // loginCmd creates a command that logs in a user and stores the JWT token locally.
 func loginCmd() *cobra.Command {
 	var username string
 	var register bool
+	var code string
 
 	cmd := &cobra.Command{
 		Use:   "login",
@@ -41,11 +46,29 @@ func loginCmd() *cobra.Command {
 
 			// Perform login to get token
 			var loginResp struct {
-				Token string `json:"token"`
+				Token                 string `json:"token"`
+				RefreshToken          string `json:"refresh_token"`
+				MFARequired           bool   `json:"mfa_required"`
+				MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+				MFAToken              string `json:"mfa_token"`
 			}
 			if err := callJSONEndpoint(client, "/auth/login", map[string]string{"username": username}, &loginResp); err != nil {
 				return fmt.Errorf("failed to login: %w", err)
 			}
+			if loginResp.MFAEnrollmentRequired {
+				return fmt.Errorf("two-factor authentication must be set up first; log in once via the web UI")
+			}
+			if loginResp.MFARequired {
+				if code == "" {
+					fmt.Print("Authentication code (or recovery code): ")
+					line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+					code = strings.TrimSpace(line)
+				}
+				mfa := map[string]string{"mfa_token": loginResp.MFAToken, "code": code}
+				if err := callJSONEndpoint(client, "/auth/mfa/login", mfa, &loginResp); err != nil {
+					return fmt.Errorf("failed to verify code: %w", err)
+				}
+			}
 			if loginResp.Token == "" {
 				return fmt.Errorf("login succeeded but no token returned")
 			}
@@ -53,6 +76,11 @@ func loginCmd() *cobra.Command {
 			if err := config.SaveToken(loginResp.Token); err != nil {
 				return fmt.Errorf("failed to save token: %w", err)
 			}
+			if loginResp.RefreshToken != "" {
+				if err := config.SaveRefreshToken(loginResp.RefreshToken); err != nil {
+					return fmt.Errorf("failed to save refresh token: %w", err)
+				}
+			}
 
 			fmt.Println("Login successful. Token stored locally.")
 			return nil
@@ -61,11 +89,49 @@ func loginCmd() *cobra.Command {
 
 	cmd.Flags().StringVar(&username, "username", "", "Username to authenticate as")
 	cmd.Flags().BoolVar(&register, "register", false, "Register the user before logging in")
+	cmd.Flags().StringVar(&code, "code", "", "Two-factor authentication code (prompted for when needed)")
+
+	return cmd
+}
+
+// logoutCmd revokes the stored session on the server and removes the local tokens.
+func logoutCmd() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "logout",
+		Short: "Log out and revoke the stored session",
+		Long:  "Revoke the stored access and refresh tokens on the server and delete them locally. Use --all to sign out every session of the user.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if config.Token() != "" {
+				payload := map[string]interface{}{"all": all}
+				if refresh := config.RefreshToken(); refresh != "" {
+					payload["refresh_token"] = refresh
+				}
+				if err := postJSON(http.DefaultClient, "/auth/logout", payload, nil, true); err != nil {
+					// Still clear local tokens so a broken session does not block logging in again.
+					fmt.Printf("Warning: server logout failed: %v\n", err)
+				}
+			}
+			if err := config.ClearTokens(); err != nil {
+				return fmt.Errorf("failed to remove local tokens: %w", err)
+			}
+			fmt.Println("Logged out.")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Revoke all sessions of the user, not just this one")
 
 	return cmd
 }
 
+// callJSONEndpoint POSTs payload to path and decodes the response into out (if non-nil).
 func callJSONEndpoint(client *http.Client, path string, payload interface{}, out interface{}) error {
+	return postJSON(client, path, payload, out, false)
+}
+
+func postJSON(client *http.Client, path string, payload interface{}, out interface{}, authenticated bool) error {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
@@ -76,6 +142,9 @@ func callJSONEndpoint(client *http.Client, path string, payload interface{}, out
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if authenticated {
+		config.AddAuthHeader(req)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1,20 +1,40 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
 	defaultAPIURL  = "http://localhost:8080/v1"
 	tokenDirName   = ".hci-asset"
 	tokenFileName  = "token"
+	refreshFileName = "refresh_token"
 	tokenEnvVar    = "HCI_ASSET_TOKEN"
 	apiURLEnvVar   = "HCI_ASSET_API_URL"
+	siteEnvVar     = "HCI_ASSET_SITE"
+	siteHeader     = "X-Site"
 )
 
+// SiteFlag is set by the global --site flag and takes precedence over HCI_ASSET_SITE.
+var SiteFlag string
+
+// Site returns the site (name or ID) requests are limited to, or "" for every site.
+// It can be set with --site or the HCI_ASSET_SITE environment variable.
+func Site() string {
+	if SiteFlag != "" {
+		return SiteFlag
+	}
+	return os.Getenv(siteEnvVar)
+}
+
 // APIURL returns the base URL for the HCI Asset API.
 // It can be overridden with the HCI_ASSET_API_URL environment variable.
 func APIURL() string {
@@ -57,20 +77,127 @@ func SaveToken(token string) error {
 	return os.WriteFile(path, []byte(strings.TrimSpace(token)), 0o600)
 }
 
-// AddAuthHeader adds the Authorization header to the request if a token is available.
+// RefreshToken returns the stored refresh token, if any. Tokens from HCI_ASSET_TOKEN are never refreshed.
+func RefreshToken() string {
+	path, err := filePath(refreshFileName)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// SaveRefreshToken persists the refresh token next to the access token.
+func SaveRefreshToken(token string) error {
+	path, err := filePath(refreshFileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.TrimSpace(token)), 0o600)
+}
+
+// ClearTokens removes the stored access and refresh tokens (logout).
+func ClearTokens() error {
+	for _, name := range []string{tokenFileName, refreshFileName} {
+		path, err := filePath(name)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAuthHeader adds the Authorization header to the request if a token is available, and the X-Site
+// header when a site is selected (see Site).
+// An expired stored access token is first exchanged using the stored refresh token.
 func AddAuthHeader(req *http.Request) {
 	if req == nil {
 		return
 	}
-	if token := Token(); token != "" {
+	if site := Site(); site != "" {
+		req.Header.Set(siteHeader, site)
+	}
+	token := Token()
+	if token != "" && os.Getenv(tokenEnvVar) == "" && tokenExpired(token) {
+		if refreshed, err := refreshTokens(); err == nil {
+			token = refreshed
+		}
+	}
+	if token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	}
 }
 
+// tokenExpired reports whether a JWT's exp claim is in the past (or within 30 seconds). The signature is
+// not checked; the API does that. Non-JWT tokens such as API keys never expire here.
+func tokenExpired(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return false
+	}
+	return time.Now().Add(30 * time.Second).Unix() >= claims.Exp
+}
+
+// refreshTokens exchanges the stored refresh token for a new token pair, saves both and returns the access token.
+func refreshTokens() (string, error) {
+	refresh := RefreshToken()
+	if refresh == "" {
+		return "", fmt.Errorf("no refresh token")
+	}
+	body, _ := json.Marshal(map[string]string{"refresh_token": refresh})
+	resp, err := http.Post(APIURL()+"/auth/refresh", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("refresh failed: status %d", resp.StatusCode)
+	}
+	var out struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.Token == "" {
+		return "", fmt.Errorf("invalid refresh response")
+	}
+	if err := SaveToken(out.Token); err != nil {
+		return "", err
+	}
+	if out.RefreshToken != "" {
+		if err := SaveRefreshToken(out.RefreshToken); err != nil {
+			return "", err
+		}
+	}
+	return out.Token, nil
+}
+
 func tokenFilePath() (string, error) {
+	return filePath(tokenFileName)
+}
+
+func filePath(name string) (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, tokenDirName, tokenFileName), nil
+	return filepath.Join(home, tokenDirName, name), nil
 }
@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+
+	"github.com/crucial707/hci-asset/cmd/cli/config"
+)
+
+// ==========================
+// Initialize Inventory CLI
+// ==========================
+// InitInventory registers the inventory command. It follows the Ansible inventory script
+// contract, so a wrapper such as `exec hci-asset inventory "$@"` can be passed to ansible -i.
+func InitInventory(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(inventoryCmd())
+}
+
+func inventoryCmd() *cobra.Command {
+	var list bool
+	var host string
+
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Short: "Print the Ansible dynamic inventory (--list or --host <name>)",
+		Long: `Print assets in the Ansible dynamic inventory JSON format.
+
+  hci-asset inventory --list          all groups and _meta.hostvars
+  hci-asset inventory --host web1     variables for one host`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !list && host == "" {
+				return fmt.Errorf("one of --list or --host is required")
+			}
+
+			path := "/inventory/ansible"
+			if host != "" {
+				path += "?host=" + url.QueryEscape(host)
+			}
+			req, _ := http.NewRequest("GET", config.APIURL()+path, nil)
+			config.AddAuthHeader(req)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("API request failed: %w", err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+			}
+
+			// Ansible parses stdout as JSON, so print the body untouched.
+			fmt.Print(string(body))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&list, "list", false, "Print the full inventory")
+	cmd.Flags().StringVar(&host, "host", "", "Print variables for a single host")
+	return cmd
+}
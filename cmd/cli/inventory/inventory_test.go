@@ -0,0 +1,87 @@
+package inventory
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureOutput helps capture stdout during command execution.
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	fn()
+
+	_ = w.Close()
+	os.Stdout = old
+
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(r)
+	return buf.String()
+}
+
+func TestInventory_List(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inventory/ansible" || r.URL.Query().Get("host") != "" {
+			t.Fatalf("unexpected request: %s", r.URL.String())
+		}
+		_, _ = w.Write([]byte(`{"_meta":{"hostvars":{"web1":{}}},"all":{"children":["ungrouped"]}}`))
+	}))
+	defer srv.Close()
+
+	_ = os.Setenv("HCI_ASSET_API_URL", srv.URL)
+	defer os.Unsetenv("HCI_ASSET_API_URL")
+
+	cmd := inventoryCmd()
+	_ = cmd.Flags().Set("list", "true")
+
+	out := captureOutput(t, func() {
+		if err := cmd.RunE(cmd, []string{}); err != nil {
+			t.Errorf("RunE: %v", err)
+		}
+	})
+
+	if !strings.Contains(out, `"hostvars"`) {
+		t.Fatalf("expected inventory JSON, got: %s", out)
+	}
+}
+
+func TestInventory_Host(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("host") != "web1" {
+			t.Fatalf("unexpected host query: %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"ansible_host":"10.0.0.5"}`))
+	}))
+	defer srv.Close()
+
+	_ = os.Setenv("HCI_ASSET_API_URL", srv.URL)
+	defer os.Unsetenv("HCI_ASSET_API_URL")
+
+	cmd := inventoryCmd()
+	_ = cmd.Flags().Set("host", "web1")
+
+	out := captureOutput(t, func() {
+		if err := cmd.RunE(cmd, []string{}); err != nil {
+			t.Errorf("RunE: %v", err)
+		}
+	})
+
+	if !strings.Contains(out, `"ansible_host":"10.0.0.5"`) {
+		t.Fatalf("expected hostvars JSON, got: %s", out)
+	}
+}
+
+func TestInventory_RequiresMode(t *testing.T) {
+	cmd := inventoryCmd()
+	if err := cmd.RunE(cmd, []string{}); err == nil {
+		t.Fatal("expected error without --list or --host")
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/crucial707/hci-asset/cmd/cli/assets"
+	"github.com/crucial707/hci-asset/cmd/cli/audit"
 	"github.com/crucial707/hci-asset/cmd/cli/auth"
+	"github.com/crucial707/hci-asset/cmd/cli/config"
+	"github.com/crucial707/hci-asset/cmd/cli/inventory"
 	"github.com/crucial707/hci-asset/cmd/cli/scan"
 	"github.com/crucial707/hci-asset/cmd/cli/users"
 
@@ -58,6 +61,7 @@ func main() {
 		Short: "HCI Asset Management CLI",
 		Long:  "Command-line interface for managing assets, users, and network scans.",
 	}
+	rootCmd.PersistentFlags().StringVar(&config.SiteFlag, "site", "", "limit commands to a site (name or ID); defaults to HCI_ASSET_SITE")
 
 	// ==========================
 	// Attach CLI Modules
@@ -66,6 +70,8 @@ func main() {
 	users.InitUsers(rootCmd)
 	scan.InitScan(rootCmd)
 	auth.InitAuth(rootCmd)
+	inventory.InitInventory(rootCmd)
+	audit.InitAudit(rootCmd)
 
 	// ==========================
 	// Execute CLI
@@ -14,10 +14,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/oidc"
 )
 
 //go:embed templates
@@ -28,10 +32,14 @@ var staticFS embed.FS
 
 const (
 	cookieName   = "hci_asset_token"
+	refreshCookieName = "hci_asset_refresh"
 	defaultPort  = "3000"
 	defaultAPI   = "http://localhost:8080/v1"
 	envWebPort   = "HCI_WEB_PORT"
 	envAPIURL    = "HCI_ASSET_API_URL"
+	envOIDCRedirectURL = "HCI_WEB_OIDC_REDIRECT_URL"
+	oidcCookieName     = "hci_asset_oidc"
+	siteCookieName     = "hci_asset_site"
 )
 
 func main() {
@@ -52,56 +60,70 @@ func main() {
 	r.Handle("/static/*", http.StripPrefix("/static", http.FileServer(http.FS(staticRoot))))
 
 	// Public
-	r.Get("/login", loginForm)
+	r.Get("/login", loginForm(apiBase))
 	r.Post("/login", loginSubmit(apiBase))
-	r.Get("/logout", logout)
+	r.Post("/login/mfa", loginMFASubmit(apiBase))
+	r.Post("/login/mfa/enroll", loginMFAEnrollSubmit(apiBase))
+	r.Get("/logout", logout(apiBase))
+	r.Get("/auth/oidc/start", oidcStart(apiBase))
+	r.Get("/auth/oidc/callback", oidcCallback(apiBase))
 
 	// Protected
 	r.Group(func(r chi.Router) {
 		r.Use(requireAuth(apiBase))
 		r.Get("/", redirectDashboard)
-		r.Get("/dashboard", dashboard(apiBase))
-		r.Get("/assets/new", assetCreateForm(apiBase))
-		r.Post("/assets", assetCreate(apiBase))
-		r.Get("/assets", assetsList(apiBase))
-		r.Get("/assets/{id}", assetDetail(apiBase))
-		r.Post("/assets/{id}/heartbeat", assetHeartbeat(apiBase))
-		r.Get("/assets/{id}/edit", assetEditForm(apiBase))
-		r.Post("/assets/{id}/edit", assetUpdate(apiBase))
-		r.Get("/assets/{id}/delete", assetDeleteConfirm(apiBase))
-		r.Post("/assets/{id}/delete", assetDelete(apiBase))
-		r.Post("/assets/batch-delete", assetsBatchDelete(apiBase))
+		r.Post("/site", selectSite)
+		r.Get("/dashboard", bySite(apiBase, dashboard))
+		r.Get("/assets/new", bySite(apiBase, assetCreateForm))
+		r.Post("/assets", bySite(apiBase, assetCreate))
+		r.Get("/assets", bySite(apiBase, assetsList))
+		r.Get("/assets/{id}", bySite(apiBase, assetDetail))
+		r.Post("/assets/{id}/heartbeat", bySite(apiBase, assetHeartbeat))
+		r.Get("/assets/{id}/edit", bySite(apiBase, assetEditForm))
+		r.Post("/assets/{id}/edit", bySite(apiBase, assetUpdate))
+		r.Get("/assets/{id}/delete", bySite(apiBase, assetDeleteConfirm))
+		r.Post("/assets/{id}/delete", bySite(apiBase, assetDelete))
+		r.Post("/assets/batch-delete", bySite(apiBase, assetsBatchDelete))
+		r.Post("/assets/{id}/decommission", bySite(apiBase, assetDecommission))
+		r.Get("/assets/trash", bySite(apiBase, assetsTrash))
+		r.Post("/assets/trash/{id}/restore", bySite(apiBase, assetRestore))
+		r.Post("/assets/trash/{id}/purge", bySite(apiBase, assetPurge))
 		r.Get("/users", usersList(apiBase))
 		r.Get("/users/new", userCreateForm(apiBase))
 		r.Post("/users", userCreate(apiBase))
+		r.Get("/users/{id}", userDetail(apiBase))
 		r.Get("/users/{id}/edit", userEditForm(apiBase))
 		r.Post("/users/{id}/edit", userUpdate(apiBase))
 		r.Get("/users/{id}/change-password", userChangePasswordForm(apiBase))
 		r.Post("/users/{id}/change-password", userChangePassword(apiBase))
 		r.Get("/users/{id}/delete", userDeleteConfirm(apiBase))
 		r.Post("/users/{id}/delete", userDelete(apiBase))
-		r.Get("/scans", scanPage(apiBase))
-		r.Post("/scans", startScan(apiBase))
-		r.Post("/scans/clear", clearScans(apiBase))
-		r.Get("/scans/{id}", scanDetail(apiBase))
-		r.Post("/scans/{id}/cancel", cancelScan(apiBase))
-		r.Get("/saved-scans", savedScansList(apiBase))
-		r.Get("/saved-scans/new", savedScanNewForm(apiBase))
-		r.Post("/saved-scans", savedScanCreate(apiBase))
-		r.Get("/saved-scans/{id}/edit", savedScanEditForm(apiBase))
-		r.Post("/saved-scans/{id}/edit", savedScanUpdate(apiBase))
-		r.Post("/saved-scans/{id}/run", savedScanRun(apiBase))
-		r.Get("/saved-scans/{id}/delete", savedScanDeleteConfirm(apiBase))
-		r.Post("/saved-scans/{id}/delete", savedScanDelete(apiBase))
-		r.Get("/schedules", schedulesList(apiBase))
-		r.Get("/schedules/new", scheduleCreateForm(apiBase))
-		r.Post("/schedules", scheduleCreate(apiBase))
-		r.Get("/schedules/{id}/edit", scheduleEditForm(apiBase))
-		r.Post("/schedules/{id}/edit", scheduleUpdate(apiBase))
-		r.Get("/schedules/{id}/delete", scheduleDeleteConfirm(apiBase))
-		r.Post("/schedules/{id}/delete", scheduleDelete(apiBase))
+		r.Get("/scans", bySite(apiBase, scanPage))
+		r.Post("/scans", bySite(apiBase, startScan))
+		r.Post("/scans/clear", bySite(apiBase, clearScans))
+		r.Get("/scans/{id}", bySite(apiBase, scanDetail))
+		r.Post("/scans/{id}/cancel", bySite(apiBase, cancelScan))
+		r.Get("/saved-scans", bySite(apiBase, savedScansList))
+		r.Get("/saved-scans/new", bySite(apiBase, savedScanNewForm))
+		r.Post("/saved-scans", bySite(apiBase, savedScanCreate))
+		r.Get("/saved-scans/{id}/edit", bySite(apiBase, savedScanEditForm))
+		r.Post("/saved-scans/{id}/edit", bySite(apiBase, savedScanUpdate))
+		r.Post("/saved-scans/{id}/run", bySite(apiBase, savedScanRun))
+		r.Get("/saved-scans/{id}/delete", bySite(apiBase, savedScanDeleteConfirm))
+		r.Post("/saved-scans/{id}/delete", bySite(apiBase, savedScanDelete))
+		r.Get("/schedules", bySite(apiBase, schedulesList))
+		r.Get("/schedules/new", bySite(apiBase, scheduleCreateForm))
+		r.Post("/schedules", bySite(apiBase, scheduleCreate))
+		r.Get("/schedules/{id}/edit", bySite(apiBase, scheduleEditForm))
+		r.Get("/schedules/{id}/runs", bySite(apiBase, scheduleRuns))
+		r.Post("/schedules/{id}/edit", bySite(apiBase, scheduleUpdate))
+		r.Post("/schedules/{id}/run", bySite(apiBase, scheduleRun))
+		r.Get("/schedules/{id}/delete", bySite(apiBase, scheduleDeleteConfirm))
+		r.Post("/schedules/{id}/delete", bySite(apiBase, scheduleDelete))
 		r.Get("/audit", auditList(apiBase))
-		r.Get("/network", networkPage(apiBase))
+		r.Get("/audit/export", auditExport(apiBase))
+		r.Get("/login-events", loginEventsList(apiBase))
+		r.Get("/network", bySite(apiBase, networkPage))
 	})
 
 	log.Printf("Web UI running on http://localhost:%s (API: %s)", port, apiBase)
@@ -131,34 +153,114 @@ type contextKey int
 
 const userContextKey contextKey = 0
 
-// currentUser is stored in context for session display in the layout.
+// currentUser is stored in context for session display in the layout, with the sites for the site selector.
 type currentUser struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username    string   `json:"username"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	Sites       []siteSummary
+	Site        string
+}
+
+// Can reports whether the user's role grants perm, so templates only show what the API will allow.
+func (u *currentUser) Can(perm string) bool {
+	for _, p := range u.Permissions {
+		if p == perm || p == models.PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// siteSummary is one entry of GET /sites: a site with its dashboard counts.
+type siteSummary struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Assets      int    `json:"assets"`
+	Scans       int    `json:"scans"`
+	Schedules   int    `json:"schedules"`
+}
+
+// selectedSite returns the site chosen in the site selector, or "" for every site.
+func selectedSite(r *http.Request) string {
+	if c, err := r.Cookie(siteCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// bySite builds h per request with an API base limited to the selected site (the API's /sites/{site}/ prefix),
+// so inventory, scan and schedule pages only show that site.
+func bySite(apiBase string, h func(apiBase string) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := apiBase
+		if site := selectedSite(r); site != "" {
+			base = apiBase + "/sites/" + url.PathEscape(site)
+		}
+		h(base)(w, r)
+	}
+}
+
+// selectSite stores the site chosen in the layout's selector (empty for every site) and returns to the dashboard.
+func selectSite(w http.ResponseWriter, r *http.Request) {
+	site := strings.TrimSpace(r.FormValue("site"))
+	c := &http.Cookie{Name: siteCookieName, Value: site, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	if site == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
 }
 
 // requireAuth redirects to /login if cookie is missing or if the API returns 401 (invalid/expired token).
+// An expired access token is renewed once with the refresh cookie before giving up.
 // On success, fetches current user from GET /me and stores in context for layout (Logged in as ...).
 func requireAuth(apiBase string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token, err := r.Cookie(cookieName)
-			if err != nil || token.Value == "" {
-				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusFound)
-				return
+			token := ""
+			if c, err := r.Cookie(cookieName); err == nil {
+				token = c.Value
+			}
+			if token == "" {
+				if refreshed, ok := refreshSession(w, r, apiBase); ok {
+					token = refreshed
+				} else {
+					http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusFound)
+					return
+				}
+			}
+			data, status, _ := apiGet(apiBase, "/me", token)
+			if status == http.StatusUnauthorized {
+				if refreshed, ok := refreshSession(w, r, apiBase); ok {
+					data, status, _ = apiGet(apiBase, "/me", refreshed)
+				}
 			}
-			data, status, _ := apiGet(apiBase, "/me", token.Value)
 			if status == http.StatusUnauthorized {
 				clearAuthAndRedirectToLogin(w, r, "Session expired. Please sign in again.")
 				return
 			}
 			if status == http.StatusOK {
 				var u struct {
-					Username string `json:"username"`
-					Role     string `json:"role"`
+					Username    string   `json:"username"`
+					Role        string   `json:"role"`
+					Permissions []string `json:"permissions"`
 				}
 				if json.Unmarshal(data, &u) == nil {
-					r = r.WithContext(context.WithValue(r.Context(), userContextKey, &currentUser{Username: u.Username, Role: u.Role}))
+					user := &currentUser{Username: u.Username, Role: u.Role, Permissions: u.Permissions, Site: selectedSite(r)}
+					if c, err := r.Cookie(cookieName); err == nil {
+						token = c.Value
+					}
+					if data, status, err := apiGet(apiBase, "/sites", token); err == nil && status == http.StatusOK {
+						var sites struct {
+							Items []siteSummary `json:"items"`
+						}
+						if json.Unmarshal(data, &sites) == nil {
+							user.Sites = sites.Items
+						}
+					}
+					r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
 				}
 			}
 			next.ServeHTTP(w, r)
@@ -166,20 +268,288 @@ func requireAuth(apiBase string) func(http.Handler) http.Handler {
 	}
 }
 
+// refreshGrace is how long the token pair a refresh cookie was exchanged for is handed to further requests that
+// still carry the old cookie (e.g. parallel page and asset loads), instead of presenting the rotated token again
+// and tripping the API's reuse detection.
+const refreshGrace = 30 * time.Second
+
+// refreshResult is the outcome of exchanging one refresh token. done is closed once it is known.
+type refreshResult struct {
+	done         chan struct{}
+	token        string
+	refreshToken string
+	ok           bool
+	at           time.Time
+}
+
+// refreshes serializes refreshes per refresh token within this process.
+var refreshes = struct {
+	sync.Mutex
+	byToken map[string]*refreshResult
+}{byToken: make(map[string]*refreshResult)}
+
+// exchangeRefreshToken calls the API once per refresh token: concurrent and recent callers with the same token
+// share the result.
+func exchangeRefreshToken(apiBase, refreshToken string) (string, string, bool) {
+	refreshes.Lock()
+	for t, res := range refreshes.byToken {
+		select {
+		case <-res.done:
+			if time.Since(res.at) > refreshGrace {
+				delete(refreshes.byToken, t)
+			}
+		default:
+		}
+	}
+	res, ok := refreshes.byToken[refreshToken]
+	if !ok {
+		res = &refreshResult{done: make(chan struct{})}
+		refreshes.byToken[refreshToken] = res
+	}
+	refreshes.Unlock()
+	if ok {
+		<-res.done
+		return res.token, res.refreshToken, res.ok
+	}
+
+	defer func() {
+		res.at = time.Now()
+		close(res.done)
+		if !res.ok {
+			// Failures are not remembered: the next request tries again.
+			refreshes.Lock()
+			delete(refreshes.byToken, refreshToken)
+			refreshes.Unlock()
+		}
+	}()
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	data, status, err := apiPost(apiBase, "/auth/refresh", "", body)
+	if err != nil || status != http.StatusOK {
+		return "", "", false
+	}
+	var out struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if json.Unmarshal(data, &out) != nil || out.Token == "" {
+		return "", "", false
+	}
+	res.token, res.refreshToken, res.ok = out.Token, out.RefreshToken, true
+	return res.token, res.refreshToken, true
+}
+
+// refreshSession exchanges the refresh cookie for a new token pair, sets both cookies on the response and
+// rewrites the request's cookies so handlers further down read the new access token.
+func refreshSession(w http.ResponseWriter, r *http.Request, apiBase string) (string, bool) {
+	c, err := r.Cookie(refreshCookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	token, refreshToken, ok := exchangeRefreshToken(apiBase, c.Value)
+	if !ok {
+		return "", false
+	}
+	setSessionCookies(w, token, refreshToken)
+
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, ck := range cookies {
+		switch ck.Name {
+		case cookieName:
+			continue
+		case refreshCookieName:
+			if refreshToken != "" {
+				continue
+			}
+		}
+		r.AddCookie(ck)
+	}
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+	if refreshToken != "" {
+		r.AddCookie(&http.Cookie{Name: refreshCookieName, Value: refreshToken})
+	}
+	return token, true
+}
+
+// setSessionCookies stores the access token and, when present, the refresh token as HttpOnly cookies.
+// The access cookie outlives the short-lived JWT on purpose: requireAuth renews it when the API returns 401.
+func setSessionCookies(w http.ResponseWriter, token, refresh string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if refresh != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    refresh,
+			Path:     "/",
+			MaxAge:   30 * 24 * 3600,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
 func redirectDashboard(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/dashboard", http.StatusFound)
 }
 
-func loginForm(w http.ResponseWriter, r *http.Request) {
-	if _, err := r.Cookie(cookieName); err == nil {
-		http.Redirect(w, r, "/dashboard", http.StatusFound)
-		return
+func loginForm(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(cookieName); err == nil {
+			http.Redirect(w, r, "/dashboard", http.StatusFound)
+			return
+		}
+		data := map[string]interface{}{}
+		if msg := r.URL.Query().Get("msg"); msg != "" {
+			data["Message"] = msg
+		}
+		data["Next"] = r.URL.Query().Get("next")
+		if cfg, ok := fetchOIDCConfig(apiBase); ok && cfg.Enabled {
+			data["SSO"] = true
+		}
+		renderTemplate(w, r, "login.html", data)
+	}
+}
+
+// oidcConfig is the API's GET /auth/oidc/config response.
+type oidcConfig struct {
+	Enabled               bool     `json:"enabled"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	ClientID              string   `json:"client_id"`
+	Scopes                []string `json:"scopes"`
+}
+
+func fetchOIDCConfig(apiBase string) (oidcConfig, bool) {
+	var cfg oidcConfig
+	data, status, err := apiGet(apiBase, "/auth/oidc/config", "")
+	if err != nil || status != http.StatusOK || json.Unmarshal(data, &cfg) != nil {
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// oidcRedirectURI is the callback registered at the identity provider: HCI_WEB_OIDC_REDIRECT_URL, or derived from the request.
+func oidcRedirectURI(r *http.Request) string {
+	if u := os.Getenv(envOIDCRedirectURL); u != "" {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/auth/oidc/callback"
+}
+
+// oidcStart begins the authorization-code + PKCE flow: state, nonce and verifier go into a short-lived
+// HttpOnly cookie and the browser is sent to the identity provider.
+func oidcStart(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := fetchOIDCConfig(apiBase)
+		if !ok || !cfg.Enabled {
+			renderTemplate(w, r, "login.html", map[string]string{"Error": "Single sign-on is not available"})
+			return
+		}
+		state, err1 := oidc.RandomString()
+		nonce, err2 := oidc.RandomString()
+		verifier, err3 := oidc.RandomString()
+		if err1 != nil || err2 != nil || err3 != nil {
+			http.Error(w, "cannot start sign-in", http.StatusInternalServerError)
+			return
+		}
+		next := r.URL.Query().Get("next")
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+			next = "/dashboard"
+		}
+		flow := url.Values{"state": {state}, "nonce": {nonce}, "verifier": {verifier}, "next": {next}}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookieName,
+			Value:    flow.Encode(),
+			Path:     "/auth/oidc",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		q := url.Values{}
+		q.Set("response_type", "code")
+		q.Set("client_id", cfg.ClientID)
+		q.Set("redirect_uri", oidcRedirectURI(r))
+		q.Set("scope", strings.Join(cfg.Scopes, " "))
+		q.Set("state", state)
+		q.Set("nonce", nonce)
+		q.Set("code_challenge", oidc.CodeChallenge(verifier))
+		q.Set("code_challenge_method", "S256")
+		sep := "?"
+		if strings.Contains(cfg.AuthorizationEndpoint, "?") {
+			sep = "&"
+		}
+		http.Redirect(w, r, cfg.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
 	}
-	data := map[string]interface{}{}
-	if msg := r.URL.Query().Get("msg"); msg != "" {
-		data["Message"] = msg
+}
+
+// oidcCallback checks state, hands the code and verifier to the API and stores the returned session.
+func oidcCallback(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fail := func(msg string) {
+			http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: "/auth/oidc", MaxAge: -1})
+			renderTemplate(w, r, "login.html", map[string]string{"Error": msg})
+		}
+		c, err := r.Cookie(oidcCookieName)
+		if err != nil {
+			fail("Sign-in session expired, please try again")
+			return
+		}
+		flow, err := url.ParseQuery(c.Value)
+		if err != nil || flow.Get("state") == "" || r.URL.Query().Get("state") != flow.Get("state") {
+			fail("Sign-in failed: state mismatch")
+			return
+		}
+		if e := r.URL.Query().Get("error"); e != "" {
+			msg := r.URL.Query().Get("error_description")
+			if msg == "" {
+				msg = e
+			}
+			fail("Sign-in failed: " + msg)
+			return
+		}
+		body, _ := json.Marshal(map[string]string{
+			"code":          r.URL.Query().Get("code"),
+			"code_verifier": flow.Get("verifier"),
+			"redirect_uri":  oidcRedirectURI(r),
+			"nonce":         flow.Get("nonce"),
+		})
+		data, status, err := apiPost(apiBase, "/auth/oidc/exchange", "", body)
+		if err != nil {
+			fail("Cannot reach API: " + err.Error())
+			return
+		}
+		if status != http.StatusOK {
+			var errResp struct{ Error string }
+			_ = json.Unmarshal(data, &errResp)
+			if errResp.Error == "" {
+				errResp.Error = "sign-in failed"
+			}
+			fail(errResp.Error)
+			return
+		}
+		var out struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil || out.Token == "" {
+			fail("Invalid login response")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: "/auth/oidc", MaxAge: -1})
+		setSessionCookies(w, out.Token, out.RefreshToken)
+		http.Redirect(w, r, flow.Get("next")+"#main", http.StatusFound)
 	}
-	renderTemplate(w, r, "login.html", data)
 }
 
 func loginSubmit(apiBase string) http.HandlerFunc {
@@ -224,43 +594,172 @@ func loginSubmit(apiBase string) http.HandlerFunc {
 		}
 
 		var out struct {
-			Token string `json:"token"`
+			Token                 string `json:"token"`
+			RefreshToken          string `json:"refresh_token"`
+			MFARequired           bool   `json:"mfa_required"`
+			MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+			MFAToken              string `json:"mfa_token"`
 		}
-		if err := json.Unmarshal(data, &out); err != nil || out.Token == "" {
+		if err := json.Unmarshal(data, &out); err != nil {
+			renderTemplate(w, r, "login.html", map[string]string{"Error": "Invalid login response"})
+			return
+		}
+		switch {
+		case out.MFARequired:
+			renderTemplate(w, r, "login.html", map[string]interface{}{"MFAToken": out.MFAToken, "Next": r.FormValue("next")})
+			return
+		case out.MFAEnrollmentRequired:
+			renderMFAEnrollment(w, r, apiBase, out.MFAToken)
+			return
+		case out.Token == "":
 			renderTemplate(w, r, "login.html", map[string]string{"Error": "Invalid login response"})
 			return
 		}
 
-		next := r.URL.Query().Get("next")
-		if next == "" {
-			next = "/dashboard"
+		setSessionCookies(w, out.Token, out.RefreshToken)
+		http.Redirect(w, r, loginNext(r), http.StatusFound)
+	}
+}
+
+// loginNext is where to go after logging in: the local "next" path, or the dashboard.
+func loginNext(r *http.Request) string {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/dashboard"
+	}
+	// Append #main so layout can focus main content for accessibility after login
+	if !strings.Contains(next, "#") {
+		next = next + "#main"
+	}
+	return next
+}
+
+// mfaLoginResponse is the API's response to the second login step.
+type mfaLoginResponse struct {
+	Token         string   `json:"token"`
+	RefreshToken  string   `json:"refresh_token"`
+	RecoveryCodes []string `json:"recovery_codes"`
+	Error         string   `json:"error"`
+}
+
+// loginMFASubmit completes a login for a user with MFA enabled (TOTP or recovery code).
+func loginMFASubmit(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		mfaToken := r.FormValue("mfa_token")
+		body, _ := json.Marshal(map[string]string{"mfa_token": mfaToken, "code": strings.TrimSpace(r.FormValue("code"))})
+		data, status, err := apiPost(apiBase, "/auth/mfa/login", "", body)
+		var out mfaLoginResponse
+		if err != nil {
+			out.Error = "Cannot reach API: " + err.Error()
+		} else if json.Unmarshal(data, &out); status != http.StatusOK && out.Error == "" {
+			out.Error = string(data)
 		}
-		// Append #main so layout can focus main content for accessibility after login
-		if !strings.Contains(next, "#") {
-			next = next + "#main"
+		if out.Token == "" {
+			if out.Error == "" {
+				out.Error = "Invalid login response"
+			}
+			if strings.Contains(out.Error, "mfa token") {
+				renderTemplate(w, r, "login.html", map[string]string{"Error": "Login expired, please log in again"})
+				return
+			}
+			renderTemplate(w, r, "login.html", map[string]interface{}{"MFAToken": mfaToken, "Next": r.FormValue("next"), "Error": out.Error})
+			return
 		}
+		setSessionCookies(w, out.Token, out.RefreshToken)
+		http.Redirect(w, r, loginNext(r), http.StatusFound)
+	}
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     cookieName,
-			Value:    out.Token,
-			Path:     "/",
-			MaxAge:   24 * 3600,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-		})
-		http.Redirect(w, r, next, http.StatusFound)
+// renderMFAEnrollment starts the enrollment the API requires before an admin can log in and shows the QR code.
+func renderMFAEnrollment(w http.ResponseWriter, r *http.Request, apiBase, mfaToken string) {
+	body, _ := json.Marshal(map[string]string{"mfa_token": mfaToken})
+	data, status, err := apiPost(apiBase, "/auth/mfa/login/enroll", "", body)
+	var out struct {
+		Secret string `json:"secret"`
+		QRCode string `json:"qr_code"`
+	}
+	if err != nil || status != http.StatusOK || json.Unmarshal(data, &out) != nil {
+		renderTemplate(w, r, "login.html", map[string]string{"Error": "Could not start two-factor enrollment, please log in again"})
+		return
 	}
+	renderTemplate(w, r, "login.html", map[string]interface{}{
+		"Enroll":   true,
+		"MFAToken": mfaToken,
+		"Secret":   out.Secret,
+		"QRCode":   qrCodeURL(out.QRCode),
+		"Next":     r.FormValue("next"),
+	})
 }
 
-func logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "", Path: "/", MaxAge: -1})
-	http.Redirect(w, r, "/login", http.StatusFound)
+// qrCodeURL marks a PNG data URI as safe for an <img src>; anything else (the value round-trips through the
+// enrollment form) is dropped.
+func qrCodeURL(s string) template.URL {
+	if !strings.HasPrefix(s, "data:image/png;base64,") {
+		return ""
+	}
+	return template.URL(s)
+}
+
+// loginMFAEnrollSubmit confirms forced enrollment, logs the user in and shows their recovery codes once.
+func loginMFAEnrollSubmit(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		mfaToken := r.FormValue("mfa_token")
+		body, _ := json.Marshal(map[string]string{"mfa_token": mfaToken, "code": strings.TrimSpace(r.FormValue("code"))})
+		data, status, err := apiPost(apiBase, "/auth/mfa/login/enable", "", body)
+		var out mfaLoginResponse
+		if err != nil {
+			renderTemplate(w, r, "login.html", map[string]string{"Error": "Cannot reach API: " + err.Error()})
+			return
+		}
+		if json.Unmarshal(data, &out); status != http.StatusOK || out.Token == "" {
+			// A wrong code leaves the pending secret in place, so show the same QR code again.
+			renderTemplate(w, r, "login.html", map[string]interface{}{
+				"Enroll":   true,
+				"MFAToken": mfaToken,
+				"Secret":   r.FormValue("secret"),
+				"QRCode":   qrCodeURL(r.FormValue("qr_code")),
+				"Next":     r.FormValue("next"),
+				"Error":    "Invalid code, try again",
+			})
+			return
+		}
+		setSessionCookies(w, out.Token, out.RefreshToken)
+		renderTemplate(w, r, "login.html", map[string]interface{}{"RecoveryCodes": out.RecoveryCodes, "Continue": loginNext(r)})
+	}
+}
+
+// logout revokes the session on the API (access token and refresh token family), then clears the cookies.
+func logout(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(cookieName); err == nil && c.Value != "" {
+			payload := map[string]string{}
+			if rc, err := r.Cookie(refreshCookieName); err == nil && rc.Value != "" {
+				payload["refresh_token"] = rc.Value
+			}
+			body, _ := json.Marshal(payload)
+			if _, _, err := apiPost(apiBase, "/auth/logout", c.Value, body); err != nil {
+				log.Printf("logout: %v", err)
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "", Path: "/", MaxAge: -1})
+		http.SetCookie(w, &http.Cookie{Name: refreshCookieName, Value: "", Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
 }
 
 // clearAuthAndRedirectToLogin clears the token cookie and redirects to login with next=current path.
 // If msg is non-empty, adds msg= to the login URL so the login page can show it (e.g. "Session expired").
 func clearAuthAndRedirectToLogin(w http.ResponseWriter, r *http.Request, msg string) {
 	http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookieName, Value: "", Path: "/", MaxAge: -1})
 	next := r.URL.Path
 	if r.URL.RawQuery != "" {
 		next = next + "?" + r.URL.RawQuery
@@ -373,10 +872,32 @@ func dashboard(apiBase string) http.HandlerFunc {
 		renderTemplate(w, r, "dashboard.html", map[string]interface{}{
 			"AssetCount": listResp.Total,
 			"Assets":    listResp.Items,
+			"Outages":   fetchOutageImpact(apiBase, tok),
+			"Site":      selectedSite(r),
 		})
 	}
 }
 
+// outageImpact is an item of the API's GET /impact/offline response.
+type outageImpact struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	LastSeen *string `json:"last_seen"`
+	Impacted int     `json:"impacted"`
+}
+
+// fetchOutageImpact returns the offline assets that impact others, most impact first. Errors hide the section.
+func fetchOutageImpact(apiBase, tok string) []outageImpact {
+	var resp struct {
+		Items []outageImpact `json:"items"`
+	}
+	data, status, err := apiGet(apiBase, "/impact/offline", tok)
+	if err != nil || status != http.StatusOK || json.Unmarshal(data, &resp) != nil {
+		return nil
+	}
+	return resp.Items
+}
+
 func assetsList(apiBase string) http.HandlerFunc {
 	const pageSize = 20
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -411,6 +932,8 @@ func assetsList(apiBase string) http.HandlerFunc {
 			Description string   `json:"description"`
 			Tags        []string `json:"tags"`
 			NetworkName string   `json:"network_name"`
+			Lifecycle   string   `json:"lifecycle"`
+			Owner       string   `json:"owner"`
 			LastSeen    *string  `json:"last_seen"`
 		}{}
 		errData := map[string]interface{}{
@@ -440,6 +963,8 @@ func assetsList(apiBase string) http.HandlerFunc {
 				Description string   `json:"description"`
 				Tags        []string `json:"tags"`
 				NetworkName string   `json:"network_name"`
+				Lifecycle   string   `json:"lifecycle"`
+				Owner       string   `json:"owner"`
 				LastSeen    *string  `json:"last_seen"`
 			} `json:"items"`
 			Total  int `json:"total"`
@@ -504,22 +1029,57 @@ func assetDetail(apiBase string) http.HandlerFunc {
 		}
 
 		var asset struct {
-			ID          int      `json:"id"`
-			Name        string   `json:"name"`
-			Description string   `json:"description"`
-			Tags        []string `json:"tags"`
-			NetworkName string   `json:"network_name"`
-			LastSeen    *string  `json:"last_seen"`
+			ID              int      `json:"id"`
+			Name            string   `json:"name"`
+			Description     string   `json:"description"`
+			Tags            []string `json:"tags"`
+			NetworkName     string   `json:"network_name"`
+			Lifecycle       string   `json:"lifecycle"`
+			Owner           string   `json:"owner"`
+			LastSeen        *string  `json:"last_seen"`
+			IPExcludedUntil *string  `json:"ip_excluded_until"`
 		}
 		if err := json.Unmarshal(data, &asset); err != nil {
 			renderTemplate(w, r, "asset_detail.html", map[string]interface{}{"Error": "Invalid asset response"})
 			return
 		}
 
+		// The audit tab lists the latest entries about the asset.
+		if r.URL.Query().Get("tab") == "audit" {
+			filters := url.Values{"resource_type": {"asset"}, "resource_id": {id}}
+			params := auditAPIQuery(filters)
+			params.Set("limit", "50")
+			entries, total, _, errMsg := fetchAudit(apiBase, tok, params)
+			renderTemplate(w, r, "asset_detail.html", map[string]interface{}{
+				"Asset":        asset,
+				"Tab":          "audit",
+				"AuditEntries": entries,
+				"AuditTotal":   total,
+				"AuditError":   errMsg,
+				"AuditURL":     "/audit?" + filters.Encode(),
+			})
+			return
+		}
+
+		var history struct {
+			Items []struct {
+				FromState string `json:"from_state"`
+				ToState   string `json:"to_state"`
+				Reason    string `json:"reason"`
+				UserID    *int   `json:"user_id"`
+				CreatedAt string `json:"created_at"`
+			} `json:"items"`
+		}
+		if data, status, err := apiGet(apiBase, "/assets/"+id+"/lifecycle", tok); err == nil && status == http.StatusOK {
+			_ = json.Unmarshal(data, &history)
+		}
+
 		heartbeatError := r.URL.Query().Get("heartbeat_error") == "1"
 		renderTemplate(w, r, "asset_detail.html", map[string]interface{}{
 			"Asset":         asset,
+			"History":       history.Items,
 			"HeartbeatError": heartbeatError,
+			"LifecycleError": r.URL.Query().Get("error"),
 		})
 	}
 }
@@ -599,10 +1159,13 @@ func assetCreate(apiBase string) http.HandlerFunc {
 			tok = token.Value
 		}
 
-		payload := map[string]interface{}{"name": name, "description": description}
+		payload := map[string]interface{}{"name": name, "description": description, "owner": strings.TrimSpace(r.FormValue("owner"))}
 		if len(tags) > 0 {
 			payload["tags"] = tags
 		}
+		if lifecycle := r.FormValue("lifecycle"); lifecycle != "" {
+			payload["lifecycle"] = lifecycle
+		}
 		body, _ := json.Marshal(payload)
 		data, status, err := apiPost(apiBase, "/assets", tok, body)
 		if err != nil {
@@ -675,6 +1238,8 @@ func assetEditForm(apiBase string) http.HandlerFunc {
 			Description string   `json:"description"`
 			Tags        []string `json:"tags"`
 			NetworkName string   `json:"network_name"`
+			Lifecycle   string   `json:"lifecycle"`
+			Owner       string   `json:"owner"`
 			LastSeen    *string  `json:"last_seen"`
 		}
 		if err := json.Unmarshal(data, &asset); err != nil {
@@ -718,7 +1283,10 @@ func assetUpdate(apiBase string) http.HandlerFunc {
 			tok = token.Value
 		}
 
-		payload := map[string]interface{}{"name": name, "description": description, "tags": tags}
+		payload := map[string]interface{}{"name": name, "description": description, "tags": tags, "owner": strings.TrimSpace(r.FormValue("owner"))}
+		if lifecycle := r.FormValue("lifecycle"); lifecycle != "" {
+			payload["lifecycle"] = lifecycle
+		}
 		body, _ := json.Marshal(payload)
 		data, status, err := apiPut(apiBase, "/assets/"+id, tok, body)
 		if err != nil {
@@ -889,17 +1457,38 @@ func assetsBatchDelete(apiBase string) http.HandlerFunc {
 	}
 }
 
-func scanPage(apiBase string) http.HandlerFunc {
+// apiErrorMessage returns the error message of an API error response, or its (truncated) body.
+func apiErrorMessage(data []byte) string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &resp) == nil && resp.Error != "" {
+		return resp.Error
+	}
+	msg := string(data)
+	if len(msg) > 150 {
+		msg = msg[:150] + "..."
+	}
+	return msg
+}
+
+func assetDecommission(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
 		token, _ := r.Cookie(cookieName)
 		tok := ""
 		if token != nil {
 			tok = token.Value
 		}
+		if err := r.ParseForm(); err != nil {
+			http.Redirect(w, r, "/assets/"+id+"?error=invalid+form", http.StatusFound)
+			return
+		}
 
-		data, status, err := apiGet(apiBase, "/scans", tok)
+		body, _ := json.Marshal(map[string]string{"reason": strings.TrimSpace(r.FormValue("reason"))})
+		data, status, err := apiPost(apiBase, "/assets/"+id+"/decommission", tok, body)
 		if err != nil {
-			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": err.Error()})
+			http.Redirect(w, r, "/assets/"+id+"?error="+url.QueryEscape(err.Error()), http.StatusFound)
 			return
 		}
 		if status == http.StatusUnauthorized {
@@ -907,51 +1496,31 @@ func scanPage(apiBase string) http.HandlerFunc {
 			return
 		}
 		if status != http.StatusOK {
-			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": "API error: " + string(data)})
-			return
-		}
-
-		var listResp struct {
-			Items []struct {
-				ID        int    `json:"id"`
-				Target    string `json:"target"`
-				Status    string `json:"status"`
-				StartedAt string `json:"started_at"`
-			} `json:"items"`
-		}
-		if err := json.Unmarshal(data, &listResp); err != nil {
-			renderTemplate(w, r, "scan.html", map[string]interface{}{})
+			http.Redirect(w, r, "/assets/"+id+"?error="+url.QueryEscape("Decommission failed: "+apiErrorMessage(data)), http.StatusFound)
 			return
 		}
-
-		renderTemplate(w, r, "scan.html", map[string]interface{}{
-			"Scans": listResp.Items,
-		})
+		http.Redirect(w, r, "/assets/"+id, http.StatusFound)
 	}
 }
 
-func startScan(apiBase string) http.HandlerFunc {
+func assetsTrash(apiBase string) http.HandlerFunc {
+	const pageSize = 20
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "bad form", http.StatusBadRequest)
-			return
-		}
-		target := strings.TrimSpace(r.FormValue("target"))
-		if target == "" {
-			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": "Target is required (e.g. 192.168.1.0/24 or hostname)"})
-			return
-		}
-
 		token, _ := r.Cookie(cookieName)
 		tok := ""
 		if token != nil {
 			tok = token.Value
 		}
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			if n, err := strconv.Atoi(p); err == nil && n > 0 {
+				page = n
+			}
+		}
 
-		body := []byte(fmt.Sprintf(`{"target":%q}`, target))
-		data, status, err := apiPost(apiBase, "/scans", tok, body)
+		data, status, err := apiGet(apiBase, fmt.Sprintf("/assets/trash?limit=%d&offset=%d", pageSize, (page-1)*pageSize), tok)
 		if err != nil {
-			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": err.Error()})
+			renderTemplate(w, r, "assets_trash.html", map[string]interface{}{"Error": err.Error()})
 			return
 		}
 		if status == http.StatusUnauthorized {
@@ -959,9 +1528,159 @@ func startScan(apiBase string) http.HandlerFunc {
 			return
 		}
 		if status != http.StatusOK {
-			var errResp struct{ Error string }
-			_ = json.Unmarshal(data, &errResp)
-			msg := errResp.Error
+			renderTemplate(w, r, "assets_trash.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			return
+		}
+
+		var listResp struct {
+			Items []struct {
+				ID          int     `json:"id"`
+				Name        string  `json:"name"`
+				NetworkName string  `json:"network_name"`
+				Lifecycle   string  `json:"lifecycle"`
+				Owner       string  `json:"owner"`
+				DeletedAt   *string `json:"deleted_at"`
+			} `json:"items"`
+			Total  int `json:"total"`
+			Offset int `json:"offset"`
+		}
+		if err := json.Unmarshal(data, &listResp); err != nil {
+			renderTemplate(w, r, "assets_trash.html", map[string]interface{}{"Error": "Invalid trash response"})
+			return
+		}
+		prevPage, nextPage := 0, 0
+		if page > 1 {
+			prevPage = page - 1
+		}
+		if listResp.Offset+len(listResp.Items) < listResp.Total {
+			nextPage = page + 1
+		}
+
+		renderTemplate(w, r, "assets_trash.html", map[string]interface{}{
+			"Assets":   listResp.Items,
+			"Total":    listResp.Total,
+			"PrevPage": prevPage,
+			"NextPage": nextPage,
+			"Message":  r.URL.Query().Get("error"),
+		})
+	}
+}
+
+// assetTrashAction posts to the API's trash endpoints and returns to the trash page.
+func assetTrashAction(apiBase string, restore bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		var data []byte
+		var status int
+		var err error
+		if restore {
+			data, status, err = apiPost(apiBase, "/assets/trash/"+id+"/restore", tok, []byte("{}"))
+		} else {
+			data, status, err = apiDelete(apiBase, "/assets/trash/"+id, tok)
+		}
+		if err != nil {
+			http.Redirect(w, r, "/assets/trash?error="+url.QueryEscape(err.Error()), http.StatusFound)
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK && status != http.StatusNoContent {
+			http.Redirect(w, r, "/assets/trash?error="+url.QueryEscape(apiErrorMessage(data)), http.StatusFound)
+			return
+		}
+		if restore {
+			http.Redirect(w, r, "/assets/"+id, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/assets/trash", http.StatusFound)
+	}
+}
+
+func assetRestore(apiBase string) http.HandlerFunc { return assetTrashAction(apiBase, true) }
+
+func assetPurge(apiBase string) http.HandlerFunc { return assetTrashAction(apiBase, false) }
+
+func scanPage(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		data, status, err := apiGet(apiBase, "/scans", tok)
+		if err != nil {
+			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK {
+			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			return
+		}
+
+		var listResp struct {
+			Items []struct {
+				ID        int    `json:"id"`
+				Target    string `json:"target"`
+				Status    string `json:"status"`
+				StartedAt string `json:"started_at"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(data, &listResp); err != nil {
+			renderTemplate(w, r, "scan.html", map[string]interface{}{})
+			return
+		}
+
+		renderTemplate(w, r, "scan.html", map[string]interface{}{
+			"Scans": listResp.Items,
+		})
+	}
+}
+
+func startScan(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		target := strings.TrimSpace(r.FormValue("target"))
+		if target == "" {
+			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": "Target is required (e.g. 192.168.1.0/24 or hostname)"})
+			return
+		}
+
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		body := []byte(fmt.Sprintf(`{"target":%q}`, target))
+		data, status, err := apiPost(apiBase, "/scans", tok, body)
+		if err != nil {
+			renderTemplate(w, r, "scan.html", map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK {
+			var errResp struct{ Error string }
+			_ = json.Unmarshal(data, &errResp)
+			msg := errResp.Error
 			if msg == "" {
 				msg = string(data)
 			}
@@ -1410,57 +2129,159 @@ func schedulesList(apiBase string) http.HandlerFunc {
 
 		var listResp struct {
 			Items []struct {
-				ID        int       `json:"id"`
-				Target    string    `json:"target"`
-				CronExpr  string    `json:"cron_expr"`
-				Enabled   bool      `json:"enabled"`
-				CreatedAt time.Time `json:"created_at"`
+				ID                  int        `json:"id"`
+				Target              string     `json:"target"`
+				SavedScanID         int        `json:"saved_scan_id"`
+				SavedScanName       string     `json:"-"`
+				AssetTag            string     `json:"asset_tag"`
+				CronExpr            string     `json:"cron_expr"`
+				Timezone            string     `json:"timezone"`
+				Enabled             bool       `json:"enabled"`
+				CreatedAt           time.Time  `json:"created_at"`
+				LastRunAt           *time.Time `json:"last_run_at"`
+				LastStatus          string     `json:"last_status"`
+				ConsecutiveFailures int        `json:"consecutive_failures"`
+				NextRunAt           *time.Time `json:"next_run_at"`
 			} `json:"items"`
 		}
 		if err := json.Unmarshal(data, &listResp); err != nil {
 			renderTemplate(w, r, "schedules.html", map[string]interface{}{"Error": "Invalid schedules response"})
 			return
 		}
+		names := make(map[int]string)
+		for _, ss := range scheduleSavedScans(apiBase, tok) {
+			names[ss.ID] = ss.Name
+		}
+		for i, sc := range listResp.Items {
+			if sc.SavedScanID != 0 {
+				listResp.Items[i].SavedScanName = names[sc.SavedScanID]
+			}
+		}
+
+		renderTemplate(w, r, "schedules.html", map[string]interface{}{
+			"Schedules": listResp.Items,
+			"Message":   r.URL.Query().Get("error"),
+		})
+	}
+}
+
+// scheduleSavedScan is a saved scan a schedule can run.
+type scheduleSavedScan struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
 
-		renderTemplate(w, r, "schedules.html", map[string]interface{}{"Schedules": listResp.Items})
+// scheduleSavedScans returns the saved scans for the schedule form and list (none if the API call fails).
+func scheduleSavedScans(apiBase, tok string) []scheduleSavedScan {
+	data, status, err := apiGet(apiBase, "/saved-scans", tok)
+	if err != nil || status != http.StatusOK {
+		return nil
 	}
+	var listResp struct {
+		Items []scheduleSavedScan `json:"items"`
+	}
+	if err := json.Unmarshal(data, &listResp); err != nil {
+		return nil
+	}
+	return listResp.Items
 }
 
 func scheduleCreateForm(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
 		renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
 			"FormAction":  "/schedules",
 			"SubmitLabel": "Create schedule",
+			"SavedScans":  scheduleSavedScans(apiBase, tok),
 		})
 	}
 }
 
+// scheduleFormPayload builds the API body of a schedule from the create/edit form. The source field selects
+// whether the schedule scans the target, a saved scan or an asset group (tag). Blackout windows are one per
+// line ("sat,sun 22:00-06:00"). It returns a message for the user when the form is incomplete or invalid.
+func scheduleFormPayload(r *http.Request) (map[string]interface{}, string) {
+	cronExpr := strings.TrimSpace(r.FormValue("cron_expr"))
+	payload := map[string]interface{}{}
+	switch r.FormValue("source") {
+	case "saved_scan":
+		id, err := strconv.Atoi(r.FormValue("saved_scan_id"))
+		if err != nil {
+			return nil, "Choose a saved scan"
+		}
+		payload["saved_scan_id"] = id
+	case "asset_group":
+		tag := strings.TrimSpace(r.FormValue("asset_tag"))
+		if tag == "" {
+			return nil, "Asset group tag is required"
+		}
+		payload["asset_tag"] = tag
+	default:
+		target := strings.TrimSpace(r.FormValue("target"))
+		if target == "" {
+			return nil, "Target and cron expression are required"
+		}
+		payload["target"] = target
+	}
+	if cronExpr == "" {
+		return nil, "Target and cron expression are required"
+	}
+	jitter := 0
+	if j := strings.TrimSpace(r.FormValue("jitter_seconds")); j != "" {
+		n, err := strconv.Atoi(j)
+		if err != nil {
+			return nil, "Jitter must be a number of seconds"
+		}
+		jitter = n
+	}
+	windows := []models.BlackoutWindow{}
+	for _, line := range strings.Split(r.FormValue("blackout_windows"), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		bw, err := models.ParseBlackoutWindow(line)
+		if err != nil {
+			return nil, "Blackout window: " + err.Error()
+		}
+		windows = append(windows, bw)
+	}
+	payload["cron_expr"] = cronExpr
+	payload["enabled"] = r.FormValue("enabled") == "1"
+	payload["timezone"] = strings.TrimSpace(r.FormValue("timezone"))
+	payload["blackout_windows"] = windows
+	payload["blackout_action"] = r.FormValue("blackout_action")
+	payload["jitter_seconds"] = jitter
+	payload["overlap_policy"] = r.FormValue("overlap_policy")
+	return payload, ""
+}
+
 func scheduleCreate(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "bad form", http.StatusBadRequest)
 			return
 		}
-		target := strings.TrimSpace(r.FormValue("target"))
-		cronExpr := strings.TrimSpace(r.FormValue("cron_expr"))
-		enabled := r.FormValue("enabled") == "1"
-
-		if target == "" || cronExpr == "" {
+		payload, formErr := scheduleFormPayload(r)
+		if formErr != "" {
 			renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
-				"Error":       "Target and cron expression are required",
+				"Error":       formErr,
 				"FormAction":  "/schedules",
 				"SubmitLabel": "Create schedule",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
 			})
 			return
 		}
 
-		token, _ := r.Cookie(cookieName)
-		tok := ""
-		if token != nil {
-			tok = token.Value
-		}
-
-		payload := map[string]interface{}{"target": target, "cron_expr": cronExpr, "enabled": enabled}
 		body, _ := json.Marshal(payload)
 		data, status, err := apiPost(apiBase, "/schedules", tok, body)
 		if err != nil {
@@ -1468,6 +2289,7 @@ func scheduleCreate(apiBase string) http.HandlerFunc {
 				"Error":       err.Error(),
 				"FormAction":  "/schedules",
 				"SubmitLabel": "Create schedule",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
 			})
 			return
 		}
@@ -1480,6 +2302,7 @@ func scheduleCreate(apiBase string) http.HandlerFunc {
 				"Error":       "API error: " + string(data),
 				"FormAction":  "/schedules",
 				"SubmitLabel": "Create schedule",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
 			})
 			return
 		}
@@ -1488,6 +2311,86 @@ func scheduleCreate(apiBase string) http.HandlerFunc {
 	}
 }
 
+func scheduleRuns(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		data, status, err := apiGet(apiBase, "/schedules/"+id+"/runs?limit=100", tok)
+		if err != nil {
+			renderTemplate(w, r, "schedule_runs.html", map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK {
+			renderTemplate(w, r, "schedule_runs.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			return
+		}
+
+		var listResp struct {
+			Items []struct {
+				ID          int        `json:"id"`
+				Target      string     `json:"target"`
+				Status      string     `json:"status"`
+				StartedAt   time.Time  `json:"started_at"`
+				CompletedAt *time.Time `json:"completed_at"`
+				Error       string     `json:"error"`
+				SkipReason  string     `json:"skip_reason"`
+			} `json:"items"`
+			Total int `json:"total"`
+		}
+		if err := json.Unmarshal(data, &listResp); err != nil {
+			renderTemplate(w, r, "schedule_runs.html", map[string]interface{}{"Error": "Invalid runs response"})
+			return
+		}
+
+		renderTemplate(w, r, "schedule_runs.html", map[string]interface{}{
+			"ScheduleID": id,
+			"Runs":       listResp.Items,
+			"Total":      listResp.Total,
+		})
+	}
+}
+
+func scheduleRun(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+		data, status, err := apiPost(apiBase, "/schedules/"+id+"/run", tok, []byte("{}"))
+		if err != nil {
+			http.Redirect(w, r, "/schedules?error="+url.QueryEscape(err.Error()), http.StatusFound)
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK {
+			http.Redirect(w, r, "/schedules?error=Failed+to+start+scan", http.StatusFound)
+			return
+		}
+		var out struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.Unmarshal(data, &out); err == nil && out.JobID != "" {
+			http.Redirect(w, r, "/scans/"+out.JobID, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/schedules", http.StatusFound)
+	}
+}
+
 func scheduleEditForm(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -1512,21 +2415,35 @@ func scheduleEditForm(apiBase string) http.HandlerFunc {
 		}
 
 		var schedule struct {
-			ID        int       `json:"id"`
-			Target    string    `json:"target"`
-			CronExpr  string    `json:"cron_expr"`
-			Enabled   bool      `json:"enabled"`
-			CreatedAt time.Time `json:"created_at"`
+			ID              int                     `json:"id"`
+			Target          string                  `json:"target"`
+			SavedScanID     int                     `json:"saved_scan_id"` // 0 when the schedule has a target or asset group
+			AssetTag        string                  `json:"asset_tag"`
+			CronExpr        string                  `json:"cron_expr"`
+			Enabled         bool                    `json:"enabled"`
+			CreatedAt       time.Time               `json:"created_at"`
+			Timezone        string                  `json:"timezone"`
+			BlackoutWindows []models.BlackoutWindow `json:"blackout_windows"`
+			BlackoutAction  string                  `json:"blackout_action"`
+			JitterSeconds   int                     `json:"jitter_seconds"`
+			OverlapPolicy   string                  `json:"overlap_policy"`
+			BlackoutText    string                  `json:"-"` // windows one per line, for the textarea
 		}
 		if err := json.Unmarshal(data, &schedule); err != nil {
 			renderTemplate(w, r, "schedule_form.html", map[string]interface{}{"Error": "Invalid schedule response"})
 			return
 		}
+		lines := make([]string, 0, len(schedule.BlackoutWindows))
+		for _, bw := range schedule.BlackoutWindows {
+			lines = append(lines, bw.String())
+		}
+		schedule.BlackoutText = strings.Join(lines, "\n")
 
 		renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
 			"Schedule":    schedule,
 			"FormAction":  "/schedules/" + id + "/edit",
 			"SubmitLabel": "Save changes",
+			"SavedScans":  scheduleSavedScans(apiBase, tok),
 		})
 	}
 }
@@ -1534,37 +2451,34 @@ func scheduleEditForm(apiBase string) http.HandlerFunc {
 func scheduleUpdate(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "bad form", http.StatusBadRequest)
 			return
 		}
-		target := strings.TrimSpace(r.FormValue("target"))
-		cronExpr := strings.TrimSpace(r.FormValue("cron_expr"))
-		enabled := r.FormValue("enabled") == "1"
-
-		if target == "" || cronExpr == "" {
+		payload, formErr := scheduleFormPayload(r)
+		if formErr != "" {
 			renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
-				"Error":       "Target and cron expression are required",
+				"Error":       formErr,
 				"FormAction":  "/schedules/" + id + "/edit",
 				"SubmitLabel": "Save changes",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
 			})
 			return
 		}
 
-		token, _ := r.Cookie(cookieName)
-		tok := ""
-		if token != nil {
-			tok = token.Value
-		}
-
-		payload := map[string]interface{}{"target": target, "cron_expr": cronExpr, "enabled": enabled}
 		body, _ := json.Marshal(payload)
-		_, status, err := apiPut(apiBase, "/schedules/"+id, tok, body)
+		data, status, err := apiPut(apiBase, "/schedules/"+id, tok, body)
 		if err != nil {
 			renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
 				"Error":       err.Error(),
 				"FormAction":  "/schedules/" + id + "/edit",
 				"SubmitLabel": "Save changes",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
 			})
 			return
 		}
@@ -1572,6 +2486,15 @@ func scheduleUpdate(apiBase string) http.HandlerFunc {
 			clearAuthAndRedirectToLogin(w, r, "")
 			return
 		}
+		if status == http.StatusBadRequest {
+			renderTemplate(w, r, "schedule_form.html", map[string]interface{}{
+				"Error":       "API error: " + string(data),
+				"FormAction":  "/schedules/" + id + "/edit",
+				"SubmitLabel": "Save changes",
+				"SavedScans":  scheduleSavedScans(apiBase, tok),
+			})
+			return
+		}
 		if status != http.StatusOK {
 			http.Redirect(w, r, "/schedules/"+id+"/edit", http.StatusFound)
 			return
@@ -1645,6 +2568,80 @@ func scheduleDelete(apiBase string) http.HandlerFunc {
 
 // ====== Audit log (Web UI) ======
 
+// auditEntry is an audit log entry from GET /audit.
+type auditEntry struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	Action       string    `json:"action"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   int       `json:"resource_id"`
+	ActorType    string    `json:"actor_type"`
+	Actor        string    `json:"actor"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	Details      string    `json:"details"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// auditFilterNames are the filters of the audit page; since and until are dates (YYYY-MM-DD, UTC).
+var auditFilterNames = []string{"user_id", "actor", "action", "resource_type", "resource_id", "since", "until", "q"}
+
+// auditResourceTypes are offered by the audit page's resource filter.
+var auditResourceTypes = []string{"asset", "asset_relationship", "scan", "saved_scan", "schedule", "user", "role",
+	"access_policy", "api_key", "service_account", "site", "subnet", "retention"}
+
+// auditFilters returns the non-empty audit page filters in q.
+func auditFilters(q url.Values) url.Values {
+	filters := url.Values{}
+	for _, k := range auditFilterNames {
+		if v := strings.TrimSpace(q.Get(k)); v != "" {
+			filters.Set(k, v)
+		}
+	}
+	return filters
+}
+
+// auditAPIQuery converts audit page filters to GET /audit query parameters: the until date is included.
+func auditAPIQuery(filters url.Values) url.Values {
+	params := url.Values{}
+	for k, v := range filters {
+		params[k] = v
+	}
+	params.Del("since")
+	params.Del("until")
+	if d, err := time.Parse("2006-01-02", filters.Get("since")); err == nil {
+		params.Set("since", d.Format(time.RFC3339))
+	}
+	if d, err := time.Parse("2006-01-02", filters.Get("until")); err == nil {
+		params.Set("until", d.AddDate(0, 0, 1).Format(time.RFC3339))
+	}
+	return params
+}
+
+// fetchAudit lists audit entries matching the GET /audit query params. It returns the API status; errMsg is
+// set when the entries could not be listed.
+func fetchAudit(apiBase, tok string, params url.Values) (entries []auditEntry, total, status int, errMsg string) {
+	data, status, err := apiGet(apiBase, "/audit?"+params.Encode(), tok)
+	if err != nil {
+		return nil, 0, 0, err.Error()
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, 0, status, "You do not have permission to view the audit log."
+	default:
+		return nil, 0, status, "API error: " + string(data)
+	}
+	var listResp struct {
+		Items []auditEntry `json:"items"`
+		Total int          `json:"total"`
+	}
+	if err := json.Unmarshal(data, &listResp); err != nil {
+		return nil, 0, status, "Invalid audit response"
+	}
+	return listResp.Items, listResp.Total, status, ""
+}
+
 func auditList(apiBase string) http.HandlerFunc {
 	const defaultLimit = 50
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1654,73 +2651,220 @@ func auditList(apiBase string) http.HandlerFunc {
 			tok = token.Value
 		}
 
+		q := r.URL.Query()
 		limit := defaultLimit
-		if l := r.URL.Query().Get("limit"); l != "" {
+		if l := q.Get("limit"); l != "" {
 			if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 200 {
 				limit = n
 			}
 		}
 		offset := 0
-		if o := r.URL.Query().Get("offset"); o != "" {
+		if o := q.Get("offset"); o != "" {
 			if n, err := strconv.Atoi(o); err == nil && n >= 0 {
 				offset = n
 			}
 		}
+		filters := auditFilters(q)
+		current := make(map[string]string, len(filters))
+		for k := range filters {
+			current[k] = filters.Get(k)
+		}
+		pageLink := func(path string, extra url.Values) string {
+			p := url.Values{}
+			for k, v := range filters {
+				p[k] = v
+			}
+			for k, v := range extra {
+				p[k] = v
+			}
+			return path + "?" + p.Encode()
+		}
+		page := map[string]interface{}{
+			"Filters":       current,
+			"ResourceTypes": auditResourceTypes,
+			"ExportCSV":     pageLink("/audit/export", url.Values{"format": {"csv"}}),
+			"ExportNDJSON":  pageLink("/audit/export", url.Values{"format": {"ndjson"}}),
+		}
 
-		path := fmt.Sprintf("/audit?limit=%d&offset=%d", limit, offset)
-		data, status, err := apiGet(apiBase, path, tok)
+		params := auditAPIQuery(filters)
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+		entries, total, status, errMsg := fetchAudit(apiBase, tok, params)
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if errMsg != "" {
+			page["Error"] = errMsg
+			renderTemplate(w, r, "audit.html", page)
+			return
+		}
+
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		page["Entries"] = entries
+		page["Total"] = total
+		page["HasPrev"] = offset > 0
+		page["HasNext"] = offset+len(entries) < total
+		page["PrevURL"] = pageLink("/audit", url.Values{"limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(prevOffset)}})
+		page["NextURL"] = pageLink("/audit", url.Values{"limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(offset + limit)}})
+		renderTemplate(w, r, "audit.html", page)
+	}
+}
+
+// auditExport streams GET /audit/export (format csv or ndjson, with the audit page filters) to the browser
+// as a download.
+func auditExport(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		q := r.URL.Query()
+		filters := auditFilters(q)
+		params := auditAPIQuery(filters)
+		params.Set("format", "csv")
+		if q.Get("format") == "ndjson" {
+			params.Set("format", "ndjson")
+		}
+		page := map[string]interface{}{"Filters": map[string]string{}, "ResourceTypes": auditResourceTypes}
+
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", apiBase+"/audit/export?"+params.Encode(), nil)
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			renderTemplate(w, r, "audit.html", map[string]interface{}{"Error": err.Error()})
+			page["Error"] = err.Error()
+			renderTemplate(w, r, "audit.html", page)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			data, _ := io.ReadAll(resp.Body)
+			page["Error"] = "API error: " + string(data)
+			renderTemplate(w, r, "audit.html", page)
+			return
+		}
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.Header().Set("Content-Disposition", resp.Header.Get("Content-Disposition"))
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("audit export: %v", err)
+		}
+	}
+}
+
+// ====== Login events (Web UI) ======
+
+// loginEventsList shows login attempts from GET /auth/events (admin only) with optional filters.
+func loginEventsList(apiBase string) http.HandlerFunc {
+	const defaultLimit = 50
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		q := r.URL.Query()
+		limit := defaultLimit
+		if l := q.Get("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 200 {
+				limit = n
+			}
+		}
+		offset := 0
+		if o := q.Get("offset"); o != "" {
+			if n, err := strconv.Atoi(o); err == nil && n >= 0 {
+				offset = n
+			}
+		}
+		filters := url.Values{}
+		for _, k := range []string{"username", "result", "ip"} {
+			if v := strings.TrimSpace(q.Get(k)); v != "" {
+				filters.Set(k, v)
+			}
+		}
+		page := map[string]interface{}{
+			"Username": filters.Get("username"),
+			"Result":   filters.Get("result"),
+			"IP":       filters.Get("ip"),
+			"Results":  []string{"success", "failure", "locked", "mfa_challenge", "mfa_failure"},
+		}
+
+		params := url.Values{}
+		for k, v := range filters {
+			params[k] = v
+		}
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+		data, status, err := apiGet(apiBase, "/auth/events?"+params.Encode(), tok)
+		if err != nil {
+			page["Error"] = err.Error()
+			renderTemplate(w, r, "login_events.html", page)
 			return
 		}
 		if status == http.StatusUnauthorized {
 			clearAuthAndRedirectToLogin(w, r, "")
 			return
 		}
+		if status == http.StatusForbidden {
+			page["Error"] = "Only admins can view login events."
+			renderTemplate(w, r, "login_events.html", page)
+			return
+		}
 		if status != http.StatusOK {
-			renderTemplate(w, r, "audit.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			page["Error"] = "API error: " + string(data)
+			renderTemplate(w, r, "login_events.html", page)
 			return
 		}
 
 		var listResp struct {
-			Items  []struct {
-				ID           int       `json:"id"`
-				UserID       int       `json:"user_id"`
-				Action       string    `json:"action"`
-				ResourceType string    `json:"resource_type"`
-				ResourceID   int       `json:"resource_id"`
-				Details      string    `json:"details"`
-				CreatedAt    time.Time `json:"created_at"`
+			Items []struct {
+				UserID    *int      `json:"user_id"`
+				Username  string    `json:"username"`
+				Method    string    `json:"method"`
+				Result    string    `json:"result"`
+				IP        string    `json:"ip"`
+				UserAgent string    `json:"user_agent"`
+				CreatedAt time.Time `json:"created_at"`
 			} `json:"items"`
-			Total  int `json:"total"`
-			Limit  int `json:"limit"`
-			Offset int `json:"offset"`
+			Total int `json:"total"`
 		}
 		if err := json.Unmarshal(data, &listResp); err != nil {
-			renderTemplate(w, r, "audit.html", map[string]interface{}{"Error": "Invalid audit response"})
+			page["Error"] = "Invalid login events response"
+			renderTemplate(w, r, "login_events.html", page)
 			return
 		}
-		entries := listResp.Items
 
-		hasNext := listResp.Offset+len(listResp.Items) < listResp.Total
-		prevOffset := 0
-		if offset > 0 {
-			prevOffset = offset - limit
-			if prevOffset < 0 {
-				prevOffset = 0
-			}
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
 		}
-		nextOffset := offset + limit
-
-		renderTemplate(w, r, "audit.html", map[string]interface{}{
-			"Entries":    entries,
-			"Limit":      limit,
-			"Offset":     offset,
-			"PrevOffset": prevOffset,
-			"NextOffset": nextOffset,
-			"HasPrev":    offset > 0,
-			"HasNext":    hasNext,
-		})
+		pageLink := func(off int) string {
+			p := url.Values{}
+			for k, v := range filters {
+				p[k] = v
+			}
+			p.Set("limit", strconv.Itoa(limit))
+			p.Set("offset", strconv.Itoa(off))
+			return "/login-events?" + p.Encode()
+		}
+		page["Events"] = listResp.Items
+		page["Total"] = listResp.Total
+		page["HasPrev"] = offset > 0
+		page["HasNext"] = offset+len(listResp.Items) < listResp.Total
+		page["PrevURL"] = pageLink(prevOffset)
+		page["NextURL"] = pageLink(offset + limit)
+		renderTemplate(w, r, "login_events.html", page)
 	}
 }
 
@@ -1799,7 +2943,7 @@ func usersList(apiBase string) http.HandlerFunc {
 
 func userCreateForm(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		renderTemplate(w, r, "user_form.html", map[string]interface{}{
+		renderUserForm(w, r, apiBase, map[string]interface{}{
 			"FormAction":  "/users",
 			"SubmitLabel": "Create user",
 			"IsCreate":    true,
@@ -1814,12 +2958,13 @@ func userCreate(apiBase string) http.HandlerFunc {
 			return
 		}
 		username := strings.TrimSpace(r.FormValue("username"))
+		password := r.FormValue("password")
 		role := strings.TrimSpace(r.FormValue("role"))
 		if role == "" {
 			role = "viewer"
 		}
 		if username == "" {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{
+			renderUserForm(w, r, apiBase, map[string]interface{}{
 				"Error":       "Username is required",
 				"FormAction":  "/users",
 				"SubmitLabel": "Create user",
@@ -1834,10 +2979,10 @@ func userCreate(apiBase string) http.HandlerFunc {
 			tok = token.Value
 		}
 
-		body := []byte(fmt.Sprintf(`{"username":%q,"role":%q}`, username, role))
+		body, _ := json.Marshal(map[string]string{"username": username, "password": password, "role": role})
 		data, status, err := apiPost(apiBase, "/users", tok, body)
 		if err != nil {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{
+			renderUserForm(w, r, apiBase, map[string]interface{}{
 				"Error":       err.Error(),
 				"FormAction":  "/users",
 				"SubmitLabel": "Create user",
@@ -1867,7 +3012,7 @@ func userCreate(apiBase string) http.HandlerFunc {
 			if len(errResp.Fields) > 0 {
 				payload["Fields"] = errResp.Fields
 			}
-			renderTemplate(w, r, "user_form.html", payload)
+			renderUserForm(w, r, apiBase, payload)
 			return
 		}
 
@@ -1875,7 +3020,7 @@ func userCreate(apiBase string) http.HandlerFunc {
 			ID int `json:"id"`
 		}
 		if err := json.Unmarshal(data, &user); err != nil || user.ID == 0 {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{
+			renderUserForm(w, r, apiBase, map[string]interface{}{
 				"Error":       "Invalid create user response",
 				"FormAction":  "/users",
 				"SubmitLabel": "Create user",
@@ -1887,6 +3032,65 @@ func userCreate(apiBase string) http.HandlerFunc {
 	}
 }
 
+// userDetail shows a user with an audit tab listing changes to the user and
+// actions the user performed.
+func userDetail(apiBase string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		token, _ := r.Cookie(cookieName)
+		tok := ""
+		if token != nil {
+			tok = token.Value
+		}
+
+		data, status, err := apiGet(apiBase, "/users/"+id, tok)
+		if err != nil {
+			renderTemplate(w, r, "user_detail.html", map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		if status == http.StatusUnauthorized {
+			clearAuthAndRedirectToLogin(w, r, "")
+			return
+		}
+		if status != http.StatusOK {
+			renderTemplate(w, r, "user_detail.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			return
+		}
+
+		var account struct {
+			ID       int    `json:"id"`
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		}
+		if err := json.Unmarshal(data, &account); err != nil {
+			renderTemplate(w, r, "user_detail.html", map[string]interface{}{"Error": "Invalid user response"})
+			return
+		}
+
+		// "User" is taken by the logged-in user in the layout.
+		page := map[string]interface{}{"Account": account}
+		if r.URL.Query().Get("tab") == "audit" {
+			page["Tab"] = "audit"
+			changes := url.Values{"resource_type": {"user"}, "resource_id": {id}}
+			params := auditAPIQuery(changes)
+			params.Set("limit", "50")
+			entries, total, _, errMsg := fetchAudit(apiBase, tok, params)
+			page["ChangeEntries"], page["ChangeTotal"], page["ChangeURL"] = entries, total, "/audit?"+changes.Encode()
+
+			actions := url.Values{"user_id": {id}}
+			params = auditAPIQuery(actions)
+			params.Set("limit", "50")
+			entries, total, _, actionErr := fetchAudit(apiBase, tok, params)
+			page["ActionEntries"], page["ActionTotal"], page["ActionURL"] = entries, total, "/audit?"+actions.Encode()
+			if errMsg == "" {
+				errMsg = actionErr
+			}
+			page["AuditError"] = errMsg
+		}
+		renderTemplate(w, r, "user_detail.html", page)
+	}
+}
+
 func userEditForm(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -1898,7 +3102,7 @@ func userEditForm(apiBase string) http.HandlerFunc {
 
 		data, status, err := apiGet(apiBase, "/users/"+id, tok)
 		if err != nil {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{"Error": err.Error()})
+			renderUserForm(w, r, apiBase, map[string]interface{}{"Error": err.Error()})
 			return
 		}
 		if status == http.StatusUnauthorized {
@@ -1906,7 +3110,7 @@ func userEditForm(apiBase string) http.HandlerFunc {
 			return
 		}
 		if status != http.StatusOK {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{"Error": "API error: " + string(data)})
+			renderUserForm(w, r, apiBase, map[string]interface{}{"Error": "API error: " + string(data)})
 			return
 		}
 
@@ -1916,11 +3120,11 @@ func userEditForm(apiBase string) http.HandlerFunc {
 			Role     string `json:"role"`
 		}
 		if err := json.Unmarshal(data, &user); err != nil {
-			renderTemplate(w, r, "user_form.html", map[string]interface{}{"Error": "Invalid user response"})
+			renderUserForm(w, r, apiBase, map[string]interface{}{"Error": "Invalid user response"})
 			return
 		}
 
-		renderTemplate(w, r, "user_form.html", map[string]interface{}{
+		renderUserForm(w, r, apiBase, map[string]interface{}{
 			"User":        user,
 			"FormAction":  "/users/" + id + "/edit",
 			"SubmitLabel": "Save changes",
@@ -1946,7 +3150,7 @@ func userUpdate(apiBase string) http.HandlerFunc {
 			}
 		}
 		if username == "" {
-			renderTemplate(w, r, "user_form.html", editPayload("Username is required"))
+			renderUserForm(w, r, apiBase, editPayload("Username is required"))
 			return
 		}
 
@@ -1959,7 +3163,7 @@ func userUpdate(apiBase string) http.HandlerFunc {
 		body := []byte(fmt.Sprintf(`{"username":%q,"role":%q}`, username, role))
 		data, status, err := apiPut(apiBase, "/users/"+id, tok, body)
 		if err != nil {
-			renderTemplate(w, r, "user_form.html", editPayload(err.Error()))
+			renderUserForm(w, r, apiBase, editPayload(err.Error()))
 			return
 		}
 		if status == http.StatusUnauthorized {
@@ -1980,7 +3184,7 @@ func userUpdate(apiBase string) http.HandlerFunc {
 			if len(errResp.Fields) > 0 {
 				payload["Fields"] = errResp.Fields
 			}
-			renderTemplate(w, r, "user_form.html", payload)
+			renderUserForm(w, r, apiBase, payload)
 			return
 		}
 
@@ -1988,6 +3192,31 @@ func userUpdate(apiBase string) http.HandlerFunc {
 	}
 }
 
+// renderUserForm renders user_form.html with the role names from the API, falling back to the built-in
+// roles if they cannot be listed.
+func renderUserForm(w http.ResponseWriter, r *http.Request, apiBase string, data map[string]interface{}) {
+	roles := []string{"viewer", "admin"}
+	tok := ""
+	if c, _ := r.Cookie(cookieName); c != nil {
+		tok = c.Value
+	}
+	if body, status, err := apiGet(apiBase, "/roles", tok); err == nil && status == http.StatusOK {
+		var resp struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+		}
+		if json.Unmarshal(body, &resp) == nil && len(resp.Items) > 0 {
+			roles = roles[:0]
+			for _, role := range resp.Items {
+				roles = append(roles, role.Name)
+			}
+		}
+	}
+	data["Roles"] = roles
+	renderTemplate(w, r, "user_form.html", data)
+}
+
 func userChangePasswordForm(apiBase string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -0,0 +1,184 @@
+// Package audit exports signed checkpoints of the audit hash chain, verifies the chain against them and forwards
+// audit entries to a syslog collector.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+const defaultCheckpointInterval = time.Hour
+
+// Sign returns the hex HMAC-SHA256 of cp's ID, hash and time with key.
+func Sign(key []byte, cp models.AuditCheckpoint) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(cp.ID) + "\n" + cp.Hash + "\n" + cp.CreatedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidSignature reports whether cp was signed with key.
+func ValidSignature(key []byte, cp models.AuditCheckpoint) bool {
+	want, err := hex.DecodeString(cp.Signature)
+	if err != nil {
+		return false
+	}
+	got, _ := hex.DecodeString(Sign(key, cp))
+	return hmac.Equal(got, want)
+}
+
+// ReadCheckpoints returns the checkpoints in the file at path, oldest first. A missing file has none.
+func ReadCheckpoints(path string) ([]models.AuditCheckpoint, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []models.AuditCheckpoint
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var cp models.AuditCheckpoint
+		if err := json.Unmarshal(sc.Bytes(), &cp); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		list = append(list, cp)
+	}
+	return list, sc.Err()
+}
+
+// AppendCheckpoint adds cp as one JSON line at the end of the file at path, creating it if needed.
+func AppendCheckpoint(path string, cp models.AuditCheckpoint) error {
+	line, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Checkpointer periodically appends a signed checkpoint of the audit chain head to a file. Ship the file to
+// storage the database administrators cannot write to, so a rewritten chain can be detected.
+type Checkpointer struct {
+	Repo *repo.AuditRepo
+	Path string
+	Key  []byte
+	// Interval between checkpoints (default 1h). A checkpoint is only written when new entries were logged.
+	Interval time.Duration
+
+	lastID int  // entry of the last checkpoint written
+	loaded bool // lastID was read from the file
+}
+
+// NewCheckpointer returns a Checkpointer writing checkpoints of audits' chain to path, signed with key.
+func NewCheckpointer(audits *repo.AuditRepo, path string, key []byte, interval time.Duration) *Checkpointer {
+	return &Checkpointer{Repo: audits, Path: path, Key: key, Interval: interval}
+}
+
+// Run writes a checkpoint now and then every Interval, until ctx is done.
+func (c *Checkpointer) Run(ctx context.Context) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if cp, err := c.Checkpoint(ctx); err != nil {
+			log.Printf("audit checkpoint: %v", err)
+		} else if cp != nil {
+			log.Printf("audit checkpoint: entry %d written to %s", cp.ID, c.Path)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Checkpoint appends a checkpoint of the current chain head, unless no entry was logged since the last one. It
+// returns the checkpoint written, if any.
+func (c *Checkpointer) Checkpoint(ctx context.Context) (*models.AuditCheckpoint, error) {
+	if !c.loaded {
+		list, err := ReadCheckpoints(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			c.lastID = list[len(list)-1].ID
+		}
+		c.loaded = true
+	}
+	id, hash, err := c.Repo.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 || id == c.lastID {
+		return nil, nil
+	}
+	cp := models.AuditCheckpoint{ID: id, Hash: hash, CreatedAt: time.Now().UTC()}
+	cp.Signature = Sign(c.Key, cp)
+	if err := AppendCheckpoint(c.Path, cp); err != nil {
+		return nil, err
+	}
+	c.lastID = id
+	return &cp, nil
+}
+
+// Verify walks the audit chain in audits and compares it with the signed checkpoints in the file at path (none
+// when path is ""). A checkpoint whose signature does not verify with key breaks the chain too.
+func Verify(ctx context.Context, audits *repo.AuditRepo, path string, key []byte) (*models.AuditVerification, error) {
+	var list []models.AuditCheckpoint
+	if path != "" {
+		var err error
+		if list, err = ReadCheckpoints(path); err != nil {
+			return nil, err
+		}
+	}
+	checkpoints := make(map[int]string, len(list))
+	var forged *models.AuditCheckpoint
+	for i, cp := range list {
+		if !ValidSignature(key, cp) {
+			if forged == nil {
+				forged = &list[i]
+			}
+			continue
+		}
+		checkpoints[cp.ID] = cp.Hash
+	}
+
+	v, err := audits.Verify(ctx, checkpoints)
+	if err != nil {
+		return nil, err
+	}
+	if v.Valid && forged != nil {
+		v.Valid = false
+		v.BrokenLink = &models.AuditBrokenLink{ID: forged.ID, Reason: "checkpoint signature does not verify: the checkpoint file was changed"}
+	}
+	return v, nil
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestCheckpointer_WritesSignedCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	path := filepath.Join(t.TempDir(), "audit-checkpoints.jsonl")
+	key := []byte("checkpoint-key")
+	head := func(id int, hash string) {
+		mock.ExpectQuery(`SELECT id, hash FROM audit_log ORDER BY id DESC LIMIT 1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(id, hash))
+	}
+	head(5, "hash5")
+	head(5, "hash5") // no new entries: nothing written
+	head(9, "hash9")
+
+	c := NewCheckpointer(repo.NewAuditRepo(db), path, key, time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Checkpoint(context.Background()); err != nil {
+			t.Fatalf("Checkpoint %d: %v", i, err)
+		}
+	}
+
+	list, err := ReadCheckpoints(path)
+	if err != nil {
+		t.Fatalf("ReadCheckpoints: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != 5 || list[1].ID != 9 || list[1].Hash != "hash9" {
+		t.Fatalf("checkpoints: got %+v, want entries 5 and 9", list)
+	}
+	for _, cp := range list {
+		if !ValidSignature(key, cp) {
+			t.Errorf("checkpoint %d: signature does not verify", cp.ID)
+		}
+		if ValidSignature([]byte("other-key"), cp) {
+			t.Errorf("checkpoint %d: verifies with another key", cp.ID)
+		}
+	}
+
+	// A new Checkpointer resumes after the last checkpoint in the file.
+	head(9, "hash9")
+	if cp, err := NewCheckpointer(repo.NewAuditRepo(db), path, key, time.Hour).Checkpoint(context.Background()); err != nil || cp != nil {
+		t.Errorf("Checkpoint after restart: got %+v, %v; want nothing written", cp, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestVerify_ForgedCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	e := models.AuditEntry{ID: 1, UserID: 1, Action: "create", ResourceType: "asset", ResourceID: 3, CreatedAt: time.Now().UTC()}
+	e.Hash = e.ChainHash()
+	path := filepath.Join(t.TempDir(), "audit-checkpoints.jsonl")
+	key := []byte("checkpoint-key")
+	forged := models.AuditCheckpoint{ID: 1, Hash: e.Hash, CreatedAt: time.Now().UTC()}
+	forged.Signature = Sign([]byte("attacker-key"), forged)
+	if err := AppendCheckpoint(path, forged); err != nil {
+		t.Fatalf("AppendCheckpoint: %v", err)
+	}
+
+	mock.ExpectQuery(`FROM audit_log ORDER BY id`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "action", "resource_type", "resource_id", "actor_type", "actor", "ip", "user_agent",
+			"request_id", "details", "created_at", "prev_hash", "hash", "hash_version"}).
+			AddRow(e.ID, e.UserID, e.Action, e.ResourceType, e.ResourceID, e.ActorType, e.Actor, e.IP, e.UserAgent,
+				e.RequestID, e.Details, e.CreatedAt, e.PrevHash, e.Hash, e.HashVersion))
+
+	v, err := Verify(context.Background(), repo.NewAuditRepo(db), path, key)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if v.Valid || v.BrokenLink == nil || !strings.Contains(v.BrokenLink.Reason, "signature") {
+		t.Fatalf("expected a forged checkpoint to break verification, got %+v", v)
+	}
+	if v.Checkpoints != 0 {
+		t.Errorf("Checkpoints: got %d, want 0 (forged checkpoints are not compared)", v.Checkpoints)
+	}
+}
@@ -0,0 +1,296 @@
+package audit
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/crucial707/hci-asset/internal/metrics"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+// Message formats of forwarded entries. Each entry is one RFC 5424 syslog message; the format decides its body.
+const (
+	// FormatRFC5424 puts the entry's fields in a structured data element and its details in the message.
+	FormatRFC5424 = "rfc5424"
+	// FormatCEF sends an ArcSight Common Event Format message.
+	FormatCEF = "cef"
+	// FormatJSON sends the entry as a JSON object, as in GET /audit/export?format=ndjson.
+	FormatJSON = "json"
+)
+
+const (
+	defaultForwardInterval = 5 * time.Second
+	forwardBatchSize       = 100
+	forwardTimeout         = 10 * time.Second
+
+	syslogFacility = 13 // log audit
+	syslogSeverity = 5  // notice
+	syslogAppName  = "hci-asset"
+	// syslogSDID names the structured data element; 32473 is the private enterprise number reserved for examples.
+	syslogSDID = "audit@32473"
+	// maxUDPMessage keeps messages within a single datagram; longer ones are truncated.
+	maxUDPMessage = 60000
+
+	cefVendor  = "crucial707"
+	cefProduct = "hci-asset"
+	// cefVersion is the version of the CEF messages sent; bump it when their fields change.
+	cefVersion  = "1"
+	cefSeverity = 3
+)
+
+// CheckForwarding reports whether network ("udp", "tcp" or "tls") and format are supported.
+func CheckForwarding(network, format string) error {
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("audit forwarding protocol %q: use udp, tcp or tls", network)
+	}
+	switch format {
+	case FormatRFC5424, FormatCEF, FormatJSON:
+	default:
+		return fmt.Errorf("audit forwarding format %q: use %s, %s or %s", format, FormatRFC5424, FormatCEF, FormatJSON)
+	}
+	return nil
+}
+
+// Forwarder ships the entries queued in the audit outbox to a syslog collector, oldest first, and removes them
+// from the outbox once written. Entries stay queued while the collector is unreachable and are sent when it comes
+// back, so an entry may be sent twice but is not lost. Over UDP a written entry counts as delivered.
+type Forwarder struct {
+	Repo *repo.AuditRepo
+	// Network is "udp", "tcp" (RFC 6587 octet counting) or "tls" (RFC 5425); Addr is the collector's host:port.
+	Network   string
+	Addr      string
+	TLSConfig *tls.Config
+	// Format is FormatRFC5424, FormatCEF or FormatJSON.
+	Format string
+	// Hostname is sent in the syslog header (default the host's name).
+	Hostname string
+	// Interval between polls of the outbox (default 5s).
+	Interval time.Duration
+
+	conn net.Conn
+}
+
+// NewForwarder returns a Forwarder sending audits' outbox to addr over network in format.
+func NewForwarder(audits *repo.AuditRepo, network, addr, format string, tlsConfig *tls.Config, interval time.Duration) *Forwarder {
+	hostname, _ := os.Hostname()
+	return &Forwarder{
+		Repo: audits, Network: network, Addr: addr, TLSConfig: tlsConfig, Format: format, Hostname: hostname, Interval: interval,
+	}
+}
+
+// Run forwards queued entries now and then every Interval, until ctx is done. After each round it updates the
+// audit_forward_pending and audit_forward_lag_seconds metrics.
+func (f *Forwarder) Run(ctx context.Context) {
+	interval := f.Interval
+	if interval <= 0 {
+		interval = defaultForwardInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer f.close()
+	for {
+		if _, err := f.Forward(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("audit forward to %s: %v", f.Addr, err)
+		}
+		if pending, oldest, err := f.Repo.OutboxBacklog(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("audit forward: backlog: %v", err)
+			}
+		} else {
+			lag := 0.0
+			if pending > 0 {
+				lag = time.Since(oldest).Seconds()
+			}
+			metrics.SetAuditForwardBacklog(pending, lag)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Forward sends queued entries until the outbox is empty, another instance is forwarding, or sending fails. It
+// returns the number of entries sent.
+func (f *Forwarder) Forward(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := f.Repo.ForwardOutbox(ctx, forwardBatchSize, f.send)
+		total += n
+		metrics.AddAuditForwarded(n)
+		if err != nil {
+			metrics.IncAuditForwardErrors()
+			return total, err
+		}
+		if n < forwardBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// send writes entries to the collector, connecting first if needed, and returns how many were written. The
+// connection is dropped after a failed write and dialled again on the next attempt.
+func (f *Forwarder) send(entries []models.AuditEntry) (int, error) {
+	if f.conn == nil {
+		conn, err := f.dial()
+		if err != nil {
+			return 0, err
+		}
+		f.conn = conn
+	}
+	for i, e := range entries {
+		msg := FormatSyslog(e, f.Format, f.Hostname)
+		if f.Network == "udp" {
+			msg = truncateUTF8(msg, maxUDPMessage)
+		} else {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if err := f.conn.SetWriteDeadline(time.Now().Add(forwardTimeout)); err != nil {
+			f.close()
+			return i, err
+		}
+		if _, err := f.conn.Write(msg); err != nil {
+			f.close()
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+func (f *Forwarder) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: forwardTimeout}
+	if f.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", f.Addr, f.TLSConfig)
+	}
+	return dialer.Dial(f.Network, f.Addr)
+}
+
+func (f *Forwarder) close() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+// FormatSyslog returns e as an RFC 5424 syslog message from hostname, with its body in format (see FormatRFC5424,
+// FormatCEF and FormatJSON).
+func FormatSyslog(e models.AuditEntry, format, hostname string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s - %s ", syslogFacility*8+syslogSeverity,
+		e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), headerField(hostname, 255), syslogAppName,
+		headerField(e.Action, 32))
+	switch format {
+	case FormatCEF:
+		b.WriteString("- ")
+		b.WriteString(FormatCEFMessage(e))
+	case FormatJSON:
+		b.WriteString("- ")
+		line, _ := json.Marshal(e)
+		b.Write(line)
+	default:
+		b.WriteString("[" + syslogSDID)
+		param := func(name, value string) {
+			if value != "" {
+				b.WriteString(" " + name + `="` + sdEscaper.Replace(value) + `"`)
+			}
+		}
+		param("id", strconv.Itoa(e.ID))
+		param("actor_type", e.ActorType)
+		param("actor", e.Actor)
+		if e.UserID > 0 {
+			param("user_id", strconv.Itoa(e.UserID))
+		}
+		param("action", e.Action)
+		param("resource_type", e.ResourceType)
+		param("resource_id", strconv.Itoa(e.ResourceID))
+		param("ip", e.IP)
+		param("user_agent", e.UserAgent)
+		param("request_id", e.RequestID)
+		param("hash", e.Hash)
+		b.WriteString("]")
+		if e.Details != "" {
+			b.WriteString(" " + e.Details)
+		}
+	}
+	return []byte(b.String())
+}
+
+// FormatCEFMessage returns e as a CEF message. The signature ID is resource_type:action.
+func FormatCEFMessage(e models.AuditEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|", cefVendor, cefProduct, cefVersion,
+		cefHeaderEscaper.Replace(e.ResourceType+":"+e.Action), cefHeaderEscaper.Replace(e.ResourceType+" "+e.Action), cefSeverity)
+	ext := []string{
+		"rt", strconv.FormatInt(e.CreatedAt.UnixMilli(), 10),
+		"externalId", strconv.Itoa(e.ID),
+		"act", e.Action,
+		"suser", e.Actor,
+		"cs1Label", "actorType", "cs1", e.ActorType,
+		"cs2Label", "resourceType", "cs2", e.ResourceType,
+		"cn1Label", "resourceId", "cn1", strconv.Itoa(e.ResourceID),
+	}
+	if e.UserID > 0 {
+		ext = append(ext, "suid", strconv.Itoa(e.UserID))
+	}
+	ext = append(ext, "src", e.IP, "requestClientApplication", e.UserAgent, "cs3Label", "requestId", "cs3", e.RequestID,
+		"cs4Label", "hash", "cs4", e.Hash, "msg", e.Details)
+	sep := ""
+	for i := 0; i < len(ext); i += 2 {
+		if ext[i+1] == "" {
+			continue
+		}
+		b.WriteString(sep + ext[i] + "=" + cefExtEscaper.Replace(ext[i+1]))
+		sep = " "
+	}
+	return b.String()
+}
+
+var (
+	sdEscaper        = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtEscaper    = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+)
+
+// headerField returns s as a syslog header field: printable ASCII without spaces, at most max bytes, or the nil
+// value "-" when empty.
+func headerField(s string, max int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if b.Len() == max {
+			break
+		}
+		if r > ' ' && r < 127 {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+// truncateUTF8 shortens msg to at most max bytes without splitting a UTF-8 sequence.
+func truncateUTF8(msg []byte, max int) []byte {
+	if len(msg) <= max {
+		return msg
+	}
+	for max > 0 && !utf8.RuneStart(msg[max]) {
+		max--
+	}
+	return msg[:max]
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func forwardEntry(id int) models.AuditEntry {
+	return models.AuditEntry{
+		ID: id, UserID: 7, Action: "delete", ResourceType: "asset", ResourceID: 42, ActorType: models.AuditActorUser,
+		Actor: "alice", IP: "192.0.2.10", UserAgent: "curl/8.0", RequestID: "req-1", Details: `{"before":{"name":"db-1"}}`,
+		CreatedAt: time.Date(2026, 3, 21, 9, 30, 0, 123456000, time.UTC), Hash: "h" + strconv.Itoa(id),
+	}
+}
+
+// expectOutbox expects one ForwardOutbox batch returning entries; the lock is held by another instance when
+// locked is false.
+func expectOutbox(mock sqlmock.Sqlmock, locked bool, entries ...models.AuditEntry) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(locked))
+	if !locked {
+		mock.ExpectRollback()
+		return
+	}
+	rows := sqlmock.NewRows([]string{"id", "user_id", "action", "resource_type", "resource_id", "actor_type", "actor", "ip",
+		"user_agent", "request_id", "details", "created_at", "prev_hash", "hash", "hash_version"})
+	for _, e := range entries {
+		rows.AddRow(e.ID, e.UserID, e.Action, e.ResourceType, e.ResourceID, e.ActorType, e.Actor, e.IP, e.UserAgent,
+			e.RequestID, e.Details, e.CreatedAt, e.PrevHash, e.Hash, 2)
+	}
+	mock.ExpectQuery(`SELECT .* FROM audit_log WHERE id IN \(SELECT audit_id FROM audit_outbox ORDER BY audit_id LIMIT \$1\)`).
+		WithArgs(forwardBatchSize).WillReturnRows(rows)
+}
+
+func newMockForwarder(t *testing.T, network, addr, format string) (*Forwarder, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	f := NewForwarder(repo.NewAuditRepo(db), network, addr, format, nil, time.Second)
+	f.Hostname = "api-1"
+	return f, mock
+}
+
+func TestFormatSyslog(t *testing.T) {
+	e := forwardEntry(12)
+	e.UserAgent = `evil"agent]\`
+
+	got := string(FormatSyslog(e, FormatRFC5424, "api 1"))
+	want := `<109>1 2026-03-21T09:30:00.123456Z api_1 hci-asset - delete [audit@32473 id="12" actor_type="user" ` +
+		`actor="alice" user_id="7" action="delete" resource_type="asset" resource_id="42" ip="192.0.2.10" ` +
+		`user_agent="evil\"agent\]\\" request_id="req-1" hash="h12"] {"before":{"name":"db-1"}}`
+	if got != want {
+		t.Errorf("rfc5424:\n got %s\nwant %s", got, want)
+	}
+
+	got = string(FormatSyslog(e, FormatJSON, ""))
+	if !strings.HasPrefix(got, `<109>1 2026-03-21T09:30:00.123456Z - hci-asset - delete - {"id":12,"user_id":7,`) {
+		t.Errorf("json: %s", got)
+	}
+
+	system := models.AuditEntry{ID: 3, Action: "run", ResourceType: "schedule", ResourceID: 5,
+		ActorType: models.AuditActorSystem, Actor: models.AuditSystemScheduler, Details: "a=b|c\nd", CreatedAt: e.CreatedAt}
+	got = FormatCEFMessage(system)
+	want = `CEF:0|crucial707|hci-asset|1|schedule:run|schedule run|3|rt=1774085400123 externalId=3 act=run ` +
+		`suser=scheduler cs1Label=actorType cs1=system cs2Label=resourceType cs2=schedule cn1Label=resourceId cn1=5 ` +
+		`cs3Label=requestId cs4Label=hash msg=a\=b|c\nd`
+	if got != want {
+		t.Errorf("cef:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestForwarder_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for len(msgs) < 2 {
+			// RFC 6587 octet counting: "<length> <message>"
+			n, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(n))
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				break
+			}
+			msgs = append(msgs, string(buf))
+		}
+		received <- msgs
+	}()
+
+	f, mock := newMockForwarder(t, "tcp", ln.Addr().String(), FormatCEF)
+	expectOutbox(mock, true, forwardEntry(1), forwardEntry(2))
+	mock.ExpectExec(`DELETE FROM audit_outbox WHERE audit_id = ANY\(\$1\)`).WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	n, err := f.Forward(context.Background())
+	f.close()
+	if err != nil || n != 2 {
+		t.Fatalf("Forward = %d, %v; want 2 entries", n, err)
+	}
+	msgs := <-received
+	if len(msgs) != 2 || !strings.Contains(msgs[0], "CEF:0|crucial707|hci-asset|1|asset:delete|") ||
+		!strings.Contains(msgs[1], "externalId=2 ") {
+		t.Errorf("received %q", msgs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestForwarder_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	f, mock := newMockForwarder(t, "udp", pc.LocalAddr().String(), FormatRFC5424)
+	expectOutbox(mock, true, forwardEntry(9))
+	mock.ExpectExec(`DELETE FROM audit_outbox`).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if n, err := f.Forward(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Forward = %d, %v; want 1 entry", n, err)
+	}
+	f.close()
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<109>1 2026-03-21T09:30:00.123456Z api-1 hci-asset - delete [audit@32473 id=\"9\"") {
+		t.Errorf("datagram %q", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestForwarder_KeepsEntriesQueued(t *testing.T) {
+	// Nothing listens on the port once the listener is closed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	f, mock := newMockForwarder(t, "tcp", addr, FormatJSON)
+	// Collector down: the batch is rolled back and stays in the outbox.
+	expectOutbox(mock, true, forwardEntry(1))
+	mock.ExpectRollback()
+	// Another instance is forwarding: nothing is sent.
+	expectOutbox(mock, false)
+
+	if n, err := f.Forward(context.Background()); err == nil || n != 0 {
+		t.Errorf("Forward with collector down = %d, %v; want an error", n, err)
+	}
+	if n, err := f.Forward(context.Background()); err != nil || n != 0 {
+		t.Errorf("Forward while locked = %d, %v; want 0, nil", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestCheckForwarding(t *testing.T) {
+	if err := CheckForwarding("tls", FormatCEF); err != nil {
+		t.Errorf("tls/cef: %v", err)
+	}
+	if err := CheckForwarding("http", FormatJSON); err == nil {
+		t.Error("http: want error")
+	}
+	if err := CheckForwarding("udp", "leef"); err == nil {
+		t.Error("leef: want error")
+	}
+}
@@ -0,0 +1,67 @@
+// Package auth holds the username/password authentication providers behind POST /auth/login: the local
+// (bcrypt) provider and an LDAP / Active Directory provider. Providers are tried in order.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+var (
+	// ErrInvalidCredentials means the provider owns the user and rejected the login; no further providers are tried.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnknownUser means the provider cannot authenticate this user; the next provider is tried.
+	ErrUnknownUser = errors.New("unknown user")
+)
+
+// Provider authenticates a username and password and returns the local user to issue tokens for.
+type Provider interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+}
+
+// Authenticate tries providers in order. The first one that does not return ErrUnknownUser decides;
+// when none knows the user the result is ErrInvalidCredentials.
+func Authenticate(ctx context.Context, providers []Provider, username, password string) (*models.User, error) {
+	for _, p := range providers {
+		user, err := p.Authenticate(ctx, username, password)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+		return user, err
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// MapRole maps external group names to a role. Admin wins when both lists match. When viewerGroups is
+// non-empty, users in neither list are refused (ok is false); otherwise everyone else is a viewer.
+func MapRole(groups, adminGroups, viewerGroups []string) (role string, ok bool) {
+	if containsAny(groups, adminGroups) {
+		return models.RoleAdmin, true
+	}
+	if len(viewerGroups) == 0 || containsAny(groups, viewerGroups) {
+		return models.RoleViewer, true
+	}
+	return "", false
+}
+
+// SyncRole reports whether a directory login should replace the user's current role with the mapped one. Group
+// membership only decides between the built-in roles: a custom role assigned by an administrator is kept.
+func SyncRole(current, mapped string) bool {
+	_, builtin := models.BuiltinRolePermissions[current]
+	return builtin && current != mapped
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+type stubProvider struct {
+	user  *models.User
+	err   error
+	calls int
+}
+
+func (s *stubProvider) Name() string { return "stub" }
+
+func (s *stubProvider) Authenticate(context.Context, string, string) (*models.User, error) {
+	s.calls++
+	return s.user, s.err
+}
+
+func TestAuthenticate_Chain(t *testing.T) {
+	alice := &models.User{ID: 1, Username: "alice"}
+
+	unknown := &stubProvider{err: auth.ErrUnknownUser}
+	ok := &stubProvider{user: alice}
+	if user, err := auth.Authenticate(context.Background(), []auth.Provider{unknown, ok}, "alice", "pw"); err != nil || user != alice {
+		t.Fatalf("unknown then ok: got %v, %v", user, err)
+	}
+
+	rejected := &stubProvider{err: auth.ErrInvalidCredentials}
+	next := &stubProvider{user: alice}
+	if _, err := auth.Authenticate(context.Background(), []auth.Provider{rejected, next}, "alice", "pw"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("rejected: got %v, want ErrInvalidCredentials", err)
+	}
+	if next.calls != 0 {
+		t.Error("a rejecting provider must stop the chain")
+	}
+
+	if _, err := auth.Authenticate(context.Background(), []auth.Provider{unknown}, "alice", "pw"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("nobody knows the user: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestMapRole(t *testing.T) {
+	admins, viewers := []string{"hci-admins"}, []string{"hci-viewers"}
+	tests := []struct {
+		groups  []string
+		viewers []string
+		role    string
+		ok      bool
+	}{
+		{[]string{"HCI-Admins"}, viewers, models.RoleAdmin, true},
+		{[]string{"hci-viewers"}, viewers, models.RoleViewer, true},
+		{[]string{"other"}, viewers, "", false},
+		{[]string{"other"}, nil, models.RoleViewer, true},
+	}
+	for _, tt := range tests {
+		role, ok := auth.MapRole(tt.groups, admins, tt.viewers)
+		if role != tt.role || ok != tt.ok {
+			t.Errorf("MapRole(%v): got %q, %v; want %q, %v", tt.groups, role, ok, tt.role, tt.ok)
+		}
+	}
+}
+
+func TestSyncRole(t *testing.T) {
+	tests := []struct {
+		current, mapped string
+		want            bool
+	}{
+		{models.RoleAdmin, models.RoleViewer, true},
+		{models.RoleViewer, models.RoleAdmin, true},
+		{models.RoleViewer, models.RoleViewer, false},
+		{"netops", models.RoleViewer, false},
+		{"netops", models.RoleAdmin, false},
+	}
+	for _, tt := range tests {
+		if got := auth.SyncRole(tt.current, tt.mapped); got != tt.want {
+			t.Errorf("SyncRole(%q, %q) = %v, want %v", tt.current, tt.mapped, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPIssuer is the user_identities issuer for directory accounts; the subject is the user's DN.
+const LDAPIssuer = "ldap"
+
+// LDAPProvider authenticates against LDAP or Active Directory: it binds with a service account, searches
+// for the user, then binds as the user with the supplied password. Group membership maps to a role.
+type LDAPProvider struct {
+	// URL is ldap://host:389 or ldaps://host:636.
+	URL string
+	// StartTLS upgrades an ldap:// connection before any credentials are sent.
+	StartTLS bool
+	// TLSConfig is used for ldaps:// and StartTLS (nil = system roots).
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the search account; empty searches anonymously.
+	BindDN       string
+	BindPassword string
+	// UserBaseDN is where users are searched. UserFilter selects the user, with {username} replaced by the
+	// escaped login name (default "(uid={username})"; Active Directory: "(sAMAccountName={username})").
+	UserBaseDN string
+	UserFilter string
+	// EmailAttr (default "mail") and GroupAttr (default "memberOf") are read from the user entry.
+	EmailAttr string
+	GroupAttr string
+	// GroupBaseDN, when set, also searches for groups with GroupFilter ({dn} = the user's DN,
+	// default "(member={dn})") for directories without memberOf.
+	GroupBaseDN string
+	GroupFilter string
+	// AdminGroups and ViewerGroups map group names (CN) or full DNs to roles; see MapRole.
+	AdminGroups  []string
+	ViewerGroups []string
+	// AutoCreate provisions a local user on first login. When false, the user must already exist locally
+	// (without a password) and is linked to the directory entry on first login.
+	AutoCreate bool
+	Timeout    time.Duration
+
+	Users           *repo.UserRepo
+	Identities      *repo.IdentityRepo
+	ServiceAccounts *repo.ServiceAccountRepo
+	// Sessions, when set, revokes a user's sessions when a login changes their role.
+	Sessions *repo.SessionRepo
+}
+
+// ldapEntry is what a successful directory login yields.
+type ldapEntry struct {
+	DN     string
+	Email  string
+	Groups []string
+}
+
+func (p *LDAPProvider) Name() string { return "ldap" }
+
+func (p *LDAPProvider) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept.
+	if username == "" || password == "" {
+		return nil, ErrUnknownUser
+	}
+	entry, err := p.verify(username, password)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := MapRole(entry.Groups, p.AdminGroups, p.ViewerGroups)
+	if !ok {
+		log.Printf("ldap: %s is not in any allowed group", entry.DN)
+		return nil, ErrInvalidCredentials
+	}
+	return p.localUser(ctx, username, entry, role)
+}
+
+// verify checks the password against the directory and returns the user's entry.
+func (p *LDAPProvider) verify(username, password string) (*ldapEntry, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := p.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	emailAttr := orDefault(p.EmailAttr, "mail")
+	groupAttr := orDefault(p.GroupAttr, "memberOf")
+	filter := strings.ReplaceAll(orDefault(p.UserFilter, "(uid={username})"), "{username}", ldap.EscapeFilter(username))
+	res, err := conn.Search(ldap.NewSearchRequest(
+		p.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(p.timeout().Seconds()), false,
+		filter, []string{emailAttr, groupAttr}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: user search: %w", err)
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUnknownUser
+	case 1:
+	default:
+		log.Printf("ldap: filter %s matched %d entries; refusing ambiguous login", filter, len(res.Entries))
+		return nil, ErrInvalidCredentials
+	}
+	user := res.Entries[0]
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind: %w", err)
+	}
+
+	entry := &ldapEntry{DN: user.DN, Email: user.GetAttributeValue(emailAttr)}
+	groups := user.GetAttributeValues(groupAttr)
+	if p.GroupBaseDN != "" {
+		// Search as the service account again: users often cannot read group membership themselves.
+		if err := p.bindService(conn); err != nil {
+			return nil, err
+		}
+		gf := strings.ReplaceAll(orDefault(p.GroupFilter, "(member={dn})"), "{dn}", ldap.EscapeFilter(user.DN))
+		gres, err := conn.Search(ldap.NewSearchRequest(
+			p.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(p.timeout().Seconds()), false,
+			gf, []string{"dn"}, nil,
+		))
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("ldap: group search: %w", err)
+		}
+		if gres != nil {
+			for _, g := range gres.Entries {
+				groups = append(groups, g.DN)
+			}
+		}
+	}
+	entry.Groups = groupNames(groups)
+	return entry, nil
+}
+
+func (p *LDAPProvider) dial() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.timeout()}
+	conn, err := ldap.DialURL(p.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(p.tlsConfig()))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial: %w", err)
+	}
+	conn.SetTimeout(p.timeout())
+	if p.StartTLS {
+		if err := conn.StartTLS(p.tlsConfig()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (p *LDAPProvider) bindService(conn *ldap.Conn) error {
+	if p.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(p.BindDN, p.BindPassword); err != nil {
+		return fmt.Errorf("ldap: service bind: %w", err)
+	}
+	return nil
+}
+
+// tlsConfig returns the configured TLS settings with ServerName filled in from the URL.
+func (p *LDAPProvider) tlsConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if p.TLSConfig != nil {
+		cfg = p.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		if u, err := url.Parse(p.URL); err == nil {
+			cfg.ServerName = u.Hostname()
+		}
+	}
+	return cfg
+}
+
+func (p *LDAPProvider) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return 10 * time.Second
+}
+
+// localUser returns the local account for a directory login, linking or provisioning it on first use
+// and syncing a built-in role from group membership on every login (see SyncRole).
+func (p *LDAPProvider) localUser(ctx context.Context, username string, entry *ldapEntry, role string) (*models.User, error) {
+	user, err := p.Identities.FindUser(ctx, LDAPIssuer, entry.DN)
+	switch {
+	case errors.Is(err, repo.ErrIdentityNotFound):
+		user, err = p.firstLogin(ctx, username, entry, role)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	if SyncRole(user.Role, role) {
+		updated, err := p.Users.Update(ctx, user.ID, user.Username, role)
+		if err != nil {
+			return nil, err
+		}
+		if p.Sessions != nil {
+			if err := p.Sessions.RevokeAllForUser(ctx, user.ID); err != nil {
+				log.Printf("ldap: role sync: revoke sessions: %v", err)
+			}
+		}
+		user = updated
+	}
+	return user, nil
+}
+
+func (p *LDAPProvider) firstLogin(ctx context.Context, username string, entry *ldapEntry, role string) (*models.User, error) {
+	existing, err := p.Users.GetByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !p.AutoCreate {
+			log.Printf("ldap: %s has no local account and auto-creation is disabled", entry.DN)
+			return nil, ErrInvalidCredentials
+		}
+		return p.Identities.CreateUser(ctx, LDAPIssuer, entry.DN, entry.Email, username, role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only link accounts that nothing else authenticates: no local password, no other identity, not a service account.
+	if existing.PasswordHash != "" {
+		return nil, ErrInvalidCredentials
+	}
+	linked, err := p.Identities.HasIdentity(ctx, existing.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !linked && p.ServiceAccounts != nil {
+		linked, err = p.ServiceAccounts.IsServiceAccount(ctx, existing.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if linked {
+		log.Printf("ldap: local user %q is managed elsewhere; not linking %s", username, entry.DN)
+		return nil, ErrInvalidCredentials
+	}
+	if err := p.Identities.Link(ctx, LDAPIssuer, entry.DN, existing.ID, entry.Email); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// groupNames returns each group DN together with its first RDN value (e.g. its CN), so role mappings can use either.
+func groupNames(dns []string) []string {
+	out := make([]string, 0, 2*len(dns))
+	for _, dn := range dns {
+		out = append(out, dn)
+		if parsed, err := ldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) > 0 {
+			out = append(out, parsed.RDNs[0].Attributes[0].Value)
+		}
+	}
+	return out
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+// startDirectory runs an in-process LDAP server. alice is in hci-admins (memberOf); bob is only a member
+// of hci-viewers (group entry). Both use the password "password".
+func startDirectory(t *testing.T, opts ...testdirectory.Option) *testdirectory.Directory {
+	t.Helper()
+	defaults := &testdirectory.Defaults{UserAttr: "uid"}
+	users := testdirectory.NewUsers(t, []string{"alice"}, testdirectory.WithDefaults(t, defaults),
+		testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"hci-admins"})...))
+	users = append(users, testdirectory.NewUsers(t, []string{"bob"}, testdirectory.WithDefaults(t, defaults))...)
+	defaults.Users = users
+	defaults.Groups = []*gldap.Entry{testdirectory.NewGroup(t, "hci-viewers", []string{"bob"}, testdirectory.WithDefaults(t, defaults))}
+	return testdirectory.Start(t, append(opts, testdirectory.WithDefaults(t, defaults))...)
+}
+
+func newLDAPProvider(t *testing.T, d *testdirectory.Directory, url string) (*auth.LDAPProvider, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	p := &auth.LDAPProvider{
+		URL:         url,
+		UserBaseDN:  testdirectory.DefaultUserDN,
+		EmailAttr:   "email",
+		AdminGroups: []string{"hci-admins"},
+		AutoCreate:  true,
+		TLSConfig:   tlsConfigTrusting(t, d.Cert()),
+		Users:       repo.NewUserRepo(db),
+		Identities:  repo.NewIdentityRepo(db),
+	}
+	return p, mock
+}
+
+func TestLDAPProvider_LDAPS_ProvisionsAdmin(t *testing.T) {
+	d := startDirectory(t)
+	p, mock := newLDAPProvider(t, d, fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()))
+	dn := "uid=alice," + testdirectory.DefaultUserDN
+
+	mock.ExpectQuery(`UPDATE user_identities`).
+		WithArgs(auth.LDAPIssuer, dn).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs("alice").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("alice", "admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(3, "alice", "admin"))
+	mock.ExpectExec(`INSERT INTO user_identities`).
+		WithArgs(auth.LDAPIssuer, dn, 3, "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := p.Authenticate(context.Background(), "alice", "password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.ID != 3 || user.Role != "admin" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestLDAPProvider_StartTLS_GroupSearchSyncsRole(t *testing.T) {
+	d := startDirectory(t, testdirectory.WithNoTLS(t))
+	p, mock := newLDAPProvider(t, d, fmt.Sprintf("ldap://%s:%d", d.Host(), d.Port()))
+	p.StartTLS = true
+	p.GroupBaseDN = testdirectory.DefaultGroupDN
+	p.ViewerGroups = []string{"hci-viewers"}
+	dn := "uid=bob," + testdirectory.DefaultUserDN
+
+	// bob is linked already but was an admin; membership of hci-viewers only demotes him.
+	mock.ExpectQuery(`UPDATE user_identities`).
+		WithArgs(auth.LDAPIssuer, dn).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(4, "bob", "admin"))
+	mock.ExpectQuery(`UPDATE users`).
+		WithArgs("bob", "viewer", 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(4, "bob", nil, "viewer"))
+
+	user, err := p.Authenticate(context.Background(), "bob", "password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Role != "viewer" {
+		t.Errorf("role: got %q, want viewer", user.Role)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestLDAPProvider_Rejects(t *testing.T) {
+	d := startDirectory(t)
+	url := fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port())
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		viewers  []string
+		want     error
+	}{
+		{"wrong password", "alice", "nope", nil, auth.ErrInvalidCredentials},
+		{"empty password", "alice", "", nil, auth.ErrUnknownUser},
+		{"not in directory", "carol", "password", nil, auth.ErrUnknownUser},
+		{"no allowed group", "bob", "password", []string{"hci-viewers"}, auth.ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, mock := newLDAPProvider(t, d, url)
+			p.ViewerGroups = tt.viewers
+			if _, err := p.Authenticate(context.Background(), tt.username, tt.password); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestLDAPProvider_UntrustedCertificate(t *testing.T) {
+	d := startDirectory(t)
+	p, _ := newLDAPProvider(t, d, fmt.Sprintf("ldaps://%s:%d", d.Host(), d.Port()))
+	p.TLSConfig = nil // system roots do not include the test CA
+
+	if _, err := p.Authenticate(context.Background(), "alice", "password"); err == nil || errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("expected a TLS error, got %v", err)
+	}
+}
+
+func tlsConfigTrusting(t *testing.T, pem string) *tls.Config {
+	t.Helper()
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(pem)) {
+		t.Fatal("no certificate in directory PEM")
+	}
+	return &tls.Config{RootCAs: pool}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LocalProvider checks passwords against the bcrypt hashes in the users table. Viewers without a password
+// may log in with their username alone unless DisablePasswordless is set.
+type LocalProvider struct {
+	Users *repo.UserRepo
+	// ServiceAccounts, when set, rejects logins for service accounts (they authenticate with API keys only).
+	ServiceAccounts *repo.ServiceAccountRepo
+	// Identities, when set, leaves users provisioned by SSO or LDAP (no local password) to their own provider.
+	Identities *repo.IdentityRepo
+	// DisablePasswordless requires a password for every login (no username-only viewers).
+	DisablePasswordless bool
+}
+
+func (p *LocalProvider) Name() string { return "local" }
+
+func (p *LocalProvider) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := p.Users.GetByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.ServiceAccounts != nil {
+		isService, err := p.ServiceAccounts.IsServiceAccount(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if isService {
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	if user.PasswordHash == "" {
+		if p.Identities != nil {
+			external, err := p.Identities.HasIdentity(ctx, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			if external {
+				return nil, ErrUnknownUser
+			}
+		}
+		// No local password to check a supplied one against: let the next provider (e.g. LDAP) try.
+		if password != "" {
+			return nil, ErrUnknownUser
+		}
+		// Only "viewer" (view only) can log in without a password; admin and any other role require password.
+		if user.Role != models.RoleViewer || p.DisablePasswordless {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+
+	// Viewer with optional password set: still allow username-only, but if password provided, verify it
+	if password == "" {
+		if user.Role != models.RoleViewer || p.DisablePasswordless {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import "time"
+
+// LockoutPolicy locks an account after repeated failed logins. Once Threshold consecutive failures are
+// reached the account is locked for Base, and every further failure doubles the lockout up to Max.
+// Failures older than Window are forgotten. A zero Threshold disables lockout.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+// Enabled reports whether lockout is configured.
+func (p LockoutPolicy) Enabled() bool {
+	return p.Threshold > 0 && p.Base > 0
+}
+
+// Duration returns how long to lock an account after its failures-th consecutive failure (0 = not locked).
+func (p LockoutPolicy) Duration(failures int) time.Duration {
+	if !p.Enabled() || failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures; i++ {
+		d *= 2
+		if p.Max > 0 && d >= p.Max {
+			return p.Max
+		}
+	}
+	if p.Max > 0 && d > p.Max {
+		return p.Max
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxPasswordBytes is bcrypt's input limit; longer passwords would be silently truncated.
+const maxPasswordBytes = 72
+
+// PasswordPolicy checks new passwords. The zero value only enforces bcrypt's length limit.
+type PasswordPolicy struct {
+	MinLength int
+	// Breached holds upper-case SHA-1 hex digests of passwords that must not be used (see LoadBreachedPasswords).
+	Breached map[string]struct{}
+}
+
+// Check returns a user-facing reason why password is not acceptable for username, or nil.
+func (p *PasswordPolicy) Check(username, password string) error {
+	if p == nil {
+		p = &PasswordPolicy{}
+	}
+	switch {
+	case len([]rune(password)) < p.MinLength:
+		return fmt.Errorf("must be at least %d characters", p.MinLength)
+	case len(password) > maxPasswordBytes:
+		return fmt.Errorf("must be at most %d bytes", maxPasswordBytes)
+	case username != "" && strings.EqualFold(password, username):
+		return errors.New("must not be the username")
+	}
+	if _, ok := p.Breached[sha1Hex(password)]; ok {
+		return errors.New("appears in a list of breached passwords")
+	}
+	return nil
+}
+
+// LoadBreachedPasswords reads a breached-password list: one password per line, or SHA-1 hex digests as in
+// the Have I Been Pwned downloads ("HASH" or "HASH:count"). Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]struct{})
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if h, _, _ := strings.Cut(line, ":"); isSHA1Hex(h) {
+			out[strings.ToUpper(h)] = struct{}{}
+			continue
+		}
+		out[sha1Hex(line)] = struct{}{}
+	}
+	return out, sc.Err()
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
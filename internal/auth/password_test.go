@@ -0,0 +1,61 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/auth"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// "password123456" in plain text; "correct horse battery" as an HIBP-style SHA-1 line.
+	list := "# comment\npassword123456\n98decc62ece399a22ed30d490ef333be7fde7385:42\n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := auth.LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords: %v", err)
+	}
+	p := &auth.PasswordPolicy{MinLength: 12, Breached: breached}
+
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"short", false},
+		{"alice-the-admin", false}, // same as username
+		{"password123456", false},
+		{"correct horse battery", false},
+		{"correct horse staple", true},
+		{strings.Repeat("x", 73), false},
+	}
+	for _, tt := range tests {
+		err := p.Check("alice-the-admin", tt.password)
+		if (err == nil) != tt.ok {
+			t.Errorf("Check(%q): got %v, want ok=%v", tt.password, err, tt.ok)
+		}
+	}
+
+	var none *auth.PasswordPolicy
+	if err := none.Check("alice", "x"); err != nil {
+		t.Errorf("nil policy rejected a short password: %v", err)
+	}
+}
+
+func TestLockoutPolicy_Duration(t *testing.T) {
+	p := auth.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 5 * time.Minute}
+	want := map[int]time.Duration{1: 0, 2: 0, 3: time.Minute, 4: 2 * time.Minute, 5: 4 * time.Minute, 6: 5 * time.Minute, 50: 5 * time.Minute}
+	for failures, d := range want {
+		if got := p.Duration(failures); got != d {
+			t.Errorf("Duration(%d): got %s, want %s", failures, got, d)
+		}
+	}
+	if (auth.LockoutPolicy{Base: time.Minute}).Duration(100) != 0 {
+		t.Error("zero threshold must disable lockout")
+	}
+}
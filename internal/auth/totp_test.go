@@ -0,0 +1,68 @@
+package auth_test
+
+import (
+	"encoding/base32"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/auth"
+)
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to 6 digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := auth.TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil || code != tt.code {
+			t.Errorf("TOTPCode at %d: got %q, %v; want %q", tt.unix, code, err, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	code, _ := auth.TOTPCode(secret, now)
+
+	step, ok := auth.ValidateTOTP(secret, code, now.Add(30*time.Second))
+	if !ok || step != now.Unix()/30 {
+		t.Errorf("previous step within skew: got %d, %v", step, ok)
+	}
+	if _, ok := auth.ValidateTOTP(secret, code, now.Add(90*time.Second)); ok {
+		t.Error("code accepted outside the skew window")
+	}
+	if _, ok := auth.ValidateTOTP(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := auth.NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	format := regexp.MustCompile(`^[a-z2-9]{5}-[a-z2-9]{5}$`)
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if !format.MatchString(c) || seen[c] {
+			t.Errorf("bad or duplicate code %q", c)
+		}
+		seen[c] = true
+	}
+	if auth.NormalizeRecoveryCode(" K7X2M-Q9P4T ") != "k7x2m-q9p4t" {
+		t.Error("NormalizeRecoveryCode did not trim and lowercase")
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	Port     string
-	APIToken string
+	Port string
 
 	DBHost string
 	DBPort string
@@ -26,11 +26,67 @@ type Config struct {
 	// Env is "dev" (default) or "prod". When "prod", JWT_SECRET must be set and not the default.
 	Env string
 
-	// JWTExpireHours is the token lifetime in hours (default 24). Set via JWT_EXPIRE_HOURS.
-	JWTExpireHours int
+	// AccessTokenMinutes is the access token (JWT) lifetime in minutes (default 15). Set via ACCESS_TOKEN_MINUTES;
+	// the legacy JWT_EXPIRE_HOURS is still honoured when ACCESS_TOKEN_MINUTES is unset.
+	AccessTokenMinutes int
+	// RefreshTokenDays is the refresh token lifetime in days (default 30). Set via REFRESH_TOKEN_DAYS.
+	RefreshTokenDays int
 
 	// NmapPath is the path to the nmap executable (e.g. "nmap" for Linux/Mac, or full Windows path).
 	NmapPath string
+	// AssetOfflineAfter is how long after last_seen an asset counts as offline for impact analysis (default 24h).
+	// Set via ASSET_OFFLINE_AFTER (e.g. "2h").
+	AssetOfflineAfter time.Duration
+	// DecommissionIPGrace is how long scans leave the IP of a decommissioned asset alone instead of creating a
+	// new asset for it (default 30 days). Set via DECOMMISSION_IP_GRACE (e.g. "168h").
+	DecommissionIPGrace time.Duration
+	// SchedulerLeaseTTL is how long the instance running scan schedules keeps the scheduler lease without renewing
+	// it; another instance takes over once it expires (default 30s). Set via SCHEDULER_LEASE_TTL.
+	SchedulerLeaseTTL time.Duration
+	// SchedulerInstanceID names this instance in the scheduler lease (default hostname-pid). Set via
+	// SCHEDULER_INSTANCE_ID.
+	SchedulerInstanceID string
+	// ScheduleMaxFailures disables a schedule after this many consecutive runs ending in error (default 5; 0 never
+	// disables). Set via SCHEDULE_MAX_FAILURES.
+	ScheduleMaxFailures int
+	// AuditCheckpointFile enables signed checkpoints of the audit hash chain, appended to this file every
+	// AuditCheckpointInterval (default 1h) and compared by GET /audit/verify. AuditCheckpointKey is the HMAC key
+	// signing them (required with the file). Set via AUDIT_CHECKPOINT_FILE, AUDIT_CHECKPOINT_INTERVAL and
+	// AUDIT_CHECKPOINT_KEY.
+	AuditCheckpointFile     string
+	AuditCheckpointInterval time.Duration
+	AuditCheckpointKey      string
+	// AuditForwardAddr enables forwarding of audit entries to a syslog collector at this host:port.
+	// AuditForwardProtocol is "udp", "tcp" (default) or "tls"; AuditForwardFormat is "rfc5424" (default), "cef" or
+	// "json"; AuditForwardCACert is a PEM file with the CA to trust for TLS. The outbox is polled every
+	// AuditForwardInterval (default 5s). Set via AUDIT_FORWARD_ADDR, AUDIT_FORWARD_PROTOCOL, AUDIT_FORWARD_FORMAT,
+	// AUDIT_FORWARD_CA_CERT and AUDIT_FORWARD_INTERVAL.
+	AuditForwardAddr     string
+	AuditForwardProtocol string
+	AuditForwardFormat   string
+	AuditForwardCACert   string
+	AuditForwardInterval time.Duration
+	// Retention*Days delete rows older than that many days (0, the default, keeps them forever): finished scan
+	// jobs (keeping the newest RetentionScanKeep per target, default 10), audit entries, login events, asset
+	// lifecycle history and assets in the trash. The pruner runs every RetentionInterval (default 1h) and deletes
+	// RetentionBatchSize rows (default 1000) per transaction. RetentionArchiveDir writes deleted rows to gzipped
+	// NDJSON files there first; RetentionDryRun only logs what would be deleted. Set via RETENTION_SCANS_DAYS,
+	// RETENTION_SCANS_KEEP, RETENTION_AUDIT_DAYS, RETENTION_LOGIN_EVENTS_DAYS, RETENTION_LIFECYCLE_DAYS,
+	// RETENTION_TRASH_DAYS, RETENTION_INTERVAL, RETENTION_BATCH_SIZE, RETENTION_ARCHIVE_DIR and
+	// RETENTION_DRY_RUN=true.
+	RetentionScanDays       int
+	RetentionScanKeep       int
+	RetentionAuditDays      int
+	RetentionLoginEventDays int
+	RetentionLifecycleDays  int
+	RetentionTrashDays      int
+	RetentionInterval       time.Duration
+	RetentionBatchSize      int
+	RetentionArchiveDir     string
+	RetentionDryRun         bool
+	// ScanTraceroute runs scans with nmap --traceroute and records the hops for the network graph. Needs root
+	// (or CAP_NET_RAW) for nmap. Set via SCAN_TRACEROUTE=true.
+	ScanTraceroute bool
 
 	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
 	// When empty, the API listens with plain HTTP.
@@ -43,12 +99,93 @@ type Config struct {
 	// CORSAllowedOrigins is a list of origins allowed for CORS (e.g. https://app.example.com, http://localhost:3000).
 	// Set via CORS_ALLOWED_ORIGINS (comma-separated). When empty, no CORS headers are sent (same-origin only).
 	CORSAllowedOrigins []string
+
+	// PrometheusSDToken is a static read-only bearer token accepted by GET /v1/sd/prometheus (in addition to JWTs).
+	// It sees what the viewer role sees under access policies. Set via PROMETHEUS_SD_TOKEN. When empty, only JWTs
+	// are accepted.
+	PrometheusSDToken string
+
+	// PrometheusSDDefaultPort is the scrape port used for assets without a tag-specific port (default 9100, node_exporter).
+	PrometheusSDDefaultPort int
+
+	// PrometheusSDTagPorts maps a tag to the scrape port for assets carrying it (e.g. "postgres:9187,nginx:9113").
+	// Set via PROMETHEUS_SD_TAG_PORTS. The first matching tag (in the asset's tag order) wins.
+	PrometheusSDTagPorts map[string]int
+
+	// TrustProxyHeaders makes API key IP allowlists use X-Forwarded-For / X-Real-IP instead of the connection address.
+	// Set via TRUST_PROXY_HEADERS=true only when the API sits behind a reverse proxy that overwrites these headers.
+	TrustProxyHeaders bool
+
+	// OIDCIssuer enables OpenID Connect single sign-on when set (e.g. https://login.example.com/realms/ops).
+	// OIDCClientID and OIDCClientSecret are the API's client credentials at that issuer (secret may be empty for
+	// public clients). Set via OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCScopes are the scopes requested at login (default "openid profile email"). Set via OIDC_SCOPES (space-separated).
+	OIDCScopes []string
+	// OIDCGroupsClaim is the ID token claim listing the user's groups (default "groups").
+	OIDCGroupsClaim string
+	// OIDCAdminGroups and OIDCViewerGroups map groups to roles (comma-separated). When OIDC_VIEWER_GROUPS is set,
+	// users in neither list are refused; otherwise they become viewers.
+	OIDCAdminGroups  []string
+	OIDCViewerGroups []string
+
+	// DisablePasswordless rejects logins and registrations without a password (the legacy viewer shortcut).
+	// Set via DISABLE_PASSWORDLESS_LOGIN=true.
+	DisablePasswordless bool
+
+	// MFARequiredRoles must enroll in TOTP MFA before they can log in with a password (comma-separated,
+	// default "admin"; "none" disables enforcement). Users in other roles may still opt in.
+	// Set via MFA_REQUIRED_ROLES.
+	MFARequiredRoles []string
+	// MFAIssuer is the account issuer shown in authenticator apps (default "HCI Asset"). Set via MFA_ISSUER.
+	MFAIssuer string
+
+	// PasswordMinLength is the minimum length of new passwords (default 12). Set via PASSWORD_MIN_LENGTH.
+	PasswordMinLength int
+	// PasswordBreachedFile is a local list of passwords that must not be used: plain text or SHA-1 hashes
+	// (Have I Been Pwned format), one per line. Set via PASSWORD_BREACHED_FILE.
+	PasswordBreachedFile string
+
+	// LockoutThreshold consecutive failed logins lock an account for LockoutDuration, doubling with every
+	// further failure up to LockoutMaxDuration. Failures older than LockoutWindow are forgotten.
+	// Set via LOCKOUT_THRESHOLD (default 5; 0 disables), LOCKOUT_DURATION (1m), LOCKOUT_MAX_DURATION (1h)
+	// and LOCKOUT_WINDOW (15m).
+	LockoutThreshold   int
+	LockoutDuration    time.Duration
+	LockoutMaxDuration time.Duration
+	LockoutWindow      time.Duration
+
+	// LDAPURL enables LDAP / Active Directory logins when set (ldap://host:389 or ldaps://host:636). Local accounts
+	// are checked first. LDAPStartTLS upgrades ldap:// connections; LDAPCACert is a PEM file with the CA to trust;
+	// LDAPInsecureSkipVerify disables certificate checks (testing only).
+	LDAPURL                string
+	LDAPStartTLS           bool
+	LDAPCACert             string
+	LDAPInsecureSkipVerify bool
+	// LDAPBindDN and LDAPBindPassword are the service account used to search for users (empty = anonymous).
+	LDAPBindDN       string
+	LDAPBindPassword string
+	// LDAPUserBaseDN and LDAPUserFilter locate the user; {username} is replaced (default "(uid={username})").
+	LDAPUserBaseDN string
+	LDAPUserFilter string
+	// LDAPEmailAttr (default "mail") and LDAPGroupAttr (default "memberOf") are read from the user entry.
+	LDAPEmailAttr string
+	LDAPGroupAttr string
+	// LDAPGroupBaseDN enables a group search with LDAPGroupFilter ({dn} = user DN, default "(member={dn})").
+	LDAPGroupBaseDN string
+	LDAPGroupFilter string
+	// LDAPAdminGroups and LDAPViewerGroups map group CNs or DNs to roles (comma-separated), as for OIDC.
+	LDAPAdminGroups  []string
+	LDAPViewerGroups []string
+	// LDAPAutoCreate provisions local users on first directory login. Set via LDAP_AUTO_CREATE=true.
+	LDAPAutoCreate bool
 }
 
 func Load() Config {
 	return Config{
-		Port:     getEnv("PORT", "8080"),
-		APIToken: getEnv("API_TOKEN", "dev-token"),
+		Port: getEnv("PORT", "8080"),
 
 		DBHost: getEnv("DB_HOST", "localhost"),
 		DBPort: getEnv("DB_PORT", "5432"),
@@ -61,10 +198,40 @@ func Load() Config {
 
 		JWTSecret: getEnv("JWT_SECRET", "supersecretkey"),
 		Env:       getEnv("ENV", "dev"),
-		JWTExpireHours: getEnvInt("JWT_EXPIRE_HOURS", 24),
+		AccessTokenMinutes: getEnvInt("ACCESS_TOKEN_MINUTES", accessTokenMinutesDefault()),
+		RefreshTokenDays:   getEnvInt("REFRESH_TOKEN_DAYS", 30),
 
 		// Default "nmap" works on Linux/Mac when nmap is in PATH; set NMAP_PATH for Windows or custom install.
-		NmapPath: getEnv("NMAP_PATH", "nmap"),
+		NmapPath:       getEnv("NMAP_PATH", "nmap"),
+		ScanTraceroute: getEnv("SCAN_TRACEROUTE", "") == "true",
+
+		AssetOfflineAfter:   getEnvDuration("ASSET_OFFLINE_AFTER", 24*time.Hour),
+		DecommissionIPGrace: getEnvDuration("DECOMMISSION_IP_GRACE", 30*24*time.Hour),
+
+		SchedulerLeaseTTL:   getEnvDuration("SCHEDULER_LEASE_TTL", 30*time.Second),
+		SchedulerInstanceID: getEnv("SCHEDULER_INSTANCE_ID", ""),
+		ScheduleMaxFailures: getEnvIntAllowZero("SCHEDULE_MAX_FAILURES", 5),
+
+		AuditCheckpointFile:     getEnv("AUDIT_CHECKPOINT_FILE", ""),
+		AuditCheckpointInterval: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		AuditCheckpointKey:      getEnv("AUDIT_CHECKPOINT_KEY", ""),
+
+		AuditForwardAddr:     getEnv("AUDIT_FORWARD_ADDR", ""),
+		AuditForwardProtocol: getEnv("AUDIT_FORWARD_PROTOCOL", "tcp"),
+		AuditForwardFormat:   getEnv("AUDIT_FORWARD_FORMAT", "rfc5424"),
+		AuditForwardCACert:   getEnv("AUDIT_FORWARD_CA_CERT", ""),
+		AuditForwardInterval: getEnvDuration("AUDIT_FORWARD_INTERVAL", 5*time.Second),
+
+		RetentionScanDays:       getEnvIntAllowZero("RETENTION_SCANS_DAYS", 0),
+		RetentionScanKeep:       getEnvIntAllowZero("RETENTION_SCANS_KEEP", 10),
+		RetentionAuditDays:      getEnvIntAllowZero("RETENTION_AUDIT_DAYS", 0),
+		RetentionLoginEventDays: getEnvIntAllowZero("RETENTION_LOGIN_EVENTS_DAYS", 0),
+		RetentionLifecycleDays:  getEnvIntAllowZero("RETENTION_LIFECYCLE_DAYS", 0),
+		RetentionTrashDays:      getEnvIntAllowZero("RETENTION_TRASH_DAYS", 0),
+		RetentionInterval:       getEnvDuration("RETENTION_INTERVAL", time.Hour),
+		RetentionBatchSize:      getEnvInt("RETENTION_BATCH_SIZE", 1000),
+		RetentionArchiveDir:     getEnv("RETENTION_ARCHIVE_DIR", ""),
+		RetentionDryRun:         getEnv("RETENTION_DRY_RUN", "") == "true",
 
 		// Optional TLS configuration for HTTPS.
 		TLSCertFile: getEnv("TLS_CERT_FILE", ""),
@@ -73,11 +240,82 @@ func Load() Config {
 		LogFormat: getEnv("LOG_FORMAT", "text"),
 
 		CORSAllowedOrigins: parseCORSOrigins(getEnv("CORS_ALLOWED_ORIGINS", "")),
+
+		PrometheusSDToken:       getEnv("PROMETHEUS_SD_TOKEN", ""),
+		PrometheusSDDefaultPort: getEnvInt("PROMETHEUS_SD_DEFAULT_PORT", 9100),
+		PrometheusSDTagPorts:    parseTagPorts(getEnv("PROMETHEUS_SD_TAG_PORTS", "")),
+
+		TrustProxyHeaders: getEnv("TRUST_PROXY_HEADERS", "") == "true",
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCScopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid profile email")),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCAdminGroups:  parseList(getEnv("OIDC_ADMIN_GROUPS", "")),
+		OIDCViewerGroups: parseList(getEnv("OIDC_VIEWER_GROUPS", "")),
+
+		DisablePasswordless: getEnv("DISABLE_PASSWORDLESS_LOGIN", "") == "true",
+
+		MFARequiredRoles: parseMFARoles(getEnv("MFA_REQUIRED_ROLES", "admin")),
+		MFAIssuer:        getEnv("MFA_ISSUER", "HCI Asset"),
+
+		PasswordMinLength:    getEnvInt("PASSWORD_MIN_LENGTH", 12),
+		PasswordBreachedFile: getEnv("PASSWORD_BREACHED_FILE", ""),
+
+		LockoutThreshold:   getEnvIntAllowZero("LOCKOUT_THRESHOLD", 5),
+		LockoutDuration:    getEnvDuration("LOCKOUT_DURATION", time.Minute),
+		LockoutMaxDuration: getEnvDuration("LOCKOUT_MAX_DURATION", time.Hour),
+		LockoutWindow:      getEnvDuration("LOCKOUT_WINDOW", 15*time.Minute),
+
+		LDAPURL:                getEnv("LDAP_URL", ""),
+		LDAPStartTLS:           getEnv("LDAP_START_TLS", "") == "true",
+		LDAPCACert:             getEnv("LDAP_CA_CERT", ""),
+		LDAPInsecureSkipVerify: getEnv("LDAP_INSECURE_SKIP_VERIFY", "") == "true",
+		LDAPBindDN:             getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPUserBaseDN:         getEnv("LDAP_USER_BASE_DN", ""),
+		LDAPUserFilter:         getEnv("LDAP_USER_FILTER", "(uid={username})"),
+		LDAPEmailAttr:          getEnv("LDAP_EMAIL_ATTR", "mail"),
+		LDAPGroupAttr:          getEnv("LDAP_GROUP_ATTR", "memberOf"),
+		LDAPGroupBaseDN:        getEnv("LDAP_GROUP_BASE_DN", ""),
+		LDAPGroupFilter:        getEnv("LDAP_GROUP_FILTER", "(member={dn})"),
+		LDAPAdminGroups:        parseList(getEnv("LDAP_ADMIN_GROUPS", "")),
+		LDAPViewerGroups:       parseList(getEnv("LDAP_VIEWER_GROUPS", "")),
+		LDAPAutoCreate:         getEnv("LDAP_AUTO_CREATE", "") == "true",
 	}
 }
 
+// parseTagPorts parses "tag:port,tag:port" into a map. Malformed entries are skipped.
+func parseTagPorts(s string) map[string]int {
+	out := make(map[string]int)
+	for _, p := range strings.Split(s, ",") {
+		tag, portStr, ok := strings.Cut(strings.TrimSpace(p), ":")
+		if !ok || strings.TrimSpace(tag) == "" {
+			continue
+		}
+		if port, err := strconv.Atoi(strings.TrimSpace(portStr)); err == nil && port > 0 && port < 65536 {
+			out[strings.TrimSpace(tag)] = port
+		}
+	}
+	return out
+}
+
 // parseCORSOrigins splits a comma-separated list of origins and trims spaces. Empty strings are omitted.
 func parseCORSOrigins(s string) []string {
+	return parseList(s)
+}
+
+// parseMFARoles parses MFA_REQUIRED_ROLES; "none" turns enforcement off.
+func parseMFARoles(s string) []string {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		return nil
+	}
+	return parseList(s)
+}
+
+// parseList splits a comma-separated list and trims spaces. Empty strings are omitted.
+func parseList(s string) []string {
 	if s == "" {
 		return nil
 	}
@@ -91,6 +329,14 @@ func parseCORSOrigins(s string) []string {
 	return out
 }
 
+// accessTokenMinutesDefault maps the legacy JWT_EXPIRE_HOURS onto the access token lifetime, else 15 minutes.
+func accessTokenMinutesDefault() int {
+	if h := getEnvInt("JWT_EXPIRE_HOURS", 0); h > 0 {
+		return h * 60
+	}
+	return 15
+}
+
 func getEnvInt(key string, fallback int) int {
 	if v := os.Getenv(key); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
@@ -100,6 +346,26 @@ func getEnvInt(key string, fallback int) int {
 	return fallback
 }
 
+// getEnvIntAllowZero is getEnvInt but accepts 0 (e.g. to turn a feature off).
+func getEnvIntAllowZero(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getEnvDuration parses a Go duration such as "90s" or "15m".
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
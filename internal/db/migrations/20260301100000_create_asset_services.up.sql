@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS asset_services (
+    asset_id INT NOT NULL REFERENCES assets (id) ON DELETE CASCADE,
+    port INT NOT NULL,
+    protocol VARCHAR(10) NOT NULL DEFAULT 'tcp',
+    service VARCHAR(100) NOT NULL DEFAULT '',
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (asset_id, port, protocol)
+);
+
+CREATE INDEX IF NOT EXISTS idx_asset_services_port ON asset_services (port);
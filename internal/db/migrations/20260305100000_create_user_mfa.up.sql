@@ -0,0 +1,18 @@
+-- TOTP multi-factor authentication. enabled_at stays NULL until the user confirms enrollment with a valid code.
+-- last_used_step is the last accepted 30-second TOTP time step, so a code cannot be replayed.
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id INT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled_at TIMESTAMPTZ NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One-time recovery codes (SHA-256 hex of the code), removed with the user's MFA enrollment.
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES user_mfa (user_id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,25 @@
+-- Every login attempt (password, MFA and SSO steps). username is stored as typed so attempts against
+-- unknown accounts can be investigated too; user_id is set when the account is known.
+CREATE TABLE IF NOT EXISTS login_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NULL REFERENCES users (id) ON DELETE SET NULL,
+    username TEXT NOT NULL,
+    method VARCHAR(16) NOT NULL,
+    result VARCHAR(32) NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_login_events_username ON login_events (LOWER(username), created_at);
+CREATE INDEX IF NOT EXISTS idx_login_events_ip ON login_events (ip, created_at);
+
+-- Per-account lockout state, keyed by lower-cased username so it also covers directory accounts and
+-- names that do not exist (an attacker cannot tell the difference).
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    username TEXT PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_role;
+UPDATE users SET role = 'viewer' WHERE role NOT IN ('viewer', 'admin');
+ALTER TABLE users ADD CONSTRAINT chk_users_role CHECK (role IN ('viewer', 'admin'));
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,21 @@
+-- Roles are named sets of permissions. viewer and admin are built in and reproduce the old fixed roles:
+-- viewer can read everything including the audit log, admin has every permission ("*").
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(20) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO roles (name, description, permissions, builtin) VALUES
+    ('viewer', 'Read-only access', '{audit:read}', TRUE),
+    ('admin', 'Full access', '{*}', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+-- users.role now references a role instead of the fixed CHECK list; a role in use cannot be deleted.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_role;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_role;
+ALTER TABLE users ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES roles (name);
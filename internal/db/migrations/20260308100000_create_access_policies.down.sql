@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_assets_tags;
+DROP TABLE IF EXISTS access_policies;
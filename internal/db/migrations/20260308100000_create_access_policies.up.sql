@@ -0,0 +1,17 @@
+-- Access policies restrict a user or every user of a role to assets carrying at least one of tags.
+-- Users matched by no policy see every asset.
+CREATE TABLE IF NOT EXISTS access_policies (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    user_id INT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role VARCHAR(20) NULL REFERENCES roles (name) ON DELETE CASCADE,
+    tags TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_access_policies_subject CHECK ((user_id IS NULL) <> (role IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_policies_user_id ON access_policies (user_id);
+CREATE INDEX IF NOT EXISTS idx_access_policies_role ON access_policies (role);
+CREATE INDEX IF NOT EXISTS idx_assets_tags ON assets USING GIN (tags);
@@ -0,0 +1,10 @@
+ALTER TABLE access_policies DROP COLUMN IF EXISTS site_id;
+DROP INDEX IF EXISTS idx_scan_schedules_site_id;
+DROP INDEX IF EXISTS idx_saved_scans_site_id;
+DROP INDEX IF EXISTS idx_scan_jobs_site_id;
+DROP INDEX IF EXISTS idx_assets_site_network_name;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS site_id;
+ALTER TABLE saved_scans DROP COLUMN IF EXISTS site_id;
+ALTER TABLE scan_jobs DROP COLUMN IF EXISTS site_id;
+ALTER TABLE assets DROP COLUMN IF EXISTS site_id;
+DROP TABLE IF EXISTS sites;
@@ -0,0 +1,27 @@
+-- Sites partition the inventory (e.g. one per cluster or datacenter). Assets, scan jobs, saved scans and
+-- schedules belong to exactly one site; existing rows move to the seeded default site.
+CREATE TABLE IF NOT EXISTS sites (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(40) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO sites (id, name, description) VALUES (1, 'default', 'Default site')
+ON CONFLICT DO NOTHING;
+SELECT setval('sites_id_seq', GREATEST((SELECT MAX(id) FROM sites), 1));
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS site_id INT NOT NULL DEFAULT 1 REFERENCES sites (id);
+ALTER TABLE scan_jobs ADD COLUMN IF NOT EXISTS site_id INT NOT NULL DEFAULT 1 REFERENCES sites (id);
+ALTER TABLE saved_scans ADD COLUMN IF NOT EXISTS site_id INT NOT NULL DEFAULT 1 REFERENCES sites (id);
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS site_id INT NOT NULL DEFAULT 1 REFERENCES sites (id);
+
+-- Discovery keys assets by IP within a site, so overlapping private ranges on different sites stay apart.
+CREATE INDEX IF NOT EXISTS idx_assets_site_network_name ON assets (site_id, network_name);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_site_id ON scan_jobs (site_id);
+CREATE INDEX IF NOT EXISTS idx_saved_scans_site_id ON saved_scans (site_id);
+CREATE INDEX IF NOT EXISTS idx_scan_schedules_site_id ON scan_schedules (site_id);
+
+-- An access policy may confine its users to one site. NULL keeps every site visible.
+ALTER TABLE access_policies ADD COLUMN IF NOT EXISTS site_id INT NULL REFERENCES sites (id) ON DELETE CASCADE;
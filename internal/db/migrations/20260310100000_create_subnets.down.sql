@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ip_reservations;
+DROP TABLE IF EXISTS subnets;
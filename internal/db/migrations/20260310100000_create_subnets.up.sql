@@ -0,0 +1,22 @@
+-- Subnets describe the real networks of a site (replacing the guessed /24 per address). Assets are assigned
+-- to the most specific subnet containing their IP; reservations hold addresses that scans will not find.
+CREATE TABLE IF NOT EXISTS subnets (
+    id SERIAL PRIMARY KEY,
+    site_id INT NOT NULL DEFAULT 1 REFERENCES sites (id),
+    cidr CIDR NOT NULL,
+    vlan_id INT NULL CHECK (vlan_id BETWEEN 1 AND 4094),
+    gateway INET NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (site_id, cidr)
+);
+
+CREATE TABLE IF NOT EXISTS ip_reservations (
+    id SERIAL PRIMARY KEY,
+    subnet_id INT NOT NULL REFERENCES subnets (id) ON DELETE CASCADE,
+    ip INET NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (subnet_id, ip)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS asset_routes;
+DROP TABLE IF EXISTS asset_relationships;
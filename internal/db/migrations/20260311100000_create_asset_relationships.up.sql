@@ -0,0 +1,22 @@
+-- Relationships between assets, shown as edges of the network graph: a VM runs_on its hypervisor node, an
+-- application depends_on its database host.
+CREATE TABLE IF NOT EXISTS asset_relationships (
+    id SERIAL PRIMARY KEY,
+    asset_id INT NOT NULL REFERENCES assets (id) ON DELETE CASCADE,
+    related_asset_id INT NOT NULL REFERENCES assets (id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_asset_relationships_type CHECK (type IN ('runs_on', 'depends_on')),
+    CONSTRAINT chk_asset_relationships_self CHECK (asset_id <> related_asset_id),
+    UNIQUE (asset_id, related_asset_id, type)
+);
+
+CREATE INDEX IF NOT EXISTS idx_asset_relationships_related ON asset_relationships (related_asset_id);
+
+-- The path of the last traceroute to each asset: intermediate hop IPs, nearest first (scans with SCAN_TRACEROUTE).
+CREATE TABLE IF NOT EXISTS asset_routes (
+    asset_id INT PRIMARY KEY REFERENCES assets (id) ON DELETE CASCADE,
+    hops TEXT[] NOT NULL DEFAULT '{}',
+    traced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
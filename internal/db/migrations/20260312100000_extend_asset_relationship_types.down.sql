@@ -0,0 +1,3 @@
+DELETE FROM asset_relationships WHERE type IN ('backs_up', 'member_of');
+ALTER TABLE asset_relationships DROP CONSTRAINT IF EXISTS chk_asset_relationships_type;
+ALTER TABLE asset_relationships ADD CONSTRAINT chk_asset_relationships_type CHECK (type IN ('runs_on', 'depends_on'));
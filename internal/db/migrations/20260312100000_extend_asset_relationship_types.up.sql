@@ -0,0 +1,4 @@
+-- backs_up (a backup server or replica of the related asset) and member_of (a node of a cluster or pool).
+ALTER TABLE asset_relationships DROP CONSTRAINT IF EXISTS chk_asset_relationships_type;
+ALTER TABLE asset_relationships ADD CONSTRAINT chk_asset_relationships_type
+    CHECK (type IN ('runs_on', 'depends_on', 'backs_up', 'member_of'));
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS asset_lifecycle_events;
+DROP INDEX IF EXISTS idx_assets_deleted_at;
+DELETE FROM assets WHERE deleted_at IS NOT NULL;
+ALTER TABLE assets DROP CONSTRAINT IF EXISTS chk_assets_lifecycle;
+ALTER TABLE assets DROP COLUMN IF EXISTS ip_excluded_until;
+ALTER TABLE assets DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE assets DROP COLUMN IF EXISTS owner;
+ALTER TABLE assets DROP COLUMN IF EXISTS lifecycle;
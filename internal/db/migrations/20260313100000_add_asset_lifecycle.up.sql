@@ -0,0 +1,25 @@
+-- Lifecycle state and owner (user or team) of each asset. Deleting an asset moves it to the trash
+-- (deleted_at) so it can be restored and so scans do not recreate it; decommissioning keeps the asset and
+-- its history but stops scans from recreating its IP until ip_excluded_until.
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS lifecycle VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS owner VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ NULL;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS ip_excluded_until TIMESTAMPTZ NULL;
+ALTER TABLE assets DROP CONSTRAINT IF EXISTS chk_assets_lifecycle;
+ALTER TABLE assets ADD CONSTRAINT chk_assets_lifecycle
+    CHECK (lifecycle IN ('planned', 'active', 'maintenance', 'retired', 'decommissioned'));
+
+CREATE INDEX IF NOT EXISTS idx_assets_deleted_at ON assets (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Every lifecycle change of an asset, with who made it and why (e.g. the decommission reason).
+CREATE TABLE IF NOT EXISTS asset_lifecycle_events (
+    id SERIAL PRIMARY KEY,
+    asset_id INT NOT NULL REFERENCES assets (id) ON DELETE CASCADE,
+    from_state VARCHAR(20) NOT NULL,
+    to_state VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    user_id INT NULL REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_asset_lifecycle_events_asset_id ON asset_lifecycle_events (asset_id);
@@ -0,0 +1,9 @@
+-- Leases elect one API instance to run a background job (e.g. the scan scheduler) when several replicas share
+-- the database. The holder renews its lease before expires_at; any instance may take over an expired lease.
+CREATE TABLE IF NOT EXISTS leases (
+    name VARCHAR(100) PRIMARY KEY,
+    holder VARCHAR(255) NOT NULL,
+    acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    renewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
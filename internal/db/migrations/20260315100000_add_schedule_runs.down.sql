@@ -0,0 +1,6 @@
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS consecutive_failures;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS last_status;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS last_run_at;
+
+DROP INDEX IF EXISTS idx_scan_jobs_schedule_id;
+ALTER TABLE scan_jobs DROP COLUMN IF EXISTS schedule_id;
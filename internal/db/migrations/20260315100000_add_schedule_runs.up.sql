@@ -0,0 +1,9 @@
+-- Scan jobs started by a schedule point back to it, so each schedule has a run history.
+ALTER TABLE scan_jobs ADD COLUMN IF NOT EXISTS schedule_id INT NULL REFERENCES scan_schedules (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_schedule_id ON scan_jobs (schedule_id, id DESC) WHERE schedule_id IS NOT NULL;
+
+-- Outcome of each schedule's latest run. consecutive_failures counts runs ending in error since the last
+-- complete one; a schedule is disabled when it reaches SCHEDULE_MAX_FAILURES.
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS last_run_at TIMESTAMPTZ NULL;
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS last_status VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS consecutive_failures INT NOT NULL DEFAULT 0;
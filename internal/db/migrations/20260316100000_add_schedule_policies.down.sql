@@ -0,0 +1,11 @@
+DELETE FROM scan_jobs WHERE status = 'skipped';
+ALTER TABLE scan_jobs DROP COLUMN IF EXISTS skip_reason;
+
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_jitter_seconds;
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_overlap_policy;
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_blackout_action;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS overlap_policy;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS jitter_seconds;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS blackout_action;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS blackout_windows;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS timezone;
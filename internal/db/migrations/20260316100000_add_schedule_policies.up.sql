@@ -0,0 +1,20 @@
+-- Per-schedule run policies. timezone is an IANA name the cron expression is evaluated in ('' = server local
+-- time). blackout_windows is a JSON list of {"days": ["sat", "sun"], "start": "22:00", "end": "06:00"} in that
+-- timezone during which runs are skipped or, with blackout_action 'defer', started when the window ends.
+-- jitter_seconds delays each run by a random amount up to that many seconds. overlap_policy decides what
+-- happens when a run is due while the previous one is still going.
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS blackout_windows JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS blackout_action VARCHAR(10) NOT NULL DEFAULT 'skip';
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS jitter_seconds INT NOT NULL DEFAULT 0;
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS overlap_policy VARCHAR(20) NOT NULL DEFAULT 'skip';
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_blackout_action;
+ALTER TABLE scan_schedules ADD CONSTRAINT chk_scan_schedules_blackout_action CHECK (blackout_action IN ('skip', 'defer'));
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_overlap_policy;
+ALTER TABLE scan_schedules ADD CONSTRAINT chk_scan_schedules_overlap_policy
+    CHECK (overlap_policy IN ('skip', 'queue', 'cancel_previous'));
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_jitter_seconds;
+ALTER TABLE scan_schedules ADD CONSTRAINT chk_scan_schedules_jitter_seconds CHECK (jitter_seconds >= 0);
+
+-- Runs a schedule skipped (status 'skipped') and why, e.g. a blackout window or the previous run still going.
+ALTER TABLE scan_jobs ADD COLUMN IF NOT EXISTS skip_reason TEXT NULL;
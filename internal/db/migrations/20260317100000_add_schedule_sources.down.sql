@@ -0,0 +1,7 @@
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_source;
+-- Copy saved scan targets back; asset group schedules have no single target and are removed.
+UPDATE scan_schedules s SET target = ss.target FROM saved_scans ss WHERE s.saved_scan_id = ss.id;
+DELETE FROM scan_schedules WHERE target = '';
+DROP INDEX IF EXISTS idx_scan_schedules_saved_scan_id;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS asset_tag;
+ALTER TABLE scan_schedules DROP COLUMN IF EXISTS saved_scan_id;
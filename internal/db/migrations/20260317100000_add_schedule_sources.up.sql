@@ -0,0 +1,15 @@
+-- A schedule scans either its own target, a saved scan (saved_scan_id) or an asset group: the assets tagged
+-- asset_tag in the schedule's site. Saved scans and asset groups are resolved when each run starts, so editing
+-- a saved scan or retagging assets applies to the next run. target is '' for schedules that reference one.
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS saved_scan_id INT NULL REFERENCES saved_scans (id) ON DELETE RESTRICT;
+ALTER TABLE scan_schedules ADD COLUMN IF NOT EXISTS asset_tag VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_scan_schedules_saved_scan_id ON scan_schedules (saved_scan_id) WHERE saved_scan_id IS NOT NULL;
+
+-- Point existing schedules at the saved scan of their site with the same target (the oldest one if several).
+UPDATE scan_schedules s SET saved_scan_id = ss.id, target = ''
+FROM (SELECT DISTINCT ON (site_id, target) id, site_id, target FROM saved_scans ORDER BY site_id, target, id) ss
+WHERE s.saved_scan_id IS NULL AND s.site_id = ss.site_id AND s.target = ss.target;
+
+ALTER TABLE scan_schedules DROP CONSTRAINT IF EXISTS chk_scan_schedules_source;
+ALTER TABLE scan_schedules ADD CONSTRAINT chk_scan_schedules_source
+    CHECK (num_nonnulls(NULLIF(target, ''), saved_scan_id, NULLIF(asset_tag, '')) = 1);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS audit_log_chain ON audit_log;
+DROP FUNCTION IF EXISTS audit_log_chain();
+DROP FUNCTION IF EXISTS audit_log_hash(TEXT, INT, TIMESTAMPTZ, INT, TEXT, TEXT, INT, TEXT);
+
+ALTER TABLE audit_log
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,50 @@
+-- Chain audit entries: each entry stores the hash of the previous entry and a SHA-256 over that hash and its
+-- own contents, so editing, deleting or inserting entries breaks the chain (see GET /audit/verify).
+ALTER TABLE audit_log
+    ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS hash VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Must match models.AuditEntry.ChainHash.
+CREATE OR REPLACE FUNCTION audit_log_hash(p_prev_hash TEXT, p_id INT, p_created_at TIMESTAMPTZ, p_user_id INT, p_action TEXT,
+    p_resource_type TEXT, p_resource_id INT, p_details TEXT) RETURNS VARCHAR(64) AS $$
+    SELECT encode(sha256(convert_to(concat_ws(E'\n',
+        p_prev_hash,
+        p_id,
+        to_char(p_created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+        p_user_id,
+        p_action,
+        p_resource_type,
+        p_resource_id,
+        COALESCE(p_details, '')
+    ), 'UTF8')), 'hex')
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Chain the existing entries in ID order.
+DO $$
+DECLARE
+    e RECORD;
+    prev VARCHAR(64) := '';
+BEGIN
+    FOR e IN SELECT * FROM audit_log ORDER BY id LOOP
+        UPDATE audit_log
+        SET prev_hash = prev,
+            hash = audit_log_hash(prev, e.id, e.created_at, e.user_id, e.action, e.resource_type, e.resource_id, e.details)
+        WHERE id = e.id
+        RETURNING hash INTO prev;
+    END LOOP;
+END $$;
+
+-- Writers are serialized and the ID is drawn inside the lock, so IDs follow the chain even with concurrent inserts.
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('audit_log_chain'));
+    NEW.id := nextval(pg_get_serial_sequence('audit_log', 'id'));
+    NEW.prev_hash := COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '');
+    NEW.hash := audit_log_hash(NEW.prev_hash, NEW.id, NEW.created_at, NEW.user_id, NEW.action, NEW.resource_type,
+        NEW.resource_id, NEW.details);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_chain ON audit_log;
+CREATE TRIGGER audit_log_chain BEFORE INSERT ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_chain();
@@ -0,0 +1,24 @@
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('audit_log_chain'));
+    NEW.id := nextval(pg_get_serial_sequence('audit_log', 'id'));
+    NEW.prev_hash := COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '');
+    NEW.hash := audit_log_hash(NEW.prev_hash, NEW.id, NEW.created_at, NEW.user_id, NEW.action, NEW.resource_type,
+        NEW.resource_id, NEW.details);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS audit_log_hash_v2(TEXT, INT, TIMESTAMPTZ, INT, TEXT, TEXT, INT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP INDEX IF EXISTS idx_audit_log_actor;
+
+-- System entries have no user; entries written since the upgrade no longer verify after downgrading.
+DELETE FROM audit_log WHERE user_id IS NULL;
+ALTER TABLE audit_log
+    DROP COLUMN IF EXISTS hash_version,
+    DROP COLUMN IF EXISTS request_id,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS actor,
+    DROP COLUMN IF EXISTS actor_type,
+    ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,54 @@
+-- Audit entries record who acted and from where: a user (actor = username at the time) or a system component
+-- (actor_type 'system', user_id NULL, e.g. actor 'scheduler'), with the client IP, user agent and request ID.
+-- details holds JSON (before/after for updates).
+ALTER TABLE audit_log
+    ALTER COLUMN user_id DROP NOT NULL,
+    ALTER COLUMN action TYPE VARCHAR(50),
+    ALTER COLUMN resource_type TYPE VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS actor_type VARCHAR(10) NOT NULL DEFAULT 'user',
+    ADD COLUMN IF NOT EXISTS actor VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip VARCHAR(45) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS request_id VARCHAR(64) NOT NULL DEFAULT '',
+    -- 1: entries chained before this migration, hashed without the columns above. New entries use 2.
+    ADD COLUMN IF NOT EXISTS hash_version SMALLINT NOT NULL DEFAULT 1;
+
+-- Earlier entries name their user as it is now (not covered by their version 1 hash).
+UPDATE audit_log a SET actor = u.username FROM users u WHERE u.id = a.user_id AND a.actor = '';
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_type, actor);
+
+-- Must match models.AuditEntry.ChainHash for hash_version 2. The "v2" line cannot be an ID, so a version 2
+-- entry never hashes like a version 1 entry.
+CREATE OR REPLACE FUNCTION audit_log_hash_v2(p_prev_hash TEXT, p_id INT, p_created_at TIMESTAMPTZ, p_user_id INT, p_action TEXT,
+    p_resource_type TEXT, p_resource_id INT, p_actor_type TEXT, p_actor TEXT, p_ip TEXT, p_user_agent TEXT,
+    p_request_id TEXT, p_details TEXT) RETURNS VARCHAR(64) AS $$
+    SELECT encode(sha256(convert_to(concat_ws(E'\n',
+        p_prev_hash,
+        'v2',
+        p_id,
+        to_char(p_created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+        COALESCE(p_user_id::TEXT, ''),
+        p_action,
+        p_resource_type,
+        p_resource_id,
+        p_actor_type,
+        p_actor,
+        p_ip,
+        p_user_agent,
+        p_request_id,
+        COALESCE(p_details, '')
+    ), 'UTF8')), 'hex')
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('audit_log_chain'));
+    NEW.id := nextval(pg_get_serial_sequence('audit_log', 'id'));
+    NEW.prev_hash := COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '');
+    NEW.hash_version := 2;
+    NEW.hash := audit_log_hash_v2(NEW.prev_hash, NEW.id, NEW.created_at, NEW.user_id, NEW.action, NEW.resource_type,
+        NEW.resource_id, NEW.actor_type, NEW.actor, NEW.ip, NEW.user_agent, NEW.request_id, NEW.details);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
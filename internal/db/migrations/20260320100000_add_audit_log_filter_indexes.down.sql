@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_audit_log_actor_lower;
+DROP INDEX IF EXISTS idx_audit_log_user;
@@ -0,0 +1,3 @@
+-- Audit log filters: entries by user, and by actor (matched case-insensitively).
+CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_lower ON audit_log (LOWER(actor));
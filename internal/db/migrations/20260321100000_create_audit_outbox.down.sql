@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS audit_log_enqueue ON audit_log;
+DROP FUNCTION IF EXISTS audit_log_enqueue();
+DROP TABLE IF EXISTS audit_outbox;
@@ -0,0 +1,15 @@
+-- Audit entries waiting to be forwarded to a syslog collector (AUDIT_FORWARD_ADDR). Every new entry is queued in
+-- the transaction that logs it, so entries are not lost while the collector or the API is down; the forwarder
+-- removes them once sent.
+CREATE TABLE IF NOT EXISTS audit_outbox (
+    audit_id INT PRIMARY KEY REFERENCES audit_log (id) ON DELETE CASCADE
+);
+
+CREATE OR REPLACE FUNCTION audit_log_enqueue() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO audit_outbox (audit_id) VALUES (NEW.id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_enqueue AFTER INSERT ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_enqueue();
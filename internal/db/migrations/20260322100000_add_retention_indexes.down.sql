@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_scan_jobs_site_target;
+DROP INDEX IF EXISTS idx_asset_lifecycle_events_created_at;
@@ -0,0 +1,3 @@
+-- Retention pruning finds old lifecycle events by time and keeps the newest scan jobs of each target.
+CREATE INDEX IF NOT EXISTS idx_asset_lifecycle_events_created_at ON asset_lifecycle_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_scan_jobs_site_target ON scan_jobs (site_id, target, id DESC);
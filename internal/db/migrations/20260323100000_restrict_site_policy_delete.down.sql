@@ -0,0 +1,3 @@
+ALTER TABLE access_policies DROP CONSTRAINT IF EXISTS access_policies_site_id_fkey;
+ALTER TABLE access_policies ADD CONSTRAINT access_policies_site_id_fkey
+    FOREIGN KEY (site_id) REFERENCES sites (id) ON DELETE CASCADE;
@@ -0,0 +1,5 @@
+-- Deleting a site used to delete the access policies confining users to it, which left those users
+-- unrestricted. Such sites now cannot be deleted until their policies are changed or removed.
+ALTER TABLE access_policies DROP CONSTRAINT IF EXISTS access_policies_site_id_fkey;
+ALTER TABLE access_policies ADD CONSTRAINT access_policies_site_id_fkey
+    FOREIGN KEY (site_id) REFERENCES sites (id) ON DELETE RESTRICT;
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// ==========================
+// AccessPolicyHandler
+// ==========================
+// AccessPolicyHandler manages the tag- and site-based policies that limit which assets users and roles can see.
+type AccessPolicyHandler struct {
+	Repo      *repo.AccessPolicyRepo
+	AuditRepo *repo.AuditRepo
+}
+
+func (h *AccessPolicyHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "access_policy", id, details)
+}
+
+// auditBefore returns policy id as it is before a change, when changes are audited.
+func (h *AccessPolicyHandler) auditBefore(r *http.Request, id int) *models.AccessPolicy {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	p, _ := h.Repo.GetByID(r.Context(), id)
+	return p
+}
+
+// decodeAccessPolicy reads and validates a policy body, writing 400 and returning false if it is invalid.
+func decodeAccessPolicy(w http.ResponseWriter, r *http.Request) (models.AccessPolicy, bool) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		UserID      *int     `json:"user_id"`
+		Role        string   `json:"role"`
+		Tags        []string `json:"tags"`
+		SiteID      *int     `json:"site_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return models.AccessPolicy{}, false
+	}
+	p := models.AccessPolicy{
+		Name:        strings.TrimSpace(input.Name),
+		Description: strings.TrimSpace(input.Description),
+		UserID:      input.UserID,
+		Role:        strings.TrimSpace(input.Role),
+		Tags:        []string{},
+		SiteID:      input.SiteID,
+	}
+	seen := make(map[string]bool)
+	for _, t := range input.Tags {
+		if t = strings.TrimSpace(t); t != "" && !seen[t] {
+			seen[t] = true
+			p.Tags = append(p.Tags, t)
+		}
+	}
+
+	fields := make(map[string]string)
+	if p.Name == "" {
+		fields["name"] = "required"
+	}
+	if (p.UserID == nil) == (p.Role == "") {
+		fields["user_id"] = "set exactly one of user_id and role"
+	}
+	if len(p.Tags) == 0 && p.SiteID == nil {
+		fields["tags"] = "at least one tag required unless site_id is set"
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return models.AccessPolicy{}, false
+	}
+	return p, true
+}
+
+// writeAccessPolicyError maps repository errors to responses.
+func writeAccessPolicyError(w http.ResponseWriter, op string, err error) {
+	if err == repo.ErrAccessPolicyNotFound {
+		JSONError(w, "access policy not found", http.StatusNotFound)
+		return
+	}
+	if e, ok := err.(*pq.Error); ok && e.Code == "23503" {
+		field, msg := "user_id", "unknown user"
+		if strings.Contains(e.Constraint, "role") {
+			field, msg = "role", "unknown role"
+		} else if strings.Contains(e.Constraint, "site") {
+			field, msg = "site_id", "unknown site"
+		}
+		JSONValidationError(w, "validation failed", map[string]string{field: msg}, http.StatusBadRequest)
+		return
+	}
+	log.Printf("%s: %v", op, err)
+	JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+}
+
+// ==========================
+// List Access Policies
+// ==========================
+func (h *AccessPolicyHandler) ListAccessPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := h.Repo.List(r.Context())
+	if err != nil {
+		writeAccessPolicyError(w, "ListAccessPolicies", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": policies,
+		"total": len(policies),
+	})
+}
+
+// ==========================
+// Get Access Policy
+// ==========================
+func (h *AccessPolicyHandler) GetAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid access policy id", http.StatusBadRequest)
+		return
+	}
+	p, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeAccessPolicyError(w, "GetAccessPolicy", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// ==========================
+// Create Access Policy
+// ==========================
+func (h *AccessPolicyHandler) CreateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeAccessPolicy(w, r)
+	if !ok {
+		return
+	}
+	p, err := h.Repo.Create(r.Context(), input)
+	if err != nil {
+		writeAccessPolicyError(w, "CreateAccessPolicy", err)
+		return
+	}
+
+	h.audit(r, "create", p.ID, map[string]interface{}{"after": p})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// ==========================
+// Update Access Policy
+// ==========================
+func (h *AccessPolicyHandler) UpdateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid access policy id", http.StatusBadRequest)
+		return
+	}
+	input, ok := decodeAccessPolicy(w, r)
+	if !ok {
+		return
+	}
+	before := h.auditBefore(r, id)
+	p, err := h.Repo.Update(r.Context(), id, input)
+	if err != nil {
+		writeAccessPolicyError(w, "UpdateAccessPolicy", err)
+		return
+	}
+
+	h.audit(r, "update", id, auditChange(before, p))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// ==========================
+// Delete Access Policy
+// ==========================
+func (h *AccessPolicyHandler) DeleteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid access policy id", http.StatusBadRequest)
+		return
+	}
+	before := h.auditBefore(r, id)
+	if err := h.Repo.Delete(r.Context(), id); err != nil {
+		writeAccessPolicyError(w, "DeleteAccessPolicy", err)
+		return
+	}
+
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
+	w.WriteHeader(http.StatusNoContent)
+}
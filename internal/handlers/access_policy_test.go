@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestAccessPolicyHandler_CreateAccessPolicy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &AccessPolicyHandler{Repo: repo.NewAccessPolicyRepo(db)}
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO access_policies \(name, description, user_id, role, tags, site_id\)`).
+		WithArgs("team-a", "", nil, "netops", `{"team-a"}`, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "user_id", "role", "tags", "site_id", "created_at", "updated_at"}).
+			AddRow(1, "team-a", "", nil, "netops", "{team-a}", nil, now, now))
+
+	rr := httptest.NewRecorder()
+	h.CreateAccessPolicy(rr, postJSON("/access-policies", map[string]interface{}{"name": "team-a", "role": "netops", "tags": []string{"team-a", " team-a "}}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201; body %s", rr.Code, rr.Body.String())
+	}
+
+	// A policy applies to a user or a role, not both.
+	rr = httptest.NewRecorder()
+	h.CreateAccessPolicy(rr, postJSON("/access-policies", map[string]interface{}{"name": "x", "user_id": 2, "role": "netops", "tags": []string{"team-a"}}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("user_id and role: got %d, want 400", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetHandler_CreateAsset_OutsideAccessPolicy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &AssetHandler{Repo: repo.NewAssetRepo(db)}
+
+	req := postJSON("/assets", map[string]interface{}{"name": "db-1", "description": "database", "tags": []string{"team-b"}})
+	req = req.WithContext(repo.WithAssetScope(req.Context(), []string{"team-a"}))
+	rr := httptest.NewRecorder()
+	h.CreateAsset(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("status: got %d, want 403", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// ==========================
+// APIKeyHandler
+// ==========================
+type APIKeyHandler struct {
+	Repo            *repo.APIKeyRepo
+	ServiceAccounts *repo.ServiceAccountRepo
+	AuditRepo       *repo.AuditRepo
+}
+
+func (h *APIKeyHandler) audit(r *http.Request, action, resourceType string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, resourceType, id, details)
+}
+
+// ==========================
+// List API Keys
+// ==========================
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Repo.List(r.Context())
+	if err != nil {
+		log.Printf("ListAPIKeys: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": keys,
+		"total": len(keys),
+	})
+}
+
+// ==========================
+// Create API Key (returns the raw key once; only its hash is stored)
+// ==========================
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string     `json:"name"`
+		UserID        int        `json:"user_id"`
+		Scopes        []string   `json:"scopes"`
+		AllowedIPs    []string   `json:"allowed_ips"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		ExpiresInDays int        `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	currentUserID, _ := middleware.GetUserID(r.Context())
+	if input.UserID == 0 {
+		input.UserID = currentUserID
+	}
+
+	fields := make(map[string]string)
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		fields["name"] = "required"
+	}
+	if len(input.Scopes) == 0 {
+		fields["scopes"] = "at least one scope required"
+	}
+	for _, s := range input.Scopes {
+		if !validScope(s) {
+			fields["scopes"] = "must be one of " + strings.Join(models.APIKeyScopes, ", ")
+			break
+		}
+	}
+	for _, ip := range input.AllowedIPs {
+		if !validIPOrCIDR(ip) {
+			fields["allowed_ips"] = "must be IP addresses or CIDRs"
+			break
+		}
+	}
+	if input.ExpiresAt != nil && input.ExpiresInDays > 0 {
+		fields["expires_at"] = "set expires_at or expires_in_days, not both"
+	} else if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		fields["expires_at"] = "must be in the future"
+	} else if input.ExpiresInDays < 0 {
+		fields["expires_in_days"] = "must be positive"
+	}
+	if input.UserID <= 0 {
+		fields["user_id"] = "required"
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	// A key acts as its user, so keys for other users are limited to service accounts: minting one for another
+	// person would let the caller act, and be audited, as them.
+	if input.UserID != currentUserID {
+		isService := false
+		if h.ServiceAccounts != nil {
+			var err error
+			if isService, err = h.ServiceAccounts.IsServiceAccount(r.Context(), input.UserID); err != nil {
+				log.Printf("CreateAPIKey: %v", err)
+				JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+				return
+			}
+		}
+		if !isService {
+			JSONError(w, "API keys can only be created for yourself or a service account", http.StatusForbidden)
+			return
+		}
+	}
+
+	expiresAt := input.ExpiresAt
+	if input.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(input.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	key, raw, err := h.Repo.Create(r.Context(), input.UserID, input.Name, input.Scopes, input.AllowedIPs, expiresAt, currentUserID)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == "23503" {
+			JSONValidationError(w, "validation failed", map[string]string{"user_id": "user not found"}, http.StatusBadRequest)
+			return
+		}
+		log.Printf("CreateAPIKey: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "create", "api_key", key.ID, map[string]interface{}{"after": key})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": key,
+		"key":     raw,
+	})
+}
+
+// ==========================
+// Revoke API Key
+// ==========================
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid api key id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.Revoke(r.Context(), id); err != nil {
+		if err == repo.ErrAPIKeyNotFound {
+			JSONError(w, "api key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("RevokeAPIKey: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "revoke", "api_key", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// List Service Accounts
+// ==========================
+func (h *APIKeyHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.ServiceAccounts.List(r.Context())
+	if err != nil {
+		log.Printf("ListServiceAccounts: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": accounts,
+		"total": len(accounts),
+	})
+}
+
+// ==========================
+// Create Service Account (no password; cannot log in, only use API keys)
+// ==========================
+func (h *APIKeyHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Username    string `json:"username"`
+		Role        string `json:"role"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	if strings.TrimSpace(input.Username) == "" {
+		fields["username"] = "required"
+	}
+	if input.Role == "" {
+		input.Role = models.RoleViewer
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	currentUserID, _ := middleware.GetUserID(r.Context())
+	sa, err := h.ServiceAccounts.Create(r.Context(), strings.TrimSpace(input.Username), input.Role, input.Description, currentUserID)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+			JSONError(w, "username already exists", http.StatusConflict)
+			return
+		}
+		if isUnknownRole(err) {
+			JSONValidationError(w, "validation failed", map[string]string{"role": "unknown role"}, http.StatusBadRequest)
+			return
+		}
+		log.Printf("CreateServiceAccount: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "create", "service_account", sa.ID, map[string]interface{}{"after": sa})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sa)
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validIPOrCIDR(s string) bool {
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return true
+	}
+	return net.ParseIP(s) != nil
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestAPIKeyHandler_CreateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM service_accounts WHERE user_id = \$1\)`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`INSERT INTO api_keys`).
+		WithArgs(9, "ci", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(1, "create", "api_key", 4, sqlmock.AnyArg(), "user", "", "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	h := &APIKeyHandler{Repo: repo.NewAPIKeyRepo(db), ServiceAccounts: repo.NewServiceAccountRepo(db), AuditRepo: repo.NewAuditRepo(db)}
+
+	body := []byte(`{"name":"ci","user_id":9,"scopes":["read","assets:write"]}`)
+	req := httptest.NewRequest("POST", "/api-keys", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+	rr := httptest.NewRecorder()
+	h.CreateAPIKey(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("CreateAPIKey status: got %d, want 201: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Key    string `json:"key"`
+		APIKey struct {
+			ID     int    `json:"id"`
+			Prefix string `json:"prefix"`
+		} `json:"api_key"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.APIKey.ID != 4 || !strings.HasPrefix(out.Key, "hci_"+out.APIKey.Prefix+"_") {
+		t.Errorf("unexpected response: %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAPIKeyHandler_CreateAPIKey_OtherHumanUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM service_accounts WHERE user_id = \$1\)`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	h := &APIKeyHandler{Repo: repo.NewAPIKeyRepo(db), ServiceAccounts: repo.NewServiceAccountRepo(db)}
+
+	body := []byte(`{"name":"admin key","user_id":2,"scopes":["*"]}`)
+	req := httptest.NewRequest("POST", "/api-keys", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+	rr := httptest.NewRecorder()
+	h.CreateAPIKey(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("CreateAPIKey status: got %d, want 403: %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAPIKeyHandler_CreateAPIKey_Validation(t *testing.T) {
+	h := &APIKeyHandler{}
+
+	body := []byte(`{"name":"","user_id":9,"scopes":["everything"],"allowed_ips":["not-an-ip"]}`)
+	req := httptest.NewRequest("POST", "/api-keys", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.CreateAPIKey(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status: got %d, want 400", rr.Code)
+	}
+	var out struct {
+		Fields map[string]string `json:"fields"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&out)
+	for _, f := range []string{"name", "scopes", "allowed_ips"} {
+		if out.Fields[f] == "" {
+			t.Errorf("expected validation error for %s, got %v", f, out.Fields)
+		}
+	}
+}
+
+func TestAPIKeyHandler_RevokeAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at`).
+		WithArgs(12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	h := &APIKeyHandler{Repo: repo.NewAPIKeyRepo(db)}
+	req := requestWithChiURLParams("DELETE", "/api-keys/12", nil, map[string]string{"id": "12"})
+	rr := httptest.NewRecorder()
+	h.RevokeAPIKey(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("status: got %d, want 404", rr.Code)
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/crucial707/hci-asset/internal/middleware"
 	"github.com/crucial707/hci-asset/internal/models"
@@ -15,15 +18,68 @@ import (
 const (
 	MaxNameLength        = 100
 	MaxDescriptionLength = 500
+	MaxOwnerLength       = 100
 )
 
 // ==========================
 // Asset Input Struct
 // ==========================
+// Owner and Lifecycle are optional: a nil Owner or empty Lifecycle leaves them unchanged (new assets are
+// active). Decommissioning goes through POST /assets/{id}/decommission instead.
 type AssetInput struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Tags        []string `json:"tags"`
+	Owner       *string  `json:"owner"`
+	Lifecycle   string   `json:"lifecycle"`
+}
+
+// validateLifecycle adds the errors in input's owner and lifecycle to fields.
+func (input *AssetInput) validateLifecycle(fields map[string]string) {
+	if input.Owner != nil {
+		owner := strings.TrimSpace(*input.Owner)
+		input.Owner = &owner
+		if len(owner) > MaxOwnerLength {
+			fields["owner"] = "too long"
+		}
+	}
+	input.Lifecycle = strings.TrimSpace(input.Lifecycle)
+	switch {
+	case input.Lifecycle == "":
+	case input.Lifecycle == models.LifecycleDecommissioned:
+		fields["lifecycle"] = "use POST /assets/{id}/decommission"
+	case !validLifecycle(input.Lifecycle):
+		fields["lifecycle"] = "must be one of: " + strings.Join(models.LifecycleStates, ", ")
+	}
+}
+
+func validLifecycle(state string) bool {
+	for _, s := range models.LifecycleStates {
+		if state == s {
+			return true
+		}
+	}
+	return false
+}
+
+// applyLifecycle sets input's owner and lifecycle on a saved asset, returning the updated asset.
+func (h *AssetHandler) applyLifecycle(r *http.Request, asset *models.Asset, input AssetInput) (*models.Asset, error) {
+	var err error
+	if input.Owner != nil && *input.Owner != asset.Owner {
+		if asset, err = h.Repo.SetOwner(r.Context(), asset.ID, *input.Owner); err != nil {
+			return nil, err
+		}
+	}
+	if input.Lifecycle != "" && input.Lifecycle != asset.Lifecycle {
+		var userID *int
+		if id, ok := middleware.GetUserID(r.Context()); ok {
+			userID = &id
+		}
+		if asset, err = h.Repo.SetLifecycle(r.Context(), asset.ID, input.Lifecycle, "", userID); err != nil {
+			return nil, err
+		}
+	}
+	return asset, nil
 }
 
 // ==========================
@@ -32,6 +88,19 @@ type AssetInput struct {
 type AssetHandler struct {
 	Repo      *repo.AssetRepo
 	AuditRepo *repo.AuditRepo
+	// DecommissionIPGrace is how long scans leave a decommissioned asset's IP alone (default 30 days).
+	DecommissionIPGrace time.Duration
+}
+
+// tagsInScope writes 403 and returns false when the caller is restricted by access policies and tags would
+// put the asset outside what they can see.
+func (h *AssetHandler) tagsInScope(w http.ResponseWriter, r *http.Request, tags []string) bool {
+	if repo.InAssetScope(r.Context(), tags) {
+		return true
+	}
+	allowed, _ := repo.AssetScope(r.Context())
+	JSONValidationError(w, "asset outside your access policy", map[string]string{"tags": "must include one of: " + strings.Join(allowed, ", ")}, http.StatusForbidden)
+	return false
 }
 
 // ==========================
@@ -57,22 +126,25 @@ func (h *AssetHandler) CreateAsset(w http.ResponseWriter, r *http.Request) {
 	if len(input.Description) > MaxDescriptionLength {
 		fields["description"] = "too long"
 	}
+	input.validateLifecycle(fields)
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
+	if !h.tagsInScope(w, r, input.Tags) || !requireSite(r.Context(), w) {
+		return
+	}
 
 	asset, err := h.Repo.Create(r.Context(), input.Name, input.Description, input.Tags)
+	if err == nil {
+		asset, err = h.applyLifecycle(r, asset, input)
+	}
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "create", "asset", asset.ID, "")
-		}
-	}
+	h.audit(r, "create", asset.ID, map[string]interface{}{"after": asset})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(asset)
@@ -184,22 +256,30 @@ func (h *AssetHandler) UpdateAsset(w http.ResponseWriter, r *http.Request) {
 	if len(input.Description) > MaxDescriptionLength {
 		fields["description"] = "too long"
 	}
+	input.validateLifecycle(fields)
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
+	if !h.tagsInScope(w, r, input.Tags) {
+		return
+	}
 
+	before := h.auditBefore(r, id)
 	asset, err := h.Repo.Update(r.Context(), id, input.Name, input.Description, input.Tags)
+	if err == nil {
+		asset, err = h.applyLifecycle(r, asset, input)
+	}
 	if err != nil {
+		if errors.Is(err, repo.ErrAssetNotFound) {
+			JSONError(w, "asset not found", http.StatusNotFound)
+			return
+		}
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "update", "asset", id, "")
-		}
-	}
+	h.audit(r, "update", id, auditChange(before, asset))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(asset)
@@ -218,7 +298,7 @@ func (h *AssetHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 
 	asset, err := h.Repo.Heartbeat(r.Context(), id)
 	if err != nil {
-		if err.Error() == "asset not found" {
+		if errors.Is(err, repo.ErrAssetNotFound) {
 			JSONError(w, "asset not found", http.StatusNotFound)
 			return
 		}
@@ -226,12 +306,14 @@ func (h *AssetHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.audit(r, "heartbeat", id, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(asset)
 }
 
 // ==========================
-// Delete Asset
+// Delete Asset (moves it to the trash)
 // ==========================
 func (h *AssetHandler) DeleteAsset(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -241,22 +323,23 @@ func (h *AssetHandler) DeleteAsset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := h.auditBefore(r, id)
 	if err := h.Repo.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, repo.ErrAssetNotFound) {
+			JSONError(w, "asset not found", http.StatusNotFound)
+			return
+		}
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "delete", "asset", id, "")
-		}
-	}
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // ==========================
-// Batch Delete Assets
+// Batch Delete Assets (moves them to the trash)
 // ==========================
 func (h *AssetHandler) BatchDeleteAssets(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -275,15 +358,12 @@ func (h *AssetHandler) BatchDeleteAssets(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 	deleted := 0
 	for _, id := range input.IDs {
+		before := h.auditBefore(r, id)
 		if err := h.Repo.Delete(ctx, id); err != nil {
 			continue // skip not-found or other errors, keep going
 		}
 		deleted++
-		if h.AuditRepo != nil {
-			if userID, ok := middleware.GetUserID(ctx); ok {
-				_ = h.AuditRepo.Log(ctx, userID, "delete", "asset", id, "")
-			}
-		}
+		h.audit(r, "delete", id, map[string]interface{}{"before": before, "batch": true})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
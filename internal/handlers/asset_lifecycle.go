@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultDecommissionIPGrace is used when AssetHandler.DecommissionIPGrace is not set.
+const defaultDecommissionIPGrace = 30 * 24 * time.Hour
+
+func (h *AssetHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "asset", id, details)
+}
+
+// auditBefore returns asset id as it is before a change, when changes are audited.
+func (h *AssetHandler) auditBefore(r *http.Request, id int) *models.Asset {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	a, _ := h.Repo.Get(r.Context(), id)
+	return a
+}
+
+// ==========================
+// Decommission Asset
+// ==========================
+func (h *AssetHandler) DecommissionAsset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	fields := make(map[string]string)
+	if input.Reason == "" {
+		fields["reason"] = "required"
+	} else if len(input.Reason) > MaxDescriptionLength {
+		fields["reason"] = "too long"
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	before := h.auditBefore(r, id)
+	grace := h.DecommissionIPGrace
+	if grace <= 0 {
+		grace = defaultDecommissionIPGrace
+	}
+	var userID *int
+	if uid, ok := middleware.GetUserID(r.Context()); ok {
+		userID = &uid
+	}
+	asset, err := h.Repo.Decommission(r.Context(), id, input.Reason, time.Now().Add(grace), userID)
+	if err != nil {
+		if err == models.ErrAssetDecommissioned {
+			JSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, repo.ErrAssetNotFound) {
+			JSONError(w, "asset not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("DecommissionAsset: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	details := auditChange(before, asset)
+	details["reason"] = input.Reason
+	h.audit(r, "decommission", id, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(asset)
+}
+
+// ==========================
+// Lifecycle History
+// ==========================
+func (h *AssetHandler) LifecycleHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.Repo.Get(r.Context(), id); err != nil {
+		JSONError(w, "asset not found", http.StatusNotFound)
+		return
+	}
+	events, err := h.Repo.LifecycleEvents(r.Context(), id)
+	if err != nil {
+		log.Printf("LifecycleHistory: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": events,
+		"total": len(events),
+	})
+}
+
+// ==========================
+// List Trash
+// ==========================
+func (h *AssetHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	offset := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil && val > 0 {
+			limit = val
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if val, err := strconv.Atoi(o); err == nil && val >= 0 {
+			offset = val
+		}
+	}
+
+	assets, err := h.Repo.ListTrash(r.Context(), limit, offset)
+	var total int
+	if err == nil {
+		total, err = h.Repo.CountTrash(r.Context())
+	}
+	if err != nil {
+		log.Printf("ListTrash error: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if assets == nil {
+		assets = []models.Asset{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":  assets,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ==========================
+// Restore Asset (from the trash)
+// ==========================
+func (h *AssetHandler) RestoreAsset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return
+	}
+	asset, err := h.Repo.Restore(r.Context(), id)
+	if err != nil {
+		if err == repo.ErrAssetNotFound {
+			JSONError(w, "asset not in trash", http.StatusNotFound)
+			return
+		}
+		log.Printf("RestoreAsset: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "restore", id, map[string]interface{}{"after": asset})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(asset)
+}
+
+// ==========================
+// Purge Asset (permanently delete from the trash)
+// ==========================
+func (h *AssetHandler) PurgeAsset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.Purge(r.Context(), id); err != nil {
+		if err == repo.ErrAssetNotFound {
+			JSONError(w, "asset not in trash", http.StatusNotFound)
+			return
+		}
+		log.Printf("PurgeAsset: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "purge", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
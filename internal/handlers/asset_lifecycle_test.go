@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestAssetHandler_DecommissionAsset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &AssetHandler{Repo: repo.NewAssetRepo(db), DecommissionIPGrace: time.Hour}
+
+	// A reason is required.
+	rr := httptest.NewRecorder()
+	h.DecommissionAsset(rr, requestWithChiURLParams("POST", "/assets/5/decommission", []byte(`{"reason": " "}`), map[string]string{"id": "5"}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("missing reason: got %d, want 400", rr.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT lifecycle FROM assets WHERE id=\$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"lifecycle"}).AddRow("active"))
+	mock.ExpectExec(`UPDATE assets SET lifecycle = \$1, ip_excluded_until = \$2 WHERE id = \$3`).
+		WithArgs("decommissioned", sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO asset_lifecycle_events`).
+		WithArgs(5, "active", "decommissioned", "replaced by pve4", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	until := time.Now().Add(time.Hour)
+	mock.ExpectQuery(`FROM assets WHERE id=\$1 AND deleted_at IS NULL`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(5, "pve3", "", "{}", nil, "10.0.0.13", "decommissioned", "", nil, until))
+
+	req := requestWithChiURLParams("POST", "/assets/5/decommission", []byte(`{"reason": "replaced by pve4"}`), map[string]string{"id": "5"})
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 2))
+	rr = httptest.NewRecorder()
+	h.DecommissionAsset(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	var got models.Asset
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Lifecycle != models.LifecycleDecommissioned || got.IPExcludedUntil == nil {
+		t.Errorf("unexpected asset: %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetHandler_CreateAsset_InvalidLifecycle(t *testing.T) {
+	h := &AssetHandler{}
+	for _, body := range []string{
+		`{"name": "web1", "description": "web", "lifecycle": "broken"}`,
+		`{"name": "web1", "description": "web", "lifecycle": "decommissioned"}`,
+	} {
+		rr := httptest.NewRecorder()
+		h.CreateAsset(rr, requestWithChiURLParams("POST", "/assets", []byte(body), nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rr.Code)
+		}
+	}
+}
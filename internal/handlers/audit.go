@@ -1,39 +1,99 @@
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/crucial707/hci-asset/internal/audit"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
+	chimw "github.com/go-chi/chi/v5/middleware"
 )
 
 // AuditHandler serves audit log endpoints.
 type AuditHandler struct {
 	Repo *repo.AuditRepo
+	// CheckpointFile holds the signed chain checkpoints VerifyAudit compares against, signed with CheckpointKey.
+	// Empty when checkpoints are disabled.
+	CheckpointFile string
+	CheckpointKey  []byte
 }
 
-// ListAudit returns recent audit log entries. Query: limit (default 50), offset (default 0).
+// auditExportFlushRows is how many exported entries are buffered before they are flushed to the client.
+const auditExportFlushRows = 500
+
+// auditFilter reads the audit filters of query q: user_id, actor, actor_type, action, resource_type,
+// resource_id, since and until (RFC 3339) and q (text in details). Invalid values are added to fields.
+func auditFilter(q url.Values, fields map[string]string) models.AuditFilter {
+	f := models.AuditFilter{
+		Actor:        q.Get("actor"),
+		ActorType:    q.Get("actor_type"),
+		Action:       q.Get("action"),
+		ResourceType: q.Get("resource_type"),
+		Query:        q.Get("q"),
+	}
+	if f.ActorType != "" && f.ActorType != models.AuditActorUser && f.ActorType != models.AuditActorSystem {
+		fields["actor_type"] = "must be user or system"
+	}
+	for name, dst := range map[string]*int{"user_id": &f.UserID, "resource_id": &f.ResourceID} {
+		if s := q.Get(name); s != "" {
+			if id, err := strconv.Atoi(s); err == nil && id > 0 {
+				*dst = id
+			} else {
+				fields[name] = "must be a positive integer"
+			}
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if s := q.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				fields[name] = "must be an RFC 3339 timestamp"
+				continue
+			}
+			*dst = t
+		}
+	}
+	return f
+}
+
+// ListAudit returns audit log entries, newest first. Query: the filters of auditFilter, limit (default 50,
+// max 200), offset (default 0).
 func (h *AuditHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
 	limit := 50
 	offset := 0
-	if l := r.URL.Query().Get("limit"); l != "" {
+	if l := q.Get("limit"); l != "" {
 		if val, err := strconv.Atoi(l); err == nil && val > 0 && val <= 200 {
 			limit = val
 		}
 	}
-	if o := r.URL.Query().Get("offset"); o != "" {
+	if o := q.Get("offset"); o != "" {
 		if val, err := strconv.Atoi(o); err == nil && val >= 0 {
 			offset = val
 		}
 	}
+	fields := make(map[string]string)
+	f := auditFilter(q, fields)
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
 
-	entries, err := h.Repo.List(r.Context(), limit, offset)
+	entries, err := h.Repo.List(r.Context(), f, limit, offset)
 	if err != nil {
+		log.Printf("ListAudit: %v", err)
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
-	total, err := h.Repo.Count(r.Context())
+	total, err := h.Repo.Count(r.Context(), f)
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
@@ -47,3 +107,169 @@ func (h *AuditHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
 		"offset": offset,
 	})
 }
+
+// ExportAudit streams every audit entry matching the filters of auditFilter, oldest first, as CSV or NDJSON
+// (one JSON entry per line). Query: format (csv or ndjson, default csv) and the filters. There is no limit.
+func (h *AuditHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	fields := make(map[string]string)
+	if format != "csv" && format != "ndjson" {
+		fields["format"] = "must be csv or ndjson"
+	}
+	f := auditFilter(q, fields)
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	flush := func() {
+		cw.Flush()
+		_ = rc.Flush()
+	}
+	// The response starts with the first entry, so a query that fails right away still gets a 500.
+	started := false
+	begin := func() {
+		if started {
+			return
+		}
+		started = true
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+			cw.Write([]string{"id", "created_at", "actor_type", "actor", "user_id", "action", "resource_type", "resource_id",
+				"ip", "user_agent", "request_id", "details", "prev_hash", "hash"})
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit-log.ndjson"`)
+		}
+	}
+	n := 0
+	err := h.Repo.Export(r.Context(), f, func(e models.AuditEntry) error {
+		begin()
+		if format == "csv" {
+			userID := ""
+			if e.UserID != 0 {
+				userID = strconv.Itoa(e.UserID)
+			}
+			cw.Write([]string{strconv.Itoa(e.ID), e.CreatedAt.UTC().Format(time.RFC3339Nano), e.ActorType, e.Actor, userID,
+				e.Action, e.ResourceType, strconv.Itoa(e.ResourceID), e.IP, e.UserAgent, e.RequestID, e.Details,
+				e.PrevHash, e.Hash})
+		} else if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if n++; n%auditExportFlushRows == 0 {
+			flush()
+			return cw.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ExportAudit: %v", err)
+		if !started {
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		}
+		return
+	}
+	begin()
+	flush()
+}
+
+// VerifyAudit walks the audit hash chain and the signed checkpoints and reports the first broken link.
+// It answers 200 with "valid": false when the log was tampered with.
+func (h *AuditHandler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	v, err := audit.Verify(r.Context(), h.Repo, h.CheckpointFile, h.CheckpointKey)
+	if err != nil {
+		log.Printf("VerifyAudit: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if !v.Valid {
+		log.Printf("VerifyAudit: audit chain broken at entry %d: %s", v.BrokenLink.ID, v.BrokenLink.Reason)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// maxAuditUserAgent bounds the user agent stored with audit entries.
+const maxAuditUserAgent = 512
+
+// logAudit records an audit entry for the user authenticated on r (nothing for anonymous requests), see
+// logAuditAs.
+func logAudit(audits *repo.AuditRepo, r *http.Request, action, resourceType string, id int, details map[string]interface{}) {
+	if userID, ok := middleware.GetUserID(r.Context()); ok {
+		logAuditAs(audits, r, userID, action, resourceType, id, details)
+	}
+}
+
+// logAuditAs records an audit entry for userID (e.g. a user logging in) with the client IP, user agent and
+// request ID of r. details (may be nil) is stored as JSON with the API key used, if any. Failures are logged,
+// not reported to the client. audits may be nil.
+func logAuditAs(audits *repo.AuditRepo, r *http.Request, userID int, action, resourceType string, id int, details map[string]interface{}) {
+	if audits == nil {
+		return
+	}
+	ip, _ := middleware.GetClientIP(r.Context())
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxAuditUserAgent {
+		userAgent = userAgent[:maxAuditUserAgent]
+	}
+	err := audits.Record(r.Context(), models.AuditEntry{
+		UserID:       userID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   id,
+		IP:           ip,
+		UserAgent:    userAgent,
+		RequestID:    chimw.GetReqID(r.Context()),
+		Details:      auditDetails(r.Context(), details),
+	})
+	if err != nil {
+		log.Printf("audit %s %s %d: %v", action, resourceType, id, err)
+	}
+}
+
+// logSystemAudit records an audit entry for system component actor (e.g. models.AuditSystemScheduler).
+// details (may be nil) is stored as JSON. Failures are logged. audits may be nil.
+func logSystemAudit(ctx context.Context, audits *repo.AuditRepo, actor, action, resourceType string, id int, details map[string]interface{}) {
+	if audits == nil {
+		return
+	}
+	if err := audits.LogSystem(ctx, actor, action, resourceType, id, auditDetails(ctx, details)); err != nil {
+		log.Printf("audit %s %s %d: %v", action, resourceType, id, err)
+	}
+}
+
+// auditChange returns audit details recording a resource before and after an update.
+func auditChange(before, after interface{}) map[string]interface{} {
+	return map[string]interface{}{"before": before, "after": after}
+}
+
+// auditDetails returns details as a JSON object, adding the API key ID when the request was authenticated with
+// an API key so actions by service accounts can be traced to the key that performed them. "" when empty.
+func auditDetails(ctx context.Context, details map[string]interface{}) string {
+	keyID, ok := middleware.GetAPIKeyID(ctx)
+	if len(details) == 0 && !ok {
+		return ""
+	}
+	out := make(map[string]interface{}, len(details)+1)
+	for k, v := range details {
+		out[k] = v
+	}
+	if ok {
+		out["api_key_id"] = keyID
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		log.Printf("audit details: %v", err)
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+// nonEmptyArg matches any non-empty string argument.
+type nonEmptyArg struct{}
+
+func (nonEmptyArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && s != ""
+}
+
+func TestLogAudit_RecordsRequestContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	audits := repo.NewAuditRepo(db)
+
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(7, "update", "asset", 42, `{"after":{"name":"web02"},"before":{"name":"web01"}}`,
+			models.AuditActorUser, "", "192.0.2.1", "curl/8.0", nonEmptyArg{}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	h := chimw.RequestID(middleware.RequestClientIP(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logAudit(audits, r, "update", "asset", 42, auditChange(map[string]string{"name": "web01"}, map[string]string{"name": "web02"}))
+	})))
+	req := httptest.NewRequest("PUT", "/assets/42", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 7))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestLogAudit_Anonymous(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// Without an authenticated user nothing is recorded (see logAuditAs for logins).
+	logAudit(repo.NewAuditRepo(db), httptest.NewRequest("POST", "/scan", nil), "start", "scan", 1, nil)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestLogSystemAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// System entries have no user, request or client.
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(nil, "run", "schedule", 5, `{"job_id":"17"}`, models.AuditActorSystem, models.AuditSystemScheduler, "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	logSystemAudit(context.Background(), repo.NewAuditRepo(db), models.AuditSystemScheduler, "run", "schedule", 5,
+		map[string]interface{}{"job_id": "17"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// auditEntryRows returns audit_log rows (see repo.auditColumns) for entries.
+func auditEntryRows(entries ...models.AuditEntry) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "action", "resource_type", "resource_id", "actor_type", "actor", "ip",
+		"user_agent", "request_id", "details", "created_at", "prev_hash", "hash", "hash_version"})
+	for _, e := range entries {
+		rows.AddRow(e.ID, e.UserID, e.Action, e.ResourceType, e.ResourceID, e.ActorType, e.Actor, e.IP, e.UserAgent,
+			e.RequestID, e.Details, e.CreatedAt, e.PrevHash, e.Hash, 2)
+	}
+	return rows
+}
+
+func TestAuditHandler_ListAuditFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &AuditHandler{Repo: repo.NewAuditRepo(db)}
+
+	rr := httptest.NewRecorder()
+	h.ListAudit(rr, httptest.NewRequest("GET", "/audit?resource_id=x&since=yesterday&actor_type=robot", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid filters: got %d, want 400", rr.Code)
+	}
+	for _, field := range []string{"resource_id", "since", "actor_type"} {
+		if !strings.Contains(rr.Body.String(), field) {
+			t.Errorf("invalid filters: %s not reported in %s", field, rr.Body.String())
+		}
+	}
+
+	since := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM audit_log WHERE LOWER\(actor\) = LOWER\(\$1\) AND resource_type = \$2 AND resource_id = \$3 AND created_at >= \$4 AND STRPOS\(LOWER\(details\), LOWER\(\$5\)\) > 0 ORDER BY created_at DESC, id DESC LIMIT \$6 OFFSET \$7`).
+		WithArgs("alice", "asset", 5, since, "web01", 20, 0).
+		WillReturnRows(auditEntryRows(models.AuditEntry{ID: 9, UserID: 2, Actor: "alice", ActorType: models.AuditActorUser, Action: "update", ResourceType: "asset", ResourceID: 5}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM audit_log WHERE LOWER\(actor\) = LOWER\(\$1\)`).
+		WithArgs("alice", "asset", 5, since, "web01").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	rr = httptest.NewRecorder()
+	h.ListAudit(rr, httptest.NewRequest("GET", "/audit?actor=alice&resource_type=asset&resource_id=5&since=2026-03-01T00:00:00Z&q=web01&limit=20", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"total":1`) {
+		t.Errorf("body: %s", rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAuditHandler_ExportAudit(t *testing.T) {
+	created := time.Date(2026, 3, 19, 10, 0, 0, 0, time.UTC)
+	entries := []models.AuditEntry{
+		{ID: 1, UserID: 2, ActorType: models.AuditActorUser, Actor: "alice", Action: "update", ResourceType: "asset", ResourceID: 5,
+			IP: "192.0.2.1", Details: `{"after":{"name":"web01, east"}}`, CreatedAt: created, Hash: "h1"},
+		{ID: 2, ActorType: models.AuditActorSystem, Actor: models.AuditSystemScheduler, Action: "run", ResourceType: "schedule",
+			ResourceID: 3, CreatedAt: created, PrevHash: "h1", Hash: "h2"},
+	}
+	tests := []struct {
+		format      string
+		contentType string
+		want        []string
+	}{
+		{"csv", "text/csv; charset=utf-8", []string{
+			"id,created_at,actor_type,actor,user_id,action,resource_type,resource_id,ip,user_agent,request_id,details,prev_hash,hash",
+			`1,2026-03-19T10:00:00Z,user,alice,2,update,asset,5,192.0.2.1,,,"{""after"":{""name"":""web01, east""}}",,h1`,
+			"2,2026-03-19T10:00:00Z,system,scheduler,,run,schedule,3,,,,,h1,h2",
+		}},
+		{"ndjson", "application/x-ndjson", []string{
+			`{"id":1,"user_id":2,"action":"update","resource_type":"asset","resource_id":5,"actor_type":"user","actor":"alice","ip":"192.0.2.1","details":"{\"after\":{\"name\":\"web01, east\"}}","created_at":"2026-03-19T10:00:00Z","prev_hash":"","hash":"h1"}`,
+			`{"id":2,"user_id":0,"action":"run","resource_type":"schedule","resource_id":3,"actor_type":"system","actor":"scheduler","created_at":"2026-03-19T10:00:00Z","prev_hash":"h1","hash":"h2"}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New: %v", err)
+			}
+			defer db.Close()
+			mock.ExpectQuery(`FROM audit_log WHERE created_at < \$1 ORDER BY id`).
+				WithArgs(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)).
+				WillReturnRows(auditEntryRows(entries...))
+
+			rr := httptest.NewRecorder()
+			(&AuditHandler{Repo: repo.NewAuditRepo(db)}).ExportAudit(rr,
+				httptest.NewRequest("GET", "/audit/export?format="+tt.format+"&until=2026-04-01T00:00:00Z", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type: got %q, want %q", got, tt.contentType)
+			}
+			if got, want := strings.TrimRight(rr.Body.String(), "\n"), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("body:\ngot  %s\nwant %s", got, want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuditHandler_ExportAuditErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &AuditHandler{Repo: repo.NewAuditRepo(db)}
+
+	rr := httptest.NewRecorder()
+	h.ExportAudit(rr, httptest.NewRequest("GET", "/audit/export?format=xml", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: got %d, want 400", rr.Code)
+	}
+
+	// A query that fails before the first entry is reported, not sent as an empty export.
+	mock.ExpectQuery(`FROM audit_log ORDER BY id`).WillReturnError(errors.New("connection reset"))
+	rr = httptest.NewRecorder()
+	h.ExportAudit(rr, httptest.NewRequest("GET", "/audit/export", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("query error: got %d, want 500", rr.Code)
+	}
+}
@@ -2,24 +2,46 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/middleware"
 	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // ==========================
 // Auth Handler
 // ==========================
 type AuthHandler struct {
-	UserRepo     *repo.UserRepo
-	Secret       []byte
-	ExpireHours  int // JWT token lifetime in hours
+	UserRepo           *repo.UserRepo
+	Secret             []byte
+	AccessTokenMinutes int // access token (JWT) lifetime in minutes (default 15)
+	RefreshTokenDays   int // refresh token lifetime in days (default 30)
+	// Sessions, when set, issues a rotating refresh token with each login and backs Refresh and Logout.
+	Sessions *repo.SessionRepo
+	// Providers check credentials in order (see auth.Authenticate). When empty, only the local (bcrypt) provider is used.
+	Providers []auth.Provider
+	// DisablePasswordless requires a password for every local login and registration (no username-only viewers).
+	DisablePasswordless bool
+	// MFA, when set, turns Login into a two-step login for users with TOTP enabled (see MFAHandler).
+	MFA *repo.MFARepo
+	// MFARequiredRoles must enroll in MFA before they get a session (default none; config default "admin").
+	MFARequiredRoles []string
+	// Events, when set, records every login attempt and enforces Lockout per account.
+	Events  *repo.LoginEventRepo
+	Lockout auth.LockoutPolicy
+	// PasswordPolicy applies to passwords set at registration (nil = only bcrypt's 72-byte limit).
+	PasswordPolicy *auth.PasswordPolicy
+	// TrustProxyHeaders records the client IP from X-Forwarded-For / X-Real-IP in login events.
+	TrustProxyHeaders bool
+	// AuditRepo, when set, audits registrations, logins and logouts.
+	AuditRepo *repo.AuditRepo
 }
 
 // ==========================
@@ -47,6 +69,12 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 	if role == models.RoleAdmin && input.Password == "" {
 		fields["password"] = "required for admin"
+	} else if h.DisablePasswordless && input.Password == "" {
+		fields["password"] = "required"
+	} else if input.Password != "" {
+		if err := h.PasswordPolicy.Check(input.Username, input.Password); err != nil {
+			fields["password"] = err.Error()
+		}
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
@@ -70,13 +98,15 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	// Self-registered: attribute the entry to the new user.
+	logAuditAs(h.AuditRepo, r, user.ID, "register", "user", user.ID, map[string]interface{}{"after": user})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
 // ==========================
-// Login (only viewer can log in without password; admin and any non-viewer require password)
+// Login (tries Providers in order; locally only viewer can log in without password; MFA users get a challenge)
 // ==========================
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -89,46 +119,164 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.UserRepo.GetByUsername(r.Context(), input.Username)
+	if h.checkLocked(w, r, nil, input.Username, models.LoginMethodPassword) {
+		return
+	}
+	user, err := auth.Authenticate(r.Context(), h.providers(), input.Username, input.Password)
 	if err != nil {
-		JSONError(w, "invalid credentials", http.StatusUnauthorized)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			h.loginFailed(r, nil, input.Username, models.LoginMethodPassword, models.LoginResultFailure)
+			JSONError(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Login: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	// Only "viewer" (view only) can log in without a password; admin and any other role require password.
-	if user.Role != models.RoleViewer {
-		if input.Password == "" {
-			JSONError(w, "invalid credentials", http.StatusUnauthorized)
+	if h.MFA != nil && h.mfaChallenge(w, r, user) {
+		return
+	}
+	h.loginSucceeded(r, user, models.LoginMethodPassword)
+	h.writeTokens(w, r, user, "")
+}
+
+// providers returns the configured providers, or the local provider alone.
+func (h *AuthHandler) providers() []auth.Provider {
+	if len(h.Providers) > 0 {
+		return h.Providers
+	}
+	return []auth.Provider{&auth.LocalProvider{Users: h.UserRepo, DisablePasswordless: h.DisablePasswordless}}
+}
+
+// ==========================
+// Refresh (rotates the refresh token; the old one is revoked, and reusing it revokes the whole session)
+// ==========================
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if input.RefreshToken == "" || h.Sessions == nil {
+		JSONError(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	userID, refresh, err := h.Sessions.RotateRefreshToken(r.Context(), input.RefreshToken, h.refreshTTL())
+	if err != nil {
+		if err == repo.ErrRefreshTokenInvalid || err == repo.ErrRefreshTokenReused {
+			if err == repo.ErrRefreshTokenReused {
+				log.Printf("Refresh: reuse of a rotated refresh token; session revoked")
+			}
+			JSONError(w, "invalid refresh token", http.StatusUnauthorized)
 			return
 		}
-		if user.PasswordHash == "" {
-			JSONError(w, "invalid credentials", http.StatusUnauthorized)
+		log.Printf("Refresh: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	// Re-read the user so the new access token carries the current role.
+	user, err := h.UserRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	h.writeTokens(w, r, user, refresh)
+}
+
+// ==========================
+// Logout (revokes the current access token and, if given, the refresh token; all=true revokes every session of the user)
+// ==========================
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			JSONError(w, "invalid json", http.StatusBadRequest)
 			return
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
-			JSONError(w, "invalid credentials", http.StatusUnauthorized)
+	}
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.Sessions == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if jti, exp, ok := middleware.GetTokenID(r.Context()); ok {
+		if exp.IsZero() {
+			exp = time.Now().Add(h.accessTTL())
+		}
+		if err := h.Sessions.RevokeAccessToken(r.Context(), jti, exp); err != nil {
+			log.Printf("Logout: revoke access token: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 			return
 		}
-	} else if user.PasswordHash != "" {
-		// Viewer with optional password set: still allow username-only, but if password provided, verify it
-		if input.Password != "" {
-			if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
-				JSONError(w, "invalid credentials", http.StatusUnauthorized)
-				return
-			}
+	}
+	if input.RefreshToken != "" {
+		if err := h.Sessions.RevokeRefreshToken(r.Context(), input.RefreshToken); err != nil {
+			log.Printf("Logout: revoke refresh token: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
 		}
 	}
+	if input.All {
+		if err := h.Sessions.RevokeAllForUser(r.Context(), userID); err != nil {
+			log.Printf("Logout: revoke all sessions: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+	}
+	logAuditAs(h.AuditRepo, r, userID, "logout", "user", userID, map[string]interface{}{"all": input.All})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) accessTTL() time.Duration {
+	if h.AccessTokenMinutes > 0 {
+		return time.Duration(h.AccessTokenMinutes) * time.Minute
+	}
+	return 15 * time.Minute
+}
 
-	expHours := h.ExpireHours
-	if expHours <= 0 {
-		expHours = 24
+func (h *AuthHandler) refreshTTL() time.Duration {
+	if h.RefreshTokenDays > 0 {
+		return time.Duration(h.RefreshTokenDays) * 24 * time.Hour
 	}
+	return 30 * 24 * time.Hour
+}
+
+// writeTokens signs an access token for user and writes the login response. When Sessions is set and
+// refresh is empty, a new refresh token family is started (login); Refresh passes the rotated token.
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, r *http.Request, user *models.User, refresh string) {
+	h.writeLogin(w, r, user, refresh, nil)
+}
+
+// writeLogin is writeTokens with extra response fields (e.g. recovery codes issued during MFA enrollment).
+func (h *AuthHandler) writeLogin(w http.ResponseWriter, r *http.Request, user *models.User, refresh string, extra map[string]interface{}) {
+	jti, err := repo.NewTokenID()
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	ttl := h.accessTTL()
 	// Create JWT token
 	claims := jwt.MapClaims{
 		"user_id":  user.ID,
 		"username": user.Username,
 		"role":     user.Role,
-		"exp":      time.Now().Add(time.Duration(expHours) * time.Hour).Unix(),
+		"jti":      jti,
+		"iat":      float64(now.UnixMicro()) / 1e6, // sub-second, see SessionRepo.ValidateSession
+		"exp":      now.Add(ttl).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -138,9 +286,26 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	out := map[string]interface{}{
+		"token":      signed,
+		"expires_in": int(ttl.Seconds()),
+		"user":       user,
+	}
+	if h.Sessions != nil {
+		if refresh == "" {
+			refresh, err = h.Sessions.CreateRefreshToken(r.Context(), user.ID, h.refreshTTL())
+			if err != nil {
+				log.Printf("Login: create refresh token: %v", err)
+				JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+				return
+			}
+		}
+		out["refresh_token"] = refresh
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token": signed,
-		"user":  user,
-	})
+	json.NewEncoder(w).Encode(out)
 }
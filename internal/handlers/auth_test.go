@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/auth"
 	"github.com/crucial707/hci-asset/internal/repo"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -308,3 +309,88 @@ func TestAuthHandler_Register_BadJSON(t *testing.T) {
 		t.Errorf("expectations: %v", err)
 	}
 }
+
+func TestAuthHandler_Login_ServiceAccountRejected(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs("ci-bot").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(9, "ci-bot", nil, "viewer"))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM service_accounts`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	h := &AuthHandler{Secret: []byte("s"), Providers: []auth.Provider{
+		&auth.LocalProvider{Users: repo.NewUserRepo(db), ServiceAccounts: repo.NewServiceAccountRepo(db)},
+	}}
+	req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader([]byte(`{"username":"ci-bot"}`)))
+	rr := httptest.NewRecorder()
+	h.Login(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status: got %d, want 401", rr.Code)
+	}
+}
+
+func TestAuthHandler_Login_PasswordlessDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "alice", nil, "viewer"))
+
+	h := &AuthHandler{UserRepo: repo.NewUserRepo(db), Secret: []byte("test-secret"), DisablePasswordless: true}
+
+	body, _ := json.Marshal(map[string]string{"username": "alice"})
+	req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.Login(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Login status: got %d, want 401 (passwordless login disabled)", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAuthHandler_Login_SSOUserWithoutPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "alice", nil, "viewer"))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM user_identities`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	h := &AuthHandler{Secret: []byte("test-secret"), Providers: []auth.Provider{
+		&auth.LocalProvider{Users: repo.NewUserRepo(db), Identities: repo.NewIdentityRepo(db)},
+	}}
+
+	body, _ := json.Marshal(map[string]string{"username": "alice"})
+	req := httptest.NewRequest("POST", "/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.Login(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Login status: got %d, want 401 (SSO users cannot log in by username alone)", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+const inventoryMaxAssets = 10000
+
+// inventoryPageSize is how many assets the Ansible inventory loads per query while paging through all of them.
+const inventoryPageSize = 1000
+
+// InventoryHandler serves inventory exports for configuration management tools (e.g. Ansible).
+type InventoryHandler struct {
+	Repo    *repo.AssetRepo
+	Subnets *repo.SubnetRepo
+}
+
+// AnsibleInventory returns all assets in the Ansible dynamic inventory JSON format. Retired and decommissioned
+// assets are left out unless ?include_retired=true.
+// Groups are derived from tags (tag_<tag>, the asset groups schedules scan), subnets (subnet_<cidr>, the defined
+// subnet containing the asset's IP, else a /24 or /64 guess), sites (site_<name>) and owners (owner_<owner>);
+// _meta.hostvars carries per-host variables.
+// With ?host=<name> it returns only that host's variables (the inventory script --host contract).
+func (h *InventoryHandler) AnsibleInventory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	includeRetired := q.Get("include_retired") == "true" || q.Get("include_retired") == "1"
+	var assets []models.Asset
+	seen := make(map[int]bool)
+	for offset := 0; ; offset += inventoryPageSize {
+		page, err := h.Repo.List(r.Context(), inventoryPageSize, offset)
+		if err != nil {
+			log.Printf("AnsibleInventory list assets: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+		for _, a := range page {
+			// Deletes between pages shift the offsets; skip assets already seen.
+			if seen[a.ID] || (!includeRetired && (a.Lifecycle == models.LifecycleRetired || a.Lifecycle == models.LifecycleDecommissioned)) {
+				continue
+			}
+			seen[a.ID] = true
+			assets = append(assets, a)
+		}
+		if len(page) < inventoryPageSize {
+			break
+		}
+	}
+	assigned := map[int]models.Subnet{}
+	if h.Subnets != nil {
+		var err error
+		if assigned, err = h.Subnets.AssetSubnets(r.Context()); err != nil {
+			log.Printf("AnsibleInventory assign subnets: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ids := make([]int, len(assets))
+	for i, a := range assets {
+		ids[i] = a.ID
+	}
+	sites, err := h.Repo.SiteNames(r.Context(), ids)
+	if err != nil {
+		log.Printf("AnsibleInventory asset sites: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	inv := BuildAnsibleInventory(assets, assigned, sites)
+
+	w.Header().Set("Content-Type", "application/json")
+	if host := q.Get("host"); host != "" {
+		vars, ok := inv.Meta.HostVars[host]
+		if !ok {
+			// Ansible expects an empty object for unknown hosts.
+			vars = map[string]interface{}{}
+		}
+		json.NewEncoder(w).Encode(vars)
+		return
+	}
+	json.NewEncoder(w).Encode(inv)
+}
+
+// AnsibleInventory is the dynamic inventory document returned by --list.
+type AnsibleInventory struct {
+	Meta   AnsibleMeta
+	Groups map[string]*AnsibleGroup
+}
+
+// AnsibleMeta holds per-host variables so Ansible does not need to call --host for each host.
+type AnsibleMeta struct {
+	HostVars map[string]map[string]interface{} `json:"hostvars"`
+}
+
+// AnsibleGroup is one inventory group.
+type AnsibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// MarshalJSON flattens groups next to _meta as Ansible expects.
+func (inv AnsibleInventory) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(inv.Groups)+1)
+	for name, g := range inv.Groups {
+		out[name] = g
+	}
+	out["_meta"] = inv.Meta
+	return json.Marshal(out)
+}
+
+// BuildAnsibleInventory converts assets into an Ansible inventory. Host names are the asset names, suffixed with
+// the asset ID when two assets share a name (and further with -2, -3, ... if that is another asset's name) so
+// they stay unique. subnets and sites map asset IDs
+// to their defined subnet and site name (either may be nil). Assets have no custom fields; their owner,
+// lifecycle and site are the host vars beyond IPs and tags.
+func BuildAnsibleInventory(assets []models.Asset, subnets map[int]models.Subnet, sites map[int]string) AnsibleInventory {
+	inv := AnsibleInventory{
+		Meta:   AnsibleMeta{HostVars: make(map[string]map[string]interface{})},
+		Groups: make(map[string]*AnsibleGroup),
+	}
+
+	nameCount := make(map[string]int)
+	for _, a := range assets {
+		nameCount[ansibleHostName(a, false)]++
+	}
+	used := make(map[string]bool, len(nameCount))
+	for name := range nameCount {
+		used[name] = true
+	}
+
+	addToGroup := func(group, host string) {
+		g, ok := inv.Groups[group]
+		if !ok {
+			g = &AnsibleGroup{}
+			inv.Groups[group] = g
+		}
+		g.Hosts = append(g.Hosts, host)
+	}
+
+	var ungrouped []string
+	for _, a := range assets {
+		host := ansibleHostName(a, false)
+		if nameCount[host] > 1 {
+			base := ansibleHostName(a, true)
+			host = base
+			for n := 2; used[host]; n++ {
+				host = base + "-" + strconv.Itoa(n)
+			}
+			used[host] = true
+		}
+
+		vars := map[string]interface{}{
+			"hci_asset_id":    a.ID,
+			"hci_description": a.Description,
+			"hci_tags":        nonNilStrings(a.Tags),
+			"hci_lifecycle":   a.Lifecycle,
+			"hci_owner":       a.Owner,
+		}
+		var ips []string
+		if ip := strings.TrimSpace(a.NetworkName); ip != "" {
+			ips = append(ips, ip)
+			vars["ansible_host"] = ip
+		}
+		vars["hci_ips"] = nonNilStrings(ips)
+		if a.LastSeen != nil {
+			vars["hci_last_seen"] = a.LastSeen.UTC().Format(time.RFC3339)
+		}
+
+		grouped := false
+		if subnet := assetSubnet(subnets, a); subnet != "" {
+			vars["hci_subnet"] = subnet
+			addToGroup("subnet_"+sanitizeName(subnet), host)
+			grouped = true
+		}
+		for _, t := range a.Tags {
+			if name := sanitizeName(t); name != "" {
+				addToGroup("tag_"+name, host)
+				grouped = true
+			}
+		}
+		if site := sites[a.ID]; site != "" {
+			vars["hci_site"] = site
+			addToGroup("site_"+sanitizeName(site), host)
+			grouped = true
+		}
+		if name := sanitizeName(a.Owner); name != "" {
+			addToGroup("owner_"+name, host)
+			grouped = true
+		}
+		if !grouped {
+			ungrouped = append(ungrouped, host)
+		}
+		inv.Meta.HostVars[host] = vars
+	}
+
+	children := make([]string, 0, len(inv.Groups)+1)
+	for name, g := range inv.Groups {
+		sort.Strings(g.Hosts)
+		children = append(children, name)
+	}
+	sort.Strings(ungrouped)
+	inv.Groups["ungrouped"] = &AnsibleGroup{Hosts: ungrouped}
+	children = append(children, "ungrouped")
+	sort.Strings(children)
+	inv.Groups["all"] = &AnsibleGroup{Children: children}
+	return inv
+}
+
+// ansibleHostName returns the inventory host name for an asset. withID disambiguates duplicate names.
+func ansibleHostName(a models.Asset, withID bool) string {
+	name := strings.TrimSpace(a.Name)
+	if name == "" {
+		name = strings.TrimSpace(a.NetworkName)
+	}
+	if name == "" {
+		return "asset-" + strconv.Itoa(a.ID)
+	}
+	if withID {
+		return name + "-" + strconv.Itoa(a.ID)
+	}
+	return name
+}
+
+// sanitizeName converts a tag or subnet into an identifier safe for Ansible groups and Prometheus labels ([A-Za-z0-9_]).
+func sanitizeName(s string) string {
+	var b strings.Builder
+	for _, c := range strings.TrimSpace(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func nonNilStrings(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestInventoryHandler_AnsibleInventory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
+		WithArgs(inventoryPageSize, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "web1", "web server", "{web,prod}", nil, "192.168.1.10", "active", "", nil, nil).
+			AddRow(2, "db1", "database", "{}", nil, "", "maintenance", "dba-team", nil, nil).
+			AddRow(3, "old1", "", "{web}", nil, "", "retired", "", nil, nil).
+			AddRow(4, "old2", "", "{web}", nil, "", "decommissioned", "", nil, nil))
+	mock.ExpectQuery(`SELECT a.id, s.name FROM assets a JOIN sites s ON s.id = a.site_id WHERE a.id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "lab-east").AddRow(2, "lab-west"))
+
+	h := &InventoryHandler{Repo: repo.NewAssetRepo(db)}
+
+	req := httptest.NewRequest("GET", "/inventory/ansible", nil)
+	rr := httptest.NewRecorder()
+	h.AnsibleInventory(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AnsibleInventory status: got %d, want 200", rr.Code)
+	}
+	var out struct {
+		Meta struct {
+			HostVars map[string]map[string]interface{} `json:"hostvars"`
+		} `json:"_meta"`
+		All       AnsibleGroup `json:"all"`
+		TagWeb    AnsibleGroup `json:"tag_web"`
+		Subnet    AnsibleGroup `json:"subnet_192_168_1_0_24"`
+		SiteWest  AnsibleGroup `json:"site_lab_west"`
+		OwnerDBA  AnsibleGroup `json:"owner_dba_team"`
+		Ungrouped AnsibleGroup `json:"ungrouped"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	// Retired and decommissioned assets are left out by default.
+	if len(out.TagWeb.Hosts) != 1 || out.TagWeb.Hosts[0] != "web1" {
+		t.Errorf("tag_web hosts: %v", out.TagWeb.Hosts)
+	}
+	if len(out.Subnet.Hosts) != 1 || out.Subnet.Hosts[0] != "web1" {
+		t.Errorf("subnet hosts: %v", out.Subnet.Hosts)
+	}
+	if len(out.SiteWest.Hosts) != 1 || out.SiteWest.Hosts[0] != "db1" {
+		t.Errorf("site_lab_west hosts: %v", out.SiteWest.Hosts)
+	}
+	if len(out.OwnerDBA.Hosts) != 1 || out.OwnerDBA.Hosts[0] != "db1" {
+		t.Errorf("owner_dba_team hosts: %v", out.OwnerDBA.Hosts)
+	}
+	if len(out.Ungrouped.Hosts) != 0 {
+		t.Errorf("ungrouped hosts: %v", out.Ungrouped.Hosts)
+	}
+	if db1 := out.Meta.HostVars["db1"]; db1["hci_site"] != "lab-west" || db1["hci_owner"] != "dba-team" || db1["hci_lifecycle"] != "maintenance" {
+		t.Errorf("db1 hostvars: %v", db1)
+	}
+	if got := out.Meta.HostVars["web1"]["ansible_host"]; got != "192.168.1.10" {
+		t.Errorf("web1 ansible_host: got %v", got)
+	}
+	if len(out.All.Children) == 0 {
+		t.Error("expected all.children to list groups")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestInventoryHandler_AnsibleInventory_Host(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets`).
+		WithArgs(inventoryPageSize, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "web1", "web server", "{web}", nil, "10.0.0.5", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT a.id, s.name FROM assets a JOIN sites s`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "default"))
+
+	h := &InventoryHandler{Repo: repo.NewAssetRepo(db)}
+
+	req := httptest.NewRequest("GET", "/inventory/ansible?host=web1", nil)
+	rr := httptest.NewRecorder()
+	h.AnsibleInventory(rr, req)
+
+	var vars map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&vars); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if vars["ansible_host"] != "10.0.0.5" || vars["hci_asset_id"] != float64(1) {
+		t.Errorf("unexpected hostvars: %v", vars)
+	}
+}
+
+func TestInventoryHandler_AnsibleInventory_AllPages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}
+	full := sqlmock.NewRows(columns)
+	for id := 1; id <= inventoryPageSize; id++ {
+		full.AddRow(id, "", "", "{}", nil, "", "active", "", nil, nil)
+	}
+	mock.ExpectQuery(`SELECT id, name, description`).WithArgs(inventoryPageSize, 0).WillReturnRows(full)
+	mock.ExpectQuery(`SELECT id, name, description`).WithArgs(inventoryPageSize, inventoryPageSize).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(inventoryPageSize+1, "", "", "{}", nil, "", "retired", "", nil, nil))
+	mock.ExpectQuery(`SELECT a.id, s.name FROM assets a JOIN sites s`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	h := &InventoryHandler{Repo: repo.NewAssetRepo(db)}
+	rr := httptest.NewRecorder()
+	h.AnsibleInventory(rr, httptest.NewRequest("GET", "/inventory/ansible?include_retired=true", nil))
+
+	var out struct {
+		Meta struct {
+			HostVars map[string]map[string]interface{} `json:"hostvars"`
+		} `json:"_meta"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(out.Meta.HostVars) != inventoryPageSize+1 {
+		t.Errorf("hostvars: got %d hosts, want %d", len(out.Meta.HostVars), inventoryPageSize+1)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestBuildAnsibleInventory_DuplicateNames(t *testing.T) {
+	inv := BuildAnsibleInventory([]models.Asset{
+		{ID: 1, Name: "node"},
+		{ID: 2, Name: "node"},
+	}, nil, nil)
+	if _, ok := inv.Meta.HostVars["node-1"]; !ok {
+		t.Errorf("expected node-1 in hostvars, got %v", inv.Meta.HostVars)
+	}
+	if _, ok := inv.Meta.HostVars["node-2"]; !ok {
+		t.Errorf("expected node-2 in hostvars, got %v", inv.Meta.HostVars)
+	}
+	if g := inv.Groups["ungrouped"]; len(g.Hosts) != 2 {
+		t.Errorf("ungrouped = %+v, want both nodes", g)
+	}
+}
+
+func TestBuildAnsibleInventory_DefinedSubnets(t *testing.T) {
+	inv := BuildAnsibleInventory([]models.Asset{
+		{ID: 1, Name: "db", NetworkName: "10.1.2.3"},
+		{ID: 2, Name: "web", NetworkName: "192.168.7.8"},
+	}, map[int]models.Subnet{1: {ID: 4, CIDR: "10.1.0.0/16"}}, nil)
+
+	// db is in a defined subnet; web falls back to the /24 guess.
+	if got := inv.Meta.HostVars["db"]["hci_subnet"]; got != "10.1.0.0/16" {
+		t.Errorf("db hci_subnet = %v, want 10.1.0.0/16", got)
+	}
+	if g := inv.Groups["subnet_10_1_0_0_16"]; g == nil || len(g.Hosts) != 1 || g.Hosts[0] != "db" {
+		t.Errorf("subnet_10_1_0_0_16 = %+v", g)
+	}
+	if g := inv.Groups["subnet_192_168_7_0_24"]; g == nil || len(g.Hosts) != 1 || g.Hosts[0] != "web" {
+		t.Errorf("subnet_192_168_7_0_24 = %+v", g)
+	}
+}
+
+func TestBuildAnsibleInventory_SuffixedNameTaken(t *testing.T) {
+	// node-2 is a real asset name, so the second "node" cannot use it.
+	inv := BuildAnsibleInventory([]models.Asset{
+		{ID: 1, Name: "node"},
+		{ID: 2, Name: "node"},
+		{ID: 3, Name: "node-2"},
+	}, nil, nil)
+	for host, id := range map[string]int{"node-1": 1, "node-2-2": 2, "node-2": 3} {
+		if vars, ok := inv.Meta.HostVars[host]; !ok || vars["hci_asset_id"] != id {
+			t.Errorf("host %s: got %v, want asset %d", host, vars, id)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+)
+
+// ==========================
+// LoginEventHandler
+// ==========================
+// LoginEventHandler serves the login history for investigating brute-force attempts and unlocks accounts.
+type LoginEventHandler struct {
+	Repo      *repo.LoginEventRepo
+	Users     *repo.UserRepo
+	AuditRepo *repo.AuditRepo
+}
+
+// ListEvents returns login attempts, newest first. Query: username, user_id, result, ip, since and until
+// (RFC 3339), limit (default 50, max 200), offset.
+func (h *LoginEventHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	offset := 0
+	if l := q.Get("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil && val > 0 && val <= 200 {
+			limit = val
+		}
+	}
+	if o := q.Get("offset"); o != "" {
+		if val, err := strconv.Atoi(o); err == nil && val >= 0 {
+			offset = val
+		}
+	}
+
+	f := models.LoginEventFilter{Username: q.Get("username"), Result: q.Get("result"), IP: q.Get("ip")}
+	fields := make(map[string]string)
+	if s := q.Get("user_id"); s != "" {
+		if id, err := strconv.Atoi(s); err == nil && id > 0 {
+			f.UserID = id
+		} else {
+			fields["user_id"] = "must be a positive integer"
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if s := q.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				fields[name] = "must be an RFC 3339 timestamp"
+				continue
+			}
+			*dst = t
+		}
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.Repo.List(r.Context(), f, limit, offset)
+	if err != nil {
+		log.Printf("ListEvents: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	total, err := h.Repo.Count(r.Context(), f)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":  events,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ==========================
+// Unlock (admin; clears a user's failed login count and lockout)
+// ==========================
+func (h *LoginEventHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Users.GetByID(r.Context(), id)
+	if err != nil {
+		JSONError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Repo.ResetFailures(r.Context(), user.Username); err != nil {
+		log.Printf("Unlock: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	logAudit(h.AuditRepo, r, "unlock", "user", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkLocked writes 429 and records the attempt when username is locked out. It returns true if the
+// request was handled.
+func (h *AuthHandler) checkLocked(w http.ResponseWriter, r *http.Request, user *models.User, username, method string) bool {
+	if h.Events == nil || !h.Lockout.Enabled() {
+		return false
+	}
+	until, err := h.Events.LockedUntil(r.Context(), username)
+	if err != nil {
+		log.Printf("Login: lockout check: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return true
+	}
+	if until.IsZero() {
+		return false
+	}
+	if user == nil {
+		user = h.knownUser(r, username)
+	}
+	h.recordLogin(r, user, username, method, models.LoginResultLocked)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	JSONError(w, "too many failed login attempts; try again later", http.StatusTooManyRequests)
+	return true
+}
+
+// loginFailed records a failed attempt and locks the account once the policy's threshold is reached.
+func (h *AuthHandler) loginFailed(r *http.Request, user *models.User, username, method, result string) {
+	if h.Events == nil {
+		return
+	}
+	if user == nil {
+		user = h.knownUser(r, username)
+	}
+	h.recordLogin(r, user, username, method, result)
+	if !h.Lockout.Enabled() {
+		return
+	}
+	if err := h.Events.PruneLockouts(r.Context(), h.Lockout.Window); err != nil {
+		log.Printf("Login: prune lockouts: %v", err)
+	}
+	failures, err := h.Events.RecordFailure(r.Context(), username, h.Lockout.Window)
+	if err != nil {
+		log.Printf("Login: record failure: %v", err)
+		return
+	}
+	if d := h.Lockout.Duration(failures); d > 0 {
+		if _, err := h.Events.Lock(r.Context(), username, d); err != nil {
+			log.Printf("Login: lock account: %v", err)
+			return
+		}
+		log.Printf("Login: account %q locked for %s after %d failed attempts", username, d, failures)
+	}
+}
+
+// knownUser returns the local account named username, or nil, so failed and locked attempts against it are
+// recorded with its user ID.
+func (h *AuthHandler) knownUser(r *http.Request, username string) *models.User {
+	if h.UserRepo == nil || username == "" {
+		return nil
+	}
+	user, err := h.UserRepo.GetByUsername(r.Context(), username)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// loginSucceeded records a completed login and clears the account's failure count.
+func (h *AuthHandler) loginSucceeded(r *http.Request, user *models.User, method string) {
+	h.recordLogin(r, user, user.Username, method, models.LoginResultSuccess)
+	logAuditAs(h.AuditRepo, r, user.ID, "login", "user", user.ID, map[string]interface{}{"method": method})
+	if h.Events != nil && h.Lockout.Enabled() {
+		if err := h.Events.ResetFailures(r.Context(), user.Username); err != nil {
+			log.Printf("Login: reset failures: %v", err)
+		}
+	}
+}
+
+// recordLogin stores a login event; user may be nil when the account is unknown.
+func (h *AuthHandler) recordLogin(r *http.Request, user *models.User, username, method, result string) {
+	if h.Events == nil {
+		return
+	}
+	e := models.LoginEvent{
+		Username:  username,
+		Method:    method,
+		Result:    result,
+		IP:        middleware.ClientIP(r, h.TrustProxyHeaders),
+		UserAgent: r.UserAgent(),
+	}
+	if user != nil {
+		e.UserID = &user.ID
+	}
+	if err := h.Events.Record(r.Context(), e); err != nil {
+		log.Printf("Login: record event: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func newLockoutTestHandler(t *testing.T) (*AuthHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &AuthHandler{
+		UserRepo: repo.NewUserRepo(db),
+		Secret:   []byte("test-secret"),
+		Events:   repo.NewLoginEventRepo(db),
+		Lockout:  auth.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour, Window: 15 * time.Minute},
+	}, mock
+}
+
+func TestAuthHandler_Login_FailureLocksAccount(t *testing.T) {
+	h, mock := newLockoutTestHandler(t)
+	mock.ExpectQuery(`SELECT locked_until FROM login_lockouts`).WithArgs("alice").WillReturnError(sql.ErrNoRows)
+	expectPasswordLogin(t, mock, 1, "alice", "admin")
+	// The failure is recorded against the existing account, so filtering events by user shows it.
+	expectPasswordLogin(t, mock, 1, "alice", "admin")
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(1, "alice", "password", "failure", "192.0.2.1", "test-agent").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`DELETE FROM login_lockouts WHERE last_failure_at`).WithArgs(float64(900)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO login_lockouts`).WithArgs("alice", float64(900)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(3))
+	mock.ExpectQuery(`UPDATE login_lockouts SET locked_until`).WithArgs("alice", float64(60)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(time.Minute)))
+
+	req := postJSON("/auth/login", map[string]string{"username": "alice", "password": "wrong"})
+	req.RemoteAddr = "192.0.2.1:5555"
+	req.Header.Set("User-Agent", "test-agent")
+	rr := httptest.NewRecorder()
+	h.Login(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status: got %d, want 401", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAuthHandler_Login_LockedAccount(t *testing.T) {
+	h, mock := newLockoutTestHandler(t)
+	mock.ExpectQuery(`SELECT locked_until FROM login_lockouts`).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(90 * time.Second)))
+	// Unknown names are locked out like accounts, and recorded without a user.
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs("alice").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(nil, "alice", "password", "locked", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// The password is never checked while the account is locked.
+	rr := httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/login", map[string]string{"username": "alice", "password": "secret"}))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status: got %d, want 429", rr.Code)
+	}
+	if ra := rr.Header().Get("Retry-After"); ra != "90" {
+		t.Errorf("Retry-After: got %q, want 90", ra)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAuthHandler_Login_SuccessResetsFailures(t *testing.T) {
+	h, mock := newLockoutTestHandler(t)
+	mock.ExpectQuery(`SELECT locked_until FROM login_lockouts`).WithArgs("alice").WillReturnError(sql.ErrNoRows)
+	expectPasswordLogin(t, mock, 1, "alice", "admin")
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(1, "alice", "password", "success", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`DELETE FROM login_lockouts`).WithArgs("alice").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr := httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/login", map[string]string{"username": "alice", "password": "secret"}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestLoginEventHandler_ListEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &LoginEventHandler{Repo: repo.NewLoginEventRepo(db)}
+	since := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT id, user_id, username, method, result, ip, user_agent, created_at FROM login_events WHERE LOWER\(username\) = LOWER\(\$1\) AND result = \$2 AND created_at >= \$3 ORDER BY created_at DESC, id DESC LIMIT \$4 OFFSET \$5`).
+		WithArgs("alice", "failure", since, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "method", "result", "ip", "user_agent", "created_at"}).
+			AddRow(9, nil, "alice", "password", "failure", "192.0.2.1", "curl/8", since))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_events WHERE`).
+		WithArgs("alice", "failure", since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	req := httptest.NewRequest("GET", "/auth/events?username=alice&result=failure&since=2026-03-01T00:00:00Z&limit=20", nil)
+	rr := httptest.NewRecorder()
+	h.ListEvents(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Items []struct {
+			IP     string `json:"ip"`
+			Result string `json:"result"`
+		} `json:"items"`
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Total != 1 || len(out.Items) != 1 || out.Items[0].IP != "192.0.2.1" {
+		t.Errorf("unexpected response: %+v", out)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ListEvents(rr, httptest.NewRequest("GET", "/auth/events?since=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("bad since: got %d, want 400", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// mfaTokenTTL is how long a password-verified login may take to supply its second factor.
+	mfaTokenTTL = 5 * time.Minute
+	// recoveryCodeCount is the number of one-time recovery codes issued on enrollment.
+	recoveryCodeCount = 10
+
+	mfaPurposeLogin  = "login"
+	mfaPurposeEnroll = "enroll"
+)
+
+// ==========================
+// MFAHandler
+// ==========================
+// MFAHandler manages TOTP enrollment and completes two-step logins started by AuthHandler.Login.
+// Logins through single sign-on are not challenged; the identity provider's own MFA applies there.
+type MFAHandler struct {
+	Repo      *repo.MFARepo
+	Users     *repo.UserRepo
+	Auth      *AuthHandler // issues tokens and holds MFARequiredRoles
+	AuditRepo *repo.AuditRepo
+	// Sessions, when set, revokes the user's sessions when an admin resets their MFA.
+	Sessions *repo.SessionRepo
+	Issuer   string // shown in authenticator apps (default "HCI Asset")
+}
+
+// mfaChallenge answers a password-verified login that still needs a second factor. It returns false
+// when the user can be issued tokens directly.
+func (h *AuthHandler) mfaChallenge(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	m, err := h.MFA.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, repo.ErrMFANotEnrolled) {
+		log.Printf("Login: mfa lookup: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return true
+	}
+	var purpose string
+	switch {
+	case m != nil && m.Enabled():
+		purpose = mfaPurposeLogin
+	case h.mfaRequired(user.Role):
+		purpose = mfaPurposeEnroll
+	default:
+		return false
+	}
+
+	token, err := h.signMFAToken(user.ID, purpose)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return true
+	}
+	h.recordLogin(r, user, user.Username, models.LoginMethodPassword, models.LoginResultMFAChallenge)
+	out := map[string]interface{}{"mfa_token": token, "expires_in": int(mfaTokenTTL.Seconds())}
+	if purpose == mfaPurposeLogin {
+		out["mfa_required"] = true
+	} else {
+		out["mfa_enrollment_required"] = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+	return true
+}
+
+// mfaRequired reports whether role must have MFA enabled.
+func (h *AuthHandler) mfaRequired(role string) bool {
+	for _, r := range h.MFARequiredRoles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// signMFAToken returns a short-lived token proving the password step succeeded. Its "typ" claim keeps
+// the API middleware from accepting it as a session.
+func (h *AuthHandler) signMFAToken(userID int, purpose string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"typ":     "mfa",
+		"purpose": purpose,
+		"user_id": userID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaTokenTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.Secret)
+}
+
+// parseMFAToken returns the user ID of a valid MFA token issued for purpose.
+func (h *AuthHandler) parseMFAToken(raw, purpose string) (int, bool) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		return h.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "mfa" || claims["purpose"] != purpose {
+		return 0, false
+	}
+	id, ok := claims["user_id"].(float64)
+	return int(id), ok
+}
+
+func (h *MFAHandler) issuer() string {
+	if h.Issuer != "" {
+		return h.Issuer
+	}
+	return "HCI Asset"
+}
+
+// verify checks a TOTP code (once per time step) or, when allowRecovery is set, consumes a recovery code.
+func (h *MFAHandler) verify(r *http.Request, m *models.UserMFA, code string, allowRecovery bool) (bool, error) {
+	if step, ok := auth.ValidateTOTP(m.Secret, code, time.Now()); ok {
+		return h.Repo.UseStep(r.Context(), m.UserID, step)
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+	used, err := h.Repo.UseRecoveryCode(r.Context(), m.UserID, auth.NormalizeRecoveryCode(code))
+	if used {
+		logAuditAs(h.AuditRepo, r, m.UserID, "use_recovery_code", "user", m.UserID, nil)
+	}
+	return used, err
+}
+
+// enabledMFA returns the current user's active enrollment, writing an error response if there is none.
+func (h *MFAHandler) enabledMFA(w http.ResponseWriter, r *http.Request, userID int) (*models.UserMFA, bool) {
+	m, err := h.Repo.Get(r.Context(), userID)
+	if err != nil && !errors.Is(err, repo.ErrMFANotEnrolled) {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return nil, false
+	}
+	if m == nil || !m.Enabled() {
+		JSONError(w, "mfa is not enabled", http.StatusConflict)
+		return nil, false
+	}
+	return m, true
+}
+
+// ==========================
+// MFA Status (current user)
+// ==========================
+func (h *MFAHandler) Status(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	role, _ := middleware.GetRole(r.Context())
+	m, err := h.Repo.Get(r.Context(), userID)
+	if err != nil && !errors.Is(err, repo.ErrMFANotEnrolled) {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	out := map[string]interface{}{"enabled": false, "required": h.Auth.mfaRequired(role)}
+	if m != nil && m.Enabled() {
+		out["enabled"] = true
+		out["enabled_at"] = m.EnabledAt
+		out["recovery_codes_remaining"] = m.RecoveryCodesRemaining
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// ==========================
+// MFA Enroll (current user; returns a new secret to confirm with Enable)
+// ==========================
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok || isAPIKey(r) {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.enroll(w, r, userID)
+}
+
+// ==========================
+// MFA Enable (current user; confirms enrollment with a code and returns recovery codes)
+// ==========================
+func (h *MFAHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok || isAPIKey(r) {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	codes, ok := h.enable(w, r, userID, input.Code)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// ==========================
+// MFA Disable (current user; needs a current code; not allowed for roles that require MFA)
+// ==========================
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok || isAPIKey(r) {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if role, _ := middleware.GetRole(r.Context()); h.Auth.mfaRequired(role) {
+		JSONError(w, "mfa is required for your role", http.StatusForbidden)
+		return
+	}
+	m, ok := h.enabledMFA(w, r, userID)
+	if !ok {
+		return
+	}
+	if valid, err := h.verify(r, m, input.Code, true); err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	} else if !valid {
+		JSONError(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	if _, err := h.Repo.Reset(r.Context(), userID); err != nil {
+		log.Printf("MFA disable: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	logAuditAs(h.AuditRepo, r, userID, "disable_mfa", "user", userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// MFA Recovery Codes (current user; needs a current TOTP code; replaces all codes)
+// ==========================
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok || isAPIKey(r) {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	m, ok := h.enabledMFA(w, r, userID)
+	if !ok {
+		return
+	}
+	if valid, err := h.verify(r, m, input.Code, false); err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	} else if !valid {
+		JSONError(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err == nil {
+		err = h.Repo.ReplaceRecoveryCodes(r.Context(), userID, codes)
+	}
+	if err != nil {
+		log.Printf("MFA recovery codes: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	logAuditAs(h.AuditRepo, r, userID, "regenerate_recovery_codes", "user", userID, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// ==========================
+// MFA Login (second step: mfa_token from Login + TOTP or recovery code -> tokens)
+// ==========================
+func (h *MFAHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	userID, ok := h.Auth.parseMFAToken(input.MFAToken, mfaPurposeLogin)
+	if !ok {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	m, err := h.Repo.Get(r.Context(), userID)
+	if err != nil || !m.Enabled() {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	// Wrong codes count towards the account lockout, so the 5-minute token cannot be used to guess codes.
+	if h.Auth.checkLocked(w, r, user, user.Username, models.LoginMethodMFA) {
+		return
+	}
+	valid, err := h.verify(r, m, input.Code, true)
+	if err != nil {
+		log.Printf("MFA login: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		h.Auth.loginFailed(r, user, user.Username, models.LoginMethodMFA, models.LoginResultMFAFailure)
+		JSONError(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	h.Auth.loginSucceeded(r, user, models.LoginMethodMFA)
+	h.Auth.writeTokens(w, r, user, "")
+}
+
+// ==========================
+// MFA Login Enroll (forced enrollment during login; mfa_token -> secret)
+// ==========================
+func (h *MFAHandler) LoginEnroll(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	userID, ok := h.Auth.parseMFAToken(input.MFAToken, mfaPurposeEnroll)
+	if !ok {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	h.enroll(w, r, userID)
+}
+
+// ==========================
+// MFA Login Enable (forced enrollment during login; mfa_token + code -> tokens and recovery codes)
+// ==========================
+func (h *MFAHandler) LoginEnable(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	userID, ok := h.Auth.parseMFAToken(input.MFAToken, mfaPurposeEnroll)
+	if !ok {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+	codes, ok := h.enable(w, r, userID, input.Code)
+	if !ok {
+		return
+	}
+	h.Auth.loginSucceeded(r, user, models.LoginMethodMFA)
+	h.Auth.writeLogin(w, r, user, "", map[string]interface{}{"recovery_codes": codes})
+}
+
+// ==========================
+// MFA Reset (admin; removes a user's enrollment, e.g. after a lost device)
+// ==========================
+func (h *MFAHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	found, err := h.Repo.Reset(r.Context(), id)
+	if err != nil {
+		log.Printf("MFA reset: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		JSONError(w, "mfa is not enabled for this user", http.StatusNotFound)
+		return
+	}
+	if h.Sessions != nil {
+		if err := h.Sessions.RevokeAllForUser(r.Context(), id); err != nil {
+			log.Printf("MFA reset: revoke sessions: %v", err)
+		}
+	}
+	logAudit(h.AuditRepo, r, "reset_mfa", "user", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enroll starts (or restarts) enrollment for userID and writes the secret, otpauth URI and QR code.
+func (h *MFAHandler) enroll(w http.ResponseWriter, r *http.Request, userID int) {
+	user, err := h.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if err := h.Repo.StartEnrollment(r.Context(), userID, secret); err != nil {
+		if errors.Is(err, repo.ErrMFAAlreadyEnabled) {
+			JSONError(w, "mfa is already enabled", http.StatusConflict)
+			return
+		}
+		log.Printf("MFA enroll: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	uri := auth.TOTPURI(h.issuer(), user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// enable confirms a pending enrollment with code and returns the new recovery codes. On failure it has
+// already written the response.
+func (h *MFAHandler) enable(w http.ResponseWriter, r *http.Request, userID int, code string) ([]string, bool) {
+	m, err := h.Repo.Get(r.Context(), userID)
+	if errors.Is(err, repo.ErrMFANotEnrolled) {
+		JSONError(w, "start enrollment first", http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return nil, false
+	}
+	if m.Enabled() {
+		JSONError(w, "mfa is already enabled", http.StatusConflict)
+		return nil, false
+	}
+	step, ok := auth.ValidateTOTP(m.Secret, code, time.Now())
+	if !ok {
+		JSONValidationError(w, "validation failed", map[string]string{"code": "invalid code"}, http.StatusBadRequest)
+		return nil, false
+	}
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err == nil {
+		err = h.Repo.Enable(r.Context(), userID, step, codes)
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrMFAAlreadyEnabled) {
+			JSONError(w, "mfa is already enabled", http.StatusConflict)
+			return nil, false
+		}
+		log.Printf("MFA enable: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return nil, false
+	}
+	logAuditAs(h.AuditRepo, r, userID, "enable_mfa", "user", userID, nil)
+	return codes, true
+}
+
+// isAPIKey reports whether the request was authenticated with an API key. MFA settings are managed
+// from an interactive session only.
+func isAPIKey(r *http.Request) bool {
+	_, ok := middleware.GetAPIKeyID(r.Context())
+	return ok
+}
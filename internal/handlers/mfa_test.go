@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func newMFATestHandler(t *testing.T) (*MFAHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mfaRepo := repo.NewMFARepo(db)
+	userRepo := repo.NewUserRepo(db)
+	authHandler := &AuthHandler{UserRepo: userRepo, Secret: []byte("test-secret"), MFA: mfaRepo, MFARequiredRoles: []string{"admin"}}
+	return &MFAHandler{Repo: mfaRepo, Users: userRepo, Auth: authHandler, AuditRepo: repo.NewAuditRepo(db)}, mock
+}
+
+func mfaRows(enabled bool) *sqlmock.Rows {
+	var enabledAt interface{}
+	if enabled {
+		enabledAt = time.Now()
+	}
+	return sqlmock.NewRows([]string{"secret", "enabled_at", "last_used_step", "count"}).AddRow(testTOTPSecret, enabledAt, 0, 10)
+}
+
+func postJSON(path string, v interface{}) *http.Request {
+	body, _ := json.Marshal(v)
+	return httptest.NewRequest("POST", path, bytes.NewReader(body))
+}
+
+func expectPasswordLogin(t *testing.T, mock sqlmock.Sqlmock, id int, username, role string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs(username).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(id, username, string(hash), role))
+}
+
+func TestMFA_LoginChallengeThenCode(t *testing.T) {
+	h, mock := newMFATestHandler(t)
+	expectPasswordLogin(t, mock, 1, "alice", "viewer")
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(1).WillReturnRows(mfaRows(true))
+
+	rr := httptest.NewRecorder()
+	h.Auth.Login(rr, postJSON("/auth/login", map[string]string{"username": "alice", "password": "secret"}))
+	var challenge struct {
+		Token       string `json:"token"`
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&challenge); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" || challenge.Token != "" {
+		t.Fatalf("expected an MFA challenge without a session token, got %d %+v", rr.Code, challenge)
+	}
+
+	// The challenge token is not a session.
+	protected := (&middleware.Authenticator{Secret: h.Auth.Secret}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge.MFAToken)
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("mfa token used as session: got %d, want 401", rr.Code)
+	}
+
+	code, _ := auth.TOTPCode(testTOTPSecret, time.Now())
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(1).WillReturnRows(mfaRows(true))
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "alice", nil, "viewer"))
+	mock.ExpectExec(`UPDATE user_mfa SET last_used_step`).WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr = httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/mfa/login", map[string]string{"mfa_token": challenge.MFAToken, "code": code}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("MFA login status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+
+	// Same code again: the step was already used.
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(1).WillReturnRows(mfaRows(true))
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "alice", nil, "viewer"))
+	mock.ExpectExec(`UPDATE user_mfa SET last_used_step`).WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr = httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/mfa/login", map[string]string{"mfa_token": challenge.MFAToken, "code": code}))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: got %d, want 401", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestMFA_LoginWithRecoveryCode(t *testing.T) {
+	h, mock := newMFATestHandler(t)
+	token, _ := h.Auth.signMFAToken(1, mfaPurposeLogin)
+
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(1).WillReturnRows(mfaRows(true))
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(1, "alice", nil, "viewer"))
+	mock.ExpectExec(`UPDATE mfa_recovery_codes SET used_at`).WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(1, "use_recovery_code", "user", 1, "", "user", "", "", "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/mfa/login", map[string]string{"mfa_token": token, "code": " K7X2M-Q9P4T "}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestMFA_AdminMustEnroll(t *testing.T) {
+	h, mock := newMFATestHandler(t)
+	expectPasswordLogin(t, mock, 2, "root", "admin")
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(2).WillReturnError(sql.ErrNoRows)
+
+	rr := httptest.NewRecorder()
+	h.Auth.Login(rr, postJSON("/auth/login", map[string]string{"username": "root", "password": "secret"}))
+	var challenge struct {
+		Token                 string `json:"token"`
+		MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+		MFAToken              string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&challenge); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !challenge.MFAEnrollmentRequired || challenge.Token != "" {
+		t.Fatalf("expected forced enrollment, got %+v", challenge)
+	}
+	// An enrollment token cannot complete a login.
+	rr = httptest.NewRecorder()
+	h.Login(rr, postJSON("/auth/mfa/login", map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("enroll token on /mfa/login: got %d, want 401", rr.Code)
+	}
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(2, "root", "x", "admin"))
+	mock.ExpectQuery(`INSERT INTO user_mfa`).WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+	rr = httptest.NewRecorder()
+	h.LoginEnroll(rr, postJSON("/auth/mfa/login/enroll", map[string]string{"mfa_token": challenge.MFAToken}))
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCode     string `json:"qr_code"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+		t.Fatalf("decode enrollment: %v", err)
+	}
+	if enrollment.Secret == "" || enrollment.QRCode == "" || enrollment.OTPAuthURI == "" {
+		t.Fatalf("incomplete enrollment: %+v", enrollment)
+	}
+
+	code, _ := auth.TOTPCode(enrollment.Secret, time.Now())
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(2, "root", "x", "admin"))
+	mock.ExpectQuery(`SELECT secret, enabled_at, last_used_step`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at", "last_used_step", "count"}).AddRow(enrollment.Secret, nil, 0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE user_mfa SET enabled_at`).WithArgs(2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM mfa_recovery_codes`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < recoveryCodeCount; i++ {
+		mock.ExpectExec(`INSERT INTO mfa_recovery_codes`).WithArgs(2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(2, "enable_mfa", "user", 2, "", "user", "", "", "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr = httptest.NewRecorder()
+	h.LoginEnable(rr, postJSON("/auth/mfa/login/enable", map[string]string{"mfa_token": challenge.MFAToken, "code": code}))
+	var out struct {
+		Token         string   `json:"token"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Token == "" || len(out.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("expected tokens and %d recovery codes, got %+v", recoveryCodeCount, out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestMFA_DisableRefusedForRequiredRole(t *testing.T) {
+	h, mock := newMFATestHandler(t)
+	req := postJSON("/auth/mfa/disable", map[string]string{"code": "123456"})
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, 2)
+	ctx = context.WithValue(ctx, middleware.RoleKey, "admin")
+
+	rr := httptest.NewRecorder()
+	h.Disable(rr, req.WithContext(ctx))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("status: got %d, want 403", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestMFA_AdminReset(t *testing.T) {
+	h, mock := newMFATestHandler(t)
+	mock.ExpectExec(`DELETE FROM user_mfa`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(1, "reset_mfa", "user", 5, "", "user", "", "", "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := requestWithChiURLParams("DELETE", "/users/5/mfa", nil, map[string]string{"id": "5"})
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+	rr := httptest.NewRecorder()
+	h.Reset(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("status: got %d, want 204", rr.Code)
+	}
+
+	mock.ExpectExec(`DELETE FROM user_mfa`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+	rr = httptest.NewRecorder()
+	h.Reset(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("second reset: got %d, want 404", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
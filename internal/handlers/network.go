@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -16,16 +18,38 @@ import (
 const (
 	graphMaxAssets = 2000
 	untaggedGroup  = "Untagged"
+	routeHopGroup  = "Route hops"
 )
 
+// Network graph edge types, selected with ?edges=hosting,dependency (default: all).
+const (
+	EdgeHosting    = "hosting"    // hypervisor node -> VM (runs_on relationships)
+	EdgeGateway    = "gateway"    // asset -> gateway of its subnet
+	EdgeRoute      = "route"      // traceroute hop -> next hop (scans with SCAN_TRACEROUTE)
+	EdgeDependency = "dependency" // asset -> asset it depends on (depends_on relationships)
+	EdgeBackup     = "backup"     // backup or replica -> asset it backs up (backs_up relationships)
+	EdgeMembership = "membership" // member -> cluster or pool (member_of relationships)
+)
+
+// NetworkEdgeTypes lists the edge types of the network graph.
+var NetworkEdgeTypes = []string{EdgeHosting, EdgeGateway, EdgeRoute, EdgeDependency, EdgeBackup, EdgeMembership}
+
 // NetworkHandler serves network topology / graph data.
 type NetworkHandler struct {
 	Repo *repo.AssetRepo
+	// Subnets, when set, groups assets by the most specific defined subnet containing their IP and links them
+	// to the subnet's gateway.
+	Subnets *repo.SubnetRepo
+	// Relationships, when set, adds hosting and dependency edges.
+	Relationships *repo.RelationshipRepo
+	// Routes, when set, adds the traceroute hops recorded by scans.
+	Routes *repo.AssetRouteRepo
 }
 
 // NetworkGraphResponse is the JSON shape for GET /v1/network/graph.
 type NetworkGraphResponse struct {
 	Nodes  []NetworkNode  `json:"nodes"`
+	Edges  []NetworkEdge  `json:"edges"`
 	Groups []NetworkGroup `json:"groups"`
 }
 
@@ -39,6 +63,15 @@ type NetworkNode struct {
 	AssetID int `json:"asset_id,omitempty"`
 }
 
+// NetworkEdge links two nodes. Type is one of NetworkEdgeTypes; edges point from the host, asset or hop
+// towards what it hosts, uses or forwards to.
+type NetworkEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+}
+
 // NetworkGroup represents a segment (tag or subnet) for styling.
 type NetworkGroup struct {
 	ID    string `json:"id"`
@@ -67,27 +100,74 @@ func subnetForIP(ipStr string) string {
 	return ""
 }
 
+// parseEdgeTypes reads the comma-separated ?edges= filter. Empty selects every type.
+func parseEdgeTypes(s string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	if strings.TrimSpace(s) == "" {
+		for _, t := range NetworkEdgeTypes {
+			types[t] = true
+		}
+		return types, nil
+	}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		valid := false
+		for _, et := range NetworkEdgeTypes {
+			if t == et {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown edge type %q (use %s)", t, strings.Join(NetworkEdgeTypes, ", "))
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
 // NetworkGraph returns all assets as graph nodes grouped by subnet (when network_name is set) or first tag (or "Untagged").
+// Defined subnets take precedence; assets outside all of them fall back to a /24 (/64) guess.
+// Edges (see NetworkEdgeTypes) can be limited with ?edges=hosting,dependency; gateways and route hops that are
+// not assets become extra nodes without asset_id.
 // Used by the network visualization UI to show segmentation.
 func (h *NetworkHandler) NetworkGraph(w http.ResponseWriter, r *http.Request) {
+	edgeTypes, err := parseEdgeTypes(r.URL.Query().Get("edges"))
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	assets, err := h.Repo.List(r.Context(), graphMaxAssets, 0)
 	if err != nil {
 		log.Printf("NetworkGraph list assets: %v", err)
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	assigned := map[int]models.Subnet{}
+	if h.Subnets != nil {
+		if assigned, err = h.Subnets.AssetSubnets(r.Context()); err != nil {
+			log.Printf("NetworkGraph assign subnets: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+	}
 
-	groupSet := make(map[string]struct{})
-	groupSet[untaggedGroup] = struct{}{}
+	groupSet := make(map[string]string)
+	groupSet[untaggedGroup] = untaggedGroup
 	var nodes []NetworkNode
 	for _, a := range assets {
 		group := untaggedGroup
-		if subnet := subnetForIP(a.NetworkName); subnet != "" {
+		if s, ok := assigned[a.ID]; ok {
+			group = "subnet-" + strconv.Itoa(s.ID)
+			groupSet[group] = subnetLabel(s)
+		} else if subnet := subnetForIP(a.NetworkName); subnet != "" {
 			group = subnet
-			groupSet[group] = struct{}{}
+			groupSet[group] = group
 		} else if len(a.Tags) > 0 {
 			group = a.Tags[0]
-			groupSet[group] = struct{}{}
+			groupSet[group] = group
 		}
 		label := a.Name
 		if label == "" {
@@ -109,15 +189,160 @@ func (h *NetworkHandler) NetworkGraph(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	g := &graphBuilder{nodes: nodes, groups: groupSet, seen: make(map[string]bool), edges: []NetworkEdge{}}
+	for _, n := range nodes {
+		g.seen[n.ID] = true
+	}
+	if err := h.addEdges(r.Context(), g, edgeTypes, assets, assigned); err != nil {
+		log.Printf("NetworkGraph edges: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
 	var groups []NetworkGroup
-	for g := range groupSet {
-		groups = append(groups, NetworkGroup{ID: g, Label: g})
+	for id, label := range g.groups {
+		groups = append(groups, NetworkGroup{ID: id, Label: label})
 	}
 	// Ensure stable order: Untagged first, then alphabetical
 	sortNetworkGroups(groups)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(NetworkGraphResponse{Nodes: nodes, Groups: groups})
+	json.NewEncoder(w).Encode(NetworkGraphResponse{Nodes: g.nodes, Edges: g.edges, Groups: groups})
+}
+
+// graphBuilder collects nodes and de-duplicated edges.
+type graphBuilder struct {
+	nodes  []NetworkNode
+	groups map[string]string
+	seen   map[string]bool // node IDs
+	edges  []NetworkEdge
+	linked map[NetworkEdge]bool
+}
+
+// addNode adds a node that is not an asset (a gateway or route hop) unless it exists.
+func (g *graphBuilder) addNode(id, label, group, groupLabel, title string) {
+	if g.seen[id] {
+		return
+	}
+	g.seen[id] = true
+	g.groups[group] = groupLabel
+	g.nodes = append(g.nodes, NetworkNode{ID: id, Label: label, Group: group, Title: title})
+}
+
+// addEdge adds an edge between existing nodes, once.
+func (g *graphBuilder) addEdge(e NetworkEdge) {
+	if e.From == e.To || !g.seen[e.From] || !g.seen[e.To] {
+		return
+	}
+	if g.linked == nil {
+		g.linked = make(map[NetworkEdge]bool)
+	}
+	if g.linked[e] {
+		return
+	}
+	g.linked[e] = true
+	g.edges = append(g.edges, e)
+}
+
+// addEdges adds the edges of the selected types between the graph's assets.
+func (h *NetworkHandler) addEdges(ctx context.Context, g *graphBuilder, types map[string]bool, assets []models.Asset, assigned map[int]models.Subnet) error {
+	ids := make([]int, 0, len(assets))
+	for _, a := range assets {
+		ids = append(ids, a.ID)
+	}
+
+	if h.Relationships != nil && (types[EdgeHosting] || types[EdgeDependency] || types[EdgeBackup] || types[EdgeMembership]) {
+		rels, err := h.Relationships.ListBetween(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, rel := range rels {
+			asset, related := "asset-"+strconv.Itoa(rel.AssetID), "asset-"+strconv.Itoa(rel.RelatedAssetID)
+			switch {
+			case rel.Type == models.RelationshipRunsOn && types[EdgeHosting]:
+				g.addEdge(NetworkEdge{From: related, To: asset, Type: EdgeHosting, Label: rel.Description})
+			case rel.Type == models.RelationshipDependsOn && types[EdgeDependency]:
+				g.addEdge(NetworkEdge{From: asset, To: related, Type: EdgeDependency, Label: rel.Description})
+			case rel.Type == models.RelationshipBacksUp && types[EdgeBackup]:
+				g.addEdge(NetworkEdge{From: asset, To: related, Type: EdgeBackup, Label: rel.Description})
+			case rel.Type == models.RelationshipMemberOf && types[EdgeMembership]:
+				g.addEdge(NetworkEdge{From: asset, To: related, Type: EdgeMembership, Label: rel.Description})
+			}
+		}
+	}
+
+	// Route hops and gateways are matched to assets by IP; an IP held by several assets (e.g. on different
+	// sites) stays a separate node.
+	byIP := make(map[string][]models.Asset)
+	for _, a := range assets {
+		if ip := strings.TrimSpace(a.NetworkName); ip != "" {
+			byIP[ip] = append(byIP[ip], a)
+		}
+	}
+
+	if types[EdgeGateway] {
+		for _, a := range assets {
+			s, ok := assigned[a.ID]
+			if !ok || s.Gateway == "" || strings.TrimSpace(a.NetworkName) == s.Gateway {
+				continue
+			}
+			group := "subnet-" + strconv.Itoa(s.ID)
+			gw := "gateway-" + strconv.Itoa(s.ID)
+			for _, cand := range byIP[s.Gateway] {
+				if cs, ok := assigned[cand.ID]; ok && cs.SiteID == s.SiteID {
+					gw = nodeID(cand)
+					break
+				}
+			}
+			g.addNode(gw, s.Gateway, group, subnetLabel(s), "Gateway of "+s.CIDR)
+			g.addEdge(NetworkEdge{From: nodeID(a), To: gw, Type: EdgeGateway})
+		}
+	}
+
+	if h.Routes != nil && types[EdgeRoute] {
+		routes, err := h.Routes.ListForAssets(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, a := range assets {
+			hops := routes[a.ID]
+			if len(hops) == 0 {
+				continue
+			}
+			prev := ""
+			for _, ip := range hops {
+				id := "hop-" + ip
+				if matches := byIP[ip]; len(matches) == 1 {
+					id = nodeID(matches[0])
+				} else {
+					g.addNode(id, ip, routeHopGroup, routeHopGroup, "Traceroute hop "+ip)
+				}
+				if prev != "" {
+					g.addEdge(NetworkEdge{From: prev, To: id, Type: EdgeRoute})
+				}
+				prev = id
+			}
+			g.addEdge(NetworkEdge{From: prev, To: nodeID(a), Type: EdgeRoute})
+		}
+	}
+	return nil
+}
+
+// subnetLabel names a defined subnet in the graph legend, e.g. "10.0.0.0/22 (VLAN 20)".
+// assetSubnet returns the CIDR of the defined subnet assigned to a (see SubnetRepo.AssetSubnets), falling back
+// to the /24 (/64) guess from its IP when none contains it.
+func assetSubnet(assigned map[int]models.Subnet, a models.Asset) string {
+	if s, ok := assigned[a.ID]; ok {
+		return s.CIDR
+	}
+	return subnetForIP(a.NetworkName)
+}
+
+func subnetLabel(s models.Subnet) string {
+	if s.VLANID != nil {
+		return s.CIDR + " (VLAN " + strconv.Itoa(*s.VLANID) + ")"
+	}
+	return s.CIDR
 }
 
 func nodeID(a models.Asset) string {
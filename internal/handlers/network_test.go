@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestNetworkGraph_Edges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &NetworkHandler{
+		Repo:          repo.NewAssetRepo(db),
+		Relationships: repo.NewRelationshipRepo(db),
+		Routes:        repo.NewAssetRouteRepo(db),
+	}
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
+		WithArgs(graphMaxAssets, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "pve1", "Proxmox node", "{}", now, "10.0.0.2", "active", "", nil, nil).
+			AddRow(2, "web01", "VM", "{}", now, "10.0.1.10", "active", "", nil, nil).
+			AddRow(3, "db01", "VM", "{}", now, "10.0.1.11", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT id, asset_id, related_asset_id, type, description, created_at FROM asset_relationships WHERE asset_id = ANY\(\$1\) AND related_asset_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "asset_id", "related_asset_id", "type", "description", "created_at"}).
+			AddRow(1, 2, 1, "runs_on", "", now).
+			AddRow(2, 3, 1, "runs_on", "", now).
+			AddRow(3, 2, 3, "depends_on", "postgres", now))
+	mock.ExpectQuery(`SELECT asset_id, hops FROM asset_routes WHERE asset_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_id", "hops"}).
+			AddRow(2, "{10.0.0.1,10.0.0.2}"))
+
+	rr := httptest.NewRecorder()
+	h.NetworkGraph(rr, httptest.NewRequest("GET", "/network/graph", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	var got NetworkGraphResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[NetworkEdge]bool{
+		{From: "asset-1", To: "asset-2", Type: EdgeHosting}:                       true,
+		{From: "asset-1", To: "asset-3", Type: EdgeHosting}:                       true,
+		{From: "asset-2", To: "asset-3", Type: EdgeDependency, Label: "postgres"}: true,
+		{From: "hop-10.0.0.1", To: "asset-1", Type: EdgeRoute}:                    true,
+		{From: "asset-1", To: "asset-2", Type: EdgeRoute}:                         true,
+	}
+	if len(got.Edges) != len(want) {
+		t.Errorf("edges: got %d, want %d: %+v", len(got.Edges), len(want), got.Edges)
+	}
+	for _, e := range got.Edges {
+		if !want[e] {
+			t.Errorf("unexpected edge %+v", e)
+		}
+	}
+	// The first hop is not an asset, so it becomes an extra node.
+	if len(got.Nodes) != 4 || got.Nodes[3].ID != "hop-10.0.0.1" || got.Nodes[3].AssetID != 0 {
+		t.Errorf("nodes: got %+v", got.Nodes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.NetworkGraph(rr, httptest.NewRequest("GET", "/network/graph?edges=hosting,links", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown edge type: got %d, want 400", rr.Code)
+	}
+}
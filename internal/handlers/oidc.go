@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/oidc"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/lib/pq"
+)
+
+// ==========================
+// OIDCHandler
+// ==========================
+// OIDCHandler completes OpenID Connect logins: the web UI runs the authorization-code + PKCE redirect and
+// posts the code here; the API exchanges it with the provider, verifies the ID token, provisions or
+// updates the user and returns the same tokens as a local login.
+type OIDCHandler struct {
+	Provider   *oidc.Provider
+	Identities *repo.IdentityRepo
+	Users      *repo.UserRepo
+	Auth       *AuthHandler // issues access/refresh tokens
+	AuditRepo  *repo.AuditRepo
+	Scopes     []string
+	// AdminGroups and ViewerGroups map ID token groups to roles (see auth.MapRole).
+	AdminGroups  []string
+	ViewerGroups []string
+	// Sessions, when set, revokes a user's other sessions when a login changes their role.
+	Sessions *repo.SessionRepo
+}
+
+// ==========================
+// OIDC Config (public; tells the web UI where to send the browser)
+// ==========================
+func (h *OIDCHandler) Config(w http.ResponseWriter, r *http.Request) {
+	out := map[string]interface{}{"enabled": false}
+	if h != nil && h.Provider != nil {
+		m, err := h.Provider.Discover(r.Context())
+		if err != nil {
+			log.Printf("OIDC config: %v", err)
+			JSONError(w, "identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+		out = map[string]interface{}{
+			"enabled":                true,
+			"issuer":                 m.Issuer,
+			"authorization_endpoint": m.AuthorizationEndpoint,
+			"client_id":              h.Provider.ClientID,
+			"scopes":                 h.scopes(),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (h *OIDCHandler) scopes() []string {
+	if len(h.Scopes) > 0 {
+		return h.Scopes
+	}
+	return []string{"openid", "profile", "email"}
+}
+
+// ==========================
+// OIDC Exchange (code + PKCE verifier -> verified identity -> local tokens)
+// ==========================
+func (h *OIDCHandler) Exchange(w http.ResponseWriter, r *http.Request) {
+	if h == nil || h.Provider == nil {
+		JSONError(w, "single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	var input struct {
+		Code         string `json:"code"`
+		CodeVerifier string `json:"code_verifier"`
+		RedirectURI  string `json:"redirect_uri"`
+		Nonce        string `json:"nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	if input.Code == "" {
+		fields["code"] = "required"
+	}
+	if input.CodeVerifier == "" {
+		fields["code_verifier"] = "required"
+	}
+	if input.RedirectURI == "" {
+		fields["redirect_uri"] = "required"
+	}
+	if input.Nonce == "" {
+		fields["nonce"] = "required"
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.Provider.Exchange(r.Context(), input.Code, input.CodeVerifier, input.RedirectURI)
+	if err != nil {
+		log.Printf("OIDC exchange: %v", err)
+		JSONError(w, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+	claims, err := h.Provider.VerifyIDToken(r.Context(), tokens.IDToken, input.Nonce)
+	if err != nil {
+		log.Printf("OIDC exchange: %v", err)
+		JSONError(w, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	role, ok := auth.MapRole(claims.Groups, h.AdminGroups, h.ViewerGroups)
+	if !ok {
+		JSONError(w, "your account is not permitted to use this application", http.StatusForbidden)
+		return
+	}
+
+	user, err := h.Identities.FindUser(r.Context(), claims.Issuer, claims.Subject)
+	switch {
+	case errors.Is(err, repo.ErrIdentityNotFound):
+		user, err = h.provision(r, claims, role)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				JSONError(w, "a local account with this username already exists; ask an administrator to rename it", http.StatusConflict)
+				return
+			}
+			log.Printf("OIDC provision: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+	case err != nil:
+		log.Printf("OIDC identity lookup: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	case auth.SyncRole(user.Role, role):
+		// Group membership is the source of truth for the built-in roles: sync them on every login.
+		updated, err := h.Users.Update(r.Context(), user.ID, user.Username, role)
+		if err != nil {
+			log.Printf("OIDC role sync: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+		if h.Sessions != nil {
+			if err := h.Sessions.RevokeAllForUser(r.Context(), user.ID); err != nil {
+				log.Printf("OIDC role sync: revoke sessions: %v", err)
+			}
+		}
+		user = updated
+	}
+
+	h.Auth.loginSucceeded(r, user, models.LoginMethodOIDC)
+	h.Auth.writeTokens(w, r, user, "")
+}
+
+// provision creates the local user for a first-time SSO login.
+func (h *OIDCHandler) provision(r *http.Request, claims *oidc.Claims, role string) (*models.User, error) {
+	user, err := h.Identities.CreateUser(r.Context(), claims.Issuer, claims.Subject, claims.Email, usernameFromClaims(claims), role)
+	if err != nil {
+		return nil, err
+	}
+	// Self-provisioned: attribute the entry to the new user.
+	logAuditAs(h.AuditRepo, r, user.ID, "create", "user", user.ID, map[string]interface{}{"after": user, "via": "oidc"})
+	return user, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// usernameFromClaims picks preferred_username, then email, then sub, limited to safe characters.
+func usernameFromClaims(c *oidc.Claims) string {
+	for _, v := range []string{c.PreferredUsername, c.Email, c.Subject} {
+		if v = usernameUnsafe.ReplaceAllString(strings.TrimSpace(v), "_"); v != "" {
+			if len(v) > 100 {
+				v = v[:100]
+			}
+			return v
+		}
+	}
+	return c.Subject
+}
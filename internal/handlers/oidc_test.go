@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/oidc"
+	"github.com/crucial707/hci-asset/internal/oidc/oidctest"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+const testRedirectURI = "http://localhost:3000/auth/oidc/callback"
+
+func newOIDCTestHandler(t *testing.T, idp *oidctest.Server) (*OIDCHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h := &OIDCHandler{
+		Provider:    &oidc.Provider{Issuer: idp.Issuer(), ClientID: idp.ClientID, ClientSecret: idp.ClientSecret},
+		Identities:  repo.NewIdentityRepo(db),
+		Users:       repo.NewUserRepo(db),
+		Auth:        &AuthHandler{Secret: []byte("test-secret")},
+		AdminGroups: []string{"hci-admins"},
+	}
+	return h, mock
+}
+
+func oidcExchangeRequest(idp *oidctest.Server) *http.Request {
+	code := idp.IssueCode(oidc.CodeChallenge("verifier-1"), "nonce-1", testRedirectURI)
+	body, _ := json.Marshal(map[string]string{
+		"code":          code,
+		"code_verifier": "verifier-1",
+		"redirect_uri":  testRedirectURI,
+		"nonce":         "nonce-1",
+	})
+	return httptest.NewRequest("POST", "/auth/oidc/exchange", bytes.NewReader(body))
+}
+
+func TestOIDCHandler_Exchange_ProvisionsUser(t *testing.T) {
+	idp := oidctest.NewServer("hci", "s3cret")
+	defer idp.Close()
+	idp.Claims = map[string]interface{}{"sub": "u-42", "preferred_username": "alice", "email": "alice@example.com", "groups": []string{"hci-admins"}}
+	h, mock := newOIDCTestHandler(t, idp)
+
+	mock.ExpectQuery(`UPDATE user_identities`).
+		WithArgs(idp.Issuer(), "u-42").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("alice", "admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "alice", "admin"))
+	mock.ExpectExec(`INSERT INTO user_identities`).
+		WithArgs(idp.Issuer(), "u-42", 7, "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	h.Exchange(rr, oidcExchangeRequest(idp))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Exchange status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Token string `json:"token"`
+		User  struct {
+			ID   int    `json:"id"`
+			Role string `json:"role"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Token == "" || out.User.ID != 7 || out.User.Role != "admin" {
+		t.Errorf("unexpected response: %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestOIDCHandler_Exchange_SyncsRole(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+	idp.Claims = map[string]interface{}{"sub": "u-42", "groups": []string{"staff"}}
+	h, mock := newOIDCTestHandler(t, idp)
+
+	mock.ExpectQuery(`UPDATE user_identities`).
+		WithArgs(idp.Issuer(), "u-42").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "alice", "admin"))
+	mock.ExpectQuery(`UPDATE users`).
+		WithArgs("alice", "viewer", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(7, "alice", nil, "viewer"))
+
+	rr := httptest.NewRecorder()
+	h.Exchange(rr, oidcExchangeRequest(idp))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Exchange status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestOIDCHandler_Exchange_KeepsCustomRole(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+	idp.Claims = map[string]interface{}{"sub": "u-42", "groups": []string{"staff"}}
+	h, mock := newOIDCTestHandler(t, idp)
+
+	// No UPDATE users: a custom role is not overwritten by the group mapping.
+	mock.ExpectQuery(`UPDATE user_identities`).
+		WithArgs(idp.Issuer(), "u-42").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "alice", "netops"))
+
+	rr := httptest.NewRecorder()
+	h.Exchange(rr, oidcExchangeRequest(idp))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Exchange status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestOIDCHandler_Exchange_GroupNotAllowed(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+	idp.Claims = map[string]interface{}{"sub": "u-42", "groups": []string{"contractors"}}
+	h, mock := newOIDCTestHandler(t, idp)
+	h.ViewerGroups = []string{"hci-viewers"}
+
+	rr := httptest.NewRecorder()
+	h.Exchange(rr, oidcExchangeRequest(idp))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Exchange status: got %d, want 403", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestOIDCHandler_Exchange_BadCode(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+	h, _ := newOIDCTestHandler(t, idp)
+
+	body, _ := json.Marshal(map[string]string{"code": "bogus", "code_verifier": "v", "redirect_uri": testRedirectURI, "nonce": "n"})
+	rr := httptest.NewRecorder()
+	h.Exchange(rr, httptest.NewRequest("POST", "/auth/oidc/exchange", bytes.NewReader(body)))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Exchange status: got %d, want 401", rr.Code)
+	}
+}
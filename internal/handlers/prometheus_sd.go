@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+// PrometheusSDHandler serves Prometheus HTTP service discovery targets built from assets.
+type PrometheusSDHandler struct {
+	Repo        *repo.AssetRepo
+	ServiceRepo *repo.AssetServiceRepo
+	Subnets     *repo.SubnetRepo
+	DefaultPort int            // scrape port when no tag matches (e.g. 9100 for node_exporter)
+	TagPorts    map[string]int // tag -> scrape port
+}
+
+// PrometheusTargetGroup is one entry of the Prometheus HTTP SD response.
+type PrometheusTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// PrometheusSD returns one target group per asset with a known IP, in the Prometheus HTTP SD format.
+// Query:
+//   - tag: only assets with this tag
+//   - port: scrape this port on every asset (overrides the tag/default port)
+//   - service: use the port of the discovered service with this name (e.g. "ssh"); assets without it are skipped
+//   - open=true: only assets whose target port was seen open by a scan
+func (h *PrometheusSDHandler) PrometheusSD(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tag := q.Get("tag")
+	service := q.Get("service")
+	openOnly := q.Get("open") == "true" || q.Get("open") == "1"
+	portOverride := 0
+	if p := q.Get("port"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			JSONError(w, "invalid port", http.StatusBadRequest)
+			return
+		}
+		portOverride = n
+	}
+
+	var assets []models.Asset
+	var err error
+	if tag != "" {
+		assets, err = h.Repo.ListByTag(r.Context(), tag, inventoryMaxAssets, 0)
+	} else {
+		assets, err = h.Repo.List(r.Context(), inventoryMaxAssets, 0)
+	}
+	if err != nil {
+		log.Printf("PrometheusSD list assets: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	// asset ID -> open services, only needed when filtering on scan results
+	servicesByAsset := make(map[int][]models.AssetService)
+	if (openOnly || service != "") && h.ServiceRepo != nil {
+		all, err := h.ServiceRepo.ListAll(r.Context())
+		if err != nil {
+			log.Printf("PrometheusSD list services: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+		for _, s := range all {
+			servicesByAsset[s.AssetID] = append(servicesByAsset[s.AssetID], s)
+		}
+	}
+
+	assigned := map[int]models.Subnet{}
+	if h.Subnets != nil {
+		if assigned, err = h.Subnets.AssetSubnets(r.Context()); err != nil {
+			log.Printf("PrometheusSD assign subnets: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	groups := make([]PrometheusTargetGroup, 0, len(assets))
+	for _, a := range assets {
+		ip := strings.TrimSpace(a.NetworkName)
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+
+		port := h.portForAsset(a)
+		if portOverride > 0 {
+			port = portOverride
+		}
+		if service != "" {
+			port = 0
+			for _, s := range servicesByAsset[a.ID] {
+				if strings.EqualFold(s.Service, service) {
+					port = s.Port
+					break
+				}
+			}
+			if port == 0 {
+				continue
+			}
+		}
+		if openOnly && !hasOpenPort(servicesByAsset[a.ID], port) {
+			continue
+		}
+
+		groups = append(groups, PrometheusTargetGroup{
+			Targets: []string{net.JoinHostPort(ip, strconv.Itoa(port))},
+			Labels:  prometheusLabels(a, assetSubnet(assigned, a)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// portForAsset returns the configured port for the asset's first tag that has one, else DefaultPort.
+func (h *PrometheusSDHandler) portForAsset(a models.Asset) int {
+	for _, t := range a.Tags {
+		if p, ok := h.TagPorts[t]; ok {
+			return p
+		}
+	}
+	if h.DefaultPort > 0 {
+		return h.DefaultPort
+	}
+	return 9100
+}
+
+func hasOpenPort(services []models.AssetService, port int) bool {
+	for _, s := range services {
+		if s.Port == port && (s.Protocol == "" || s.Protocol == "tcp") {
+			return true
+		}
+	}
+	return false
+}
+
+// prometheusLabels builds __meta_hci_* labels for relabeling. Tags are exposed both as a
+// comma-wrapped list (",a,b,", like Prometheus' own SD mechanisms) and as one label per tag. subnet is the
+// asset's subnet CIDR, if known.
+func prometheusLabels(a models.Asset, subnet string) map[string]string {
+	labels := map[string]string{
+		"__meta_hci_asset_id":   strconv.Itoa(a.ID),
+		"__meta_hci_asset_name": a.Name,
+		"__meta_hci_ip":         a.NetworkName,
+		"__meta_hci_group":      untaggedGroup,
+	}
+	if len(a.Tags) > 0 {
+		labels["__meta_hci_group"] = a.Tags[0]
+		labels["__meta_hci_tags"] = "," + strings.Join(a.Tags, ",") + ","
+		for _, t := range a.Tags {
+			if name := sanitizeName(t); name != "" {
+				labels["__meta_hci_tag_"+name] = "true"
+			}
+		}
+	}
+	if subnet != "" {
+		labels["__meta_hci_subnet"] = subnet
+	}
+	return labels
+}
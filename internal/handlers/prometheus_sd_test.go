@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestPrometheusSDHandler_PrometheusSD(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
+		WithArgs(inventoryMaxAssets, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "db1", "database", "{postgres}", nil, "10.0.0.5", "active", "", nil, nil).
+			AddRow(2, "web1", "web", "{}", nil, "10.0.0.6", "active", "", nil, nil).
+			AddRow(3, "no-ip", "unknown", "{}", nil, "", "active", "", nil, nil))
+	// web1 is in a defined subnet; db1 falls back to the /24 guess.
+	now := time.Now()
+	mock.ExpectQuery(`SELECT .+ FROM subnets ORDER BY site_id, cidr`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "cidr", "vlan_id", "gateway", "description", "created_at", "updated_at"}).
+			AddRow(4, 1, "10.0.0.6/31", nil, "", "", now, now))
+	mock.ExpectQuery(`SELECT id, site_id, network_name FROM assets WHERE network_name <> ''`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "network_name"}).
+			AddRow(1, 2, "10.0.0.5").
+			AddRow(2, 1, "10.0.0.6"))
+
+	h := &PrometheusSDHandler{
+		Repo:        repo.NewAssetRepo(db),
+		ServiceRepo: repo.NewAssetServiceRepo(db),
+		Subnets:     repo.NewSubnetRepo(db),
+		DefaultPort: 9100,
+		TagPorts:    map[string]int{"postgres": 9187},
+	}
+
+	req := httptest.NewRequest("GET", "/sd/prometheus", nil)
+	rr := httptest.NewRecorder()
+	h.PrometheusSD(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PrometheusSD status: got %d, want 200", rr.Code)
+	}
+	var groups []PrometheusTargetGroup
+	if err := json.NewDecoder(rr.Body).Decode(&groups); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 target groups (asset without IP skipped), got %+v", groups)
+	}
+	if groups[0].Targets[0] != "10.0.0.5:9187" || groups[1].Targets[0] != "10.0.0.6:9100" {
+		t.Errorf("unexpected targets: %v %v", groups[0].Targets, groups[1].Targets)
+	}
+	if groups[0].Labels["__meta_hci_tag_postgres"] != "true" || groups[0].Labels["__meta_hci_subnet"] != "10.0.0.0/24" {
+		t.Errorf("unexpected labels: %v", groups[0].Labels)
+	}
+	if groups[1].Labels["__meta_hci_subnet"] != "10.0.0.6/31" {
+		t.Errorf("unexpected labels: %v", groups[1].Labels)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestPrometheusSDHandler_PrometheusSD_OpenOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id`).
+		WithArgs(inventoryMaxAssets, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "a", "", "{}", nil, "10.0.0.5", "active", "", nil, nil).
+			AddRow(2, "b", "", "{}", nil, "10.0.0.6", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT asset_id, port, protocol, service, last_seen FROM asset_services`).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_id", "port", "protocol", "service", "last_seen"}).
+			AddRow(2, 9100, "tcp", "jetdirect", time.Now()))
+
+	h := &PrometheusSDHandler{Repo: repo.NewAssetRepo(db), ServiceRepo: repo.NewAssetServiceRepo(db), DefaultPort: 9100}
+
+	req := httptest.NewRequest("GET", "/sd/prometheus?open=true", nil)
+	rr := httptest.NewRecorder()
+	h.PrometheusSD(rr, req)
+
+	var groups []PrometheusTargetGroup
+	if err := json.NewDecoder(rr.Body).Decode(&groups); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Targets[0] != "10.0.0.6:9100" {
+		t.Errorf("expected only asset with port 9100 open, got %+v", groups)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestPrometheusSDHandler_PrometheusSD_InvalidPort(t *testing.T) {
+	h := &PrometheusSDHandler{}
+	req := httptest.NewRequest("GET", "/sd/prometheus?port=abc", nil)
+	rr := httptest.NewRecorder()
+	h.PrometheusSD(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status: got %d, want 400", rr.Code)
+	}
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+)
+
+// ==========================
+// RelationshipHandler
+// ==========================
+// RelationshipHandler manages typed relationships between assets (see models.RelationshipTypes) and the
+// impact analysis built on them. Both assets of a relationship must be visible to the caller.
+type RelationshipHandler struct {
+	Repo      *repo.RelationshipRepo
+	Assets    *repo.AssetRepo
+	AuditRepo *repo.AuditRepo
+	// OfflineAfter is how long after last_seen an asset counts as offline (default 24h).
+	OfflineAfter time.Duration
+}
+
+func (h *RelationshipHandler) status(a models.Asset, now time.Time) string {
+	offlineAfter := h.OfflineAfter
+	if offlineAfter <= 0 {
+		offlineAfter = 24 * time.Hour
+	}
+	return models.AssetStatus(a.LastSeen, offlineAfter, now)
+}
+
+func (h *RelationshipHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "asset_relationship", id, details)
+}
+
+// assetParam returns the asset ID in the {id} URL parameter, writing an error and returning false if it is
+// invalid or the asset is not visible.
+func (h *RelationshipHandler) assetParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return 0, false
+	}
+	if _, err := h.Assets.Get(r.Context(), id); err != nil {
+		JSONError(w, "asset not found", http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+func validRelationshipType(t string) bool {
+	for _, rt := range models.RelationshipTypes {
+		if t == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// ==========================
+// List Relationships (both directions)
+// ==========================
+func (h *RelationshipHandler) ListRelationships(w http.ResponseWriter, r *http.Request) {
+	assetID, ok := h.assetParam(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.Repo.ListForAsset(r.Context(), assetID)
+	if err != nil {
+		log.Printf("ListRelationships: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	// Hide relationships to assets outside the caller's access policies or site.
+	others := make([]int, 0, len(list))
+	for _, rel := range list {
+		others = append(others, rel.AssetID, rel.RelatedAssetID)
+	}
+	visible, err := h.Assets.VisibleIDs(r.Context(), others)
+	if err != nil {
+		log.Printf("ListRelationships visible assets: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	items := make([]models.AssetRelationship, 0, len(list))
+	for _, rel := range list {
+		if visible[rel.AssetID] && visible[rel.RelatedAssetID] {
+			items = append(items, rel)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+		"total": len(items),
+	})
+}
+
+// ==========================
+// Create Relationship
+// ==========================
+func (h *RelationshipHandler) CreateRelationship(w http.ResponseWriter, r *http.Request) {
+	assetID, ok := h.assetParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Type           string `json:"type"`
+		RelatedAssetID int    `json:"related_asset_id"`
+		Description    string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	fields := make(map[string]string)
+	input.Type = strings.TrimSpace(input.Type)
+	if !validRelationshipType(input.Type) {
+		fields["type"] = "must be one of: " + strings.Join(models.RelationshipTypes, ", ")
+	}
+	if input.RelatedAssetID == assetID {
+		fields["related_asset_id"] = "must be another asset"
+	} else if _, err := h.Assets.Get(r.Context(), input.RelatedAssetID); err != nil {
+		fields["related_asset_id"] = "asset not found"
+	}
+	if len(input.Description) > MaxDescriptionLength {
+		fields["description"] = "too long"
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	rel, err := h.Repo.Create(r.Context(), models.AssetRelationship{
+		AssetID:        assetID,
+		RelatedAssetID: input.RelatedAssetID,
+		Type:           input.Type,
+		Description:    strings.TrimSpace(input.Description),
+	})
+	if err == repo.ErrRelationshipExists || err == repo.ErrRelationshipCycle {
+		JSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("CreateRelationship: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "create", rel.ID, map[string]interface{}{"after": rel})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rel)
+}
+
+// ==========================
+// Delete Relationship
+// ==========================
+func (h *RelationshipHandler) DeleteRelationship(w http.ResponseWriter, r *http.Request) {
+	assetID, ok := h.assetParam(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "relationshipID"))
+	if err != nil {
+		JSONError(w, "invalid relationship id", http.StatusBadRequest)
+		return
+	}
+	rel, err := h.Repo.Get(r.Context(), assetID, id)
+	if err == models.ErrRelationshipNotFound {
+		JSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("DeleteRelationship: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	// Relationships to assets outside the caller's access policies or site are hidden, as in ListRelationships.
+	visible, err := h.Assets.VisibleIDs(r.Context(), []int{rel.AssetID, rel.RelatedAssetID})
+	if err != nil {
+		log.Printf("DeleteRelationship visible assets: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if !visible[rel.AssetID] || !visible[rel.RelatedAssetID] {
+		JSONError(w, models.ErrRelationshipNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.Repo.Delete(r.Context(), assetID, id); err != nil {
+		if err == models.ErrRelationshipNotFound {
+			JSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("DeleteRelationship: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "delete", id, map[string]interface{}{"asset_id": assetID, "before": rel})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// Impact (assets affected if this one goes down)
+// ==========================
+func (h *RelationshipHandler) Impact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid asset id", http.StatusBadRequest)
+		return
+	}
+	asset, err := h.Assets.Get(r.Context(), id)
+	if err != nil {
+		JSONError(w, "asset not found", http.StatusNotFound)
+		return
+	}
+	depths, err := h.Repo.Impacted(r.Context(), id)
+	if err != nil {
+		log.Printf("Impact: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	impacted, err := h.impactedAssets(r, depths)
+	if err != nil {
+		log.Printf("Impact list assets: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"asset":    asset,
+		"status":   h.status(*asset, time.Now()),
+		"impacted": impacted,
+		"total":    len(impacted),
+	})
+}
+
+// impactedAssets loads the visible assets of depths (asset ID to depth), nearest first.
+func (h *RelationshipHandler) impactedAssets(r *http.Request, depths map[int]int) ([]models.ImpactedAsset, error) {
+	impacted := []models.ImpactedAsset{}
+	if len(depths) == 0 {
+		return impacted, nil
+	}
+	ids := make([]int, 0, len(depths))
+	for id := range depths {
+		ids = append(ids, id)
+	}
+	assets, err := h.Assets.ListByIDs(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, a := range assets {
+		impacted = append(impacted, models.ImpactedAsset{Asset: a, Depth: depths[a.ID], Status: h.status(a, now)})
+	}
+	sort.SliceStable(impacted, func(i, j int) bool { return impacted[i].Depth < impacted[j].Depth })
+	return impacted, nil
+}
+
+// ==========================
+// Outage Impact (offline assets and how many assets they impact, for the dashboard)
+// ==========================
+func (h *RelationshipHandler) OutageImpact(w http.ResponseWriter, r *http.Request) {
+	offlineAfter := h.OfflineAfter
+	if offlineAfter <= 0 {
+		offlineAfter = 24 * time.Hour
+	}
+	now := time.Now()
+	ids, err := h.Repo.OfflineSources(r.Context(), now.Add(-offlineAfter))
+	if err != nil {
+		log.Printf("OutageImpact: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	items := []models.OutageImpact{}
+	if len(ids) > 0 {
+		sources, err := h.Assets.ListByIDs(r.Context(), ids)
+		if err != nil {
+			log.Printf("OutageImpact list assets: %v", err)
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+		for _, a := range sources {
+			depths, err := h.Repo.Impacted(r.Context(), a.ID)
+			if err != nil {
+				log.Printf("OutageImpact impacted: %v", err)
+				JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+				return
+			}
+			impacted, err := h.impactedAssets(r, depths)
+			if err != nil {
+				log.Printf("OutageImpact list impacted: %v", err)
+				JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+				return
+			}
+			if len(impacted) > 0 {
+				items = append(items, models.OutageImpact{Asset: a, Status: models.AssetOffline, Impacted: len(impacted)})
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Impacted > items[j].Impacted })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+		"total": len(items),
+	})
+}
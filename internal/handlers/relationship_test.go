@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+var impactAssetColumns = []string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}
+
+func TestRelationshipHandler_Impact(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &RelationshipHandler{Repo: repo.NewRelationshipRepo(db), Assets: repo.NewAssetRepo(db), OfflineAfter: time.Hour}
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE id=\$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(impactAssetColumns).AddRow(1, "pve1", "", "{}", now.Add(-2*time.Hour), "", "active", "", nil, nil))
+	mock.ExpectQuery(`WITH RECURSIVE edges AS .* FROM impacted WHERE id <> \$1 GROUP BY id`).
+		WithArgs(1, 32).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "min"}).AddRow(2, 1).AddRow(3, 2))
+	mock.ExpectQuery(`FROM assets WHERE id = ANY\(\$1\) AND deleted_at IS NULL ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows(impactAssetColumns).
+			AddRow(2, "web01", "", "{}", now, "", "active", "", nil, nil).
+			AddRow(3, "app", "", "{}", nil, "", "active", "", nil, nil))
+
+	rr := httptest.NewRecorder()
+	h.Impact(rr, requestWithChiURLParams("GET", "/assets/1/impact", nil, map[string]string{"id": "1"}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200; body %s", rr.Code, rr.Body.String())
+	}
+	var got struct {
+		Status   string                 `json:"status"`
+		Impacted []models.ImpactedAsset `json:"impacted"`
+		Total    int                    `json:"total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Status != models.AssetOffline {
+		t.Errorf("status: got %q, want offline", got.Status)
+	}
+	if got.Total != 2 || got.Impacted[0].ID != 2 || got.Impacted[0].Depth != 1 || got.Impacted[0].Status != models.AssetOnline ||
+		got.Impacted[1].ID != 3 || got.Impacted[1].Depth != 2 || got.Impacted[1].Status != models.AssetStatusUnknown {
+		t.Errorf("impacted: got %+v", got.Impacted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestRelationshipHandler_DeleteHidesOtherScopes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &RelationshipHandler{Repo: repo.NewRelationshipRepo(db), Assets: repo.NewAssetRepo(db)}
+
+	// Asset 1 is visible, but the relationship links it to asset 2, which is outside the caller's tags.
+	mock.ExpectQuery(`FROM assets WHERE id=\$1 AND tags && \$2`).
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(impactAssetColumns).AddRow(1, "web-a", "", "{team-a}", nil, "", "active", "", nil, nil))
+	mock.ExpectQuery(`SELECT id, asset_id, related_asset_id, type, description, created_at FROM asset_relationships WHERE id = \$1`).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "asset_id", "related_asset_id", "type", "description", "created_at"}).
+			AddRow(5, 1, 2, models.RelationshipDependsOn, "", time.Now()))
+	mock.ExpectQuery(`SELECT id FROM assets WHERE id = ANY\(\$1\) AND tags && \$2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	req := requestWithChiURLParams("DELETE", "/assets/1/relationships/5", nil, map[string]string{"id": "1", "relationshipID": "5"})
+	req = req.WithContext(repo.WithAssetScope(req.Context(), []string{"team-a"}))
+	rr := httptest.NewRecorder()
+	h.DeleteRelationship(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status: got %d, want 404; body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/crucial707/hci-asset/internal/retention"
+)
+
+// ======================
+// Retention Handler
+// ======================
+
+// RetentionHandler reports and applies the data retention policies.
+type RetentionHandler struct {
+	Pruner    *retention.Pruner
+	AuditRepo *repo.AuditRepo
+}
+
+// Report is a dry run of every retention policy: what a run now would delete, and when the oldest of those rows
+// was written.
+func (h *RetentionHandler) Report(w http.ResponseWriter, r *http.Request) {
+	writeRetentionResults(w, h.Pruner.Prune(r.Context(), true))
+}
+
+// Run applies the retention policies now, or reports them like Report with ?dry_run=true. Policies that fail
+// report their error in the result. Runs that delete rows are audited.
+func (h *RetentionHandler) Run(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	results := h.Pruner.Prune(r.Context(), dryRun)
+	if !dryRun {
+		deleted := map[string]int{}
+		for _, res := range results {
+			if res.Rows > 0 {
+				deleted[res.DataType] = res.Rows
+			}
+		}
+		if len(deleted) > 0 {
+			logAudit(h.AuditRepo, r, "prune", "retention", 0, map[string]interface{}{"rows": deleted})
+		}
+	}
+	writeRetentionResults(w, results)
+}
+
+func writeRetentionResults(w http.ResponseWriter, results []models.RetentionResult) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": results})
+}
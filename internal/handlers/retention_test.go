@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/middleware"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/crucial707/hci-asset/internal/retention"
+)
+
+func TestRetentionHandler_Run(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	audits := repo.NewAuditRepo(db)
+	policies := []models.RetentionPolicy{
+		{DataType: models.RetentionTrash, MaxAgeDays: 30},
+		{DataType: models.RetentionAudit},
+	}
+	h := &RetentionHandler{
+		Pruner:    retention.NewPruner(repo.NewRetentionRepo(db), audits, policies, 100, "", false, time.Hour),
+		AuditRepo: audits,
+	}
+
+	// Dry run: only counts, nothing is audited.
+	mock.ExpectQuery(`SELECT COUNT\(\*\), MIN\(deleted_at\) FROM assets WHERE deleted_at < \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min"}).AddRow(2, time.Now().AddDate(0, 0, -40)))
+	// Run: one short batch, then the run is audited as the user.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery(`SELECT id, row_to_json\(t\)::text FROM assets t WHERE deleted_at < \$1 ORDER BY id LIMIT \$2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "row_to_json"}).AddRow(4, `{"id":4}`).AddRow(9, `{"id":9}`))
+	mock.ExpectExec(`DELETE FROM assets WHERE id = ANY\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(1, "prune", "retention", 0, `{"rows":{"trash":2}}`, models.AuditActorUser, "", "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	for _, dryRun := range []bool{true, false} {
+		target := "/retention/run"
+		if dryRun {
+			target += "?dry_run=true"
+		}
+		req := httptest.NewRequest("POST", target, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+		rec := httptest.NewRecorder()
+		h.Run(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("dry_run=%v: status %d: %s", dryRun, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Items []models.RetentionResult `json:"items"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Items) != 2 {
+			t.Fatalf("dry_run=%v: body %s", dryRun, rec.Body.String())
+		}
+		if trash := body.Items[0]; trash.Rows != 2 || trash.DryRun != dryRun || trash.Error != "" {
+			t.Errorf("dry_run=%v: trash = %+v", dryRun, trash)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// roleNamePattern matches role names: lower-case letters, digits, '-' and '_', at most 20 characters (users.role).
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,19}$`)
+
+// ==========================
+// RoleHandler
+// ==========================
+type RoleHandler struct {
+	Repo      *repo.RoleRepo
+	AuditRepo *repo.AuditRepo
+}
+
+func (h *RoleHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "role", id, details)
+}
+
+// auditBefore returns role id as it is before a change, when changes are audited.
+func (h *RoleHandler) auditBefore(r *http.Request, id int) *models.Role {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	v, _ := h.Repo.GetByID(r.Context(), id)
+	return v
+}
+
+// validatePermissions adds a field error for unknown permissions and returns them de-duplicated.
+func validatePermissions(perms []string, fields map[string]string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, p := range perms {
+		p = strings.TrimSpace(p)
+		known := false
+		for _, k := range models.Permissions {
+			if p == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			fields["permissions"] = "unknown permission: " + p
+			continue
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ==========================
+// List Roles
+// ==========================
+func (h *RoleHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.Repo.List(r.Context())
+	if err != nil {
+		log.Printf("ListRoles: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":       roles,
+		"total":       len(roles),
+		"permissions": models.Permissions,
+	})
+}
+
+// ==========================
+// Get Role
+// ==========================
+func (h *RoleHandler) GetRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+	role, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		if err == repo.ErrRoleNotFound {
+			JSONError(w, "role not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("GetRole: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// ==========================
+// Create Role
+// ==========================
+func (h *RoleHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	input.Name = strings.TrimSpace(input.Name)
+	if !roleNamePattern.MatchString(input.Name) {
+		fields["name"] = "must be 1-20 lower-case letters, digits, '-' or '_', starting with a letter"
+	}
+	perms := validatePermissions(input.Permissions, fields)
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.Repo.Create(r.Context(), input.Name, strings.TrimSpace(input.Description), perms)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+			JSONError(w, "role already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("CreateRole: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "create", role.ID, map[string]interface{}{"after": role})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// ==========================
+// Update Role (description and permissions; built-in roles are read-only)
+// ==========================
+func (h *RoleHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	perms := validatePermissions(input.Permissions, fields)
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return
+	}
+
+	before := h.auditBefore(r, id)
+	role, err := h.Repo.Update(r.Context(), id, strings.TrimSpace(input.Description), perms)
+	if err != nil {
+		h.writeRoleError(w, "UpdateRole", err)
+		return
+	}
+
+	h.audit(r, "update", id, auditChange(before, role))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// ==========================
+// Delete Role (only custom roles no user is assigned to)
+// ==========================
+func (h *RoleHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+	before := h.auditBefore(r, id)
+	if err := h.Repo.Delete(r.Context(), id); err != nil {
+		h.writeRoleError(w, "DeleteRole", err)
+		return
+	}
+
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RoleHandler) writeRoleError(w http.ResponseWriter, op string, err error) {
+	switch err {
+	case repo.ErrRoleNotFound:
+		JSONError(w, "role not found", http.StatusNotFound)
+	case repo.ErrRoleBuiltin:
+		JSONError(w, "built-in roles cannot be changed", http.StatusForbidden)
+	case repo.ErrRoleInUse:
+		JSONError(w, "role is assigned to users", http.StatusConflict)
+	default:
+		log.Printf("%s: %v", op, err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+	}
+}
+
+// isUnknownRole reports whether err is the users.role foreign key rejecting a role that does not exist.
+func isUnknownRole(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == "23503" && e.Constraint == "fk_users_role"
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/lib/pq"
+)
+
+var roleColumns = []string{"id", "name", "description", "permissions", "builtin", "created_at", "updated_at"}
+
+func TestRoleHandler_CreateRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &RoleHandler{Repo: repo.NewRoleRepo(db)}
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO roles \(name, description, permissions\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs("netops", "Network team", `{"scans:run","schedules:write"}`).
+		WillReturnRows(sqlmock.NewRows(roleColumns).AddRow(3, "netops", "Network team", "{scans:run,schedules:write}", false, now, now))
+
+	rr := httptest.NewRecorder()
+	h.CreateRole(rr, postJSON("/roles", map[string]interface{}{
+		"name":        "netops",
+		"description": "Network team",
+		"permissions": []string{"scans:run", "schedules:write", "scans:run"},
+	}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201; body %s", rr.Code, rr.Body.String())
+	}
+	var role struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&role); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if role.Name != "netops" || len(role.Permissions) != 2 {
+		t.Errorf("unexpected role: %+v", role)
+	}
+
+	rr = httptest.NewRecorder()
+	h.CreateRole(rr, postJSON("/roles", map[string]interface{}{"name": "Net Ops", "permissions": []string{"everything"}}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid role: got %d, want 400", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestRoleHandler_UpdateBuiltinRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &RoleHandler{Repo: repo.NewRoleRepo(db)}
+
+	mock.ExpectQuery(`UPDATE roles SET description = \$2, permissions = \$3, updated_at = NOW\(\) WHERE id = \$1 AND NOT builtin`).
+		WithArgs(1, "", "{}").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT builtin FROM roles WHERE id = \$1`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"builtin"}).AddRow(true))
+
+	body, _ := json.Marshal(map[string]interface{}{"permissions": []string{}})
+	rr := httptest.NewRecorder()
+	h.UpdateRole(rr, requestWithChiURLParams("PUT", "/roles/1", body, map[string]string{"id": "1"}))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("status: got %d, want 403", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestRoleHandler_DeleteRoleInUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &RoleHandler{Repo: repo.NewRoleRepo(db)}
+
+	mock.ExpectExec(`DELETE FROM roles WHERE id = \$1 AND NOT builtin`).WithArgs(3).
+		WillReturnError(&pq.Error{Code: "23503", Constraint: "fk_users_role"})
+
+	rr := httptest.NewRecorder()
+	h.DeleteRole(rr, requestWithChiURLParams("DELETE", "/roles/3", nil, map[string]string{"id": "3"}))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("status: got %d, want 409", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
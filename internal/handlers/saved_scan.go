@@ -13,6 +13,20 @@ import (
 type SavedScanHandler struct {
 	Repo        *repo.SavedScanRepo
 	ScanHandler *ScanHandler // used to start a scan from a saved target
+	AuditRepo   *repo.AuditRepo
+}
+
+func (h *SavedScanHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "saved_scan", id, details)
+}
+
+// auditBefore returns saved scan id as it is before a change, when changes are audited.
+func (h *SavedScanHandler) auditBefore(r *http.Request, id int) *repo.SavedScan {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	saved, _ := h.Repo.GetByID(r.Context(), id)
+	return saved
 }
 
 // ListSavedScans returns all saved scans.
@@ -59,16 +73,22 @@ func (h *SavedScanHandler) CreateSavedScan(w http.ResponseWriter, r *http.Reques
 	}
 	if input.Target == "" {
 		fields["target"] = "required"
+	} else if !validScanTarget(input.Target) {
+		fields["target"] = errInvalidScanTarget
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
+	if !requireSite(r.Context(), w) {
+		return
+	}
 	saved, err := h.Repo.Create(r.Context(), input.Name, input.Target)
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, "create", saved.ID, map[string]interface{}{"after": saved})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(saved)
 }
@@ -95,11 +115,14 @@ func (h *SavedScanHandler) UpdateSavedScan(w http.ResponseWriter, r *http.Reques
 	}
 	if input.Target == "" {
 		fields["target"] = "required"
+	} else if !validScanTarget(input.Target) {
+		fields["target"] = errInvalidScanTarget
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
+	before := h.auditBefore(r, id)
 	saved, err := h.Repo.Update(r.Context(), id, input.Name, input.Target)
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
@@ -109,6 +132,7 @@ func (h *SavedScanHandler) UpdateSavedScan(w http.ResponseWriter, r *http.Reques
 		JSONError(w, "saved scan not found", http.StatusNotFound)
 		return
 	}
+	h.audit(r, "update", id, auditChange(before, saved))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(saved)
 }
@@ -121,14 +145,20 @@ func (h *SavedScanHandler) DeleteSavedScan(w http.ResponseWriter, r *http.Reques
 		JSONError(w, "invalid id", http.StatusBadRequest)
 		return
 	}
+	before := h.auditBefore(r, id)
 	if err := h.Repo.Delete(r.Context(), id); err != nil {
 		if err.Error() == "saved scan not found" {
 			JSONError(w, "saved scan not found", http.StatusNotFound)
 			return
 		}
+		if err == repo.ErrSavedScanInUse {
+			JSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -145,7 +175,8 @@ func (h *SavedScanHandler) RunSavedScan(w http.ResponseWriter, r *http.Request)
 		JSONError(w, "saved scan not found", http.StatusNotFound)
 		return
 	}
-	jobID := h.ScanHandler.StartScanTarget(r.Context(), saved.Target)
+	jobID := h.ScanHandler.StartScanTarget(repo.WithSite(r.Context(), saved.SiteID), saved.Target)
+	h.audit(r, "run", id, map[string]interface{}{"job_id": jobID, "target": saved.Target})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"job_id": jobID,
@@ -5,12 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
+	"log"
 	"net/http"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/crucial707/hci-asset/internal/metrics"
 	"github.com/crucial707/hci-asset/internal/models"
@@ -28,7 +31,14 @@ type ScanJob struct {
 	CompletedAt *time.Time     `json:"completed_at,omitempty"`
 	Assets      []models.Asset `json:"assets,omitempty"`
 	Error       string         `json:"error,omitempty"`
+	targets     []string       // nmap target arguments, one per host or range
 	cancel      chan struct{}  `json:"-"`
+	ctx         context.Context    // canceled with the job, which kills its nmap process
+	stop        context.CancelFunc // cancels ctx
+	done        chan struct{}  // closed when the scan ends
+	resolveErr  error          // the schedule's target could not be resolved: the job fails without scanning
+	siteID      int
+	scheduleID  int // schedule that started the job, 0 for on-demand scans
 }
 
 // ==========================
@@ -37,7 +47,14 @@ type ScanJob struct {
 type ScanHandler struct {
 	Repo       *repo.AssetRepo
 	ScanJobRepo *repo.ScanJobRepo
+	ServiceRepo *repo.AssetServiceRepo // optional: records open ports per discovered asset
+	RouteRepo   *repo.AssetRouteRepo   // optional: records traceroute hops per discovered asset when Traceroute is set
+	ScheduleRepo *repo.ScheduleRepo    // optional: records the outcome of scans started by a schedule
+	SavedScanRepo *repo.SavedScanRepo  // resolves the target of schedules that run a saved scan
+	ScheduleMaxFailures int            // disable a schedule after this many consecutive failed runs (0 = never)
+	AuditRepo  *repo.AuditRepo // optional: audits scans started, cancelled and cleared, and their results
 	NmapPath   string // path to nmap executable (e.g. "nmap" or "C:\\Program Files (x86)\\Nmap\\nmap.exe")
+	Traceroute bool   // also run nmap --traceroute (needs root / CAP_NET_RAW) so the network graph can show hops
 	scanJobs   map[string]*ScanJob // in-memory only for running jobs (for cancel channel)
 	scanJobsMu sync.Mutex
 }
@@ -53,8 +70,16 @@ func (h *ScanHandler) StartScan(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, "invalid JSON or missing target", http.StatusBadRequest)
 		return
 	}
+	if !validScanTarget(input.Target) {
+		JSONValidationError(w, "validation failed", map[string]string{"target": errInvalidScanTarget}, http.StatusBadRequest)
+		return
+	}
+	if !requireSite(r.Context(), w) {
+		return
+	}
 
 	jobID := h.StartScanTarget(r.Context(), input.Target)
+	logAudit(h.AuditRepo, r, "start", "scan", scanAuditID(jobID), map[string]interface{}{"job_id": jobID, "target": input.Target})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"job_id": jobID,
@@ -64,8 +89,144 @@ func (h *ScanHandler) StartScan(w http.ResponseWriter, r *http.Request) {
 
 // StartScanTarget starts a scan for the given target and returns the job ID.
 // Used by the API (StartScan) and by the schedule runner. Persists the job to DB.
+// The job and the assets it discovers belong to ctx's site (see repo.WithSite), or the default site.
 func (h *ScanHandler) StartScanTarget(ctx context.Context, target string) string {
-	id, err := h.ScanJobRepo.Create(ctx, target)
+	jobID, _ := h.startScan(ctx, target, []string{target}, 0, nil)
+	return jobID
+}
+
+// errInvalidScanTarget is the validation message for targets rejected by validScanTarget.
+const errInvalidScanTarget = "must be a single host, range or CIDR that does not start with \"-\""
+
+// validScanTarget reports whether target can be passed to nmap as one argument: a leading "-" would be read
+// as an option and whitespace would separate several arguments.
+func validScanTarget(target string) bool {
+	return target != "" && !strings.HasPrefix(target, "-") && strings.IndexFunc(target, unicode.IsSpace) < 0
+}
+
+// StartScheduledScan starts a scan of targets (see ScheduleTarget) for schedule s, recording it in the
+// schedule's runs and last run. Returns the job ID and a channel closed when the scan ends (see
+// scheduler.Runner). When resolveErr is set the targets could not be resolved and the run fails without scanning.
+func (h *ScanHandler) StartScheduledScan(ctx context.Context, s models.Schedule, targets []string, resolveErr error) (string, <-chan struct{}) {
+	if h.ScheduleRepo != nil {
+		if err := h.ScheduleRepo.RecordStart(ctx, s.ID, time.Now()); err != nil {
+			log.Printf("scan: record start of schedule id=%d: %v", s.ID, err)
+		}
+	}
+	target := strings.Join(targets, " ")
+	if resolveErr != nil {
+		target = s.TargetLabel()
+	}
+	jobID, job := h.startScan(ctx, target, targets, s.ID, resolveErr)
+	logSystemAudit(ctx, h.AuditRepo, models.AuditSystemScheduler, "run", "schedule", s.ID,
+		map[string]interface{}{"job_id": jobID, "target": target})
+	return jobID, job.done
+}
+
+// unresolvedTarget is returned by ScheduleTarget when a schedule's saved scan or asset group leaves nothing to
+// scan, as opposed to an error loading it.
+type unresolvedTarget string
+
+func (e unresolvedTarget) Error() string { return string(e) }
+
+// ScheduleTarget resolves what schedule s scans now: its target, its saved scan's target, or the IPs of the
+// assets in its asset group, one nmap argument each. Asset IPs that are not valid targets are skipped.
+func (h *ScanHandler) ScheduleTarget(ctx context.Context, s models.Schedule) ([]string, error) {
+	switch {
+	case s.SavedScanID != nil:
+		if h.SavedScanRepo == nil {
+			return nil, fmt.Errorf("saved scan #%d: saved scans not available", *s.SavedScanID)
+		}
+		saved, err := h.SavedScanRepo.GetByID(ctx, *s.SavedScanID)
+		if err != nil {
+			return nil, fmt.Errorf("load saved scan #%d: %w", *s.SavedScanID, err)
+		}
+		if saved == nil {
+			return nil, unresolvedTarget(fmt.Sprintf("saved scan #%d not found", *s.SavedScanID))
+		}
+		return []string{saved.Target}, nil
+	case s.AssetTag != "":
+		ips, err := h.Repo.IPsByTag(ctx, s.AssetTag)
+		if err != nil {
+			return nil, fmt.Errorf("load asset group %s: %w", s.AssetTag, err)
+		}
+		var targets []string
+		for _, ip := range ips {
+			if validScanTarget(ip) {
+				targets = append(targets, ip)
+			}
+		}
+		if len(targets) == 0 {
+			return nil, unresolvedTarget(fmt.Sprintf("asset group %s has no assets with an IP", s.AssetTag))
+		}
+		return targets, nil
+	}
+	return []string{s.Target}, nil
+}
+
+// scanAuditID returns the audit resource ID of scan job jobID (0 for in-memory jobs).
+func scanAuditID(jobID string) int {
+	id, _ := strconv.Atoi(jobID)
+	return id
+}
+
+// RunningScan returns the running scan of target in ctx's site (the default site when unset), whether a
+// schedule or a user started it.
+func (h *ScanHandler) RunningScan(ctx context.Context, target string) (string, <-chan struct{}, bool) {
+	siteID, ok := repo.SiteID(ctx)
+	if !ok {
+		siteID = models.DefaultSiteID
+	}
+	h.scanJobsMu.Lock()
+	defer h.scanJobsMu.Unlock()
+	for jobID, job := range h.scanJobs {
+		if job.Target != target || job.siteID != siteID {
+			continue
+		}
+		select {
+		case <-job.done:
+		default:
+			return jobID, job.done, true
+		}
+	}
+	return "", nil, false
+}
+
+// CancelJob stops a running scan, killing its nmap process. It returns false when the job is not running.
+func (h *ScanHandler) CancelJob(jobID string) bool {
+	h.scanJobsMu.Lock()
+	job, exists := h.scanJobs[jobID]
+	h.scanJobsMu.Unlock()
+	if !exists {
+		return false
+	}
+	cancelJob(job)
+	return true
+}
+
+func cancelJob(job *ScanJob) {
+	select {
+	case <-job.cancel:
+	default:
+		close(job.cancel)
+		job.stop()
+		job.Status = "canceled"
+	}
+}
+
+// startScan starts a job labelled target that scans targets, the nmap target arguments.
+func (h *ScanHandler) startScan(ctx context.Context, target string, targets []string, scheduleID int, resolveErr error) (string, *ScanJob) {
+	siteID, ok := repo.SiteID(ctx)
+	if !ok {
+		siteID = models.DefaultSiteID
+	}
+	var id int
+	var err error
+	if scheduleID != 0 {
+		id, err = h.ScanJobRepo.CreateForSchedule(repo.WithSite(ctx, siteID), target, scheduleID)
+	} else {
+		id, err = h.ScanJobRepo.Create(repo.WithSite(ctx, siteID), target)
+	}
 	if err != nil {
 		// Fallback to in-memory-only id if DB fails (e.g. table missing)
 		h.scanJobsMu.Lock()
@@ -73,20 +234,27 @@ func (h *ScanHandler) StartScanTarget(ctx context.Context, target string) string
 			h.scanJobs = make(map[string]*ScanJob)
 		}
 		jobID := strconv.Itoa(len(h.scanJobs)+1) + "-mem"
-		job := &ScanJob{Target: target, Status: "running", StartedAt: time.Now(), cancel: make(chan struct{})}
+		job := &ScanJob{Target: target, Status: "running", StartedAt: time.Now(), targets: targets, cancel: make(chan struct{}), done: make(chan struct{}), resolveErr: resolveErr, siteID: siteID, scheduleID: scheduleID}
+		job.ctx, job.stop = context.WithCancel(context.Background())
 		h.scanJobs[jobID] = job
 		h.scanJobsMu.Unlock()
 		metrics.IncScanJobsRunning()
 		go h.runScan(jobID, target, job.cancel)
-		return jobID
+		return jobID, job
 	}
 	jobID := strconv.Itoa(id)
 	job := &ScanJob{
 		Target:    target,
 		Status:    "running",
 		StartedAt: time.Now(),
-		cancel:    make(chan struct{}),
+		targets:    targets,
+		cancel:     make(chan struct{}),
+		done:       make(chan struct{}),
+		resolveErr: resolveErr,
+		siteID:     siteID,
+		scheduleID: scheduleID,
 	}
+	job.ctx, job.stop = context.WithCancel(context.Background())
 	h.scanJobsMu.Lock()
 	if h.scanJobs == nil {
 		h.scanJobs = make(map[string]*ScanJob)
@@ -96,7 +264,7 @@ func (h *ScanHandler) StartScanTarget(ctx context.Context, target string) string
 
 	metrics.IncScanJobsRunning()
 	go h.runScan(jobID, target, job.cancel)
-	return jobID
+	return jobID, job
 }
 
 // ==========================
@@ -143,14 +311,23 @@ func (h *ScanHandler) GetScanStatus(w http.ResponseWriter, r *http.Request) {
 	job, inMem := h.scanJobs[jobID]
 	h.scanJobsMu.Unlock()
 
+	if inMem && !jobInSite(r.Context(), job) {
+		JSONError(w, "scan job not found", http.StatusNotFound)
+		return
+	}
 	if inMem {
+		assets, err := h.visibleAssets(r.Context(), job.Assets)
+		if err != nil {
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
 		// Return in-memory job with id for consistency
 		out := map[string]interface{}{
 			"id":         jobID,
 			"target":    job.Target,
 			"status":    job.Status,
 			"started_at": job.StartedAt,
-			"assets":    job.Assets,
+			"assets":    assets,
 			"error":     job.Error,
 		}
 		if job.CompletedAt != nil {
@@ -171,10 +348,41 @@ func (h *ScanHandler) GetScanStatus(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, "scan job not found", http.StatusNotFound)
 		return
 	}
+	if row.Assets, err = h.visibleAssets(r.Context(), row.Assets); err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(row)
 }
 
+// jobInSite reports whether an in-memory job belongs to a site visible in ctx (see repo.SiteVisible).
+func jobInSite(ctx context.Context, job *ScanJob) bool {
+	return repo.SiteVisible(ctx, job.siteID)
+}
+
+// visibleAssets drops the discovered assets the caller's access policies hide.
+func (h *ScanHandler) visibleAssets(ctx context.Context, assets []models.Asset) ([]models.Asset, error) {
+	if _, restricted := repo.AssetScope(ctx); !restricted || len(assets) == 0 {
+		return assets, nil
+	}
+	ids := make([]int, len(assets))
+	for i, a := range assets {
+		ids[i] = a.ID
+	}
+	visible, err := h.Repo.VisibleIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	out := []models.Asset{}
+	for _, a := range assets {
+		if visible[a.ID] {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
 // ClearScans deletes all scan job records from the DB so the active scans list starts fresh.
 // Running in-memory jobs are not stopped; they will complete and no longer appear after clear.
 func (h *ScanHandler) ClearScans(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +390,7 @@ func (h *ScanHandler) ClearScans(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	logAudit(h.AuditRepo, r, "clear", "scan", 0, nil)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -194,23 +403,24 @@ func (h *ScanHandler) CancelScan(w http.ResponseWriter, r *http.Request) {
 	job, exists := h.scanJobs[jobID]
 	h.scanJobsMu.Unlock()
 
-	if !exists {
+	if !exists || !jobInSite(r.Context(), job) {
 		JSONError(w, "scan job not found or not running", http.StatusNotFound)
 		return
 	}
 
-	select {
-	case <-job.cancel:
-	default:
-		close(job.cancel)
-		job.Status = "canceled"
-	}
+	cancelJob(job)
+	logAudit(h.AuditRepo, r, "cancel", "scan", scanAuditID(jobID), map[string]interface{}{"job_id": jobID, "target": job.Target})
 
+	assets, err := h.visibleAssets(r.Context(), job.Assets)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id": jobID, "target": job.Target, "status": job.Status,
 		"started_at": job.StartedAt, "completed_at": job.CompletedAt,
-		"assets": job.Assets, "error": job.Error,
+		"assets": assets, "error": job.Error,
 	})
 }
 
@@ -218,17 +428,38 @@ func (h *ScanHandler) CancelScan(w http.ResponseWriter, r *http.Request) {
 // Internal Scan Executor (persists result to DB when jobID is numeric).
 // ==========================
 func (h *ScanHandler) runScan(jobID, target string, cancelCh chan struct{}) {
-	ctx := context.Background()
 	h.scanJobsMu.Lock()
 	job := h.scanJobs[jobID]
 	h.scanJobsMu.Unlock()
+	// Discovered IPs are matched within the job's site so overlapping private ranges on other sites never merge.
+	ctx := repo.WithSite(context.Background(), job.siteID)
 
 	defer func() {
+		job.stop()
 		metrics.DecScanJobsRunning()
 		metrics.IncScanJobsTotal(job.Status)
+		close(job.done)
 	}()
 
 	persist := func() {
+		if job.scheduleID != 0 && h.ScheduleRepo != nil {
+			disabled, err := h.ScheduleRepo.RecordResult(ctx, job.scheduleID, job.Status, h.ScheduleMaxFailures)
+			if err != nil {
+				log.Printf("scan: record result of schedule id=%d: %v", job.scheduleID, err)
+			} else if disabled {
+				log.Printf("scan: disabled schedule id=%d after %d consecutive failed runs", job.scheduleID, h.ScheduleMaxFailures)
+				_ = h.ScheduleRepo.Notify(ctx, job.scheduleID)
+				logSystemAudit(ctx, h.AuditRepo, models.AuditSystemScheduler, "disable", "schedule", job.scheduleID,
+					map[string]interface{}{"consecutive_failures": h.ScheduleMaxFailures})
+			}
+		}
+		assetIDs := make([]int, 0, len(job.Assets))
+		for _, a := range job.Assets {
+			assetIDs = append(assetIDs, a.ID)
+		}
+		logSystemAudit(ctx, h.AuditRepo, models.AuditSystemScanner, "finish", "scan", scanAuditID(jobID), map[string]interface{}{
+			"job_id": jobID, "target": target, "status": job.Status, "error": job.Error, "asset_ids": assetIDs,
+		})
 		id, err := strconv.Atoi(jobID)
 		if err != nil {
 			return // fallback in-memory job (e.g. "3-mem"), skip persist
@@ -236,18 +467,38 @@ func (h *ScanHandler) runScan(jobID, target string, cancelCh chan struct{}) {
 		_ = h.ScanJobRepo.Update(ctx, id, job.Status, job.CompletedAt, job.Error, job.Assets)
 	}
 
+	if job.resolveErr != nil {
+		now := time.Now()
+		job.CompletedAt = &now
+		job.Status = "error"
+		job.Error = job.resolveErr.Error()
+		persist()
+		return
+	}
+
 	nmapExe := h.NmapPath
 	if nmapExe == "" {
 		nmapExe = "nmap"
 	}
 	// TCP port scan (-T4 -F): only hosts that respond to TCP are reported, matching a local
 	// "quick scan" and avoiding 260+ false positives from ping sweep (-sn) in Docker/NAT.
-	cmd := exec.Command(nmapExe, "-T4", "-F", "-oX", "-", target)
+	// Each target is its own argument; asset group schedules pass several IPs.
+	args := append([]string{"-T4", "-F", "-oX", "-"}, job.targets...)
+	if h.Traceroute {
+		args = append([]string{"--traceroute"}, args...)
+	}
+	// Canceling the job kills nmap rather than waiting for it to finish.
+	cmd := exec.CommandContext(job.ctx, nmapExe, args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	outputBytes, err := cmd.Output()
 	now := time.Now()
 	job.CompletedAt = &now
+	if err != nil && job.ctx.Err() != nil {
+		job.Status = "canceled"
+		persist()
+		return
+	}
 	if err != nil {
 		job.Status = "error"
 		if se := strings.TrimSpace(stderr.String()); se != "" {
@@ -274,6 +525,24 @@ func (h *ScanHandler) runScan(jobID, target string, cancelCh chan struct{}) {
 					Name string `xml:"name,attr"`
 				} `xml:"hostname"`
 			} `xml:"hostnames"`
+			Ports struct {
+				Ports []struct {
+					Protocol string `xml:"protocol,attr"`
+					PortID   int    `xml:"portid,attr"`
+					State    struct {
+						State string `xml:"state,attr"`
+					} `xml:"state"`
+					Service struct {
+						Name string `xml:"name,attr"`
+					} `xml:"service"`
+				} `xml:"port"`
+			} `xml:"ports"`
+			Trace struct {
+				Hops []struct {
+					TTL    int    `xml:"ttl,attr"`
+					IPAddr string `xml:"ipaddr,attr"`
+				} `xml:"hop"`
+			} `xml:"trace"`
 		} `xml:"host"`
 	}
 
@@ -328,6 +597,9 @@ func (h *ScanHandler) runScan(jobID, target string, cancelCh chan struct{}) {
 		}
 
 		asset, err := h.Repo.UpsertDiscoveredByIP(ctx, ip, hostname, desc)
+		if err == repo.ErrIPExcluded {
+			continue // trashed or recently decommissioned: leave it alone
+		}
 		if err != nil {
 			if job.Error == "" {
 				job.Error = "one or more assets failed to upsert"
@@ -335,6 +607,32 @@ func (h *ScanHandler) runScan(jobID, target string, cancelCh chan struct{}) {
 			continue
 		}
 
+		if h.ServiceRepo != nil {
+			var services []models.AssetService
+			for _, p := range host.Ports.Ports {
+				if strings.ToLower(p.State.State) != "open" {
+					continue
+				}
+				services = append(services, models.AssetService{Port: p.PortID, Protocol: p.Protocol, Service: p.Service.Name})
+			}
+			if err := h.ServiceRepo.ReplaceForAsset(ctx, asset.ID, services); err != nil {
+				log.Printf("scan: record services of asset id=%d: %v", asset.ID, err)
+			}
+		}
+
+		if h.RouteRepo != nil && len(host.Trace.Hops) > 0 {
+			// The last hop is the host itself; keep the routers in front of it.
+			var hops []string
+			for _, hop := range host.Trace.Hops {
+				if hop.IPAddr != "" && hop.IPAddr != ip {
+					hops = append(hops, hop.IPAddr)
+				}
+			}
+			if err := h.RouteRepo.Replace(ctx, asset.ID, hops); err != nil {
+				log.Printf("scan: record route of asset id=%d: %v", asset.ID, err)
+			}
+		}
+
 		// Ensure response includes the IP field (stored in network_name).
 		asset.NetworkName = ip
 		discovered = append(discovered, *asset)
@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
 )
 
@@ -76,6 +82,25 @@ func TestScanHandler_StartScan_BadRequest(t *testing.T) {
 	}
 }
 
+func TestScanHandler_StartScan_RejectsOptions(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &ScanHandler{Repo: repo.NewAssetRepo(db), ScanJobRepo: repo.NewScanJobRepo(db), NmapPath: "/nonexistent/nmap"}
+	for _, target := range []string{"-iL /etc/shadow", "-oN/var/www/x", "10.0.0.1 --script /tmp/x.nse", "10.0.0.1\t-sV"} {
+		body, _ := json.Marshal(map[string]string{"target": target})
+		req := httptest.NewRequest("POST", "/scans", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		h.StartScan(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("StartScan(%q) status: got %d, want 400", target, rr.Code)
+		}
+	}
+}
+
 func TestScanHandler_ListScans(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -272,3 +297,114 @@ func TestScanHandler_CancelScan(t *testing.T) {
 	}
 	time.Sleep(50 * time.Millisecond) // allow runScan to persist
 }
+
+func TestScanHandler_StartScheduledScan_ResolvesTarget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &ScanHandler{Repo: repo.NewAssetRepo(db), ScanJobRepo: repo.NewScanJobRepo(db), SavedScanRepo: repo.NewSavedScanRepo(db), NmapPath: "/nonexistent/nmap"}
+	ctx := repo.WithSite(context.Background(), 2)
+	start := func(sc models.Schedule) <-chan struct{} {
+		targets, err := h.ScheduleTarget(ctx, sc)
+		_, done := h.StartScheduledScan(ctx, sc, targets, err)
+		return done
+	}
+
+	// A saved scan is resolved to its current target.
+	savedScanID := 4
+	mock.ExpectQuery(`FROM saved_scans WHERE id = \$1 AND site_id = \$2`).
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "target", "site_id", "created_at"}).AddRow(4, "lab", "10.0.5.0/24", 2, time.Now()))
+	mock.ExpectQuery(`INSERT INTO scan_jobs`).
+		WithArgs("10.0.5.0/24", 7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE scan_jobs SET status`).
+		WithArgs("error", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	<-start(models.Schedule{ID: 7, SiteID: 2, SavedScanID: &savedScanID})
+
+	// An asset group without IPs fails the run without scanning.
+	mock.ExpectQuery(`SELECT DISTINCT network_name FROM assets WHERE`).
+		WithArgs("web", models.LifecycleDecommissioned, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"network_name"}))
+	mock.ExpectQuery(`INSERT INTO scan_jobs`).
+		WithArgs("asset group web", 8, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec(`UPDATE scan_jobs SET status`).
+		WithArgs("error", sqlmock.AnyArg(), "asset group web has no assets with an IP", sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	<-start(models.Schedule{ID: 8, SiteID: 2, AssetTag: "web"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScanHandler_ScheduleTarget_AssetGroup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &ScanHandler{Repo: repo.NewAssetRepo(db)}
+	mock.ExpectQuery(`SELECT DISTINCT network_name FROM assets WHERE`).
+		WithArgs("web", models.LifecycleDecommissioned).
+		WillReturnRows(sqlmock.NewRows([]string{"network_name"}).AddRow("10.0.0.1").AddRow("-iL/etc/shadow").AddRow("10.0.0.2"))
+	targets, err := h.ScheduleTarget(context.Background(), models.Schedule{AssetTag: "web"})
+	if err != nil {
+		t.Fatalf("ScheduleTarget: %v", err)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("ScheduleTarget: got %q, want %q", targets, want)
+	}
+}
+
+func TestScanHandler_CancelJob_KillsNmap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as nmap")
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// A stand-in for nmap that never finishes on its own.
+	nmap := filepath.Join(t.TempDir(), "nmap")
+	if err := os.WriteFile(nmap, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(`INSERT INTO scan_jobs .* RETURNING id`).
+		WithArgs("10.0.0.0/24", models.DefaultSiteID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE scan_jobs SET status .* WHERE id`).
+		WithArgs("canceled", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	h := &ScanHandler{Repo: repo.NewAssetRepo(db), ScanJobRepo: repo.NewScanJobRepo(db), NmapPath: nmap}
+	h.StartScanTarget(context.Background(), "10.0.0.0/24")
+	jobID, done, ok := h.RunningScan(context.Background(), "10.0.0.0/24")
+	if !ok || jobID != "1" {
+		t.Fatalf("RunningScan: got %q %v, want job 1", jobID, ok)
+	}
+	if _, _, ok := h.RunningScan(repo.WithSite(context.Background(), 2), "10.0.0.0/24"); ok {
+		t.Error("RunningScan: found a scan of another site")
+	}
+
+	h.CancelJob(jobID)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan still running after CancelJob")
+	}
+	if _, _, ok := h.RunningScan(context.Background(), "10.0.0.0/24"); ok {
+		t.Error("RunningScan: found a canceled scan")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
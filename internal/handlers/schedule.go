@@ -1,17 +1,81 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/crucial707/hci-asset/internal/scheduler"
 	"github.com/go-chi/chi/v5"
 )
 
 // ScheduleHandler handles scan schedule CRUD.
 type ScheduleHandler struct {
 	Repo *repo.ScheduleRepo
+	// Runs lists the scan jobs started by a schedule.
+	Runs *repo.ScanJobRepo
+	// Scheduler runs the schedules; used for the scheduler status.
+	Scheduler *scheduler.Scheduler
+	// SavedScans checks the saved scans schedules reference.
+	SavedScans *repo.SavedScanRepo
+	// Runner starts the runs requested with RunSchedule (handlers.ScanHandler).
+	Runner    scheduler.Runner
+	AuditRepo *repo.AuditRepo
+}
+
+func (h *ScheduleHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "schedule", id, details)
+}
+
+// auditBefore returns schedule id as it is before a change, when changes are audited.
+func (h *ScheduleHandler) auditBefore(r *http.Request, id int) *models.Schedule {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	s, _ := h.Repo.GetByID(r.Context(), id)
+	return s
+}
+
+// notify tells the scheduler (on whichever instance runs it) to apply the change to schedule id now.
+func (h *ScheduleHandler) notify(r *http.Request, id int) {
+	if err := h.Repo.Notify(r.Context(), id); err != nil {
+		log.Printf("schedule id=%d change notification: %v", id, err)
+	}
+}
+
+// setNextRun fills in when an enabled schedule fires next.
+func setNextRun(s *models.Schedule, now time.Time) {
+	if !s.Enabled {
+		return
+	}
+	if next, err := scheduler.NextRun(*s, now); err == nil {
+		s.NextRunAt = &next
+	}
+}
+
+// SchedulerStatus returns the instance currently running schedules (the lease holder) and the registered entries.
+func (h *ScheduleHandler) SchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Scheduler == nil {
+		JSONError(w, "scheduler not running", http.StatusServiceUnavailable)
+		return
+	}
+	st, err := h.Scheduler.Status(r.Context())
+	if err != nil {
+		log.Printf("SchedulerStatus: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
 }
 
 // ListSchedules returns paginated schedules (query: limit, offset).
@@ -39,6 +103,10 @@ func (h *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request)
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	now := time.Now()
+	for i := range list {
+		setNextRun(&list[i], now)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -67,51 +135,222 @@ func (h *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, "schedule not found", http.StatusNotFound)
 		return
 	}
+	setNextRun(s, time.Now())
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s)
 }
 
-// CreateSchedule creates a new schedule. Body: {"target": "...", "cron_expr": "0 * * * *", "enabled": true}.
-func (h *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Target   string `json:"target"`
-		CronExpr string `json:"cron_expr"`
-		Enabled  *bool  `json:"enabled"`
+// ListRuns returns the scans started by a schedule, most recent first (query: limit, offset).
+func (h *ScheduleHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid schedule id", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	offset := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if n, err := strconv.Atoi(o); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+
+	s, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		JSONError(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+	runs, err := h.Runs.ListForSchedule(r.Context(), id, limit, offset)
+	if err != nil {
+		log.Printf("ListRuns: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	total, err := h.Runs.CountForSchedule(r.Context(), id)
+	if err != nil {
+		log.Printf("ListRuns count: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":  runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// scheduleInput is the body of CreateSchedule and UpdateSchedule.
+type scheduleInput struct {
+	Target          string                  `json:"target"`
+	SavedScanID     *int                    `json:"saved_scan_id"`
+	AssetTag        string                  `json:"asset_tag"`
+	CronExpr        string                  `json:"cron_expr"`
+	Enabled         *bool                   `json:"enabled"`
+	Timezone        string                  `json:"timezone"`
+	BlackoutWindows []models.BlackoutWindow `json:"blackout_windows"`
+	BlackoutAction  string                  `json:"blackout_action"`
+	JitterSeconds   int                     `json:"jitter_seconds"`
+	OverlapPolicy   string                  `json:"overlap_policy"`
+}
+
+// schedule validates the input, adding errors to fields, and returns the schedule it describes. It scans
+// exactly one of target, saved_scan_id and asset_tag. Enabled, blackout_action and overlap_policy default to
+// true, skip and skip.
+func (in scheduleInput) schedule(fields map[string]string) models.Schedule {
+	s := models.Schedule{
+		Target:          strings.TrimSpace(in.Target),
+		SavedScanID:     in.SavedScanID,
+		AssetTag:        strings.TrimSpace(in.AssetTag),
+		CronExpr:        strings.TrimSpace(in.CronExpr),
+		Enabled:         true,
+		Timezone:        strings.TrimSpace(in.Timezone),
+		BlackoutWindows: in.BlackoutWindows,
+		BlackoutAction:  in.BlackoutAction,
+		JitterSeconds:   in.JitterSeconds,
+		OverlapPolicy:   in.OverlapPolicy,
+	}
+	if in.Enabled != nil {
+		s.Enabled = *in.Enabled
 	}
+	if s.BlackoutWindows == nil {
+		s.BlackoutWindows = []models.BlackoutWindow{}
+	}
+	if s.BlackoutAction == "" {
+		s.BlackoutAction = models.BlackoutSkip
+	}
+	if s.OverlapPolicy == "" {
+		s.OverlapPolicy = models.OverlapSkip
+	}
+
+	sources := 0
+	for _, set := range []bool{s.Target != "", s.SavedScanID != nil, s.AssetTag != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		fields["target"] = "required (or saved_scan_id or asset_tag)"
+	case sources > 1:
+		fields["target"] = "set only one of target, saved_scan_id and asset_tag"
+	case s.Target != "" && !validScanTarget(s.Target):
+		fields["target"] = errInvalidScanTarget
+	}
+	if len(s.AssetTag) > 255 {
+		fields["asset_tag"] = "too long"
+	}
+	if s.Timezone != "" {
+		// "Local" would depend on the server's zone, which is what an empty timezone already means.
+		if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "Local" {
+			fields["timezone"] = "unknown time zone"
+		}
+	}
+	if s.CronExpr == "" {
+		fields["cron_expr"] = "required"
+	} else if _, ok := fields["timezone"]; !ok {
+		if _, err := scheduler.NextRun(s, time.Now()); err != nil {
+			fields["cron_expr"] = "invalid cron expression"
+		}
+	}
+	for i, w := range s.BlackoutWindows {
+		if err := w.Validate(); err != nil {
+			fields["blackout_windows"] = fmt.Sprintf("window %d: %v", i+1, err)
+			break
+		}
+	}
+	if s.BlackoutAction != models.BlackoutSkip && s.BlackoutAction != models.BlackoutDefer {
+		fields["blackout_action"] = "must be one of: " + models.BlackoutSkip + ", " + models.BlackoutDefer
+	}
+	if s.JitterSeconds < 0 || s.JitterSeconds > models.MaxJitterSeconds {
+		fields["jitter_seconds"] = fmt.Sprintf("must be between 0 and %d", models.MaxJitterSeconds)
+	}
+	validOverlap := false
+	for _, p := range models.OverlapPolicies {
+		if s.OverlapPolicy == p {
+			validOverlap = true
+		}
+	}
+	if !validOverlap {
+		fields["overlap_policy"] = "must be one of: " + strings.Join(models.OverlapPolicies, ", ")
+	}
+	return s
+}
+
+// savedScanSite checks the saved scan sc references in ctx's site, adding an error to fields if it does not
+// exist, and returns the saved scan's site (0 when sc references none or fields already has errors).
+func (h *ScheduleHandler) savedScanSite(ctx context.Context, sc models.Schedule, fields map[string]string) (int, error) {
+	if sc.SavedScanID == nil || h.SavedScans == nil || len(fields) > 0 {
+		return 0, nil
+	}
+	saved, err := h.SavedScans.GetByID(ctx, *sc.SavedScanID)
+	if err != nil {
+		return 0, err
+	}
+	if saved == nil {
+		fields["saved_scan_id"] = "saved scan not found"
+		return 0, nil
+	}
+	return saved.SiteID, nil
+}
+
+// CreateSchedule creates a new schedule. Body: {"target": "..." (or "saved_scan_id": 3, or "asset_tag": "web"),
+// "cron_expr": "0 * * * *", "enabled": true,
+// "timezone": "Europe/Paris", "blackout_windows": [{"days": ["sat"], "start": "08:00", "end": "18:00"}],
+// "blackout_action": "skip", "jitter_seconds": 60, "overlap_policy": "skip"}; only target and cron_expr are required.
+func (h *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var input scheduleInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		JSONError(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
 	fields := make(map[string]string)
-	if input.Target == "" {
-		fields["target"] = "required"
-	}
-	if input.CronExpr == "" {
-		fields["cron_expr"] = "required"
+	sc := input.schedule(fields)
+	ctx := r.Context()
+	siteID, err := h.savedScanSite(ctx, sc, fields)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
-
-	enabled := true
-	if input.Enabled != nil {
-		enabled = *input.Enabled
+	// Without a site selected, a schedule running a saved scan belongs to the saved scan's site.
+	if _, ok := repo.SiteID(ctx); !ok && siteID != 0 {
+		ctx = repo.WithSite(ctx, siteID)
+	}
+	if !requireSite(ctx, w) {
+		return
 	}
 
-	s, err := h.Repo.Create(r.Context(), input.Target, input.CronExpr, enabled)
+	s, err := h.Repo.Create(ctx, sc)
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	h.notify(r, s.ID)
+	h.audit(r, "create", s.ID, map[string]interface{}{"after": s})
+	setNextRun(s, time.Now())
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(s)
 }
 
-// UpdateSchedule updates a schedule. Body: {"target": "...", "cron_expr": "...", "enabled": true}.
+// UpdateSchedule updates a schedule. Body: as for CreateSchedule; omitted policies reset to their defaults.
 func (h *ScheduleHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
@@ -120,38 +359,46 @@ func (h *ScheduleHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var input struct {
-		Target   string `json:"target"`
-		CronExpr string `json:"cron_expr"`
-		Enabled  *bool  `json:"enabled"`
-	}
+	var input scheduleInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		JSONError(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
 	fields := make(map[string]string)
-	if input.Target == "" {
-		fields["target"] = "required"
-	}
-	if input.CronExpr == "" {
-		fields["cron_expr"] = "required"
+	sc := input.schedule(fields)
+	if sc.SavedScanID != nil && len(fields) == 0 {
+		// The saved scan must be in the schedule's site.
+		existing, err := h.Repo.GetByID(r.Context(), id)
+		if err != nil {
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
+		if existing == nil {
+			JSONError(w, "schedule not found", http.StatusNotFound)
+			return
+		}
+		if _, err := h.savedScanSite(repo.WithSite(r.Context(), existing.SiteID), sc, fields); err != nil {
+			JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+			return
+		}
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
 
-	enabled := true
-	if input.Enabled != nil {
-		enabled = *input.Enabled
-	}
-
-	if err := h.Repo.Update(r.Context(), id, input.Target, input.CronExpr, enabled); err != nil {
+	before := h.auditBefore(r, id)
+	if err := h.Repo.Update(r.Context(), id, sc); err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	h.notify(r, id)
 
 	s, _ := h.Repo.GetByID(r.Context(), id)
+	h.audit(r, "update", id, auditChange(before, s))
+	if s != nil {
+		setNextRun(s, time.Now())
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s)
 }
@@ -165,10 +412,59 @@ func (h *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	before := h.auditBefore(r, id)
 	if err := h.Repo.Delete(r.Context(), id); err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	h.notify(r, id)
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RunSchedule starts a run of a schedule now, even when it is disabled. Blackout windows and the overlap policy
+// do not apply; the run is recorded in the schedule's runs like a scheduled one. The scan runs on the instance
+// serving the request, whether or not it holds the scheduler lease, so it can overlap a scheduled run elsewhere.
+func (h *ScheduleHandler) RunSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid schedule id", http.StatusBadRequest)
+		return
+	}
+	s, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		JSONError(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+	if h.Runner == nil {
+		JSONError(w, "scheduler not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Like scheduled runs, resolve the target in the schedule's site without the caller's asset scope. Unlike
+	// them, a target that does not resolve is reported to the caller instead of recorded as a failed run.
+	ctx := repo.WithSite(context.Background(), s.SiteID)
+	targets, err := h.Runner.ScheduleTarget(ctx, *s)
+	var unresolved unresolvedTarget
+	if errors.As(err, &unresolved) {
+		JSONError(w, unresolved.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("RunSchedule: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+	jobID, _ := h.Runner.StartScheduledScan(ctx, *s, targets, nil)
+	h.audit(r, "run", id, map[string]interface{}{"job_id": jobID})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"job_id": jobID,
+		"status": "running",
+	})
+}
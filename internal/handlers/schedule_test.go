@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/crucial707/hci-asset/internal/scheduler"
 )
 
 func TestScheduleHandler_ListSchedules(t *testing.T) {
@@ -23,8 +27,8 @@ func TestScheduleHandler_ListSchedules(t *testing.T) {
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(50, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM scan_schedules`).
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
@@ -67,7 +71,7 @@ func TestScheduleHandler_ListSchedules_QueryParams(t *testing.T) {
 
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(10, 20).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}))
 	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM scan_schedules`).
 		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 
@@ -96,8 +100,8 @@ func TestScheduleHandler_GetSchedule(t *testing.T) {
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	scheduleRepo := repo.NewScheduleRepo(db)
 	h := &ScheduleHandler{Repo: scheduleRepo}
@@ -185,9 +189,12 @@ func TestScheduleHandler_CreateSchedule(t *testing.T) {
 
 	now := time.Now()
 	mock.ExpectQuery(`INSERT INTO scan_schedules`).
-		WithArgs("192.168.1.0/24", "0 * * * *", true).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WithArgs("192.168.1.0/24", "0 * * * *", true, "", []byte("[]"), "skip", 0, "skip", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs("schedule_changes", "1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	scheduleRepo := repo.NewScheduleRepo(db)
 	h := &ScheduleHandler{Repo: scheduleRepo}
@@ -239,6 +246,52 @@ func TestScheduleHandler_CreateSchedule_BadRequest(t *testing.T) {
 	}
 }
 
+func TestScheduleHandler_CreateSchedule_InvalidPolicies(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &ScheduleHandler{Repo: repo.NewScheduleRepo(db)}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"target":           "192.168.1.0/24",
+		"cron_expr":        "0 * * *",
+		"timezone":         "Mars/Olympus",
+		"blackout_windows": []map[string]interface{}{{"days": []string{"someday"}, "start": "22:00", "end": "06:00"}},
+		"blackout_action":  "later",
+		"jitter_seconds":   7200,
+		"overlap_policy":   "parallel",
+	})
+	req := httptest.NewRequest("POST", "/schedules", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.CreateSchedule(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("CreateSchedule status: got %d, want 400", rr.Code)
+	}
+	var resp struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	for _, f := range []string{"timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy"} {
+		if resp.Fields[f] == "" {
+			t.Errorf("expected a validation error for %s, got %v", f, resp.Fields)
+		}
+	}
+
+	// With a valid zone, the cron expression is checked too.
+	body, _ = json.Marshal(map[string]interface{}{"target": "192.168.1.0/24", "cron_expr": "0 * * *"})
+	rr = httptest.NewRecorder()
+	h.CreateSchedule(rr, httptest.NewRequest("POST", "/schedules", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest || !bytes.Contains(rr.Body.Bytes(), []byte("invalid cron expression")) {
+		t.Errorf("CreateSchedule invalid cron: got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestScheduleHandler_UpdateSchedule(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -247,13 +300,16 @@ func TestScheduleHandler_UpdateSchedule(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectExec(`UPDATE scan_schedules SET target`).
-		WithArgs("10.0.0.0/24", "*/15 * * * *", false, 1).
+		WithArgs("10.0.0.0/24", "*/15 * * * *", false, "", []byte("[]"), "skip", 0, "skip", nil, "", 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs("schedule_changes", "1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "10.0.0.0/24", "*/15 * * * *", false, now))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "10.0.0.0/24", "*/15 * * * *", false, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	scheduleRepo := repo.NewScheduleRepo(db)
 	h := &ScheduleHandler{Repo: scheduleRepo}
@@ -315,6 +371,9 @@ func TestScheduleHandler_DeleteSchedule(t *testing.T) {
 	mock.ExpectExec(`DELETE FROM scan_schedules WHERE id`).
 		WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs("schedule_changes", "1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	scheduleRepo := repo.NewScheduleRepo(db)
 	h := &ScheduleHandler{Repo: scheduleRepo}
@@ -349,3 +408,241 @@ func TestScheduleHandler_DeleteSchedule_InvalidID(t *testing.T) {
 		t.Errorf("DeleteSchedule status: got %d, want 400", rr.Code)
 	}
 }
+
+func TestScheduleHandler_SchedulerStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM leases`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "holder", "acquired_at", "renewed_at", "expires_at"}).
+			AddRow("scheduler", "api-2", now, now, now.Add(30*time.Second)))
+	mock.ExpectQuery(`FROM scan_schedules\s+WHERE enabled = true`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+
+	sched := scheduler.New(repo.NewScheduleRepo(db), repo.NewLeaseRepo(db), &ScanHandler{})
+	h := &ScheduleHandler{Repo: repo.NewScheduleRepo(db), Scheduler: sched}
+
+	rr := httptest.NewRecorder()
+	h.SchedulerStatus(rr, httptest.NewRequest("GET", "/scheduler/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SchedulerStatus status: got %d, want 200", rr.Code)
+	}
+	var body struct {
+		IsLeader bool `json:"is_leader"`
+		Lease    struct {
+			Holder string `json:"holder"`
+		} `json:"lease"`
+		Entries []struct {
+			ScheduleID int `json:"schedule_id"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.IsLeader || body.Lease.Holder != "api-2" || len(body.Entries) != 1 || body.Entries[0].ScheduleID != 1 {
+		t.Errorf("unexpected status: %+v", body)
+	}
+}
+
+func TestScheduleHandler_ListRuns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, now, "error", 1, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	mock.ExpectQuery(`FROM scan_jobs WHERE schedule_id = \$1 ORDER BY id DESC`).
+		WithArgs(1, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "status", "started_at", "completed_at", "error", "skip_reason"}).
+			AddRow(12, "192.168.1.0/24", "error", now, now, "exit status 1", nil).
+			AddRow(9, "192.168.1.0/24", "complete", now.Add(-time.Hour), now.Add(-time.Hour), nil, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM scan_jobs WHERE schedule_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	h := &ScheduleHandler{Repo: repo.NewScheduleRepo(db), Runs: repo.NewScanJobRepo(db)}
+	req := requestWithChiURLParams("GET", "/schedules/1/runs", nil, map[string]string{"id": "1"})
+	rr := httptest.NewRecorder()
+	h.ListRuns(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ListRuns status: got %d, want 200: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Items []struct {
+			ID     int    `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"items"`
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Total != 2 || len(body.Items) != 2 || body.Items[0].ID != 12 || body.Items[0].Error != "exit status 1" {
+		t.Errorf("unexpected runs: %+v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleHandler_CreateSchedule_SavedScan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &ScheduleHandler{Repo: repo.NewScheduleRepo(db), SavedScans: repo.NewSavedScanRepo(db)}
+
+	// Only one source is allowed.
+	body, _ := json.Marshal(map[string]interface{}{"target": "10.0.0.0/24", "saved_scan_id": 4, "cron_expr": "0 * * * *"})
+	rr := httptest.NewRecorder()
+	h.CreateSchedule(rr, httptest.NewRequest("POST", "/schedules", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("CreateSchedule with two sources: got %d, want 400", rr.Code)
+	}
+
+	// Without a site selected, the schedule goes to the saved scan's site.
+	now := time.Now()
+	mock.ExpectQuery(`FROM saved_scans WHERE id = \$1`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "target", "site_id", "created_at"}).AddRow(4, "lab", "10.0.5.0/24", 2, now))
+	mock.ExpectQuery(`INSERT INTO scan_schedules .*site_id`).
+		WithArgs("", "0 * * * *", true, "", []byte("[]"), "skip", 0, "skip", 4, "", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(5, "", "0 * * * *", true, now, 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", 4, ""))
+	mock.ExpectExec(`SELECT pg_notify`).WithArgs("schedule_changes", "5").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	body, _ = json.Marshal(map[string]interface{}{"saved_scan_id": 4, "cron_expr": "0 * * * *"})
+	rr = httptest.NewRecorder()
+	h.CreateSchedule(rr, httptest.NewRequest("POST", "/schedules", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("CreateSchedule status: got %d, want 201: %s", rr.Code, rr.Body.String())
+	}
+	var s struct {
+		SiteID      int  `json:"site_id"`
+		SavedScanID *int `json:"saved_scan_id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&s); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if s.SiteID != 2 || s.SavedScanID == nil || *s.SavedScanID != 4 {
+		t.Errorf("unexpected schedule: %+v", s)
+	}
+
+	// Unknown saved scans are rejected.
+	mock.ExpectQuery(`FROM saved_scans WHERE id = \$1`).WithArgs(9).WillReturnError(sql.ErrNoRows)
+	body, _ = json.Marshal(map[string]interface{}{"saved_scan_id": 9, "cron_expr": "0 * * * *"})
+	rr = httptest.NewRecorder()
+	h.CreateSchedule(rr, httptest.NewRequest("POST", "/schedules", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest || !bytes.Contains(rr.Body.Bytes(), []byte("saved scan not found")) {
+		t.Errorf("CreateSchedule unknown saved scan: got %d %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// fakeRunner records the runs started through a ScheduleHandler.
+type fakeRunner struct {
+	started []models.Schedule
+	sites   []int
+	// targetErr, when set, is returned by ScheduleTarget.
+	targetErr error
+}
+
+func (f *fakeRunner) ScheduleTarget(ctx context.Context, s models.Schedule) ([]string, error) {
+	if f.targetErr != nil {
+		return nil, f.targetErr
+	}
+	return []string{s.Target}, nil
+}
+
+func (f *fakeRunner) StartScheduledScan(ctx context.Context, s models.Schedule, targets []string, resolveErr error) (string, <-chan struct{}) {
+	f.started = append(f.started, s)
+	siteID, _ := repo.SiteID(ctx)
+	f.sites = append(f.sites, siteID)
+	return "42", make(chan struct{})
+}
+
+func (f *fakeRunner) RunningScan(context.Context, string) (string, <-chan struct{}, bool) {
+	return "", nil, false
+}
+
+func (f *fakeRunner) CancelJob(string) bool { return false }
+
+func TestScheduleHandler_RunSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(3, "", "0 * * * *", false, time.Now(), 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "web"))
+	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
+		WithArgs(4).
+		WillReturnError(sql.ErrNoRows)
+
+	runner := &fakeRunner{}
+	h := &ScheduleHandler{Repo: repo.NewScheduleRepo(db), Runner: runner}
+
+	rr := httptest.NewRecorder()
+	h.RunSchedule(rr, requestWithChiURLParams("POST", "/schedules/3/run", nil, map[string]string{"id": "3"}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("RunSchedule status: got %d, want 200: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	// Disabled schedules can be run now; the run belongs to the schedule's site.
+	if out.JobID != "42" || len(runner.started) != 1 || runner.started[0].AssetTag != "web" || runner.sites[0] != 2 {
+		t.Errorf("unexpected run: job %q, started %+v in sites %v", out.JobID, runner.started, runner.sites)
+	}
+
+	rr = httptest.NewRecorder()
+	h.RunSchedule(rr, requestWithChiURLParams("POST", "/schedules/4/run", nil, map[string]string{"id": "4"}))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("RunSchedule unknown schedule: got %d, want 404", rr.Code)
+	}
+
+	// A target that does not resolve is reported instead of started; other errors are internal.
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{unresolvedTarget("asset group web has no assets with an IP"), http.StatusConflict},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	} {
+		mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+				AddRow(3, "", "0 * * * *", true, time.Now(), 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "web"))
+		runner.targetErr = tc.err
+		rr = httptest.NewRecorder()
+		h.RunSchedule(rr, requestWithChiURLParams("POST", "/schedules/3/run", nil, map[string]string{"id": "3"}))
+		if rr.Code != tc.want || len(runner.started) != 1 {
+			t.Errorf("RunSchedule with %v: got %d and %d runs, want %d and 1", tc.err, rr.Code, len(runner.started), tc.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// siteNamePattern matches site names: lower-case letters, digits, '-' and '_', at most 40 characters. Names
+// start with a letter so X-Site can also take a numeric ID.
+var siteNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,39}$`)
+
+// ==========================
+// SiteHandler
+// ==========================
+// SiteHandler manages the sites that partition assets, scans, saved scans and schedules.
+type SiteHandler struct {
+	Repo      *repo.SiteRepo
+	AuditRepo *repo.AuditRepo
+}
+
+func (h *SiteHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "site", id, details)
+}
+
+// auditBefore returns site id as it is before a change, when changes are audited.
+func (h *SiteHandler) auditBefore(r *http.Request, id int) *models.Site {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	v, _ := h.Repo.GetByID(r.Context(), id)
+	return v
+}
+
+// decodeSite reads and validates a site body, writing 400 and returning false if it is invalid.
+func decodeSite(w http.ResponseWriter, r *http.Request) (name, description string, ok bool) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return "", "", false
+	}
+	name = strings.TrimSpace(input.Name)
+	if !siteNamePattern.MatchString(name) {
+		JSONValidationError(w, "validation failed", map[string]string{
+			"name": "must be 1-40 lower-case letters, digits, '-' or '_', starting with a letter",
+		}, http.StatusBadRequest)
+		return "", "", false
+	}
+	return name, strings.TrimSpace(input.Description), true
+}
+
+// writeSiteError maps repository errors to responses.
+func writeSiteError(w http.ResponseWriter, op string, err error) {
+	switch err {
+	case models.ErrSiteNotFound:
+		JSONError(w, "site not found", http.StatusNotFound)
+	case repo.ErrSiteDefault:
+		JSONError(w, err.Error(), http.StatusForbidden)
+	case repo.ErrSiteInUse:
+		JSONError(w, err.Error(), http.StatusConflict)
+	default:
+		if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+			JSONError(w, "site already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("%s: %v", op, err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+	}
+}
+
+// ==========================
+// List Sites (with per-site asset, scan and schedule counts for the dashboard)
+// ==========================
+func (h *SiteHandler) ListSites(w http.ResponseWriter, r *http.Request) {
+	sites, err := h.Repo.List(r.Context())
+	if err != nil {
+		writeSiteError(w, "ListSites", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": sites,
+		"total": len(sites),
+	})
+}
+
+// ==========================
+// Get Site
+// ==========================
+func (h *SiteHandler) GetSite(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid site id", http.StatusBadRequest)
+		return
+	}
+	site, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeSiteError(w, "GetSite", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// ==========================
+// Create Site
+// ==========================
+func (h *SiteHandler) CreateSite(w http.ResponseWriter, r *http.Request) {
+	name, description, ok := decodeSite(w, r)
+	if !ok {
+		return
+	}
+	site, err := h.Repo.Create(r.Context(), name, description)
+	if err != nil {
+		writeSiteError(w, "CreateSite", err)
+		return
+	}
+
+	h.audit(r, "create", site.ID, map[string]interface{}{"after": site})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(site)
+}
+
+// ==========================
+// Update Site
+// ==========================
+func (h *SiteHandler) UpdateSite(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid site id", http.StatusBadRequest)
+		return
+	}
+	name, description, ok := decodeSite(w, r)
+	if !ok {
+		return
+	}
+	before := h.auditBefore(r, id)
+	site, err := h.Repo.Update(r.Context(), id, name, description)
+	if err != nil {
+		writeSiteError(w, "UpdateSite", err)
+		return
+	}
+
+	h.audit(r, "update", id, auditChange(before, site))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// ==========================
+// Delete Site (only empty sites other than the default)
+// ==========================
+func (h *SiteHandler) DeleteSite(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid site id", http.StatusBadRequest)
+		return
+	}
+	before := h.auditBefore(r, id)
+	if err := h.Repo.Delete(r.Context(), id); err != nil {
+		writeSiteError(w, "DeleteSite", err)
+		return
+	}
+
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireSite writes 400 and returns false when ctx selects no site while the caller's access policies allow
+// several, since the new rows would otherwise land in the default site.
+func requireSite(ctx context.Context, w http.ResponseWriter) bool {
+	if _, ok := repo.SiteID(ctx); ok {
+		return true
+	}
+	if _, restricted := repo.AllowedSites(ctx); !restricted {
+		return true
+	}
+	JSONError(w, models.ErrSiteRequired.Error(), http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestSiteHandler_CreateSite(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &SiteHandler{Repo: repo.NewSiteRepo(db)}
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO sites \(name, description\) VALUES \(\$1, \$2\)`).
+		WithArgs("lab-east", "Lab cluster").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "created_at", "updated_at"}).
+			AddRow(2, "lab-east", "Lab cluster", now, now))
+
+	rr := httptest.NewRecorder()
+	h.CreateSite(rr, postJSON("/sites", map[string]string{"name": " lab-east ", "description": "Lab cluster"}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201; body %s", rr.Code, rr.Body.String())
+	}
+
+	// Numeric names would be ambiguous with IDs in X-Site.
+	rr = httptest.NewRecorder()
+	h.CreateSite(rr, postJSON("/sites", map[string]string{"name": "42"}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("numeric name: got %d, want 400", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestSiteHandler_DeleteDefaultSite(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &SiteHandler{Repo: repo.NewSiteRepo(db)}
+
+	rr := httptest.NewRecorder()
+	h.DeleteSite(rr, requestWithChiURLParams("DELETE", "/sites/1", nil, map[string]string{"id": "1"}))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("status: got %d, want 403", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
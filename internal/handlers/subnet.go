@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/ipam"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// ==========================
+// SubnetHandler
+// ==========================
+// SubnetHandler manages the subnets of the selected site, their IP reservations and address allocation.
+type SubnetHandler struct {
+	Repo      *repo.SubnetRepo
+	AuditRepo *repo.AuditRepo
+}
+
+func (h *SubnetHandler) audit(r *http.Request, action string, id int, details map[string]interface{}) {
+	logAudit(h.AuditRepo, r, action, "subnet", id, details)
+}
+
+// auditBefore returns subnet id as it is before a change, when changes are audited.
+func (h *SubnetHandler) auditBefore(r *http.Request, id int) *models.Subnet {
+	if h.AuditRepo == nil {
+		return nil
+	}
+	v, _ := h.Repo.GetByID(r.Context(), id)
+	return v
+}
+
+// decodeSubnet reads and validates a subnet body, writing 400 and returning false if it is invalid.
+func decodeSubnet(w http.ResponseWriter, r *http.Request) (models.Subnet, bool) {
+	var input struct {
+		CIDR        string `json:"cidr"`
+		VLANID      *int   `json:"vlan_id"`
+		Gateway     string `json:"gateway"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return models.Subnet{}, false
+	}
+
+	fields := make(map[string]string)
+	s := models.Subnet{VLANID: input.VLANID, Description: strings.TrimSpace(input.Description)}
+	prefix, err := ipam.ParsePrefix(input.CIDR)
+	if err != nil {
+		fields["cidr"] = "must be a network in CIDR notation, e.g. 10.0.0.0/22"
+	} else {
+		s.CIDR = prefix.String()
+	}
+	if s.VLANID != nil && (*s.VLANID < 1 || *s.VLANID > 4094) {
+		fields["vlan_id"] = "must be between 1 and 4094"
+	}
+	if gw := strings.TrimSpace(input.Gateway); gw != "" {
+		addr, ok := ipam.ParseAddr(gw)
+		switch {
+		case !ok:
+			fields["gateway"] = "must be an IP address"
+		case err == nil && !ipam.IsUsable(prefix, addr):
+			fields["gateway"] = "must be a usable address of the subnet"
+		default:
+			s.Gateway = addr.String()
+		}
+	}
+	if len(fields) > 0 {
+		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
+		return models.Subnet{}, false
+	}
+	return s, true
+}
+
+// writeSubnetError maps repository errors to responses.
+func writeSubnetError(w http.ResponseWriter, op string, err error) {
+	switch err {
+	case models.ErrSubnetNotFound, models.ErrReservationNotFound:
+		JSONError(w, err.Error(), http.StatusNotFound)
+	case models.ErrSubnetFull, repo.ErrIPReserved:
+		JSONError(w, err.Error(), http.StatusConflict)
+	default:
+		if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+			JSONError(w, "subnet already exists in this site", http.StatusConflict)
+			return
+		}
+		log.Printf("%s: %v", op, err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+	}
+}
+
+// subnetParam loads the subnet named by the {id} URL parameter, writing an error and returning false if
+// it is invalid or not in the selected site.
+func (h *SubnetHandler) subnetParam(w http.ResponseWriter, r *http.Request, op string) (*models.Subnet, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid subnet id", http.StatusBadRequest)
+		return nil, false
+	}
+	s, err := h.Repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeSubnetError(w, op, err)
+		return nil, false
+	}
+	return s, true
+}
+
+// ==========================
+// List Subnets (with utilization)
+// ==========================
+func (h *SubnetHandler) ListSubnets(w http.ResponseWriter, r *http.Request) {
+	subnets, err := h.Repo.List(r.Context())
+	if err != nil {
+		writeSubnetError(w, "ListSubnets", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": subnets,
+		"total": len(subnets),
+	})
+}
+
+// ==========================
+// Get Subnet (with utilization)
+// ==========================
+func (h *SubnetHandler) GetSubnet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid subnet id", http.StatusBadRequest)
+		return
+	}
+	s, err := h.Repo.Summary(r.Context(), id)
+	if err != nil {
+		writeSubnetError(w, "GetSubnet", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// ==========================
+// Create Subnet
+// ==========================
+func (h *SubnetHandler) CreateSubnet(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeSubnet(w, r)
+	if !ok || !requireSite(r.Context(), w) {
+		return
+	}
+	s, err := h.Repo.Create(r.Context(), input)
+	if err != nil {
+		writeSubnetError(w, "CreateSubnet", err)
+		return
+	}
+
+	h.audit(r, "create", s.ID, map[string]interface{}{"after": s})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// ==========================
+// Update Subnet
+// ==========================
+func (h *SubnetHandler) UpdateSubnet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid subnet id", http.StatusBadRequest)
+		return
+	}
+	input, ok := decodeSubnet(w, r)
+	if !ok {
+		return
+	}
+	before := h.auditBefore(r, id)
+	s, err := h.Repo.Update(r.Context(), id, input)
+	if err != nil {
+		writeSubnetError(w, "UpdateSubnet", err)
+		return
+	}
+
+	h.audit(r, "update", id, auditChange(before, s))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// ==========================
+// Delete Subnet (and its reservations)
+// ==========================
+func (h *SubnetHandler) DeleteSubnet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid subnet id", http.StatusBadRequest)
+		return
+	}
+	before := h.auditBefore(r, id)
+	if err := h.Repo.Delete(r.Context(), id); err != nil {
+		writeSubnetError(w, "DeleteSubnet", err)
+		return
+	}
+
+	h.audit(r, "delete", id, map[string]interface{}{"before": before})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// List Reservations
+// ==========================
+func (h *SubnetHandler) ListReservations(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.subnetParam(w, r, "ListReservations")
+	if !ok {
+		return
+	}
+	list, err := h.Repo.Reservations(r.Context(), s.ID)
+	if err != nil {
+		writeSubnetError(w, "ListReservations", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": list,
+		"total": len(list),
+	})
+}
+
+// ==========================
+// Reserve IP (a usable address of the subnet)
+// ==========================
+func (h *SubnetHandler) CreateReservation(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.subnetParam(w, r, "CreateReservation")
+	if !ok {
+		return
+	}
+	var input struct {
+		IP          string `json:"ip"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		JSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	addr, ok := ipam.ParseAddr(input.IP)
+	prefix, err := ipam.ParsePrefix(s.CIDR)
+	if !ok || err != nil || !ipam.IsUsable(prefix, addr) {
+		JSONValidationError(w, "validation failed", map[string]string{
+			"ip": "must be a usable address of " + s.CIDR,
+		}, http.StatusBadRequest)
+		return
+	}
+	res, err := h.Repo.Reserve(r.Context(), s.ID, addr.String(), strings.TrimSpace(input.Description))
+	if err != nil {
+		writeSubnetError(w, "CreateReservation", err)
+		return
+	}
+
+	h.audit(r, "reserve", s.ID, map[string]interface{}{"after": res})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ==========================
+// Release IP Reservation
+// ==========================
+func (h *SubnetHandler) DeleteReservation(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.subnetParam(w, r, "DeleteReservation")
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "reservationID"))
+	if err != nil {
+		JSONError(w, "invalid reservation id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.DeleteReservation(r.Context(), s.ID, id); err != nil {
+		writeSubnetError(w, "DeleteReservation", err)
+		return
+	}
+
+	h.audit(r, "release", s.ID, map[string]interface{}{"reservation_id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// Next Free IP (without reserving it)
+// ==========================
+func (h *SubnetHandler) NextFreeIP(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.subnetParam(w, r, "NextFreeIP")
+	if !ok {
+		return
+	}
+	ip, err := h.Repo.NextFree(r.Context(), *s)
+	if err != nil {
+		writeSubnetError(w, "NextFreeIP", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"ip": ip})
+}
+
+// ==========================
+// Allocate IP (reserve the next free address)
+// ==========================
+func (h *SubnetHandler) AllocateIP(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.subnetParam(w, r, "AllocateIP")
+	if !ok {
+		return
+	}
+	var input struct {
+		Description string `json:"description"`
+	}
+	// The body is optional.
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			JSONError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := h.Repo.Allocate(r.Context(), *s, strings.TrimSpace(input.Description))
+	if err != nil {
+		writeSubnetError(w, "AllocateIP", err)
+		return
+	}
+
+	h.audit(r, "reserve", s.ID, map[string]interface{}{"after": res})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
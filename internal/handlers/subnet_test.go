@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+func TestSubnetHandler_CreateSubnet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &SubnetHandler{Repo: repo.NewSubnetRepo(db)}
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO subnets \(cidr, vlan_id, gateway, description\) VALUES \(\$1, \$2, \$3, \$4\)`).
+		WithArgs("10.20.0.0/22", 20, "10.20.0.1", "servers").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "cidr", "vlan_id", "gateway", "description", "created_at", "updated_at"}).
+			AddRow(1, 1, "10.20.0.0/22", 20, "10.20.0.1", "servers", now, now))
+
+	rr := httptest.NewRecorder()
+	h.CreateSubnet(rr, postJSON("/subnets", map[string]interface{}{
+		"cidr": "10.20.0.0/22", "vlan_id": 20, "gateway": "10.20.0.1", "description": " servers ",
+	}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201; body %s", rr.Code, rr.Body.String())
+	}
+
+	invalid := []map[string]interface{}{
+		{"cidr": "10.20.1.0/22"},
+		{"cidr": "10.20.0.0/22", "vlan_id": 4095},
+		{"cidr": "10.20.0.0/22", "gateway": "10.20.4.1"},
+		{"cidr": "10.20.0.0/22", "gateway": "10.20.0.0"},
+	}
+	for _, body := range invalid {
+		rr = httptest.NewRecorder()
+		h.CreateSubnet(rr, postJSON("/subnets", body))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%v: got %d, want 400", body, rr.Code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestSubnetHandler_AllocateIP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	h := &SubnetHandler{Repo: repo.NewSubnetRepo(db)}
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT .+ FROM subnets WHERE id = \$1`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "cidr", "vlan_id", "gateway", "description", "created_at", "updated_at"}).
+			AddRow(4, 1, "192.168.5.16/28", nil, "192.168.5.17", "", now, now))
+	mock.ExpectQuery(`SELECT site_id, network_name FROM assets`).
+		WillReturnRows(sqlmock.NewRows([]string{"site_id", "network_name"}).AddRow(1, "192.168.5.18"))
+	mock.ExpectQuery(`SELECT subnet_id, host\(ip\) FROM ip_reservations`).
+		WillReturnRows(sqlmock.NewRows([]string{"subnet_id", "ip"}).AddRow(4, "192.168.5.19"))
+	mock.ExpectQuery(`INSERT INTO ip_reservations \(subnet_id, ip, description\)`).
+		WithArgs(4, "192.168.5.20", "new vm").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip", "created_at"}).AddRow(9, "192.168.5.20", now))
+
+	rr := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"description": "new vm"})
+	h.AllocateIP(rr, requestWithChiURLParams("POST", "/subnets/4/allocate", body, map[string]string{"id": "4"}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201; body %s", rr.Code, rr.Body.String())
+	}
+	var got struct {
+		IP string `json:"ip"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.IP != "192.168.5.20" {
+		t.Errorf("ip: got %q (%v), want 192.168.5.20", got.IP, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/crucial707/hci-asset/internal/auth"
 	"github.com/crucial707/hci-asset/internal/middleware"
 	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
@@ -18,10 +20,14 @@ import (
 type UserHandler struct {
 	Repo      *repo.UserRepo
 	AuditRepo *repo.AuditRepo
+	// Sessions, when set, revokes a user's sessions on role or password change and backs RevokeSessions.
+	Sessions *repo.SessionRepo
+	// PasswordPolicy applies to passwords set by CreateUser and ChangePassword (nil = only bcrypt's 72-byte limit).
+	PasswordPolicy *auth.PasswordPolicy
 }
 
 // ==========================
-// Create User (optional role default viewer; admin requires password)
+// Create User (optional role default viewer; every other role requires a password)
 // ==========================
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -42,11 +48,12 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if role == "" {
 		role = models.RoleViewer
 	}
-	if role != models.RoleViewer && role != models.RoleAdmin {
-		fields["role"] = "must be viewer or admin"
-	}
-	if role == models.RoleAdmin && input.Password == "" {
-		fields["password"] = "required for admin"
+	if role != models.RoleViewer && input.Password == "" {
+		fields["password"] = "required for roles other than viewer"
+	} else if input.Password != "" {
+		if err := h.PasswordPolicy.Check(input.Username, input.Password); err != nil {
+			fields["password"] = err.Error()
+		}
 	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
@@ -55,15 +62,15 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.Repo.Create(r.Context(), input.Username, input.Password, role)
 	if err != nil {
+		if isUnknownRole(err) {
+			JSONValidationError(w, "validation failed", map[string]string{"role": "unknown role"}, http.StatusBadRequest)
+			return
+		}
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "create", "user", user.ID, "")
-		}
-	}
+	logAudit(h.AuditRepo, r, "create", "user", user.ID, map[string]interface{}{"after": user})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
@@ -117,8 +124,12 @@ func (h *UserHandler) Me(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, "user not found", http.StatusNotFound)
 		return
 	}
+	// Permissions lets clients such as the web UI show only what the role allows.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user) // PasswordHash has json:"-" so not exposed
+	json.NewEncoder(w).Encode(struct { // PasswordHash has json:"-" so not exposed
+		*models.User
+		Permissions []string `json:"permissions"`
+	}{user, middleware.GetPermissions(r.Context())})
 }
 
 // ==========================
@@ -166,26 +177,40 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if input.Username == "" {
 		fields["username"] = "required"
 	}
-	if input.Role != "" && input.Role != models.RoleViewer && input.Role != models.RoleAdmin {
-		fields["role"] = "must be viewer or admin"
-	}
 	if len(fields) > 0 {
 		JSONValidationError(w, "validation failed", fields, http.StatusBadRequest)
 		return
 	}
 
+	roleChange := h.Sessions != nil && input.Role != ""
+	var before *models.User
+	if roleChange || h.AuditRepo != nil {
+		before, _ = h.Repo.GetByID(r.Context(), id)
+	}
+	previousRole := ""
+	if roleChange && before != nil {
+		previousRole = before.Role
+	}
+
 	user, err := h.Repo.Update(r.Context(), id, input.Username, input.Role)
 	if err != nil {
+		if isUnknownRole(err) {
+			JSONValidationError(w, "validation failed", map[string]string{"role": "unknown role"}, http.StatusBadRequest)
+			return
+		}
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "update", "user", id, "")
+	// A role change signs the user out everywhere so no session keeps the old privileges.
+	if previousRole != "" && previousRole != user.Role {
+		if err := h.Sessions.RevokeAllForUser(r.Context(), id); err != nil {
+			log.Printf("UpdateUser: revoke sessions for user %d: %v", id, err)
 		}
 	}
 
+	logAudit(h.AuditRepo, r, "update", "user", id, auditChange(before, user))
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -201,16 +226,16 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var before *models.User
+	if h.AuditRepo != nil {
+		before, _ = h.Repo.GetByID(r.Context(), id)
+	}
 	if err := h.Repo.Delete(r.Context(), id); err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
 
-	if h.AuditRepo != nil {
-		if userID, ok := middleware.GetUserID(r.Context()); ok {
-			_ = h.AuditRepo.Log(r.Context(), userID, "delete", "user", id, "")
-		}
-	}
+	logAudit(h.AuditRepo, r, "delete", "user", id, map[string]interface{}{"before": before})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -218,8 +243,8 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 // ==========================
 // Change Password
 // ==========================
-// Viewer: can only change own password; must send current_password and new_password. Target must have a password already.
-// Admin: can change any user's password. When changing own, must send current_password and new_password. When changing another user's, only new_password required.
+// Without users:manage: can only change own password; must send current_password and new_password. Target must have a password already.
+// With users:manage (admins): can change any user's password. When changing own, must send current_password and new_password. When changing another user's, only new_password required.
 func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	targetID, err := strconv.Atoi(idStr)
@@ -233,7 +258,7 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	role, _ := middleware.GetRole(r.Context())
+	canManage := middleware.HasPermission(r.Context(), models.PermissionUsersManage)
 
 	var input struct {
 		CurrentPassword string `json:"current_password"`
@@ -250,13 +275,13 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Viewer: only own account
-	if role != models.RoleAdmin {
+	// Without users:manage: only own account
+	if !canManage {
 		if targetID != currentUserID {
 			JSONError(w, "forbidden", http.StatusForbidden)
 			return
 		}
-		// Viewer changing own: must have current password and new password; account must have a password to change
+		// Changing own: must have current password and new password; account must have a password to change
 		if target.PasswordHash == "" {
 			JSONValidationError(w, "account has no password to change", map[string]string{"current_password": "viewer accounts without a password cannot set one via this endpoint"}, http.StatusBadRequest)
 			return
@@ -277,9 +302,9 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// Admin
+		// users:manage
 		if targetID == currentUserID {
-			// Admin changing own: require current password
+			// Changing own: require current password
 			if target.PasswordHash != "" {
 				if input.CurrentPassword == "" {
 					JSONValidationError(w, "validation failed", map[string]string{"current_password": "required when changing your own password"}, http.StatusBadRequest)
@@ -297,6 +322,11 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := h.PasswordPolicy.Check(target.Username, input.NewPassword); err != nil {
+		JSONValidationError(w, "validation failed", map[string]string{"new_password": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
@@ -306,10 +336,50 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
 		return
 	}
+	if h.Sessions != nil {
+		if err := h.Sessions.RevokeAllForUser(r.Context(), targetID); err != nil {
+			log.Printf("ChangePassword: revoke sessions for user %d: %v", targetID, err)
+		}
+	}
 
-	if h.AuditRepo != nil {
-		_ = h.AuditRepo.Log(r.Context(), currentUserID, "change_password", "user", targetID, "")
+	logAudit(h.AuditRepo, r, "change_password", "user", targetID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ==========================
+// Revoke Sessions (signs a user out everywhere; users:manage can revoke anyone, others only themselves)
+// ==========================
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		JSONError(w, "invalid user id", http.StatusBadRequest)
+		return
 	}
+	currentUserID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		JSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !middleware.HasPermission(r.Context(), models.PermissionUsersManage) && targetID != currentUserID {
+		JSONError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if h.Sessions == nil {
+		JSONError(w, "sessions not enabled", http.StatusNotImplemented)
+		return
+	}
+	if _, err := h.Repo.GetByID(r.Context(), targetID); err != nil {
+		JSONError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Sessions.RevokeAllForUser(r.Context(), targetID); err != nil {
+		log.Printf("RevokeSessions: %v", err)
+		JSONError(w, ErrMessageInternal, http.StatusInternalServerError)
+		return
+	}
+
+	logAudit(h.AuditRepo, r, "revoke_sessions", "user", targetID, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
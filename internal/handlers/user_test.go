@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/auth"
+	"github.com/crucial707/hci-asset/internal/middleware"
 	"github.com/crucial707/hci-asset/internal/repo"
 )
 
@@ -74,6 +77,37 @@ func TestUserHandler_CreateUser_BadRequest(t *testing.T) {
 	}
 }
 
+func TestUserHandler_CreateUser_WeakPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	h := &UserHandler{Repo: repo.NewUserRepo(db), PasswordPolicy: &auth.PasswordPolicy{MinLength: 12}}
+
+	body, _ := json.Marshal(map[string]string{"username": "dana", "password": "short", "role": "admin"})
+	req := httptest.NewRequest("POST", "/users", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.CreateUser(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("CreateUser status: got %d, want 400", rr.Code)
+	}
+	var out struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Fields["password"] == "" {
+		t.Errorf("expected a password field error, got %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
 func TestUserHandler_ListUsers(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -116,6 +150,44 @@ func TestUserHandler_ListUsers(t *testing.T) {
 	}
 }
 
+func TestUserHandler_Me(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, username, password_hash, role`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(4, "dana", nil, "netops"))
+
+	h := &UserHandler{Repo: repo.NewUserRepo(db)}
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, 4)
+	ctx = context.WithValue(ctx, middleware.PermissionsKey, []string{"scans:run", "users:manage"})
+	rr := httptest.NewRecorder()
+	h.Me(rr, req.WithContext(ctx))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Me status: got %d, want 200", rr.Code)
+	}
+	var me struct {
+		Username    string   `json:"username"`
+		Role        string   `json:"role"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&me); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if me.Username != "dana" || me.Role != "netops" || len(me.Permissions) != 2 || me.Permissions[1] != "users:manage" {
+		t.Errorf("unexpected /me: %+v", me)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
 func TestUserHandler_GetUser(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
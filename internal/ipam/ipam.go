@@ -0,0 +1,109 @@
+// Package ipam does the address arithmetic behind subnets: matching IPs to the most specific subnet,
+// counting usable addresses and finding the next free one.
+package ipam
+
+import (
+	"errors"
+	"math"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses a CIDR such as "10.0.0.0/22". The host bits must be zero so a subnet has one spelling.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if p.Masked() != p {
+		return netip.Prefix{}, errors.New("host bits must be zero (use " + p.Masked().String() + ")")
+	}
+	return p, nil
+}
+
+// ParseAddr parses an IP address, ignoring surrounding space and any IPv6 zone. ok is false for anything
+// that is not an IP, such as a host name in an asset's network name.
+func ParseAddr(s string) (addr netip.Addr, ok bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// MostSpecific returns the index of the longest prefix containing addr, or -1 if none does.
+func MostSpecific(prefixes []netip.Prefix, addr netip.Addr) int {
+	best := -1
+	for i, p := range prefixes {
+		if p.Contains(addr) && (best < 0 || p.Bits() > prefixes[best].Bits()) {
+			best = i
+		}
+	}
+	return best
+}
+
+// hostBits returns the number of host bits in p.
+func hostBits(p netip.Prefix) int {
+	return p.Addr().BitLen() - p.Bits()
+}
+
+// Usable returns the first and last assignable address of p. IPv4 subnets larger than /31 exclude the
+// network and broadcast addresses; IPv6 subnets and IPv4 /31 and /32 use every address.
+func Usable(p netip.Prefix) (first, last netip.Addr) {
+	first = p.Masked().Addr()
+	last = lastAddr(p)
+	if p.Addr().Is4() && hostBits(p) >= 2 {
+		first, last = first.Next(), last.Prev()
+	}
+	return first, last
+}
+
+// lastAddr returns the highest address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for bit := p.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Size returns the number of usable addresses in p (see Usable), saturating at math.MaxUint64 for IPv6
+// subnets larger than /64.
+func Size(p netip.Prefix) uint64 {
+	n := hostBits(p)
+	if n >= 64 {
+		return math.MaxUint64
+	}
+	size := uint64(1) << n
+	if p.Addr().Is4() && n >= 2 {
+		size -= 2
+	}
+	return size
+}
+
+// IsUsable reports whether addr is an assignable address of p.
+func IsUsable(p netip.Prefix, addr netip.Addr) bool {
+	first, last := Usable(p)
+	return p.Contains(addr) && addr.Compare(first) >= 0 && addr.Compare(last) <= 0
+}
+
+// NextFree returns the lowest usable address of p that is not in taken. ok is false when p is full.
+func NextFree(p netip.Prefix, taken map[netip.Addr]bool) (addr netip.Addr, ok bool) {
+	first, last := Usable(p)
+	// At most len(taken) addresses can be skipped, so the loop ends quickly even for huge IPv6 subnets.
+	for addr = first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+		if !taken[addr] {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// Percent returns used as a percentage of size, rounded to one decimal.
+func Percent(used, size uint64) float64 {
+	if size == 0 {
+		return 0
+	}
+	return math.Round(float64(used)/float64(size)*1000) / 10
+}
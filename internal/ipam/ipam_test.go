@@ -0,0 +1,91 @@
+package ipam
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	if _, err := ParsePrefix(" 10.0.0.0/22 "); err != nil {
+		t.Errorf("10.0.0.0/22: %v", err)
+	}
+	if _, err := ParsePrefix("10.0.1.5/22"); err == nil {
+		t.Error("10.0.1.5/22: want error for host bits set")
+	}
+	if _, err := ParsePrefix("10.0.0.0"); err == nil {
+		t.Error("10.0.0.0: want error without prefix length")
+	}
+}
+
+func TestMostSpecific(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/22"),
+		netip.MustParsePrefix("10.0.1.0/28"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+	}
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"10.0.1.5", 1},
+		{"10.0.1.17", 0},
+		{"10.0.3.255", 0},
+		{"10.0.4.1", -1},
+		{"192.168.1.9", 2},
+	}
+	for _, tt := range tests {
+		addr, _ := ParseAddr(tt.ip)
+		if got := MostSpecific(prefixes, addr); got != tt.want {
+			t.Errorf("MostSpecific(%s) = %d, want %d", tt.ip, got, tt.want)
+		}
+	}
+	if _, ok := ParseAddr("db01.example.com"); ok {
+		t.Error("ParseAddr(host name): want ok false")
+	}
+}
+
+func TestSizeAndUsable(t *testing.T) {
+	tests := []struct {
+		cidr        string
+		size        uint64
+		first, last string
+	}{
+		{"10.0.0.0/22", 1022, "10.0.0.1", "10.0.3.254"},
+		{"10.0.0.16/28", 14, "10.0.0.17", "10.0.0.30"},
+		{"10.0.0.2/31", 2, "10.0.0.2", "10.0.0.3"},
+		{"10.0.0.7/32", 1, "10.0.0.7", "10.0.0.7"},
+		{"2001:db8::/120", 256, "2001:db8::", "2001:db8::ff"},
+	}
+	for _, tt := range tests {
+		p := netip.MustParsePrefix(tt.cidr)
+		if got := Size(p); got != tt.size {
+			t.Errorf("Size(%s) = %d, want %d", tt.cidr, got, tt.size)
+		}
+		first, last := Usable(p)
+		if first.String() != tt.first || last.String() != tt.last {
+			t.Errorf("Usable(%s) = %s-%s, want %s-%s", tt.cidr, first, last, tt.first, tt.last)
+		}
+	}
+	if got := Size(netip.MustParsePrefix("2001:db8::/48")); got != math.MaxUint64 {
+		t.Errorf("Size(/48) = %d, want saturated", got)
+	}
+}
+
+func TestNextFree(t *testing.T) {
+	p := netip.MustParsePrefix("10.0.0.0/29")
+	taken := map[netip.Addr]bool{
+		netip.MustParseAddr("10.0.0.1"): true,
+		netip.MustParseAddr("10.0.0.2"): true,
+		netip.MustParseAddr("10.0.0.4"): true,
+	}
+	if addr, ok := NextFree(p, taken); !ok || addr.String() != "10.0.0.3" {
+		t.Errorf("NextFree = %s, %v; want 10.0.0.3", addr, ok)
+	}
+	for _, ip := range []string{"10.0.0.3", "10.0.0.5", "10.0.0.6"} {
+		taken[netip.MustParseAddr(ip)] = true
+	}
+	if addr, ok := NextFree(p, taken); ok {
+		t.Errorf("full subnet: got %s, want none", addr)
+	}
+}
@@ -44,6 +44,48 @@ var (
 		},
 		[]string{"status"},
 	)
+
+	// AuditForwardLag is the age in seconds of the oldest audit entry not yet forwarded to the syslog collector
+	// (0 when caught up).
+	AuditForwardLag = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "audit_forward_lag_seconds",
+			Help: "Age of the oldest audit entry waiting to be forwarded, in seconds",
+		},
+	)
+
+	// AuditForwardPending is the number of audit entries waiting in the outbox.
+	AuditForwardPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "audit_forward_pending",
+			Help: "Number of audit entries waiting to be forwarded",
+		},
+	)
+
+	// AuditForwardedTotal counts audit entries delivered to the syslog collector.
+	AuditForwardedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_forwarded_total",
+			Help: "Total number of audit entries forwarded",
+		},
+	)
+
+	// AuditForwardErrorsTotal counts failed attempts to forward audit entries.
+	AuditForwardErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_forward_errors_total",
+			Help: "Total number of failed audit forwarding attempts",
+		},
+	)
+
+	// RetentionDeletedTotal counts rows deleted by retention policies, by data type.
+	RetentionDeletedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "retention_deleted_rows_total",
+			Help: "Total number of rows deleted by retention policies",
+		},
+		[]string{"data_type"},
+	)
 )
 
 var (
@@ -53,7 +95,8 @@ var (
 
 func init() {
 	initOnce.Do(func() {
-		prometheus.MustRegister(RequestDuration, RequestTotal, ScanJobsRunning, ScanJobsTotal)
+		prometheus.MustRegister(RequestDuration, RequestTotal, ScanJobsRunning, ScanJobsTotal,
+			AuditForwardLag, AuditForwardPending, AuditForwardedTotal, AuditForwardErrorsTotal, RetentionDeletedTotal)
 	})
 }
 
@@ -85,3 +128,24 @@ func DecScanJobsRunning() {
 func IncScanJobsTotal(status string) {
 	ScanJobsTotal.WithLabelValues(status).Inc()
 }
+
+// SetAuditForwardBacklog records the audit outbox size and the age of its oldest entry.
+func SetAuditForwardBacklog(pending int, lagSeconds float64) {
+	AuditForwardPending.Set(float64(pending))
+	AuditForwardLag.Set(lagSeconds)
+}
+
+// AddAuditForwarded adds n to the forwarded audit entries counter.
+func AddAuditForwarded(n int) {
+	AuditForwardedTotal.Add(float64(n))
+}
+
+// IncAuditForwardErrors increments the failed audit forwarding attempts counter.
+func IncAuditForwardErrors() {
+	AuditForwardErrorsTotal.Inc()
+}
+
+// AddRetentionDeleted adds n to the rows deleted by the retention policy of dataType.
+func AddRetentionDeleted(dataType string, n int) {
+	RetentionDeletedTotal.WithLabelValues(dataType).Add(float64(n))
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// ClientIPKey holds the client IP of the request (set by RequestClientIP).
+const ClientIPKey key = "client_ip"
+
+// RequestClientIP stores the client IP of every request in its context (see ClientIP), so audit entries and
+// other records can name the source of an action without access to the proxy settings.
+func RequestClientIP(trustProxyHeaders bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ClientIPKey, ClientIP(r, trustProxyHeaders))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetClientIP returns the client IP stored by RequestClientIP. ok is false if not found.
+func GetClientIP(ctx context.Context) (ip string, ok bool) {
+	ip, ok = ctx.Value(ClientIPKey).(string)
+	return ip, ok
+}
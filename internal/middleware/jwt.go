@@ -2,8 +2,11 @@ package middleware
 
 import (
 	"context"
+	"math"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/golang-jwt/jwt/v5"
@@ -13,6 +16,11 @@ type key string
 
 const UserIDKey key = "user_id"
 const RoleKey key = "role"
+const ScopesKey key = "scopes"
+const APIKeyIDKey key = "api_key_id"
+const TokenIDKey key = "jti"
+const TokenExpiresKey key = "token_expires"
+const PermissionsKey key = "permissions"
 
 // GetUserID returns the user ID from the request context (set by JWTMiddleware). ok is false if not found.
 func GetUserID(ctx context.Context) (userID int, ok bool) {
@@ -24,6 +32,25 @@ func GetUserID(ctx context.Context) (userID int, ok bool) {
 	return id, ok
 }
 
+// GetScopes returns the API key scopes from the request context. ok is false for JWT sessions.
+func GetScopes(ctx context.Context) (scopes []string, ok bool) {
+	scopes, ok = ctx.Value(ScopesKey).([]string)
+	return scopes, ok
+}
+
+// GetAPIKeyID returns the ID of the API key that authenticated the request. ok is false for JWT sessions.
+func GetAPIKeyID(ctx context.Context) (id int, ok bool) {
+	id, ok = ctx.Value(APIKeyIDKey).(int)
+	return id, ok
+}
+
+// GetTokenID returns the access token ID (jti) and expiry of a JWT session. ok is false for API keys or tokens without a jti.
+func GetTokenID(ctx context.Context) (jti string, expiresAt time.Time, ok bool) {
+	jti, ok = ctx.Value(TokenIDKey).(string)
+	expiresAt, _ = ctx.Value(TokenExpiresKey).(time.Time)
+	return jti, expiresAt, ok
+}
+
 // GetRole returns the user role from the request context (set by JWTMiddleware). ok is false if not found.
 func GetRole(ctx context.Context) (role string, ok bool) {
 	v := ctx.Value(RoleKey)
@@ -34,52 +61,253 @@ func GetRole(ctx context.Context) (role string, ok bool) {
 	return role, ok
 }
 
+// GetPermissions returns the permissions of the request's role: those resolved by Authenticator.Roles, or the
+// built-in role's defaults when roles are not loaded from the database.
+func GetPermissions(ctx context.Context) []string {
+	if perms, ok := ctx.Value(PermissionsKey).([]string); ok {
+		return perms
+	}
+	role, _ := GetRole(ctx)
+	return models.BuiltinRolePermissions[role]
+}
+
+// HasPermission reports whether the request's role grants perm. API keys also need the matching scope
+// (audit:read is covered by the read scope), so a key never exceeds its account's role.
+func HasPermission(ctx context.Context, perm string) bool {
+	if !containsPermission(GetPermissions(ctx), perm) {
+		return false
+	}
+	if scopes, ok := GetScopes(ctx); ok {
+		return hasScope(scopes, perm) || (perm == models.PermissionAuditRead && hasScope(scopes, models.ScopeRead))
+	}
+	return true
+}
+
+func containsPermission(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm || p == models.PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyVerifier checks a raw API key presented from clientIP (implemented by repo.APIKeyRepo).
+type APIKeyVerifier interface {
+	Verify(ctx context.Context, raw, clientIP string) (*models.APIKeyPrincipal, error)
+}
+
+// SessionValidator checks server-side session state for a JWT (implemented by repo.SessionRepo) and
+// returns the user's current role.
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, userID int, jti string, issuedAt time.Time) (role string, err error)
+}
+
+// PermissionResolver returns the permissions of a role (implemented by repo.RoleRepo).
+type PermissionResolver interface {
+	Permissions(ctx context.Context, role string) ([]string, error)
+}
+
+// RequestScoper narrows what a request may see, e.g. to the assets allowed by access policies (implemented
+// by repo.AccessPolicyRepo).
+type RequestScoper interface {
+	ScopeContext(ctx context.Context, userID int, role string) (context.Context, error)
+}
+
+// Authenticator accepts bearer JWTs and, when APIKeys is set, API keys (tokens starting with
+// models.APIKeyPrefix). Both put the user ID and role in the request context; API keys also set
+// their scopes and key ID so RequirePermission can restrict them.
+type Authenticator struct {
+	Secret  []byte
+	APIKeys APIKeyVerifier
+	// Sessions, when set, rejects revoked tokens (logout, revoke-all, deleted users) and tokens without a jti,
+	// and takes the role from the database instead of the token claims.
+	Sessions SessionValidator
+	// Roles, when set, loads the role's permissions on every request so custom roles and permission
+	// changes take effect immediately. Without it only the built-in roles have permissions.
+	Roles PermissionResolver
+	// AccessPolicies, when set, restricts each request to the assets its user or role may see.
+	AccessPolicies RequestScoper
+	// Sites, when set, limits each request to the site in its X-Site header (see SitePrefix). Unknown sites get
+	// 404 and sites outside the caller's access policies 403.
+	Sites SiteSelector
+	// TrustProxyHeaders uses X-Forwarded-For / X-Real-IP for API key IP allowlists. Only enable behind a proxy that sets them.
+	TrustProxyHeaders bool
+}
+
+// JWTMiddleware authenticates bearer JWTs only. Use Authenticator to also accept API keys.
 func JWTMiddleware(secret []byte) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "missing authorization header", http.StatusUnauthorized)
-				return
-			}
+	return (&Authenticator{Secret: secret}).Middleware
+}
 
-			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+// Middleware returns 401 unless the request carries a valid JWT or API key.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
 
-			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-				return secret, nil
-			})
+		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 
-			if err != nil || !token.Valid {
+		if a.APIKeys != nil && strings.HasPrefix(tokenStr, models.APIKeyPrefix) {
+			a.serveAPIKey(w, r, next, tokenStr)
+			return
+		}
+
+		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+			return a.Secret, nil
+		})
+
+		if err != nil || !token.Valid {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			http.Error(w, "invalid token claims", http.StatusUnauthorized)
+			return
+		}
+		// Typed tokens (e.g. the short-lived MFA challenge token) are signed with the same secret but are not sessions.
+		if typ, _ := claims["typ"].(string); typ != "" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		userIDf, ok := claims["user_id"].(float64)
+		if !ok {
+			http.Error(w, "invalid token claims", http.StatusUnauthorized)
+			return
+		}
+		userID := int(userIDf)
+		role, ok := claims["role"].(string)
+		if !ok {
+			// Tokens issued before role existed: treat as viewer
+			role = models.RoleViewer
+		}
+		jti, _ := claims["jti"].(string)
+
+		if a.Sessions != nil {
+			iat, ok := issuedAt(claims)
+			if jti == "" || !ok {
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
-
-			if claims, ok := token.Claims.(jwt.MapClaims); ok {
-				ctx := r.Context()
-				ctx = context.WithValue(ctx, UserIDKey, int(claims["user_id"].(float64)))
-				if role, ok := claims["role"].(string); ok {
-					ctx = context.WithValue(ctx, RoleKey, role)
-				} else {
-					// Tokens issued before role existed: treat as viewer
-					ctx = context.WithValue(ctx, RoleKey, models.RoleViewer)
-				}
-				next.ServeHTTP(w, r.WithContext(ctx))
-			} else {
-				http.Error(w, "invalid token claims", http.StatusUnauthorized)
+			current, err := a.Sessions.ValidateSession(r.Context(), userID, jti, iat)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
-		})
-	}
-}
+			role = current
+		}
 
-// RequireAdmin returns 403 Forbidden if the request context role is not admin. Use after JWTMiddleware.
-func RequireAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		role, _ := GetRole(r.Context())
-		if role != models.RoleAdmin {
-			http.Error(w, "admin required", http.StatusForbidden)
+		ctx, ok := a.withAccess(r.Context(), userID, role)
+		if !ok {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
-		next.ServeHTTP(w, r)
+		ctx = context.WithValue(ctx, UserIDKey, userID)
+		ctx = context.WithValue(ctx, RoleKey, role)
+		if jti != "" {
+			ctx = context.WithValue(ctx, TokenIDKey, jti)
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				ctx = context.WithValue(ctx, TokenExpiresKey, exp.Time)
+			}
+		}
+		a.serveSite(w, r.WithContext(ctx), next)
 	})
 }
+
+func (a *Authenticator) serveAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, raw string) {
+	p, err := a.APIKeys.Verify(r.Context(), raw, ClientIP(r, a.TrustProxyHeaders))
+	if err != nil {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+	// Read-only endpoints still need the read scope so a write-only key cannot list data.
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !hasScope(p.Scopes, models.ScopeRead) {
+		http.Error(w, "api key lacks scope: "+models.ScopeRead, http.StatusForbidden)
+		return
+	}
+	ctx, ok := a.withAccess(r.Context(), p.UserID, p.Role)
+	if !ok {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	ctx = context.WithValue(ctx, UserIDKey, p.UserID)
+	ctx = context.WithValue(ctx, RoleKey, p.Role)
+	ctx = context.WithValue(ctx, ScopesKey, p.Scopes)
+	ctx = context.WithValue(ctx, APIKeyIDKey, p.KeyID)
+	a.serveSite(w, r.WithContext(ctx), next)
+}
+
+// issuedAt returns the iat claim to the microsecond; jwt's NumericDate rounds it to whole seconds.
+func issuedAt(claims jwt.MapClaims) (time.Time, bool) {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(int64(math.Round(iat * 1e6))), true
+}
+
+// withAccess stores role's permissions in ctx when a.Roles is set and applies a.AccessPolicies. ok is false
+// if either could not be loaded.
+func (a *Authenticator) withAccess(ctx context.Context, userID int, role string) (context.Context, bool) {
+	if a.Roles != nil {
+		perms, err := a.Roles.Permissions(ctx, role)
+		if err != nil {
+			return ctx, false
+		}
+		ctx = context.WithValue(ctx, PermissionsKey, perms)
+	}
+	if a.AccessPolicies != nil {
+		scoped, err := a.AccessPolicies.ScopeContext(ctx, userID, role)
+		if err != nil {
+			return ctx, false
+		}
+		ctx = scoped
+	}
+	return ctx, true
+}
+
+// ClientIP returns the client address for logging: the connection's IP, or the X-Forwarded-For / X-Real-IP
+// value when trustProxyHeaders is set.
+func ClientIP(r *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		return clientIP(r)
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the connection's IP without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == models.ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission returns 403 Forbidden unless the request's role grants perm and, for API keys, the key
+// has the matching scope. Use after Authenticator.Middleware.
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasPermission(r.Context(), perm) {
+				http.Error(w, "permission required: "+perm, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -26,6 +26,11 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush streamed responses).
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // RequestLog logs each request with request_id, method, path, status, duration, and size.
 // Use after RequestID middleware so the ID is available. Uses slog for structured logging.
 func RequestLog(next http.Handler) http.Handler {
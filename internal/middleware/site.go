@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// SiteSelector limits a request to the site named or numbered by ref (implemented by repo.SiteRepo). An empty
+// ref selects no site, so the request spans the sites the caller's access policies allow. Returns
+// models.ErrSiteNotFound or models.ErrSiteNotAllowed for sites the caller cannot use.
+type SiteSelector interface {
+	SelectSite(ctx context.Context, ref string) (context.Context, error)
+}
+
+// serveSite applies the X-Site selection (see Authenticator.Sites) and calls next.
+func (a *Authenticator) serveSite(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if a.Sites == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	ctx, err := a.Sites.SelectSite(r.Context(), strings.TrimSpace(r.Header.Get(models.SiteHeader)))
+	switch {
+	case errors.Is(err, models.ErrSiteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrSiteNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// SitePrefix rewrites prefix/sites/{site}/rest to prefix/rest with the X-Site header set to site, so clients
+// can select a site in the path instead of a header. Use before routing; prefix/sites/{id} itself is left
+// alone.
+func SitePrefix(prefix string) func(http.Handler) http.Handler {
+	sitesPrefix := prefix + "/sites/"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rest, ok := strings.CutPrefix(r.URL.Path, sitesPrefix); ok {
+				if site, path, ok := strings.Cut(rest, "/"); ok && site != "" && path != "" {
+					r2 := r.Clone(r.Context())
+					r2.URL.Path = prefix + "/" + path
+					r2.URL.RawPath = ""
+					r2.Header.Set(models.SiteHeader, site)
+					r = r2
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// ReadOnlyToken accepts a static bearer token (e.g. for Prometheus service discovery) and otherwise defers to
+// a.Middleware. Requests authenticated by the static token run with no user ID as the viewer role: the viewer
+// role's access policies restrict them and the X-Site header selects the site, as for a viewer account. It must
+// only be mounted on read-only routes. An empty token disables it.
+func (a *Authenticator) ReadOnlyToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		viaFallback := a.Middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+					ctx, ok := a.withAccess(r.Context(), 0, models.RoleViewer)
+					if !ok {
+						http.Error(w, "internal server error", http.StatusInternalServerError)
+						return
+					}
+					ctx = context.WithValue(ctx, RoleKey, models.RoleViewer)
+					a.serveSite(w, r.WithContext(ctx), next)
+					return
+				}
+			}
+			viaFallback.ServeHTTP(w, r)
+		})
+	}
+}
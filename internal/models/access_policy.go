@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// AccessPolicy restricts one user (UserID) or every user of a role (Role) to assets carrying at least one
+// of Tags and, when SiteID is set, to that site. A user matched by several policies sees the union of their
+// tags and sites; a user matched by none sees every asset. A policy without tags must name a site and allows
+// every asset in it.
+type AccessPolicy struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	UserID      *int      `json:"user_id,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Tags        []string  `json:"tags"`
+	SiteID      *int      `json:"site_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
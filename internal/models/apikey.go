@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// APIKeyPrefix starts every raw API key (hci_<prefix>_<secret>), so keys are easy to tell apart
+// from JWTs and to spot in config files and secret scanners.
+const APIKeyPrefix = "hci_"
+
+// API key scopes. ScopeRead allows every read (GET) endpoint; the others allow mutating one resource type.
+// ScopeAll grants everything the owning account's role allows.
+const (
+	ScopeAll             = "*"
+	ScopeRead            = "read"
+	ScopeAssetsWrite     = "assets:write"
+	ScopeScansRun        = "scans:run"
+	ScopeSchedulesWrite  = "schedules:write"
+	ScopeUsersManage     = "users:manage"
+	ScopeSitesManage     = "sites:manage"
+	ScopeIPAMWrite       = "ipam:write"
+	ScopeRetentionManage = "retention:manage"
+)
+
+// APIKeyScopes lists the scopes accepted when creating an API key.
+var APIKeyScopes = []string{ScopeAll, ScopeRead, ScopeAssetsWrite, ScopeScansRun, ScopeSchedulesWrite, ScopeUsersManage, ScopeSitesManage, ScopeIPAMWrite, ScopeRetentionManage}
+
+// APIKey is a long-lived credential owned by a user or service account. The secret is only
+// returned once at creation; the database stores its SHA-256 hash.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username,omitempty"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyPrincipal is the identity behind a verified API key.
+type APIKeyPrincipal struct {
+	KeyID    int
+	UserID   int
+	Username string
+	Role     string
+	Scopes   []string
+}
+
+// ServiceAccount is a non-human user that authenticates only with API keys.
+type ServiceAccount struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
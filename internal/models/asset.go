@@ -1,6 +1,45 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// Asset lifecycle states. New and discovered assets are active; decommissioned assets keep their history but
+// their IP is not rediscovered for a grace period.
+const (
+	LifecyclePlanned        = "planned"
+	LifecycleActive         = "active"
+	LifecycleMaintenance    = "maintenance"
+	LifecycleRetired        = "retired"
+	LifecycleDecommissioned = "decommissioned"
+)
+
+// LifecycleStates lists the accepted lifecycle states.
+var LifecycleStates = []string{LifecyclePlanned, LifecycleActive, LifecycleMaintenance, LifecycleRetired, LifecycleDecommissioned}
+
+// ErrAssetDecommissioned is returned when a decommissioned asset is decommissioned again.
+var ErrAssetDecommissioned = errors.New("asset already decommissioned")
+
+// Asset statuses derived from last_seen: online when seen within the offline threshold (see
+// config.AssetOfflineAfter), offline when seen before it, unknown when never seen.
+const (
+	AssetOnline        = "online"
+	AssetOffline       = "offline"
+	AssetStatusUnknown = "unknown"
+)
+
+// AssetStatus returns the status of an asset last seen at lastSeen, as of now.
+func AssetStatus(lastSeen *time.Time, offlineAfter time.Duration, now time.Time) string {
+	switch {
+	case lastSeen == nil:
+		return AssetStatusUnknown
+	case now.Sub(*lastSeen) > offlineAfter:
+		return AssetOffline
+	default:
+		return AssetOnline
+	}
+}
 
 type Asset struct {
 	ID          int        `json:"id"`
@@ -8,6 +47,33 @@ type Asset struct {
 	Description string     `json:"description"`
 	Tags        []string   `json:"tags,omitempty"`
 	NetworkName string     `json:"network_name,omitempty"`
+	Lifecycle   string     `json:"lifecycle"`
+	Owner       string     `json:"owner,omitempty"`
 	CreatedAt   time.Time  `json:"created_at,omitempty"`
 	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	// DeletedAt is set while the asset is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// IPExcludedUntil is set on decommissioned assets: scans do not recreate the IP before then.
+	IPExcludedUntil *time.Time `json:"ip_excluded_until,omitempty"`
+}
+
+// AssetLifecycleEvent is a lifecycle change of an asset. UserID is nil for changes made by the system or by a
+// since-deleted user.
+type AssetLifecycleEvent struct {
+	ID        int       `json:"id"`
+	AssetID   int       `json:"asset_id"`
+	FromState string    `json:"from_state"`
+	ToState   string    `json:"to_state"`
+	Reason    string    `json:"reason,omitempty"`
+	UserID    *int      `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AssetService is an open port discovered on an asset by a scan.
+type AssetService struct {
+	AssetID  int       `json:"asset_id"`
+	Port     int       `json:"port"`
+	Protocol string    `json:"protocol"`
+	Service  string    `json:"service,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
 }
@@ -1,14 +1,111 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actor types: entries are logged by a user or by a system component (see AuditEntry.Actor).
+const (
+	AuditActorUser   = "user"
+	AuditActorSystem = "system"
+)
+
+// System components recorded as the actor of system audit entries.
+const (
+	AuditSystemScheduler = "scheduler" // scheduled scans and schedules disabled after failing
+	AuditSystemScanner   = "scanner"   // scan results (discovered assets)
+	AuditSystemRetention = "retention" // rows deleted by retention policies
+)
 
 // AuditEntry represents one audit log row.
 type AuditEntry struct {
-	ID           int       `json:"id"`
-	UserID       int       `json:"user_id"`
-	Action       string    `json:"action"`        // create, update, delete
-	ResourceType string    `json:"resource_type"` // asset, user
-	ResourceID   int       `json:"resource_id"`
-	Details      string    `json:"details,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int    `json:"id"`
+	UserID       int    `json:"user_id"`       // 0 for system actors
+	Action       string `json:"action"`        // create, update, delete, ...
+	ResourceType string `json:"resource_type"` // asset, user, scan, schedule, ...
+	ResourceID   int    `json:"resource_id"`
+	// ActorType is AuditActorUser or AuditActorSystem. Actor is the user's username when the entry was logged,
+	// or the system component (e.g. "scheduler").
+	ActorType string `json:"actor_type"`
+	Actor     string `json:"actor"`
+	// IP, UserAgent and RequestID identify the API request that caused the entry ("" for system actors).
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Details is a JSON object (e.g. {"before": {...}, "after": {...}}), or plain text for old entries.
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// PrevHash is the Hash of the previous entry ("" for the first one) and Hash is ChainHash, both set by the
+	// database when the entry is written. HashVersion is the ChainHash format (1 for entries logged before
+	// actors were recorded).
+	PrevHash    string `json:"prev_hash"`
+	Hash        string `json:"hash"`
+	HashVersion int    `json:"-"`
+}
+
+// ChainHash returns the hex SHA-256 of e's PrevHash and contents, as computed by the audit_log_hash and
+// audit_log_hash_v2 database functions. It differs from Hash when the entry was changed after it was written.
+func (e AuditEntry) ChainHash() string {
+	created := e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z")
+	var fields []string
+	if e.HashVersion < 2 {
+		fields = []string{e.PrevHash, strconv.Itoa(e.ID), created, strconv.Itoa(e.UserID), e.Action, e.ResourceType,
+			strconv.Itoa(e.ResourceID), e.Details}
+	} else {
+		userID := ""
+		if e.UserID != 0 {
+			userID = strconv.Itoa(e.UserID)
+		}
+		fields = []string{e.PrevHash, "v2", strconv.Itoa(e.ID), created, userID, e.Action, e.ResourceType,
+			strconv.Itoa(e.ResourceID), e.ActorType, e.Actor, e.IP, e.UserAgent, e.RequestID, e.Details}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilter narrows an audit log listing or export. Zero values match everything.
+type AuditFilter struct {
+	UserID       int
+	Actor        string // username at the time, or system component (case-insensitive)
+	ActorType    string // AuditActorUser or AuditActorSystem
+	Action       string
+	ResourceType string
+	ResourceID   int
+	Since        time.Time
+	Until        time.Time
+	// Query matches entries whose details contain it (case-insensitive).
+	Query string
+}
+
+// AuditVerification is the result of walking the audit hash chain.
+type AuditVerification struct {
+	Valid bool `json:"valid"`
+	// Checked is the number of entries verified (up to the broken link, if any).
+	Checked int `json:"checked"`
+	// FirstID is the oldest entry. When older entries were pruned, its PrevHash is trusted as the chain start.
+	FirstID  int    `json:"first_id,omitempty"`
+	LastID   int    `json:"last_id,omitempty"`
+	LastHash string `json:"last_hash,omitempty"`
+	// Checkpoints is the number of signed checkpoints compared with the chain.
+	Checkpoints int              `json:"checkpoints"`
+	BrokenLink  *AuditBrokenLink `json:"broken_link,omitempty"`
+}
+
+// AuditBrokenLink is the first entry at which the audit hash chain no longer verifies.
+type AuditBrokenLink struct {
+	ID     int    `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// AuditCheckpoint records the head of the audit hash chain at a point in time. Checkpoints are appended to a
+// file outside the database and signed, so a rewrite of the whole chain no longer matches them.
+type AuditCheckpoint struct {
+	ID        int       `json:"id"`   // last entry covered
+	Hash      string    `json:"hash"` // its hash
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"` // hex HMAC-SHA256 of the fields above
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Lease is held by one API instance at a time to run a background job such as the scan scheduler.
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SchedulerEntry is a schedule registered with the cron runner and its next and previous run times.
+type SchedulerEntry struct {
+	ScheduleID int        `json:"schedule_id"`
+	SiteID     int        `json:"site_id"`
+	Target     string     `json:"target"`
+	CronExpr   string     `json:"cron_expr"`
+	Next       *time.Time `json:"next,omitempty"`
+	Prev       *time.Time `json:"prev,omitempty"`
+}
+
+// SchedulerStatus reports which instance runs the scan schedules and what is registered.
+type SchedulerStatus struct {
+	// Instance is the ID of the API instance answering; IsLeader is true when it runs the schedules.
+	Instance string `json:"instance"`
+	IsLeader bool   `json:"is_leader"`
+	// Lease is the current leader's lease (nil when no instance holds one).
+	Lease   *Lease           `json:"lease"`
+	Entries []SchedulerEntry `json:"entries"`
+}
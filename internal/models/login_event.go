@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Login methods recorded in login_events.
+const (
+	LoginMethodPassword = "password"
+	LoginMethodMFA      = "mfa"
+	LoginMethodOIDC     = "oidc"
+)
+
+// Login results recorded in login_events.
+const (
+	LoginResultSuccess      = "success"
+	LoginResultFailure      = "failure"       // wrong credentials or unknown user
+	LoginResultLocked       = "locked"        // refused because the account is locked out
+	LoginResultMFAChallenge = "mfa_challenge" // password accepted, second factor pending
+	LoginResultMFAFailure   = "mfa_failure"   // wrong TOTP or recovery code
+)
+
+// LoginEvent is one login attempt.
+type LoginEvent struct {
+	ID        int64     `json:"id"`
+	UserID    *int      `json:"user_id,omitempty"`
+	Username  string    `json:"username"`
+	Method    string    `json:"method"`
+	Result    string    `json:"result"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginEventFilter narrows a login event listing. Zero values match everything.
+type LoginEventFilter struct {
+	Username string
+	UserID   int
+	Result   string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserMFA is a user's TOTP enrollment. Secret and LastUsedStep never leave the API.
+type UserMFA struct {
+	UserID                 int        `json:"user_id"`
+	Secret                 string     `json:"-"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	LastUsedStep           int64      `json:"-"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// Enabled reports whether enrollment was confirmed with a valid code.
+func (m *UserMFA) Enabled() bool { return m != nil && m.EnabledAt != nil }
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Relationship types. An asset runs_on the asset hosting it (a VM on its Proxmox node), depends_on the
+// assets it needs (an application on its database host), backs_up the asset it holds backups or a replica of,
+// and is a member_of a cluster or pool.
+const (
+	RelationshipRunsOn    = "runs_on"
+	RelationshipDependsOn = "depends_on"
+	RelationshipBacksUp   = "backs_up"
+	RelationshipMemberOf  = "member_of"
+)
+
+// RelationshipTypes lists the accepted relationship types.
+var RelationshipTypes = []string{RelationshipRunsOn, RelationshipDependsOn, RelationshipBacksUp, RelationshipMemberOf}
+
+// ErrRelationshipNotFound is returned when a relationship does not exist for the given asset.
+var ErrRelationshipNotFound = errors.New("relationship not found")
+
+// ImpactedAsset is an asset affected when another one goes down. Depth is the number of relationships
+// between them (1 for direct dependants).
+type ImpactedAsset struct {
+	Asset
+	Depth  int    `json:"depth"`
+	Status string `json:"status"`
+}
+
+// OutageImpact is an offline asset and the number of assets it impacts.
+type OutageImpact struct {
+	Asset
+	Status   string `json:"status"`
+	Impacted int    `json:"impacted"`
+}
+
+// AssetRelationship is a typed link from AssetID to RelatedAssetID, read as "asset <type> related asset".
+type AssetRelationship struct {
+	ID             int       `json:"id"`
+	AssetID        int       `json:"asset_id"`
+	RelatedAssetID int       `json:"related_asset_id"`
+	Type           string    `json:"type"`
+	Description    string    `json:"description"`
+	CreatedAt      time.Time `json:"created_at"`
+}
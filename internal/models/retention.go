@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Data types with a retention policy.
+const (
+	RetentionScans       = "scans"        // finished scan jobs, by start time
+	RetentionAudit       = "audit"        // audit log entries, oldest first
+	RetentionLoginEvents = "login_events" // login attempts
+	RetentionLifecycle   = "lifecycle"    // asset lifecycle history
+	RetentionTrash       = "trash"        // assets in the trash, by deletion time
+)
+
+// RetentionPolicy deletes rows of DataType older than MaxAgeDays (0 keeps them forever). For scans, the newest
+// Keep jobs of each target are kept regardless of age.
+type RetentionPolicy struct {
+	DataType   string `json:"data_type"`
+	MaxAgeDays int    `json:"max_age_days"`
+	Keep       int    `json:"keep,omitempty"`
+}
+
+// RetentionResult reports what a policy deleted, or in a dry run what it would delete.
+type RetentionResult struct {
+	RetentionPolicy
+	Cutoff time.Time `json:"cutoff"`
+	// Rows deleted, or due for deletion in a dry run.
+	Rows int `json:"rows"`
+	// Oldest is when the oldest row due was written (dry run only).
+	Oldest  *time.Time `json:"oldest,omitempty"`
+	Archive string     `json:"archive,omitempty"`
+	DryRun  bool       `json:"dry_run"`
+	// Skipped is set when another instance was pruning; the policy applies again on the next run.
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Permissions granted by roles. PermissionAll grants every permission; write permissions also gate
+// API keys, which additionally need the scope of the same name (audit:read is covered by ScopeRead).
+const (
+	PermissionAll             = "*"
+	PermissionAssetsWrite     = "assets:write"
+	PermissionScansRun        = "scans:run"
+	PermissionSchedulesWrite  = "schedules:write"
+	PermissionUsersManage     = "users:manage"
+	PermissionSitesManage     = "sites:manage"
+	PermissionIPAMWrite       = "ipam:write"
+	PermissionAuditRead       = "audit:read"
+	PermissionRetentionManage = "retention:manage"
+)
+
+// Permissions lists the permissions accepted in a role.
+var Permissions = []string{PermissionAll, PermissionAssetsWrite, PermissionScansRun, PermissionSchedulesWrite, PermissionUsersManage, PermissionSitesManage, PermissionIPAMWrite, PermissionAuditRead, PermissionRetentionManage}
+
+// BuiltinRolePermissions are the permissions of the built-in roles, used when roles are not loaded from the database.
+var BuiltinRolePermissions = map[string][]string{
+	RoleViewer: {PermissionAuditRead},
+	RoleAdmin:  {PermissionAll},
+}
+
+// Role is a named set of permissions assigned to users. Built-in roles cannot be changed or deleted.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
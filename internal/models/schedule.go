@@ -1,12 +1,210 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Overlap policies: what a schedule does when a run is due while a scan of the same target is still going.
+const (
+	OverlapSkip           = "skip"            // record the new run as skipped
+	OverlapQueue          = "queue"           // start it when the running scan ends (at most one waits)
+	OverlapCancelPrevious = "cancel_previous" // cancel the running scan and start the new one
+)
+
+// OverlapPolicies lists the accepted overlap policies.
+var OverlapPolicies = []string{OverlapSkip, OverlapQueue, OverlapCancelPrevious}
+
+// Blackout actions: what a schedule does with a run due inside one of its blackout windows.
+const (
+	BlackoutSkip  = "skip"  // record the run as skipped
+	BlackoutDefer = "defer" // start it when the window ends
+)
+
+// MaxJitterSeconds bounds Schedule.JitterSeconds.
+const MaxJitterSeconds = 3600
 
 // Schedule represents a recurring scan schedule (cron-like).
 type Schedule struct {
-	ID        int       `json:"id"`
-	Target    string    `json:"target"`
-	CronExpr  string    `json:"cron_expr"`
-	Enabled   bool      `json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
+	ID int `json:"id"`
+	// A schedule scans exactly one of Target, the saved scan SavedScanID, or the asset group AssetTag (the
+	// assets with that tag in the schedule's site). Saved scans and asset groups are resolved at each run.
+	Target      string    `json:"target"`
+	SavedScanID *int      `json:"saved_scan_id"`
+	AssetTag    string    `json:"asset_tag"`
+	CronExpr    string    `json:"cron_expr"`
+	Enabled     bool      `json:"enabled"`
+	SiteID      int       `json:"site_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Timezone is the IANA zone CronExpr and BlackoutWindows are evaluated in ("" for the server's local time).
+	Timezone        string           `json:"timezone"`
+	BlackoutWindows []BlackoutWindow `json:"blackout_windows"`
+	BlackoutAction  string           `json:"blackout_action"`
+	// JitterSeconds delays each run by a random amount up to this many seconds, to spread load.
+	JitterSeconds int    `json:"jitter_seconds"`
+	OverlapPolicy string `json:"overlap_policy"`
+	// LastRunAt and LastStatus describe the latest scan started by the schedule (status running, complete,
+	// canceled, error or skipped). ConsecutiveFailures counts runs ending in error since the last complete one.
+	LastRunAt           *time.Time `json:"last_run_at"`
+	LastStatus          string     `json:"last_status"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	// NextRunAt is computed from CronExpr (nil when disabled or the expression is invalid).
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+// TargetLabel describes what the schedule scans: its target, "saved scan #<id>" or "asset group <tag>".
+func (s Schedule) TargetLabel() string {
+	switch {
+	case s.SavedScanID != nil:
+		return fmt.Sprintf("saved scan #%d", *s.SavedScanID)
+	case s.AssetTag != "":
+		return "asset group " + s.AssetTag
+	}
+	return s.Target
+}
+
+// Location returns the schedule's time zone, or time.Local when unset or unknown.
+func (s Schedule) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Blackout returns the blackout window active at t and when it ends. ok is false outside every window.
+func (s Schedule) Blackout(t time.Time) (w BlackoutWindow, until time.Time, ok bool) {
+	t = t.In(s.Location())
+	for _, w := range s.BlackoutWindows {
+		if until, ok := w.Active(t); ok {
+			return w, until, true
+		}
+	}
+	return BlackoutWindow{}, time.Time{}, false
+}
+
+// weekdays are the accepted BlackoutWindow days, indexed by time.Weekday.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// BlackoutWindow is a daily time range, in the schedule's timezone, during which its scans do not start.
+// Days limits it to some days of the week (mon..sun; empty for every day). An End before Start wraps past
+// midnight, so {"days": ["fri"], "start": "22:00", "end": "06:00"} runs from Friday night to Saturday morning.
+type BlackoutWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// parseClock parses HH:MM into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the days and times of w.
+func (w BlackoutWindow) Validate() error {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return errors.New("start and end must differ")
+	}
+	for _, d := range w.Days {
+		if !validDay(d) {
+			return fmt.Errorf("invalid day %q (want mon..sun)", d)
+		}
+	}
+	return nil
+}
+
+// validDay reports whether day is a weekday name (mon..sun).
+func validDay(day string) bool {
+	for _, d := range weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// onDay reports whether the window starts on weekday wd.
+func (w BlackoutWindow) onDay(wd time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == weekdays[wd] {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether t (already in the schedule's timezone) is inside the window, and when it ends.
+func (w BlackoutWindow) Active(t time.Time) (until time.Time, ok bool) {
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Hour()*60 + t.Minute()
+	at := func(day time.Time, minutes int) time.Time {
+		return day.Add(time.Duration(minutes) * time.Minute)
+	}
+	if start < end {
+		if w.onDay(t.Weekday()) && now >= start && now < end {
+			return at(midnight, end), true
+		}
+		return time.Time{}, false
+	}
+	// Wraps past midnight: started today, or started yesterday and not over yet.
+	if w.onDay(t.Weekday()) && now >= start {
+		return at(midnight.AddDate(0, 0, 1), end), true
+	}
+	if w.onDay(midnight.AddDate(0, 0, -1).Weekday()) && now < end {
+		return at(midnight, end), true
+	}
+	return time.Time{}, false
+}
+
+// String formats w as "sat,sun 22:00-06:00" (see ParseBlackoutWindow).
+func (w BlackoutWindow) String() string {
+	s := w.Start + "-" + w.End
+	if len(w.Days) > 0 {
+		s = strings.Join(w.Days, ",") + " " + s
+	}
+	return s
+}
+
+// ParseBlackoutWindow parses "22:00-06:00" or "sat,sun 22:00-06:00".
+func ParseBlackoutWindow(s string) (BlackoutWindow, error) {
+	var w BlackoutWindow
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 2 {
+		w.Days = strings.Split(fields[0], ",")
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return w, fmt.Errorf("invalid blackout window %q (want [days] HH:MM-HH:MM)", s)
+	}
+	start, end, found := strings.Cut(fields[0], "-")
+	if !found {
+		return w, fmt.Errorf("invalid blackout window %q (want [days] HH:MM-HH:MM)", s)
+	}
+	w.Start, w.End = start, end
+	return w, w.Validate()
 }
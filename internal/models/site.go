@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultSiteID is the site existing inventory belongs to and new rows use when no site is selected.
+const DefaultSiteID = 1
+
+// SiteHeader selects the site a request works in, by name or ID. /v1/sites/{site}/... is equivalent.
+const SiteHeader = "X-Site"
+
+var (
+	// ErrSiteNotFound is returned when a selected site does not exist.
+	ErrSiteNotFound = errors.New("site not found")
+	// ErrSiteNotAllowed is returned when the caller's access policies exclude the selected site.
+	ErrSiteNotAllowed = errors.New("site outside your access policy")
+	// ErrSiteRequired is returned when creating rows without selecting a site while access policies allow
+	// several.
+	ErrSiteRequired = errors.New("select a site with the X-Site header: your access policy allows several")
+)
+
+// Site partitions the inventory, e.g. one per cluster or datacenter. Assets, scans, saved scans and schedules
+// each belong to one site, so overlapping private address ranges on different sites do not collide.
+type Site struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SiteSummary is a site with the counts shown on its dashboard.
+type SiteSummary struct {
+	Site
+	Assets    int `json:"assets"`
+	Scans     int `json:"scans"`
+	Schedules int `json:"schedules"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrSubnetNotFound is returned when a subnet does not exist in the selected site.
+	ErrSubnetNotFound = errors.New("subnet not found")
+	// ErrReservationNotFound is returned when an IP reservation does not exist in its subnet.
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrSubnetFull is returned when a subnet has no free address left to allocate.
+	ErrSubnetFull = errors.New("subnet has no free addresses")
+)
+
+// Subnet is a network of a site. Assets belong to the most specific subnet containing their IP.
+type Subnet struct {
+	ID          int       `json:"id"`
+	SiteID      int       `json:"site_id"`
+	CIDR        string    `json:"cidr"`
+	VLANID      *int      `json:"vlan_id,omitempty"`
+	Gateway     string    `json:"gateway,omitempty"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SubnetUtilization counts a subnet's usable addresses. Used are addresses held by assets, reserved are
+// reservations and the gateway not held by an asset, free is the rest. Size and free saturate for IPv6
+// subnets larger than /64.
+type SubnetUtilization struct {
+	Size        uint64  `json:"size"`
+	Used        int     `json:"used"`
+	Reserved    int     `json:"reserved"`
+	Free        uint64  `json:"free"`
+	PercentUsed float64 `json:"percent_used"`
+}
+
+// SubnetSummary is a subnet with its utilization.
+type SubnetSummary struct {
+	Subnet
+	Utilization SubnetUtilization `json:"utilization"`
+}
+
+// IPReservation holds an address of a subnet, e.g. for a device scans cannot see or an allocated address.
+type IPReservation struct {
+	ID          int       `json:"id"`
+	SubnetID    int       `json:"subnet_id"`
+	IP          string    `json:"ip"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,89 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/crucial707/hci-asset/internal/oidc"
+	"github.com/crucial707/hci-asset/internal/oidc/oidctest"
+)
+
+const redirectURI = "http://localhost:3000/auth/oidc/callback"
+
+func TestProvider_CodeFlowWithPKCE(t *testing.T) {
+	idp := oidctest.NewServer("hci", "s3cret")
+	defer idp.Close()
+	idp.Claims = map[string]interface{}{"sub": "abc", "preferred_username": "alice", "groups": []string{"ops", "admins"}}
+
+	p := &oidc.Provider{Issuer: idp.Issuer(), ClientID: "hci", ClientSecret: "s3cret"}
+	verifier, _ := oidc.RandomString()
+	authURL, err := p.AuthCodeURL(context.Background(), redirectURI, "state-1", "nonce-1", oidc.CodeChallenge(verifier), []string{"openid"})
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	// Follow the authorization request without a browser: the mock provider redirects straight back.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	if loc.Query().Get("state") != "state-1" {
+		t.Fatalf("state not echoed: %s", loc)
+	}
+
+	tokens, err := p.Exchange(context.Background(), loc.Query().Get("code"), verifier, redirectURI)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.VerifyIDToken(context.Background(), tokens.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "abc" || claims.PreferredUsername != "alice" || len(claims.Groups) != 2 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestProvider_Exchange_WrongVerifier(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+
+	p := &oidc.Provider{Issuer: idp.Issuer(), ClientID: "hci"}
+	code := idp.IssueCode(oidc.CodeChallenge("right"), "", redirectURI)
+	if _, err := p.Exchange(context.Background(), code, "wrong", redirectURI); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange with wrong verifier: got %v, want invalid_grant", err)
+	}
+}
+
+func TestProvider_VerifyIDToken_Rejects(t *testing.T) {
+	idp := oidctest.NewServer("hci", "")
+	defer idp.Close()
+	other := oidctest.NewServer("hci", "")
+	defer other.Close()
+
+	p := &oidc.Provider{Issuer: idp.Issuer(), ClientID: "hci"}
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"wrong nonce", idp.SignIDToken(map[string]interface{}{"sub": "a", "nonce": "n1"}), "n2"},
+		{"wrong audience", idp.SignIDToken(map[string]interface{}{"sub": "a", "aud": "someone-else"}), ""},
+		{"expired", idp.SignIDToken(map[string]interface{}{"sub": "a", "exp": 1000}), ""},
+		{"foreign signer", other.SignIDToken(map[string]interface{}{"sub": "a", "iss": idp.Issuer()}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.VerifyIDToken(context.Background(), tt.token, tt.nonce); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
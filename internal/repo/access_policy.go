@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+// ErrAccessPolicyNotFound is returned when an access policy cannot be found.
+var ErrAccessPolicyNotFound = errors.New("access policy not found")
+
+type assetScopeKey struct{}
+
+// WithAssetScope restricts AssetRepo calls made with the returned context to assets carrying at least one of tags.
+func WithAssetScope(ctx context.Context, tags []string) context.Context {
+	if tags == nil {
+		tags = []string{}
+	}
+	return context.WithValue(ctx, assetScopeKey{}, tags)
+}
+
+// AssetScope returns the tags ctx is restricted to. ok is false when every asset is visible.
+func AssetScope(ctx context.Context) (tags []string, ok bool) {
+	tags, ok = ctx.Value(assetScopeKey{}).([]string)
+	return tags, ok
+}
+
+// InAssetScope reports whether an asset with the given tags is visible in ctx.
+func InAssetScope(ctx context.Context, tags []string) bool {
+	allowed, ok := AssetScope(ctx)
+	if !ok {
+		return true
+	}
+	for _, t := range tags {
+		for _, a := range allowed {
+			if t == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assetScopeFilter returns the condition limiting assets to ctx's scope using placeholder $n, and its
+// argument. cond is empty when ctx is unrestricted.
+func assetScopeFilter(ctx context.Context, n int) (cond string, args []interface{}) {
+	tags, ok := AssetScope(ctx)
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprintf("tags && $%d", n), []interface{}{pq.Array(tags)}
+}
+
+// AccessPolicyRepo persists access policies and applies them to requests.
+type AccessPolicyRepo struct {
+	DB *sql.DB
+}
+
+// NewAccessPolicyRepo returns a new AccessPolicyRepo.
+func NewAccessPolicyRepo(db *sql.DB) *AccessPolicyRepo {
+	return &AccessPolicyRepo{DB: db}
+}
+
+const accessPolicySelect = `SELECT id, name, description, user_id, COALESCE(role, ''), tags, site_id, created_at, updated_at FROM access_policies`
+
+func scanAccessPolicy(row rowScanner) (*models.AccessPolicy, error) {
+	var p models.AccessPolicy
+	var userID, siteID sql.NullInt64
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &userID, &p.Role, pq.Array(&p.Tags), &siteID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		p.UserID = &id
+	}
+	if siteID.Valid {
+		id := int(siteID.Int64)
+		p.SiteID = &id
+	}
+	return &p, nil
+}
+
+// nullRole maps an empty role to NULL.
+func nullRole(role string) interface{} {
+	if role == "" {
+		return nil
+	}
+	return role
+}
+
+// List returns all access policies ordered by name.
+func (r *AccessPolicyRepo) List(ctx context.Context) ([]models.AccessPolicy, error) {
+	rows, err := r.DB.QueryContext(ctx, accessPolicySelect+` ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []models.AccessPolicy{}
+	for rows.Next() {
+		p, err := scanAccessPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+	return policies, rows.Err()
+}
+
+// GetByID returns one policy or ErrAccessPolicyNotFound.
+func (r *AccessPolicyRepo) GetByID(ctx context.Context, id int) (*models.AccessPolicy, error) {
+	p, err := scanAccessPolicy(r.DB.QueryRowContext(ctx, accessPolicySelect+` WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAccessPolicyNotFound
+	}
+	return p, err
+}
+
+// Create stores a policy. Exactly one of p.UserID and p.Role must be set.
+func (r *AccessPolicyRepo) Create(ctx context.Context, p models.AccessPolicy) (*models.AccessPolicy, error) {
+	return scanAccessPolicy(r.DB.QueryRowContext(ctx,
+		`INSERT INTO access_policies (name, description, user_id, role, tags, site_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, description, user_id, COALESCE(role, ''), tags, site_id, created_at, updated_at`,
+		p.Name, p.Description, p.UserID, nullRole(p.Role), pq.Array(p.Tags), p.SiteID,
+	))
+}
+
+// Update replaces a policy's fields. Returns ErrAccessPolicyNotFound for unknown IDs.
+func (r *AccessPolicyRepo) Update(ctx context.Context, id int, p models.AccessPolicy) (*models.AccessPolicy, error) {
+	updated, err := scanAccessPolicy(r.DB.QueryRowContext(ctx,
+		`UPDATE access_policies SET name = $2, description = $3, user_id = $4, role = $5, tags = $6, site_id = $7, updated_at = NOW() WHERE id = $1 RETURNING id, name, description, user_id, COALESCE(role, ''), tags, site_id, created_at, updated_at`,
+		id, p.Name, p.Description, p.UserID, nullRole(p.Role), pq.Array(p.Tags), p.SiteID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrAccessPolicyNotFound
+	}
+	return updated, err
+}
+
+// Delete removes a policy. Returns ErrAccessPolicyNotFound for unknown IDs.
+func (r *AccessPolicyRepo) Delete(ctx context.Context, id int) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM access_policies WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAccessPolicyNotFound
+	}
+	return nil
+}
+
+// ScopeContext restricts ctx to the tags and sites of the policies matching userID or role (implements
+// middleware.RequestScoper). ctx is returned unchanged when no policy matches; a matching policy without tags
+// or without a site lifts that restriction.
+func (r *AccessPolicyRepo) ScopeContext(ctx context.Context, userID int, role string) (context.Context, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT tags, site_id FROM access_policies WHERE user_id = $1 OR role = $2`, userID, role)
+	if err != nil {
+		return ctx, err
+	}
+	defer rows.Close()
+
+	matched, allTags, allSites := false, false, false
+	seen := make(map[string]bool)
+	tags := []string{}
+	sites := []int{}
+	for rows.Next() {
+		var policyTags []string
+		var siteID sql.NullInt64
+		if err := rows.Scan(pq.Array(&policyTags), &siteID); err != nil {
+			return ctx, err
+		}
+		matched = true
+		if len(policyTags) == 0 {
+			allTags = true
+		}
+		for _, t := range policyTags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+		if !siteID.Valid {
+			allSites = true
+		} else if !containsInt(sites, int(siteID.Int64)) {
+			sites = append(sites, int(siteID.Int64))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ctx, err
+	}
+	if !matched {
+		return ctx, nil
+	}
+	if !allTags {
+		ctx = WithAssetScope(ctx, tags)
+	}
+	if !allSites {
+		sort.Ints(sites)
+		ctx = withAllowedSites(ctx, sites)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyInvalid is returned by Verify for unknown, revoked, expired or IP-restricted keys.
+	ErrAPIKeyInvalid = errors.New("invalid api key")
+)
+
+// APIKeyRepo persists API keys. Only the SHA-256 hash of each key is stored.
+type APIKeyRepo struct {
+	DB *sql.DB
+}
+
+// NewAPIKeyRepo returns a new APIKeyRepo.
+func NewAPIKeyRepo(db *sql.DB) *APIKeyRepo {
+	return &APIKeyRepo{DB: db}
+}
+
+// generateAPIKey returns a raw key of the form hci_<prefix>_<secret> and its lookup prefix.
+func generateAPIKey() (raw, prefix string, err error) {
+	p := make([]byte, 4)
+	s := make([]byte, 32)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(s); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(p)
+	return models.APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(s), prefix, nil
+}
+
+// parseAPIKeyPrefix extracts the lookup prefix from a raw key.
+func parseAPIKeyPrefix(raw string) (string, bool) {
+	rest := strings.TrimPrefix(raw, models.APIKeyPrefix)
+	if rest == raw {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashToken returns the hex SHA-256 of a secret token (API keys, refresh tokens); only hashes are stored.
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a key for userID and returns it with the raw secret, which is not retrievable later.
+func (r *APIKeyRepo) Create(ctx context.Context, userID int, name string, scopes, allowedIPs []string, expiresAt *time.Time, createdBy int) (*models.APIKey, string, error) {
+	raw, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+	k := &models.APIKey{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		ExpiresAt:  expiresAt,
+	}
+	var creator interface{}
+	if createdBy > 0 {
+		creator = createdBy
+	}
+	err = r.DB.QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, created_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		userID, name, prefix, hashToken(raw), pq.Array(scopes), pq.Array(allowedIPs), expiresAt, creator,
+	).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, raw, nil
+}
+
+const apiKeySelect = `SELECT k.id, k.user_id, u.username, k.name, k.prefix, k.scopes, k.allowed_ips, k.expires_at, k.last_used_at, k.revoked_at, k.created_at FROM api_keys k JOIN users u ON u.id = k.user_id`
+
+// List returns all keys, newest first. Secrets and hashes are never returned.
+func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := r.DB.QueryContext(ctx, apiKeySelect+` ORDER BY k.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// GetByID returns one key or ErrAPIKeyNotFound.
+func (r *APIKeyRepo) GetByID(ctx context.Context, id int) (*models.APIKey, error) {
+	k, err := scanAPIKey(r.DB.QueryRowContext(ctx, apiKeySelect+` WHERE k.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var k models.APIKey
+	var expires, lastUsed, revoked sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &k.Username, &k.Name, &k.Prefix, pq.Array(&k.Scopes), pq.Array(&k.AllowedIPs), &expires, &lastUsed, &revoked, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	if expires.Valid {
+		k.ExpiresAt = &expires.Time
+	}
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		k.RevokedAt = &revoked.Time
+	}
+	return &k, nil
+}
+
+// Revoke marks a key as revoked. Revoking an already revoked key is a no-op; unknown IDs return ErrAPIKeyNotFound.
+func (r *APIKeyRepo) Revoke(ctx context.Context, id int) error {
+	result, err := r.DB.ExecContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Verify checks a raw key presented from clientIP and returns its principal. Any failure other than a
+// database error is reported as ErrAPIKeyInvalid so callers cannot distinguish unknown from revoked keys.
+func (r *APIKeyRepo) Verify(ctx context.Context, raw, clientIP string) (*models.APIKeyPrincipal, error) {
+	prefix, ok := parseAPIKeyPrefix(raw)
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	var p models.APIKeyPrincipal
+	var hash string
+	var allowedIPs []string
+	var expires, revoked sql.NullTime
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT k.id, k.user_id, u.username, u.role, k.key_hash, k.scopes, k.allowed_ips, k.expires_at, k.revoked_at FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.prefix = $1`,
+		prefix,
+	).Scan(&p.KeyID, &p.UserID, &p.Username, &p.Role, &hash, pq.Array(&p.Scopes), pq.Array(&allowedIPs), &expires, &revoked)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(raw))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	if revoked.Valid || (expires.Valid && !expires.Time.After(time.Now())) {
+		return nil, ErrAPIKeyInvalid
+	}
+	if !IPAllowed(allowedIPs, clientIP) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	// Best effort: a failed timestamp update must not reject an otherwise valid key.
+	_, _ = r.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, p.KeyID)
+	return &p, nil
+}
+
+// IPAllowed reports whether ip matches one of the allowlist entries (single IPs or CIDRs).
+// An empty allowlist allows every address.
+func IPAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.Contains(a, "/") {
+			if _, n, err := net.ParseCIDR(a); err == nil && n.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if other := net.ParseIP(a); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
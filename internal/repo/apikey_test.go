@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGenerateAPIKey_Format(t *testing.T) {
+	raw, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(raw, "hci_"+prefix+"_") {
+		t.Errorf("key %q does not start with hci_%s_", raw, prefix)
+	}
+	got, ok := parseAPIKeyPrefix(raw)
+	if !ok || got != prefix {
+		t.Errorf("parseAPIKeyPrefix: got %q, %v", got, ok)
+	}
+}
+
+func TestAPIKeyRepo_Verify(t *testing.T) {
+	const raw = "hci_0011aabb_dGVzdA"
+	rows := func(revoked, expires interface{}, allowed string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+			AddRow(5, 2, "ci-bot", "viewer", hashToken(raw), "{read}", allowed, expires, revoked)
+	}
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		ip      string
+		wantErr bool
+	}{
+		{"valid", rows(nil, nil, "{}"), "192.0.2.1", false},
+		{"revoked", rows(time.Now(), nil, "{}"), "192.0.2.1", true},
+		{"expired", rows(nil, time.Now().Add(-time.Hour), "{}"), "192.0.2.1", true},
+		{"ip allowed", rows(nil, nil, "{10.0.0.0/8}"), "10.1.2.3", false},
+		{"ip denied", rows(nil, nil, "{10.0.0.0/8}"), "192.0.2.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT k.id, k.user_id, u.username, u.role, k.key_hash`).
+				WithArgs("0011aabb").
+				WillReturnRows(tt.rows)
+			if !tt.wantErr {
+				mock.ExpectExec(`UPDATE api_keys SET last_used_at`).
+					WithArgs(5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			p, err := NewAPIKeyRepo(db).Verify(context.Background(), raw, tt.ip)
+			if tt.wantErr {
+				if err != ErrAPIKeyInvalid {
+					t.Fatalf("Verify: got %v, want ErrAPIKeyInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if p.UserID != 2 || p.KeyID != 5 || len(p.Scopes) != 1 || p.Scopes[0] != "read" {
+				t.Errorf("unexpected principal: %+v", p)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRepo_Verify_WrongSecret(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT k.id, k.user_id`).
+		WithArgs("0011aabb").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "role", "key_hash", "scopes", "allowed_ips", "expires_at", "revoked_at"}).
+			AddRow(5, 2, "ci-bot", "viewer", hashToken("hci_0011aabb_other"), "{read}", "{}", nil, nil))
+
+	if _, err := NewAPIKeyRepo(db).Verify(context.Background(), "hci_0011aabb_dGVzdA", "192.0.2.1"); err != ErrAPIKeyInvalid {
+		t.Fatalf("Verify: got %v, want ErrAPIKeyInvalid", err)
+	}
+}
+
+func TestAPIKeyRepo_Revoke_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at`).
+		WithArgs(99).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := NewAPIKeyRepo(db).Revoke(context.Background(), 99); err != ErrAPIKeyNotFound {
+		t.Fatalf("Revoke: got %v, want ErrAPIKeyNotFound", err)
+	}
+}
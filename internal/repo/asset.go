@@ -15,8 +15,12 @@ type AssetRepo struct {
 	db *sql.DB
 }
 
-// ErrAssetNotFound is returned when an asset cannot be found.
-var ErrAssetNotFound = errors.New("asset not found")
+var (
+	// ErrAssetNotFound is returned when an asset cannot be found.
+	ErrAssetNotFound = errors.New("asset not found")
+	// ErrIPExcluded is returned by UpsertDiscoveredByIP for IPs of trashed or recently decommissioned assets.
+	ErrIPExcluded = errors.New("ip excluded from discovery")
+)
 
 // ==========================
 // Constructor
@@ -32,11 +36,14 @@ func (r *AssetRepo) Create(ctx context.Context, name, description string, tags [
 	if tags == nil {
 		tags = []string{}
 	}
+	query := "INSERT INTO assets (name, description, tags) VALUES ($1, $2, $3) RETURNING id"
+	args := []interface{}{name, description, pq.Array(tags)}
+	if siteID, ok := SiteID(ctx); ok {
+		query = "INSERT INTO assets (name, description, tags, site_id) VALUES ($1, $2, $3, $4) RETURNING id"
+		args = append(args, siteID)
+	}
 	var id int
-	err := r.db.QueryRowContext(ctx,
-		"INSERT INTO assets (name, description, tags) VALUES ($1, $2, $3) RETURNING id",
-		name, description, pq.Array(tags),
-	).Scan(&id)
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
@@ -45,51 +52,42 @@ func (r *AssetRepo) Create(ctx context.Context, name, description string, tags [
 		Name:        name,
 		Description: description,
 		Tags:        tags,
+		Lifecycle:   models.LifecycleActive,
 	}, nil
 }
 
 // ==========================
-// Find asset by name
+// Find asset by name (within ctx's site, see WithSite)
 // ==========================
 func (r *AssetRepo) FindByName(ctx context.Context, name string) (*models.Asset, error) {
-	var a models.Asset
-	var lastSeen sql.NullTime
-	err := r.db.QueryRowContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets WHERE name=$1",
-		name,
-	).Scan(&a.ID, &a.Name, &a.Description, pq.Array(&a.Tags), &lastSeen, &a.NetworkName)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrAssetNotFound
-		}
-		return nil, err
+	where, args := siteScoped(ctx, "name=$1 AND deleted_at IS NULL", name)
+	a, err := scanAsset(r.db.QueryRowContext(ctx, assetSelect+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrAssetNotFound
 	}
-	if lastSeen.Valid {
-		a.LastSeen = &lastSeen.Time
-	}
-	return &a, nil
+	return a, err
 }
 
 // ==========================
-// Find asset by network_name
+// Find asset by network_name (within ctx's site, see WithSite)
 // ==========================
+// FindByNetworkName skips trashed and decommissioned assets.
 func (r *AssetRepo) FindByNetworkName(ctx context.Context, networkName string) (*models.Asset, error) {
-	var a models.Asset
-	var lastSeen sql.NullTime
-	err := r.db.QueryRowContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets WHERE network_name=$1",
-		networkName,
-	).Scan(&a.ID, &a.Name, &a.Description, pq.Array(&a.Tags), &lastSeen, &a.NetworkName)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrAssetNotFound
-		}
-		return nil, err
-	}
-	if lastSeen.Valid {
-		a.LastSeen = &lastSeen.Time
+	where, args := siteScoped(ctx, "network_name=$1 AND deleted_at IS NULL AND lifecycle <> 'decommissioned'", networkName)
+	a, err := scanAsset(r.db.QueryRowContext(ctx, assetSelect+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrAssetNotFound
 	}
-	return &a, nil
+	return a, err
+}
+
+// ipExcluded reports whether discovery must not create an asset for ip: an asset holding it is in the trash or
+// was decommissioned less than the grace period ago.
+func (r *AssetRepo) ipExcluded(ctx context.Context, ip string) (bool, error) {
+	where, args := siteScoped(ctx, "network_name=$1 AND (deleted_at IS NOT NULL OR ip_excluded_until > NOW())", ip)
+	var excluded bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM assets"+where+")", args...).Scan(&excluded)
+	return excluded, err
 }
 
 // ==========================
@@ -122,7 +120,9 @@ func (r *AssetRepo) UpsertDiscovered(ctx context.Context, name, description stri
 // Upsert discovered asset by IP (network_name)
 // ==========================
 // UpsertDiscoveredByIP finds/creates an asset keyed by the discovered IP (stored in network_name).
-// This avoids duplicate assets when a hostname changes between scans.
+// This avoids duplicate assets when a hostname changes between scans. The IP is only unique per site, so
+// scans pass their site in ctx (see WithSite) and overlapping private ranges on other sites are left alone.
+// Returns ErrIPExcluded instead of recreating an asset that was moved to the trash or recently decommissioned.
 func (r *AssetRepo) UpsertDiscoveredByIP(ctx context.Context, ip, hostname, description string) (*models.Asset, error) {
 	if strings.TrimSpace(ip) == "" {
 		return nil, fmt.Errorf("missing ip")
@@ -154,6 +154,14 @@ func (r *AssetRepo) UpsertDiscoveredByIP(ctx context.Context, ip, hostname, desc
 		return nil, err
 	}
 
+	excluded, err := r.ipExcluded(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	if excluded {
+		return nil, ErrIPExcluded
+	}
+
 	name := strings.TrimSpace(hostname)
 	if name == "" {
 		name = ip
@@ -168,25 +176,92 @@ func (r *AssetRepo) UpsertDiscoveredByIP(ctx context.Context, ip, hostname, desc
 	return r.Get(ctx, created.ID)
 }
 
+// assetSelect is the column list shared by the asset queries (see scanAsset).
+const assetSelect = "SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, ''), lifecycle, owner, deleted_at, ip_excluded_until FROM assets"
+
+func scanAsset(row rowScanner) (*models.Asset, error) {
+	var a models.Asset
+	var lastSeen, deletedAt, excludedUntil sql.NullTime
+	if err := row.Scan(&a.ID, &a.Name, &a.Description, pq.Array(&a.Tags), &lastSeen, &a.NetworkName, &a.Lifecycle, &a.Owner, &deletedAt, &excludedUntil); err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		a.LastSeen = &lastSeen.Time
+	}
+	if deletedAt.Valid {
+		a.DeletedAt = &deletedAt.Time
+	}
+	if excludedUntil.Valid {
+		a.IPExcludedUntil = &excludedUntil.Time
+	}
+	return &a, nil
+}
+
+// scoped appends ctx's asset scope (see WithAssetScope) and site (see WithSite) to a query whose WHERE clause
+// is where (may be empty) and whose arguments are args, leaving out assets in the trash. group wraps where in
+// parentheses so an OR keeps its meaning.
+func scoped(ctx context.Context, where string, group bool, args ...interface{}) (string, []interface{}) {
+	return scopedIn(ctx, false, where, group, args...)
+}
+
+// trashScoped is scoped for the assets in the trash.
+func trashScoped(ctx context.Context, where string, group bool, args ...interface{}) (string, []interface{}) {
+	return scopedIn(ctx, true, where, group, args...)
+}
+
+func scopedIn(ctx context.Context, trash bool, where string, group bool, args ...interface{}) (string, []interface{}) {
+	var conds []string
+	if cond, scopeArgs := assetScopeFilter(ctx, len(args)+1); cond != "" {
+		conds = append(conds, cond)
+		args = append(args, scopeArgs...)
+	}
+	if cond, siteArgs := siteFilter(ctx, len(args)+1); cond != "" {
+		conds = append(conds, cond)
+		args = append(args, siteArgs...)
+	}
+	if trash {
+		conds = append(conds, "deleted_at IS NOT NULL")
+	} else {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	switch {
+	case where == "":
+	case group:
+		conds = append([]string{"(" + where + ")"}, conds...)
+	default:
+		conds = append([]string{where}, conds...)
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// page appends ORDER BY id and LIMIT/OFFSET placeholders after args.
+func page(args []interface{}, limit, offset int) (string, []interface{}) {
+	args = append(args, limit, offset)
+	return fmt.Sprintf(" ORDER BY id LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
 // Count returns the total number of assets.
 func (r *AssetRepo) Count(ctx context.Context) (int, error) {
+	where, args := scoped(ctx, "", false)
 	var n int
-	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets").Scan(&n)
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets"+where, args...).Scan(&n)
 	return n, err
 }
 
 // CountByTag returns the number of assets with the given tag.
 func (r *AssetRepo) CountByTag(ctx context.Context, tag string) (int, error) {
+	where, args := scoped(ctx, "$1 = ANY(COALESCE(tags, '{}'))", false, tag)
 	var n int
-	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets WHERE $1 = ANY(COALESCE(tags, '{}'))", tag).Scan(&n)
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets"+where, args...).Scan(&n)
 	return n, err
 }
 
 // CountSearch returns the number of assets matching the search query (name or description).
 func (r *AssetRepo) CountSearch(ctx context.Context, query string) (int, error) {
 	likeQuery := "%" + strings.ToLower(query) + "%"
+	where, args := scoped(ctx, "LOWER(name) LIKE $1 OR LOWER(description) LIKE $1", true, likeQuery)
 	var n int
-	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets WHERE LOWER(name) LIKE $1 OR LOWER(description) LIKE $1", likeQuery).Scan(&n)
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets"+where, args...).Scan(&n)
 	return n, err
 }
 
@@ -194,10 +269,9 @@ func (r *AssetRepo) CountSearch(ctx context.Context, query string) (int, error)
 // List assets with pagination
 // ==========================
 func (r *AssetRepo) List(ctx context.Context, limit, offset int) ([]models.Asset, error) {
-	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets ORDER BY id LIMIT $1 OFFSET $2",
-		limit, offset,
-	)
+	where, args := scoped(ctx, "", false)
+	order, args := page(args, limit, offset)
+	rows, err := r.db.QueryContext(ctx, assetSelect+where+order, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -207,10 +281,9 @@ func (r *AssetRepo) List(ctx context.Context, limit, offset int) ([]models.Asset
 
 // ListByTag returns assets that have the given tag.
 func (r *AssetRepo) ListByTag(ctx context.Context, tag string, limit, offset int) ([]models.Asset, error) {
-	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets WHERE $1 = ANY(COALESCE(tags, '{}')) ORDER BY id LIMIT $2 OFFSET $3",
-		tag, limit, offset,
-	)
+	where, args := scoped(ctx, "$1 = ANY(COALESCE(tags, '{}'))", false, tag)
+	order, args := page(args, limit, offset)
+	rows, err := r.db.QueryContext(ctx, assetSelect+where+order, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -218,18 +291,64 @@ func (r *AssetRepo) ListByTag(ctx context.Context, tag string, limit, offset int
 	return r.scanAssetRows(rows)
 }
 
+// IPsByTag returns the IPs (network_name) of the assets with the given tag, skipping decommissioned assets and
+// assets without an IP, in IP order. Used to scan an asset group.
+func (r *AssetRepo) IPsByTag(ctx context.Context, tag string) ([]string, error) {
+	where, args := scoped(ctx, "$1 = ANY(COALESCE(tags, '{}')) AND COALESCE(network_name, '') <> '' AND lifecycle <> $2", false, tag, models.LifecycleDecommissioned)
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT network_name FROM assets"+where+" ORDER BY network_name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// ListByIDs returns the assets among ids that are visible in ctx, ordered by ID.
+func (r *AssetRepo) ListByIDs(ctx context.Context, ids []int) ([]models.Asset, error) {
+	where, args := scoped(ctx, "id = ANY($1)", false, pq.Array(ids))
+	rows, err := r.db.QueryContext(ctx, assetSelect+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return r.scanAssetRows(rows)
+}
+
+// SiteNames returns the name of the site of each asset among ids, keyed by asset ID.
+func (r *AssetRepo) SiteNames(ctx context.Context, ids []int) (map[int]string, error) {
+	names := make(map[int]string, len(ids))
+	rows, err := r.db.QueryContext(ctx, "SELECT a.id, s.name FROM assets a JOIN sites s ON s.id = a.site_id WHERE a.id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
 func (r *AssetRepo) scanAssetRows(rows *sql.Rows) ([]models.Asset, error) {
 	var assets []models.Asset
 	for rows.Next() {
-		var a models.Asset
-		var lastSeen sql.NullTime
-		if err := rows.Scan(&a.ID, &a.Name, &a.Description, pq.Array(&a.Tags), &lastSeen, &a.NetworkName); err != nil {
+		a, err := scanAsset(rows)
+		if err != nil {
 			return nil, err
 		}
-		if lastSeen.Valid {
-			a.LastSeen = &lastSeen.Time
-		}
-		assets = append(assets, a)
+		assets = append(assets, *a)
 	}
 	return assets, rows.Err()
 }
@@ -239,10 +358,9 @@ func (r *AssetRepo) scanAssetRows(rows *sql.Rows) ([]models.Asset, error) {
 // ==========================
 func (r *AssetRepo) Search(ctx context.Context, query string, limit, offset int) ([]models.Asset, error) {
 	likeQuery := "%" + strings.ToLower(query) + "%"
-	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets WHERE LOWER(name) LIKE $1 OR LOWER(description) LIKE $1 ORDER BY id LIMIT $2 OFFSET $3",
-		likeQuery, limit, offset,
-	)
+	where, args := scoped(ctx, "LOWER(name) LIKE $1 OR LOWER(description) LIKE $1", true, likeQuery)
+	order, args := page(args, limit, offset)
+	rows, err := r.db.QueryContext(ctx, assetSelect+where+order, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -254,28 +372,20 @@ func (r *AssetRepo) Search(ctx context.Context, query string, limit, offset int)
 // Get an asset by ID
 // ==========================
 func (r *AssetRepo) Get(ctx context.Context, id int) (*models.Asset, error) {
-	var a models.Asset
-	var lastSeen sql.NullTime
-	err := r.db.QueryRowContext(ctx,
-		"SELECT id, name, description, COALESCE(tags, '{}'), last_seen, COALESCE(network_name, '') FROM assets WHERE id=$1", id,
-	).Scan(&a.ID, &a.Name, &a.Description, pq.Array(&a.Tags), &lastSeen, &a.NetworkName)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("asset not found")
-		}
-		return nil, err
-	}
-	if lastSeen.Valid {
-		a.LastSeen = &lastSeen.Time
+	where, args := scoped(ctx, "id=$1", false, id)
+	a, err := scanAsset(r.db.QueryRowContext(ctx, assetSelect+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrAssetNotFound
 	}
-	return &a, nil
+	return a, err
 }
 
 // ==========================
 // Heartbeat updates last_seen for an asset (e.g. agent check-in).
 // ==========================
 func (r *AssetRepo) Heartbeat(ctx context.Context, id int) (*models.Asset, error) {
-	res, err := r.db.ExecContext(ctx, "UPDATE assets SET last_seen = NOW() WHERE id = $1", id)
+	where, args := scoped(ctx, "id = $1", false, id)
+	res, err := r.db.ExecContext(ctx, "UPDATE assets SET last_seen = NOW()"+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -284,7 +394,7 @@ func (r *AssetRepo) Heartbeat(ctx context.Context, id int) (*models.Asset, error
 		return nil, err
 	}
 	if rowsAffected == 0 {
-		return nil, fmt.Errorf("asset not found")
+		return nil, ErrAssetNotFound
 	}
 	return r.Get(ctx, id)
 }
@@ -296,10 +406,8 @@ func (r *AssetRepo) Update(ctx context.Context, id int, name, description string
 	if tags == nil {
 		tags = []string{}
 	}
-	res, err := r.db.ExecContext(ctx,
-		"UPDATE assets SET name=$1, description=$2, tags=$3 WHERE id=$4",
-		name, description, pq.Array(tags), id,
-	)
+	where, args := scoped(ctx, "id=$4", false, name, description, pq.Array(tags), id)
+	res, err := r.db.ExecContext(ctx, "UPDATE assets SET name=$1, description=$2, tags=$3"+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -308,7 +416,7 @@ func (r *AssetRepo) Update(ctx context.Context, id int, name, description string
 		return nil, err
 	}
 	if rowsAffected == 0 {
-		return nil, fmt.Errorf("asset not found")
+		return nil, ErrAssetNotFound
 	}
 	return r.Get(ctx, id)
 }
@@ -327,16 +435,17 @@ func (r *AssetRepo) UpdateNetworkName(ctx context.Context, id int, networkName s
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("asset not found")
+		return ErrAssetNotFound
 	}
 	return nil
 }
 
 // ==========================
-// Delete an asset by ID
+// Delete an asset by ID (moves it to the trash)
 // ==========================
 func (r *AssetRepo) Delete(ctx context.Context, id int) error {
-	res, err := r.db.ExecContext(ctx, "DELETE FROM assets WHERE id=$1", id)
+	where, args := scoped(ctx, "id=$1", false, id)
+	res, err := r.db.ExecContext(ctx, "UPDATE assets SET deleted_at = NOW()"+where, args...)
 	if err != nil {
 		return err
 	}
@@ -345,7 +454,34 @@ func (r *AssetRepo) Delete(ctx context.Context, id int) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("asset not found")
+		return ErrAssetNotFound
 	}
 	return nil
 }
+
+// VisibleIDs returns which of ids are assets visible in ctx (see WithAssetScope, WithSite and AllowedSites).
+// Without a scope or site limit every ID is returned without querying.
+func (r *AssetRepo) VisibleIDs(ctx context.Context, ids []int) (map[int]bool, error) {
+	visible := make(map[int]bool, len(ids))
+	_, restricted := AssetScope(ctx)
+	if cond, _ := siteFilter(ctx, 1); !restricted && cond == "" {
+		for _, id := range ids {
+			visible[id] = true
+		}
+		return visible, nil
+	}
+	where, args := scoped(ctx, "id = ANY($1)", false, pq.Array(ids))
+	rows, err := r.db.QueryContext(ctx, "SELECT id FROM assets"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		visible[id] = true
+	}
+	return visible, rows.Err()
+}
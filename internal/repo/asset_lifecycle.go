@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// CountTrash returns the number of assets in the trash.
+func (r *AssetRepo) CountTrash(ctx context.Context) (int, error) {
+	where, args := trashScoped(ctx, "", false)
+	var n int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets"+where, args...).Scan(&n)
+	return n, err
+}
+
+// ListTrash returns the assets in the trash, most recently deleted first.
+func (r *AssetRepo) ListTrash(ctx context.Context, limit, offset int) ([]models.Asset, error) {
+	where, args := trashScoped(ctx, "", false)
+	args = append(args, limit, offset)
+	rows, err := r.db.QueryContext(ctx,
+		assetSelect+where+fmt.Sprintf(" ORDER BY deleted_at DESC, id LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return r.scanAssetRows(rows)
+}
+
+// Restore moves an asset out of the trash. Returns ErrAssetNotFound if it is not in the trash.
+func (r *AssetRepo) Restore(ctx context.Context, id int) (*models.Asset, error) {
+	where, args := trashScoped(ctx, "id=$1", false, id)
+	res, err := r.db.ExecContext(ctx, "UPDATE assets SET deleted_at = NULL"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrAssetNotFound
+	}
+	return r.Get(ctx, id)
+}
+
+// Purge permanently deletes an asset in the trash, with its services, relationships and lifecycle history.
+// Returns ErrAssetNotFound if it is not in the trash.
+func (r *AssetRepo) Purge(ctx context.Context, id int) error {
+	where, args := trashScoped(ctx, "id=$1", false, id)
+	res, err := r.db.ExecContext(ctx, "DELETE FROM assets"+where, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAssetNotFound
+	}
+	return nil
+}
+
+// SetOwner sets the user or team owning an asset ("" for none).
+func (r *AssetRepo) SetOwner(ctx context.Context, id int, owner string) (*models.Asset, error) {
+	where, args := scoped(ctx, "id=$2", false, owner, id)
+	res, err := r.db.ExecContext(ctx, "UPDATE assets SET owner=$1"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrAssetNotFound
+	}
+	return r.Get(ctx, id)
+}
+
+// SetLifecycle moves an asset to state and records the change with reason and the acting user (nil for the
+// system). Leaving the decommissioned state releases the asset's IP for discovery. Setting the current state
+// again records nothing.
+func (r *AssetRepo) SetLifecycle(ctx context.Context, id int, state, reason string, userID *int) (*models.Asset, error) {
+	return r.changeLifecycle(ctx, id, state, reason, nil, userID)
+}
+
+// Decommission marks an asset decommissioned with reason, keeping it and its history, and stops discovery
+// from recreating its IP until excludeUntil. Returns models.ErrAssetDecommissioned if it already is.
+func (r *AssetRepo) Decommission(ctx context.Context, id int, reason string, excludeUntil time.Time, userID *int) (*models.Asset, error) {
+	return r.changeLifecycle(ctx, id, models.LifecycleDecommissioned, reason, &excludeUntil, userID)
+}
+
+func (r *AssetRepo) changeLifecycle(ctx context.Context, id int, state, reason string, excludeUntil *time.Time, userID *int) (*models.Asset, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	where, args := scoped(ctx, "id=$1", false, id)
+	err = tx.QueryRowContext(ctx, "SELECT lifecycle FROM assets"+where+" FOR UPDATE", args...).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil, ErrAssetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current == state {
+		if state == models.LifecycleDecommissioned {
+			return nil, models.ErrAssetDecommissioned
+		}
+		return r.Get(ctx, id)
+	}
+
+	var until interface{}
+	if excludeUntil != nil {
+		until = *excludeUntil
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE assets SET lifecycle = $1, ip_excluded_until = $2 WHERE id = $3", state, until, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO asset_lifecycle_events (asset_id, from_state, to_state, reason, user_id) VALUES ($1, $2, $3, $4, $5)`,
+		id, current, state, reason, userID,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+// LifecycleEvents returns the lifecycle changes of an asset, oldest first.
+func (r *AssetRepo) LifecycleEvents(ctx context.Context, id int) ([]models.AssetLifecycleEvent, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, asset_id, from_state, to_state, reason, user_id, created_at FROM asset_lifecycle_events WHERE asset_id = $1 ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AssetLifecycleEvent{}
+	for rows.Next() {
+		var e models.AssetLifecycleEvent
+		var userID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.AssetID, &e.FromState, &e.ToState, &e.Reason, &userID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			uid := int(userID.Int64)
+			e.UserID = &uid
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
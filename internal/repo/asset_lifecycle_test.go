@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+var lifecycleAssetColumns = []string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}
+
+func TestAssetRepo_Decommission(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	until := time.Now().Add(30 * 24 * time.Hour)
+	userID := 3
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT lifecycle FROM assets WHERE id=\$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"lifecycle"}).AddRow("retired"))
+	mock.ExpectExec(`UPDATE assets SET lifecycle = \$1, ip_excluded_until = \$2 WHERE id = \$3`).
+		WithArgs("decommissioned", until, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO asset_lifecycle_events \(asset_id, from_state, to_state, reason, user_id\)`).
+		WithArgs(5, "retired", "decommissioned", "hardware failure", 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM assets WHERE id=\$1 AND deleted_at IS NULL`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(lifecycleAssetColumns).
+			AddRow(5, "pve3", "", "{}", nil, "10.0.0.13", "decommissioned", "infra", nil, until))
+
+	a, err := NewAssetRepo(db).Decommission(context.Background(), 5, "hardware failure", until, &userID)
+	if err != nil {
+		t.Fatalf("Decommission: %v", err)
+	}
+	if a.Lifecycle != models.LifecycleDecommissioned || a.IPExcludedUntil == nil || a.Owner != "infra" {
+		t.Errorf("unexpected asset: %+v", a)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetRepo_DecommissionTwice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT lifecycle FROM assets WHERE id=\$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"lifecycle"}).AddRow("decommissioned"))
+	mock.ExpectRollback()
+
+	_, err = NewAssetRepo(db).Decommission(context.Background(), 5, "again", time.Now(), nil)
+	if err != models.ErrAssetDecommissioned {
+		t.Errorf("Decommission: got %v, want ErrAssetDecommissioned", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetRepo_UpsertDiscoveredByIP_Excluded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// The asset holding 10.0.0.13 is in the trash or was just decommissioned: the scan must not recreate it.
+	mock.ExpectQuery(`FROM assets WHERE network_name=\$1 AND deleted_at IS NULL AND lifecycle <> 'decommissioned'`).
+		WithArgs("10.0.0.13").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM assets WHERE network_name=\$1 AND \(deleted_at IS NOT NULL OR ip_excluded_until > NOW\(\)\)\)`).
+		WithArgs("10.0.0.13").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	_, err = NewAssetRepo(db).UpsertDiscoveredByIP(context.Background(), "10.0.0.13", "pve3", "Discovered device")
+	if err != ErrIPExcluded {
+		t.Errorf("UpsertDiscoveredByIP: got %v, want ErrIPExcluded", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetRepo_RestoreAndPurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	deleted := time.Now()
+	mock.ExpectExec(`UPDATE assets SET deleted_at = NULL WHERE id=\$1 AND deleted_at IS NOT NULL`).
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM assets WHERE id=\$1 AND deleted_at IS NULL`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(lifecycleAssetColumns).AddRow(4, "web1", "", "{}", nil, "", "active", "", nil, nil))
+	mock.ExpectExec(`DELETE FROM assets WHERE id=\$1 AND deleted_at IS NOT NULL`).
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM assets WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id LIMIT \$1 OFFSET \$2`).
+		WithArgs(10, 0).
+		WillReturnRows(sqlmock.NewRows(lifecycleAssetColumns).AddRow(6, "old", "", "{}", nil, "", "retired", "", deleted, nil))
+
+	r := NewAssetRepo(db)
+	if a, err := r.Restore(context.Background(), 4); err != nil || a.DeletedAt != nil {
+		t.Errorf("Restore: got %+v, %v", a, err)
+	}
+	// Only assets in the trash can be purged.
+	if err := r.Purge(context.Background(), 4); err != ErrAssetNotFound {
+		t.Errorf("Purge of live asset: got %v, want ErrAssetNotFound", err)
+	}
+	trash, err := r.ListTrash(context.Background(), 10, 0)
+	if err != nil || len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Errorf("ListTrash: got %+v, %v", trash, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
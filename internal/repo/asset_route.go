@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// AssetRouteRepo persists the traceroute path to each asset recorded by scans.
+type AssetRouteRepo struct {
+	DB *sql.DB
+}
+
+// NewAssetRouteRepo returns a new AssetRouteRepo.
+func NewAssetRouteRepo(db *sql.DB) *AssetRouteRepo {
+	return &AssetRouteRepo{DB: db}
+}
+
+// Replace records hops (intermediate hop IPs, nearest first) as the path to an asset (latest scan wins).
+func (r *AssetRouteRepo) Replace(ctx context.Context, assetID int, hops []string) error {
+	if hops == nil {
+		hops = []string{}
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO asset_routes (asset_id, hops) VALUES ($1, $2) ON CONFLICT (asset_id) DO UPDATE SET hops = EXCLUDED.hops, traced_at = NOW()`,
+		assetID, pq.Array(hops),
+	)
+	return err
+}
+
+// ListForAssets returns the recorded hops of each of assetIDs that has a route.
+func (r *AssetRouteRepo) ListForAssets(ctx context.Context, assetIDs []int) (map[int][]string, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT asset_id, hops FROM asset_routes WHERE asset_id = ANY($1)`,
+		pq.Array(assetIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make(map[int][]string)
+	for rows.Next() {
+		var assetID int
+		var hops []string
+		if err := rows.Scan(&assetID, pq.Array(&hops)); err != nil {
+			return nil, err
+		}
+		routes[assetID] = hops
+	}
+	return routes, rows.Err()
+}
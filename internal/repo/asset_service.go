@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// AssetServiceRepo persists open ports discovered on assets by scans.
+type AssetServiceRepo struct {
+	DB *sql.DB
+}
+
+// NewAssetServiceRepo returns a new AssetServiceRepo.
+func NewAssetServiceRepo(db *sql.DB) *AssetServiceRepo {
+	return &AssetServiceRepo{DB: db}
+}
+
+// ReplaceForAsset replaces the recorded services of an asset with the given set (latest scan wins).
+func (r *AssetServiceRepo) ReplaceForAsset(ctx context.Context, assetID int, services []models.AssetService) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM asset_services WHERE asset_id = $1`, assetID); err != nil {
+		return err
+	}
+	for _, s := range services {
+		protocol := s.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO asset_services (asset_id, port, protocol, service) VALUES ($1, $2, $3, $4) ON CONFLICT (asset_id, port, protocol) DO UPDATE SET service = EXCLUDED.service, last_seen = NOW()`,
+			assetID, s.Port, protocol, s.Service,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListByAsset returns the services recorded for one asset, ordered by port.
+func (r *AssetServiceRepo) ListByAsset(ctx context.Context, assetID int) ([]models.AssetService, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT asset_id, port, protocol, service, last_seen FROM asset_services WHERE asset_id = $1 ORDER BY port, protocol`,
+		assetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAssetServiceRows(rows)
+}
+
+// ListAll returns every recorded service (used by service discovery exports).
+func (r *AssetServiceRepo) ListAll(ctx context.Context) ([]models.AssetService, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT asset_id, port, protocol, service, last_seen FROM asset_services ORDER BY asset_id, port, protocol`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAssetServiceRows(rows)
+}
+
+func scanAssetServiceRows(rows *sql.Rows) ([]models.AssetService, error) {
+	var list []models.AssetService
+	for rows.Next() {
+		var s models.AssetService
+		if err := rows.Scan(&s.AssetID, &s.Port, &s.Protocol, &s.Service, &s.LastSeen); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+func TestAssetServiceRepo_ReplaceForAsset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM asset_services WHERE asset_id = \$1`).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO asset_services \(asset_id, port, protocol, service\)`).
+		WithArgs(7, 22, "tcp", "ssh").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := NewAssetServiceRepo(db)
+	err = repo.ReplaceForAsset(context.Background(), 7, []models.AssetService{{Port: 22, Service: "ssh"}})
+	if err != nil {
+		t.Fatalf("ReplaceForAsset: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetServiceRepo_ListByAsset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT asset_id, port, protocol, service, last_seen FROM asset_services WHERE asset_id = \$1`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_id", "port", "protocol", "service", "last_seen"}).
+			AddRow(7, 22, "tcp", "ssh", time.Now()).
+			AddRow(7, 9100, "tcp", "jetdirect", time.Now()))
+
+	repo := NewAssetServiceRepo(db)
+	list, err := repo.ListByAsset(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("ListByAsset: %v", err)
+	}
+	if len(list) != 2 || list[1].Port != 9100 {
+		t.Errorf("unexpected services: %+v", list)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"testing"
 	"time"
 
@@ -42,10 +43,10 @@ func TestAssetRepo_Get(t *testing.T) {
 	defer db.Close()
 
 	now := time.Now()
-	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\) FROM assets WHERE id=\$1`).
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE id=\$1`).
 		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name"}).
-			AddRow(1, "a1", "desc1", "{}", now, ""))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "a1", "desc1", "{}", now, "", "active", "", nil, nil))
 
 	repo := NewAssetRepo(db)
 	asset, err := repo.Get(context.Background(), 1)
@@ -67,7 +68,7 @@ func TestAssetRepo_Get_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\) FROM assets WHERE id=\$1`).
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE id=\$1`).
 		WithArgs(999).
 		WillReturnError(sql.ErrNoRows)
 
@@ -76,7 +77,7 @@ func TestAssetRepo_Get_NotFound(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error for missing asset")
 	}
-	if err.Error() != "asset not found" {
+	if !errors.Is(err, ErrAssetNotFound) {
 		t.Errorf("unexpected error: %v", err)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -91,11 +92,11 @@ func TestAssetRepo_List(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\) FROM assets ORDER BY id LIMIT \$1 OFFSET \$2`).
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE deleted_at IS NULL ORDER BY id LIMIT \$1 OFFSET \$2`).
 		WithArgs(10, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name"}).
-			AddRow(1, "n1", "d1", "{}", nil, "").
-			AddRow(2, "n2", "d2", "{}", nil, ""))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "n1", "d1", "{}", nil, "", "active", "", nil, nil).
+			AddRow(2, "n2", "d2", "{}", nil, "", "active", "", nil, nil))
 
 	repo := NewAssetRepo(db)
 	assets, err := repo.List(context.Background(), 10, 0)
@@ -117,7 +118,8 @@ func TestAssetRepo_Delete(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectExec(`DELETE FROM assets WHERE id=\$1`).
+	// Deleting moves the asset to the trash.
+	mock.ExpectExec(`UPDATE assets SET deleted_at = NOW\(\) WHERE id=\$1 AND deleted_at IS NULL`).
 		WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
@@ -143,10 +145,10 @@ func TestAssetRepo_Heartbeat(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	now := time.Now()
-	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\) FROM assets WHERE id=\$1`).
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE id=\$1`).
 		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name"}).
-			AddRow(1, "a1", "d1", "{}", now, ""))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "a1", "d1", "{}", now, "", "active", "", nil, nil))
 
 	repo := NewAssetRepo(db)
 	asset, err := repo.Heartbeat(context.Background(), 1)
@@ -160,3 +162,77 @@ func TestAssetRepo_Heartbeat(t *testing.T) {
 		t.Errorf("expectations: %v", err)
 	}
 }
+
+func TestAssetRepo_ScopedQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	ctx := WithAssetScope(context.Background(), []string{"team-a"})
+	mock.ExpectQuery(`SELECT id, name, description, COALESCE\(tags, '{}'\), last_seen, COALESCE\(network_name, ''\), lifecycle, owner, deleted_at, ip_excluded_until FROM assets WHERE \(LOWER\(name\) LIKE \$1 OR LOWER\(description\) LIKE \$1\) AND tags && \$2 AND deleted_at IS NULL ORDER BY id LIMIT \$3 OFFSET \$4`).
+		WithArgs("%web%", pq.Array([]string{"team-a"}), 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(1, "web-1", "", "{team-a}", nil, "", "active", "", nil, nil))
+	mock.ExpectExec(`UPDATE assets SET deleted_at = NOW\(\) WHERE id=\$1 AND tags && \$2`).
+		WithArgs(2, pq.Array([]string{"team-a"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewAssetRepo(db)
+	assets, err := repo.Search(ctx, "web", 10, 0)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(assets) != 1 || assets[0].Name != "web-1" {
+		t.Errorf("unexpected assets: %+v", assets)
+	}
+	// An asset outside the scope looks like it does not exist.
+	if err := repo.Delete(ctx, 2); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("Delete out of scope: got %v, want ErrAssetNotFound", err)
+	}
+	if !InAssetScope(ctx, []string{"db", "team-a"}) || InAssetScope(ctx, []string{"team-b"}) {
+		t.Error("InAssetScope: unexpected result")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAssetRepo_UpsertDiscoveredByIP_PerSite(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// 10.0.0.5 already exists on the default site; discovering it on site 2 must create a separate asset.
+	ctx := WithSite(context.Background(), 2)
+	mock.ExpectQuery(`FROM assets WHERE network_name=\$1 AND deleted_at IS NULL AND lifecycle <> 'decommissioned' AND site_id = \$2`).
+		WithArgs("10.0.0.5", 2).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM assets WHERE network_name=\$1 AND \(deleted_at IS NOT NULL OR ip_excluded_until > NOW\(\)\) AND site_id = \$2\)`).
+		WithArgs("10.0.0.5", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO assets \(name, description, tags, site_id\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id`).
+		WithArgs("pve-2", "Discovered device", "{}", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(`UPDATE assets SET network_name = \$1 WHERE id = \$2`).
+		WithArgs("10.0.0.5", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM assets WHERE id=\$1 AND site_id = \$2`).
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "tags", "last_seen", "network_name", "lifecycle", "owner", "deleted_at", "ip_excluded_until"}).
+			AddRow(7, "pve-2", "Discovered device", "{}", nil, "10.0.0.5", "active", "", nil, nil))
+
+	a, err := NewAssetRepo(db).UpsertDiscoveredByIP(ctx, "10.0.0.5", "pve-2", "Discovered device")
+	if err != nil {
+		t.Fatalf("UpsertDiscoveredByIP: %v", err)
+	}
+	if a.ID != 7 || a.NetworkName != "10.0.0.5" {
+		t.Errorf("unexpected asset: %+v", a)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
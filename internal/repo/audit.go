@@ -3,6 +3,8 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/crucial707/hci-asset/internal/models"
 )
@@ -17,27 +19,112 @@ func NewAuditRepo(db *sql.DB) *AuditRepo {
 	return &AuditRepo{db: db}
 }
 
-// Log records an audit entry. action is create|update|delete; resourceType is asset|user.
+const auditColumns = `id, COALESCE(user_id, 0), action, resource_type, resource_id, actor_type, actor, ip, user_agent, request_id,
+	COALESCE(details,''), created_at, prev_hash, hash, hash_version`
+
+func scanAuditEntry(rows *sql.Rows) (models.AuditEntry, error) {
+	var e models.AuditEntry
+	err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.ResourceType, &e.ResourceID, &e.ActorType, &e.Actor, &e.IP, &e.UserAgent,
+		&e.RequestID, &e.Details, &e.CreatedAt, &e.PrevHash, &e.Hash, &e.HashVersion)
+	return e, err
+}
+
+// Log records an audit entry for a user. action is create|update|delete|...; resourceType is asset|user|....
 func (r *AuditRepo) Log(ctx context.Context, userID int, action, resourceType string, resourceID int, details string) error {
+	return r.Record(ctx, models.AuditEntry{
+		UserID: userID, Action: action, ResourceType: resourceType, ResourceID: resourceID, Details: details,
+	})
+}
+
+// LogSystem records an audit entry for the system component actor (e.g. "scheduler") rather than a user.
+func (r *AuditRepo) LogSystem(ctx context.Context, actor, action, resourceType string, resourceID int, details string) error {
+	return r.Record(ctx, models.AuditEntry{
+		ActorType: models.AuditActorSystem, Actor: actor, Action: action, ResourceType: resourceType, ResourceID: resourceID,
+		Details: details,
+	})
+}
+
+// Record stores e. User entries (the default ActorType) get the user's current username as Actor unless set;
+// system entries have no user ID. The audit_log_chain trigger links the entry to the previous one (prev_hash,
+// hash).
+func (r *AuditRepo) Record(ctx context.Context, e models.AuditEntry) error {
+	var userID interface{}
+	if e.ActorType == "" {
+		e.ActorType = models.AuditActorUser
+	}
+	if e.ActorType == models.AuditActorUser {
+		userID = e.UserID
+	}
 	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO audit_log (user_id, action, resource_type, resource_id, details) VALUES ($1, $2, $3, $4, $5)`,
-		userID, action, resourceType, resourceID, details,
+		`INSERT INTO audit_log (user_id, action, resource_type, resource_id, details, actor_type, actor, ip, user_agent, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), (SELECT username FROM users WHERE id = $1), ''), $8, $9, $10)`,
+		userID, e.Action, e.ResourceType, e.ResourceID, e.Details, e.ActorType, e.Actor, e.IP, e.UserAgent, e.RequestID,
 	)
 	return err
 }
 
-// Count returns the total number of audit entries.
-func (r *AuditRepo) Count(ctx context.Context) (int, error) {
+// Count returns the number of audit entries matching f.
+func (r *AuditRepo) Count(ctx context.Context, f models.AuditFilter) (int, error) {
+	where, args := auditWhere(ctx, f)
 	var n int
-	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log").Scan(&n)
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&n)
 	return n, err
 }
 
-// List returns recent audit entries, newest first.
-func (r *AuditRepo) List(ctx context.Context, limit, offset int) ([]models.AuditEntry, error) {
+// auditWhere builds the WHERE clause for f; args are numbered from $1. Callers restricted by access policies
+// only see entries about assets they can see.
+func auditWhere(ctx context.Context, f models.AuditFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if cond, scopeArgs := assetScopeFilter(ctx, 1); cond != "" {
+		conds = append(conds, "resource_type = 'asset' AND resource_id IN (SELECT id FROM assets WHERE "+cond+")")
+		args = scopeArgs
+	}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.UserID > 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.Actor != "" {
+		add("LOWER(actor) = LOWER($%d)", f.Actor)
+	}
+	if f.ActorType != "" {
+		add("actor_type = $%d", f.ActorType)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.ResourceType != "" {
+		add("resource_type = $%d", f.ResourceType)
+	}
+	if f.ResourceID > 0 {
+		add("resource_id = $%d", f.ResourceID)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if f.Query != "" {
+		add("STRPOS(LOWER(details), LOWER($%d)) > 0", f.Query)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// List returns audit entries matching f, newest first.
+func (r *AuditRepo) List(ctx context.Context, f models.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	where, args := auditWhere(ctx, f)
+	args = append(args, limit, offset)
 	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, user_id, action, resource_type, resource_id, COALESCE(details,''), created_at FROM audit_log ORDER BY created_at DESC LIMIT $1 OFFSET $2`,
-		limit, offset,
+		`SELECT `+auditColumns+` FROM audit_log`+where+
+			fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args)),
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -46,11 +133,105 @@ func (r *AuditRepo) List(ctx context.Context, limit, offset int) ([]models.Audit
 
 	var entries []models.AuditEntry
 	for rows.Next() {
-		var e models.AuditEntry
-		if err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.ResourceType, &e.ResourceID, &e.Details, &e.CreatedAt); err != nil {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
 			return nil, err
 		}
 		entries = append(entries, e)
 	}
 	return entries, rows.Err()
 }
+
+// Export calls fn for every audit entry matching f, oldest first, reading them as it goes so exports of the
+// whole log do not load it into memory. It stops at the first error fn returns.
+func (r *AuditRepo) Export(ctx context.Context, f models.AuditFilter, fn func(models.AuditEntry) error) error {
+	where, args := auditWhere(ctx, f)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Head returns the ID and hash of the latest audit entry (0 and "" when the log is empty).
+func (r *AuditRepo) Head(ctx context.Context) (int, string, error) {
+	var id int
+	var hash string
+	err := r.db.QueryRowContext(ctx, `SELECT id, hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return id, hash, err
+}
+
+// Verify walks the whole audit log in ID order and checks that every entry links to the previous one and that
+// its hash matches its contents. checkpoints maps entry IDs to the hash recorded in a signed checkpoint; those
+// entries must still exist with that hash, unless they are older than the oldest entry (pruned). Verification
+// stops at the first broken link.
+func (r *AuditRepo) Verify(ctx context.Context, checkpoints map[int]string) (*models.AuditVerification, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	v := &models.AuditVerification{}
+	seen := make(map[int]bool)
+	broken := func(id int, reason string) (*models.AuditVerification, error) {
+		v.BrokenLink = &models.AuditBrokenLink{ID: id, Reason: reason}
+		return v, nil
+	}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if v.Checked == 0 {
+			v.FirstID = e.ID
+		} else if e.PrevHash != v.LastHash {
+			return broken(e.ID, fmt.Sprintf("prev_hash does not match entry %d: an entry before it was deleted or changed", v.LastID))
+		}
+		if e.ChainHash() != e.Hash {
+			return broken(e.ID, "hash does not match the entry's contents: the entry was changed")
+		}
+		if want, ok := checkpoints[e.ID]; ok {
+			v.Checkpoints++
+			seen[e.ID] = true
+			if want != e.Hash {
+				return broken(e.ID, "hash does not match the signed checkpoint: the chain was rewritten")
+			}
+		}
+		v.Checked++
+		v.LastID, v.LastHash = e.ID, e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Checkpointed entries that were not walked were deleted (pruned entries are older than FirstID).
+	missing := 0
+	for id := range checkpoints {
+		if seen[id] || (v.Checked > 0 && id < v.FirstID) {
+			continue
+		}
+		if missing == 0 || id < missing {
+			missing = id
+		}
+	}
+	if missing != 0 {
+		return broken(missing, "entry in a signed checkpoint is missing: entries were deleted")
+	}
+	v.Valid = true
+	return v, nil
+}
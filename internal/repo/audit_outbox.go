@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+// ForwardOutbox passes up to limit entries queued in the audit outbox to send, oldest first, and removes the
+// ones send reports as delivered (the first n). The batch runs under a transaction-scoped advisory lock so only
+// one API instance forwards at a time and entries reach the collector in order; when another instance holds the
+// lock, send is not called and 0 is returned.
+func (r *AuditRepo) ForwardOutbox(ctx context.Context, limit int, send func([]models.AuditEntry) (int, error)) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext('audit_outbox'))`).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+auditColumns+` FROM audit_log WHERE id IN (SELECT audit_id FROM audit_outbox ORDER BY audit_id LIMIT $1)
+		ORDER BY id`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var entries []models.AuditEntry
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	n, sendErr := send(entries)
+	if n > 0 {
+		ids := make([]int64, n)
+		for i, e := range entries[:n] {
+			ids[i] = int64(e.ID)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM audit_outbox WHERE audit_id = ANY($1)`, pq.Array(ids)); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return n, sendErr
+}
+
+// OutboxBacklog returns the number of entries waiting to be forwarded and the time the oldest of them was
+// logged (zero when the outbox is empty).
+func (r *AuditRepo) OutboxBacklog(ctx context.Context) (int, time.Time, error) {
+	var n int
+	var oldest sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), MIN(a.created_at) FROM audit_outbox o JOIN audit_log a ON a.id = o.audit_id`).Scan(&n, &oldest)
+	return n, oldest.Time, err
+}
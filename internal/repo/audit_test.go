@@ -0,0 +1,293 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+func TestAuditEntry_ChainHash(t *testing.T) {
+	// Same format as the audit_log_hash database function (prev_hash, id, created_at in UTC with microseconds,
+	// user_id, action, resource_type, resource_id, details, joined by newlines).
+	e := models.AuditEntry{
+		ID: 1, UserID: 7, Action: "create", ResourceType: "asset", ResourceID: 42, Details: "name: web01",
+		CreatedAt: time.Date(2026, 3, 18, 11, 0, 0, 123456000, time.FixedZone("CET", 3600)),
+	}
+	if got, want := e.ChainHash(), "4ed96ac754c83b1355183640d21378482c66c188638ffa1240f81d6a675b6ccd"; got != want {
+		t.Errorf("ChainHash: got %s, want %s", got, want)
+	}
+}
+
+func TestAuditEntry_ChainHashV2(t *testing.T) {
+	// Same format as audit_log_hash_v2: prev_hash, "v2", id, created_at, user_id ("" for system actors), action,
+	// resource_type, resource_id, actor_type, actor, ip, user_agent, request_id, details.
+	tests := []struct {
+		name string
+		e    models.AuditEntry
+		want string
+	}{
+		{
+			name: "user",
+			e: models.AuditEntry{
+				ID: 3, UserID: 7, Action: "update", ResourceType: "asset", ResourceID: 42,
+				ActorType: models.AuditActorUser, Actor: "alice", IP: "192.0.2.10", UserAgent: "curl/8.0",
+				RequestID: "host/abc-000001", Details: `{"after":{"name":"web02"},"before":{"name":"web01"}}`,
+				CreatedAt: time.Date(2026, 3, 19, 10, 0, 0, 0, time.UTC), PrevHash: "abc", HashVersion: 2,
+			},
+			want: "bdfe7c9df4b026b866d8d9f322f848f21926122b329e353af6b3e3ee4626a5ad",
+		},
+		{
+			name: "system",
+			e: models.AuditEntry{
+				ID: 2, Action: "run", ResourceType: "schedule", ResourceID: 5,
+				ActorType: models.AuditActorSystem, Actor: models.AuditSystemScheduler, Details: `{"job_id":"17"}`,
+				CreatedAt: time.Date(2026, 3, 19, 10, 0, 0, 123456000, time.UTC), HashVersion: 2,
+			},
+			want: "0ee6a48325e7b3113e0c4cb2f51cc19b067f2779771e76cd7ea1423abc6382c5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.e.ChainHash(); got != tt.want {
+				t.Errorf("ChainHash: got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditRepo_Record(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	r := NewAuditRepo(db)
+
+	// User entries default to the user actor type; the username is filled in by the insert.
+	mock.ExpectExec(`INSERT INTO audit_log .* COALESCE\(NULLIF\(\$7, ''\), \(SELECT username FROM users WHERE id = \$1\), ''\)`).
+		WithArgs(7, "delete", "asset", 42, "", models.AuditActorUser, "", "192.0.2.10", "curl/8.0", "req-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// System entries have no user ID.
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(nil, "disable", "schedule", 5, "", models.AuditActorSystem, models.AuditSystemScheduler, "", "", "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	err = r.Record(context.Background(), models.AuditEntry{
+		UserID: 7, Action: "delete", ResourceType: "asset", ResourceID: 42, IP: "192.0.2.10", UserAgent: "curl/8.0", RequestID: "req-1",
+	})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := r.LogSystem(context.Background(), models.AuditSystemScheduler, "disable", "schedule", 5, ""); err != nil {
+		t.Fatalf("LogSystem: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestAuditRepo_ListFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	r := NewAuditRepo(db)
+
+	since := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	f := models.AuditFilter{Actor: "Alice", ResourceType: "asset", ResourceID: 42, Since: since, Query: "rack"}
+	mock.ExpectQuery(`SELECT .* FROM audit_log WHERE LOWER\(actor\) = LOWER\(\$1\) AND resource_type = \$2 AND resource_id = \$3 `+
+		`AND created_at >= \$4 AND STRPOS\(LOWER\(details\), LOWER\(\$5\)\) > 0 ORDER BY created_at DESC, id DESC LIMIT \$6 OFFSET \$7`).
+		WithArgs("Alice", "asset", 42, since, "rack", 50, 0).
+		WillReturnRows(auditRows(auditChain(1)))
+	// Access-policy scope comes first so its placeholders start at $1.
+	scoped := WithAssetScope(context.Background(), []string{"prod"})
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM audit_log WHERE resource_type = 'asset' AND resource_id IN \(SELECT id FROM assets WHERE .*\$1.*\) AND action = \$2`).
+		WithArgs(sqlmock.AnyArg(), "delete").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(`SELECT .* FROM audit_log ORDER BY id$`).
+		WillReturnRows(auditRows(auditChain(3)))
+
+	entries, err := r.List(context.Background(), f, 50, 0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("List: %v, %d entries", err, len(entries))
+	}
+	n, err := r.Count(scoped, models.AuditFilter{Action: "delete"})
+	if err != nil || n != 4 {
+		t.Fatalf("Count: %v, %d", err, n)
+	}
+	var ids []int
+	err = r.Export(context.Background(), models.AuditFilter{}, func(e models.AuditEntry) error {
+		ids = append(ids, e.ID)
+		return nil
+	})
+	if err != nil || len(ids) != 3 || ids[0] != 1 {
+		t.Fatalf("Export: %v, ids %v", err, ids)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+// auditChain returns n chained entries with IDs 1..n.
+func auditChain(n int) []models.AuditEntry {
+	entries := make([]models.AuditEntry, n)
+	prev := ""
+	for i := range entries {
+		e := models.AuditEntry{
+			ID: i + 1, UserID: 1, Action: "update", ResourceType: "asset", ResourceID: 10 + i,
+			CreatedAt: time.Date(2026, 3, 18, 10, i, 0, 0, time.UTC), PrevHash: prev,
+		}
+		e.Hash = e.ChainHash()
+		prev = e.Hash
+		entries[i] = e
+	}
+	return entries
+}
+
+func auditRows(entries []models.AuditEntry) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "action", "resource_type", "resource_id", "actor_type", "actor", "ip",
+		"user_agent", "request_id", "details", "created_at", "prev_hash", "hash", "hash_version"})
+	for _, e := range entries {
+		rows.AddRow(e.ID, e.UserID, e.Action, e.ResourceType, e.ResourceID, e.ActorType, e.Actor, e.IP, e.UserAgent,
+			e.RequestID, e.Details, e.CreatedAt, e.PrevHash, e.Hash, e.HashVersion)
+	}
+	return rows
+}
+
+func TestAuditRepo_Verify(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     func() []models.AuditEntry
+		checkpoints func(chain []models.AuditEntry) map[int]string
+		wantBroken  int
+		wantReason  string
+		wantChecked int
+	}{
+		{
+			name:        "valid",
+			entries:     func() []models.AuditEntry { return auditChain(4) },
+			checkpoints: func(c []models.AuditEntry) map[int]string { return map[int]string{2: c[1].Hash, 4: c[3].Hash} },
+			wantChecked: 4,
+		},
+		{
+			// Entries logged after the actor columns were added use hash version 2.
+			name: "upgraded chain",
+			entries: func() []models.AuditEntry {
+				c := auditChain(4)
+				for i := 2; i < len(c); i++ {
+					c[i].PrevHash = c[i-1].Hash
+					c[i].HashVersion = 2
+					c[i].ActorType = models.AuditActorSystem
+					c[i].Actor = models.AuditSystemScheduler
+					c[i].UserID = 0
+					c[i].Hash = c[i].ChainHash()
+				}
+				return c
+			},
+			wantChecked: 4,
+		},
+		{
+			name: "changed actor",
+			entries: func() []models.AuditEntry {
+				c := auditChain(4)
+				c[3].HashVersion = 2
+				c[3].Actor = "alice"
+				c[3].Hash = c[3].ChainHash()
+				c[3].Actor = "bob"
+				return c
+			},
+			wantBroken:  4,
+			wantReason:  "contents",
+			wantChecked: 3,
+		},
+		{
+			name: "pruned head",
+			entries: func() []models.AuditEntry {
+				return auditChain(4)[2:]
+			},
+			checkpoints: func(c []models.AuditEntry) map[int]string { return map[int]string{1: "gone", 4: c[1].Hash} },
+			wantChecked: 2,
+		},
+		{
+			name: "changed entry",
+			entries: func() []models.AuditEntry {
+				c := auditChain(4)
+				c[2].Details = "rewritten"
+				return c
+			},
+			wantBroken:  3,
+			wantReason:  "contents",
+			wantChecked: 2,
+		},
+		{
+			name: "deleted entry",
+			entries: func() []models.AuditEntry {
+				c := auditChain(4)
+				return append(c[:1], c[2:]...)
+			},
+			wantBroken:  3,
+			wantReason:  "prev_hash",
+			wantChecked: 1,
+		},
+		{
+			name:        "rewritten chain",
+			entries:     func() []models.AuditEntry { return auditChain(4) },
+			checkpoints: func(c []models.AuditEntry) map[int]string { return map[int]string{3: strings.Repeat("0", 64)} },
+			wantBroken:  3,
+			wantReason:  "checkpoint",
+			wantChecked: 2,
+		},
+		{
+			name:        "truncated tail",
+			entries:     func() []models.AuditEntry { return auditChain(4)[:3] },
+			checkpoints: func(c []models.AuditEntry) map[int]string { return map[int]string{4: "deleted"} },
+			wantBroken:  4,
+			wantReason:  "missing",
+			wantChecked: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New: %v", err)
+			}
+			defer db.Close()
+
+			entries := tt.entries()
+			var checkpoints map[int]string
+			if tt.checkpoints != nil {
+				checkpoints = tt.checkpoints(entries)
+			}
+			mock.ExpectQuery(`SELECT id, COALESCE\(user_id, 0\), action, .* FROM audit_log ORDER BY id`).WillReturnRows(auditRows(entries))
+
+			v, err := NewAuditRepo(db).Verify(context.Background(), checkpoints)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if v.Checked != tt.wantChecked {
+				t.Errorf("Checked: got %d, want %d", v.Checked, tt.wantChecked)
+			}
+			if tt.wantBroken == 0 {
+				if !v.Valid || v.BrokenLink != nil {
+					t.Fatalf("expected a valid chain, got %+v", v.BrokenLink)
+				}
+				if last := entries[len(entries)-1]; v.LastID != last.ID || v.LastHash != last.Hash {
+					t.Errorf("head: got %d %s, want %d %s", v.LastID, v.LastHash, last.ID, last.Hash)
+				}
+				return
+			}
+			if v.Valid || v.BrokenLink == nil {
+				t.Fatal("expected a broken chain")
+			}
+			if v.BrokenLink.ID != tt.wantBroken || !strings.Contains(v.BrokenLink.Reason, tt.wantReason) {
+				t.Errorf("broken link: got %+v, want entry %d (%s)", v.BrokenLink, tt.wantBroken, tt.wantReason)
+			}
+		})
+	}
+}
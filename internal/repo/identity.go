@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// ErrIdentityNotFound is returned when no user is linked to an external identity.
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// IdentityRepo links users to external (OIDC) identities.
+type IdentityRepo struct {
+	DB *sql.DB
+}
+
+// NewIdentityRepo returns a new IdentityRepo.
+func NewIdentityRepo(db *sql.DB) *IdentityRepo {
+	return &IdentityRepo{DB: db}
+}
+
+// FindUser returns the user linked to issuer/subject and records the login, or ErrIdentityNotFound.
+func (r *IdentityRepo) FindUser(ctx context.Context, issuer, subject string) (*models.User, error) {
+	user := &models.User{}
+	err := r.DB.QueryRowContext(ctx,
+		`UPDATE user_identities i SET last_login_at = NOW() FROM users u WHERE u.id = i.user_id AND i.issuer = $1 AND i.subject = $2 RETURNING u.id, u.username, u.role`,
+		issuer, subject,
+	).Scan(&user.ID, &user.Username, &user.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser provisions a password-less user linked to issuer/subject (just-in-time provisioning).
+func (r *IdentityRepo) CreateUser(ctx context.Context, issuer, subject, email, username, role string) (*models.User, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user := &models.User{}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO users (username, password_hash, role) VALUES ($1, NULL, $2) RETURNING id, username, role`,
+		username, role,
+	).Scan(&user.ID, &user.Username, &user.Role); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO user_identities (issuer, subject, user_id, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())`,
+		issuer, subject, user.ID, email,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// HasIdentity reports whether userID is linked to any external identity (such users may not log in locally without a password).
+func (r *IdentityRepo) HasIdentity(ctx context.Context, userID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+// Link attaches issuer/subject to an existing user (e.g. a directory account an admin created ahead of time).
+func (r *IdentityRepo) Link(ctx context.Context, issuer, subject string, userID int, email string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO user_identities (issuer, subject, user_id, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())`,
+		issuer, subject, userID, email,
+	)
+	return err
+}
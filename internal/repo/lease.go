@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// LeaseRepo stores named leases with a TTL, used to elect one instance to run a background job.
+type LeaseRepo struct {
+	DB *sql.DB
+}
+
+// NewLeaseRepo returns a new LeaseRepo.
+func NewLeaseRepo(db *sql.DB) *LeaseRepo {
+	return &LeaseRepo{DB: db}
+}
+
+// Acquire takes the lease name for holder for ttl, or renews it if holder already has it. It returns false
+// when another holder's lease has not expired yet. Expiry is compared against the database clock so instances
+// with skewed clocks agree.
+func (r *LeaseRepo) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	var got string
+	err := r.DB.QueryRowContext(ctx, `
+		INSERT INTO leases (name, holder, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW(), NOW() + make_interval(secs => $3))
+		ON CONFLICT (name) DO UPDATE SET
+			holder = EXCLUDED.holder,
+			acquired_at = CASE WHEN leases.holder = EXCLUDED.holder THEN leases.acquired_at ELSE NOW() END,
+			renewed_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < NOW()
+		RETURNING holder`,
+		name, holder, ttl.Seconds(),
+	).Scan(&got)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return got == holder, nil
+}
+
+// Release gives up the lease name if holder has it, so another instance can take over without waiting for it
+// to expire.
+func (r *LeaseRepo) Release(ctx context.Context, name, holder string) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM leases WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
+
+// Get returns the unexpired lease name, or nil if nobody holds it.
+func (r *LeaseRepo) Get(ctx context.Context, name string) (*models.Lease, error) {
+	l := &models.Lease{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT name, holder, acquired_at, renewed_at, expires_at FROM leases WHERE name = $1 AND expires_at >= NOW()`,
+		name,
+	).Scan(&l.Name, &l.Holder, &l.AcquiredAt, &l.RenewedAt, &l.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
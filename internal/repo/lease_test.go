@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLeaseRepo_Acquire(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`INSERT INTO leases .* ON CONFLICT \(name\) DO UPDATE .* WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < NOW\(\)`).
+		WithArgs("scheduler", "api-1", float64(30)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("api-1"))
+	// Held by another instance: the conditional update matches no row.
+	mock.ExpectQuery(`INSERT INTO leases`).
+		WithArgs("scheduler", "api-2", float64(30)).
+		WillReturnError(sql.ErrNoRows)
+
+	r := NewLeaseRepo(db)
+	ok, err := r.Acquire(context.Background(), "scheduler", "api-1", 30*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("Acquire api-1: ok=%v err=%v, want true", ok, err)
+	}
+	ok, err = r.Acquire(context.Background(), "scheduler", "api-2", 30*time.Second)
+	if err != nil || ok {
+		t.Fatalf("Acquire api-2: ok=%v err=%v, want false", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaseRepo_Get_Expired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT name, holder, acquired_at, renewed_at, expires_at FROM leases WHERE name = \$1 AND expires_at >= NOW\(\)`).
+		WithArgs("scheduler").
+		WillReturnError(sql.ErrNoRows)
+
+	lease, err := NewLeaseRepo(db).Get(context.Background(), "scheduler")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if lease != nil {
+		t.Errorf("expected no lease, got %+v", lease)
+	}
+}
@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// LoginEventRepo records login attempts and per-account lockouts.
+type LoginEventRepo struct {
+	DB *sql.DB
+}
+
+// NewLoginEventRepo returns a new LoginEventRepo.
+func NewLoginEventRepo(db *sql.DB) *LoginEventRepo {
+	return &LoginEventRepo{DB: db}
+}
+
+// Record stores one login attempt. e.UserID may be nil for unknown accounts.
+func (r *LoginEventRepo) Record(ctx context.Context, e models.LoginEvent) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO login_events (user_id, username, method, result, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6)`,
+		e.UserID, e.Username, e.Method, e.Result, e.IP, e.UserAgent,
+	)
+	return err
+}
+
+// loginEventWhere builds the WHERE clause for f; args are numbered from $1.
+func loginEventWhere(f models.LoginEventFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Username != "" {
+		add("LOWER(username) = LOWER($%d)", f.Username)
+	}
+	if f.UserID > 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.Result != "" {
+		add("result = $%d", f.Result)
+	}
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Count returns the number of login events matching f.
+func (r *LoginEventRepo) Count(ctx context.Context, f models.LoginEventFilter) (int, error) {
+	where, args := loginEventWhere(f)
+	var n int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_events"+where, args...).Scan(&n)
+	return n, err
+}
+
+// List returns login events matching f, newest first.
+func (r *LoginEventRepo) List(ctx context.Context, f models.LoginEventFilter, limit, offset int) ([]models.LoginEvent, error) {
+	where, args := loginEventWhere(f)
+	args = append(args, limit, offset)
+	rows, err := r.DB.QueryContext(ctx,
+		fmt.Sprintf(`SELECT id, user_id, username, method, result, ip, user_agent, created_at FROM login_events%s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+			where, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var e models.LoginEvent
+		var userID sql.NullInt64
+		if err := rows.Scan(&e.ID, &userID, &e.Username, &e.Method, &e.Result, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			e.UserID = &id
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// LockedUntil returns when the account's lockout ends, or the zero time if it is not locked.
+func (r *LoginEventRepo) LockedUntil(ctx context.Context, username string) (time.Time, error) {
+	var until sql.NullTime
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT locked_until FROM login_lockouts WHERE username = LOWER($1) AND locked_until > NOW()`,
+		username,
+	).Scan(&until)
+	if err == sql.ErrNoRows || !until.Valid {
+		return time.Time{}, nil
+	}
+	return until.Time, err
+}
+
+// RecordFailure counts a failed attempt and returns the number of consecutive failures. Failures older
+// than window no longer count, so the counter starts again at 1.
+func (r *LoginEventRepo) RecordFailure(ctx context.Context, username string, window time.Duration) (int, error) {
+	var failures int
+	err := r.DB.QueryRowContext(ctx,
+		`INSERT INTO login_lockouts (username, failures, last_failure_at) VALUES (LOWER($1), 1, NOW())
+		ON CONFLICT (username) DO UPDATE SET
+			failures = CASE WHEN login_lockouts.last_failure_at < NOW() - make_interval(secs => $2) THEN 1 ELSE login_lockouts.failures + 1 END,
+			last_failure_at = NOW()
+		RETURNING failures`,
+		username, window.Seconds(),
+	).Scan(&failures)
+	return failures, err
+}
+
+// PruneLockouts deletes the lockout state that no longer has an effect: failures older than window and no
+// active lock. Names that do not exist get a row like real accounts, so this keeps the table from growing
+// with every name an attacker tries.
+func (r *LoginEventRepo) PruneLockouts(ctx context.Context, window time.Duration) error {
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM login_lockouts WHERE last_failure_at < NOW() - make_interval(secs => $1) AND (locked_until IS NULL OR locked_until < NOW())`,
+		window.Seconds(),
+	)
+	return err
+}
+
+// Lock locks the account for d.
+func (r *LoginEventRepo) Lock(ctx context.Context, username string, d time.Duration) (time.Time, error) {
+	var until time.Time
+	err := r.DB.QueryRowContext(ctx,
+		`UPDATE login_lockouts SET locked_until = NOW() + make_interval(secs => $2) WHERE username = LOWER($1) RETURNING locked_until`,
+		username, d.Seconds(),
+	).Scan(&until)
+	return until, err
+}
+
+// ResetFailures clears the account's failure count and lockout (after a successful login or an admin unlock).
+func (r *LoginEventRepo) ResetFailures(ctx context.Context, username string) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM login_lockouts WHERE username = LOWER($1)`, username)
+	return err
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+var (
+	// ErrMFANotEnrolled is returned when a user has not started TOTP enrollment.
+	ErrMFANotEnrolled = errors.New("mfa not enrolled")
+	// ErrMFAAlreadyEnabled is returned when starting or confirming enrollment for a user who already has MFA.
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+)
+
+// MFARepo stores TOTP secrets and recovery codes.
+type MFARepo struct {
+	DB *sql.DB
+}
+
+// NewMFARepo returns a new MFARepo.
+func NewMFARepo(db *sql.DB) *MFARepo {
+	return &MFARepo{DB: db}
+}
+
+// Get returns the user's enrollment (confirmed or pending), or ErrMFANotEnrolled.
+func (r *MFARepo) Get(ctx context.Context, userID int) (*models.UserMFA, error) {
+	m := &models.UserMFA{UserID: userID}
+	var enabledAt sql.NullTime
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT secret, enabled_at, last_used_step, (SELECT COUNT(*) FROM mfa_recovery_codes c WHERE c.user_id = m.user_id AND c.used_at IS NULL) FROM user_mfa m WHERE m.user_id = $1`,
+		userID,
+	).Scan(&m.Secret, &enabledAt, &m.LastUsedStep, &m.RecoveryCodesRemaining)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		m.EnabledAt = &enabledAt.Time
+	}
+	return m, nil
+}
+
+// StartEnrollment stores a new pending secret, replacing any earlier unconfirmed one.
+// Returns ErrMFAAlreadyEnabled when MFA is already active.
+func (r *MFARepo) StartEnrollment(ctx context.Context, userID int, secret string) error {
+	var id int
+	err := r.DB.QueryRowContext(ctx,
+		`INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.enabled_at IS NULL
+		RETURNING user_id`,
+		userID, secret,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrMFAAlreadyEnabled
+	}
+	return err
+}
+
+// Enable confirms a pending enrollment (step is the TOTP step of the verifying code) and stores recovery codes.
+func (r *MFARepo) Enable(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE user_mfa SET enabled_at = NOW(), last_used_step = $2 WHERE user_id = $1 AND enabled_at IS NULL`,
+		userID, step,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes and stores new ones.
+func (r *MFARepo) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashToken(c),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep records step as the last accepted TOTP step. It returns false when step is not newer than the last
+// one, i.e. the code was already used (replay protection).
+func (r *MFARepo) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns false when the code is unknown or already used.
+func (r *MFARepo) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// Reset removes the user's enrollment and recovery codes. It returns false when the user had none.
+func (r *MFARepo) Reset(ctx context.Context, userID int) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
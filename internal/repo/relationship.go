@@ -0,0 +1,217 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrRelationshipExists is returned when the same typed relationship between two assets is created twice.
+	ErrRelationshipExists = errors.New("relationship already exists")
+	// ErrRelationshipCycle is returned when a relationship would make an asset impact itself.
+	ErrRelationshipCycle = errors.New("relationship would create a cycle")
+)
+
+// maxImpactDepth bounds how many relationships Impacted follows from the failed asset.
+const maxImpactDepth = 32
+
+// impactEdges lists relationships as "src going down impacts dst": an asset is impacted by the asset it runs on
+// or depends on, and a cluster or pool by its members. backs_up does not propagate outages.
+const impactEdges = `SELECT related_asset_id AS src, asset_id AS dst FROM asset_relationships WHERE type IN ('runs_on', 'depends_on')
+	UNION ALL SELECT asset_id, related_asset_id FROM asset_relationships WHERE type = 'member_of'`
+
+// impactDirection returns rel as an impact edge (see impactEdges). ok is false for types that do not propagate.
+func impactDirection(rel models.AssetRelationship) (src, dst int, ok bool) {
+	switch rel.Type {
+	case models.RelationshipRunsOn, models.RelationshipDependsOn:
+		return rel.RelatedAssetID, rel.AssetID, true
+	case models.RelationshipMemberOf:
+		return rel.AssetID, rel.RelatedAssetID, true
+	}
+	return 0, 0, false
+}
+
+// RelationshipRepo persists typed relationships between assets.
+type RelationshipRepo struct {
+	DB *sql.DB
+}
+
+// NewRelationshipRepo returns a new RelationshipRepo.
+func NewRelationshipRepo(db *sql.DB) *RelationshipRepo {
+	return &RelationshipRepo{DB: db}
+}
+
+const relationshipColumns = `id, asset_id, related_asset_id, type, description, created_at`
+
+func scanRelationshipRows(rows *sql.Rows) ([]models.AssetRelationship, error) {
+	defer rows.Close()
+	list := []models.AssetRelationship{}
+	for rows.Next() {
+		var rel models.AssetRelationship
+		if err := rows.Scan(&rel.ID, &rel.AssetID, &rel.RelatedAssetID, &rel.Type, &rel.Description, &rel.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, rel)
+	}
+	return list, rows.Err()
+}
+
+// Get returns the relationship with id if assetID is one of its ends. Returns models.ErrRelationshipNotFound
+// otherwise.
+func (r *RelationshipRepo) Get(ctx context.Context, assetID, id int) (*models.AssetRelationship, error) {
+	var rel models.AssetRelationship
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT `+relationshipColumns+` FROM asset_relationships WHERE id = $1 AND (asset_id = $2 OR related_asset_id = $2)`,
+		id, assetID,
+	).Scan(&rel.ID, &rel.AssetID, &rel.RelatedAssetID, &rel.Type, &rel.Description, &rel.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrRelationshipNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// ListForAsset returns the relationships of an asset in both directions, oldest first.
+func (r *RelationshipRepo) ListForAsset(ctx context.Context, assetID int) ([]models.AssetRelationship, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+relationshipColumns+` FROM asset_relationships WHERE asset_id = $1 OR related_asset_id = $1 ORDER BY id`,
+		assetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRelationshipRows(rows)
+}
+
+// ListBetween returns the relationships whose two assets are both in assetIDs (e.g. the nodes of a graph).
+func (r *RelationshipRepo) ListBetween(ctx context.Context, assetIDs []int) ([]models.AssetRelationship, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+relationshipColumns+` FROM asset_relationships WHERE asset_id = ANY($1) AND related_asset_id = ANY($1) ORDER BY id`,
+		pq.Array(assetIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRelationshipRows(rows)
+}
+
+// Create stores a relationship. Returns ErrRelationshipExists for duplicates and ErrRelationshipCycle when an
+// outage would propagate back to where it started (see impactEdges).
+func (r *RelationshipRepo) Create(ctx context.Context, rel models.AssetRelationship) (*models.AssetRelationship, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if src, dst, ok := impactDirection(rel); ok {
+		// Serialize writers so two concurrent inserts cannot close a cycle together.
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE asset_relationships IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return nil, err
+		}
+		var cycle bool
+		err := tx.QueryRowContext(ctx, `WITH RECURSIVE edges AS (`+impactEdges+`),
+			reach(id) AS (SELECT dst FROM edges WHERE src = $1 UNION SELECT e.dst FROM edges e JOIN reach ON e.src = reach.id)
+			SELECT EXISTS (SELECT 1 FROM reach WHERE id = $2)`,
+			dst, src,
+		).Scan(&cycle)
+		if err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, ErrRelationshipCycle
+		}
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO asset_relationships (asset_id, related_asset_id, type, description) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		rel.AssetID, rel.RelatedAssetID, rel.Type, rel.Description,
+	).Scan(&rel.ID, &rel.CreatedAt)
+	if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+		return nil, ErrRelationshipExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// Impacted returns the assets transitively impacted if assetID goes down (see impactEdges), mapped to the
+// shortest number of relationships between them.
+func (r *RelationshipRepo) Impacted(ctx context.Context, assetID int) (map[int]int, error) {
+	rows, err := r.DB.QueryContext(ctx, `WITH RECURSIVE edges AS (`+impactEdges+`),
+		impacted(id, depth) AS (
+			SELECT dst, 1 FROM edges WHERE src = $1
+			UNION
+			SELECT e.dst, i.depth + 1 FROM edges e JOIN impacted i ON e.src = i.id WHERE i.depth < $2
+		)
+		SELECT id, MIN(depth) FROM impacted WHERE id <> $1 GROUP BY id`,
+		assetID, maxImpactDepth,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	impacted := make(map[int]int)
+	for rows.Next() {
+		var id, depth int
+		if err := rows.Scan(&id, &depth); err != nil {
+			return nil, err
+		}
+		impacted[id] = depth
+	}
+	return impacted, rows.Err()
+}
+
+// OfflineSources returns the assets last seen before cutoff whose outage impacts other assets, by ID.
+func (r *RelationshipRepo) OfflineSources(ctx context.Context, cutoff time.Time) ([]int, error) {
+	rows, err := r.DB.QueryContext(ctx, `WITH edges AS (`+impactEdges+`)
+		SELECT DISTINCT a.id FROM assets a JOIN edges e ON e.src = a.id WHERE a.last_seen < $1 AND a.deleted_at IS NULL ORDER BY a.id`,
+		cutoff,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Delete removes a relationship of an asset (either direction). Returns models.ErrRelationshipNotFound for
+// unknown IDs.
+func (r *RelationshipRepo) Delete(ctx context.Context, assetID, id int) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM asset_relationships WHERE id = $1 AND (asset_id = $2 OR related_asset_id = $2)`,
+		id, assetID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrRelationshipNotFound
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+func TestRelationshipRepo_CreateRejectsCycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// pve1 (1) runs_on web01 (2) while web01 already runs on pve1: web01 reaches pve1, so this closes a cycle.
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE asset_relationships IN SHARE ROW EXCLUSIVE MODE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`WITH RECURSIVE edges AS .* SELECT EXISTS \(SELECT 1 FROM reach WHERE id = \$2\)`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	_, err = NewRelationshipRepo(db).Create(context.Background(), models.AssetRelationship{
+		AssetID: 1, RelatedAssetID: 2, Type: models.RelationshipRunsOn,
+	})
+	if err != ErrRelationshipCycle {
+		t.Errorf("Create: got %v, want ErrRelationshipCycle", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestRelationshipRepo_CreateBackupSkipsCycleCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	// backs_up does not propagate outages, so it never closes a cycle.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO asset_relationships \(asset_id, related_asset_id, type, description\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at`).
+		WithArgs(3, 2, "backs_up", "nightly").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+	mock.ExpectCommit()
+
+	rel, err := NewRelationshipRepo(db).Create(context.Background(), models.AssetRelationship{
+		AssetID: 3, RelatedAssetID: 2, Type: models.RelationshipBacksUp, Description: "nightly",
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if rel.ID != 7 {
+		t.Errorf("ID: got %d, want 7", rel.ID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestRelationshipRepo_Impacted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`WITH RECURSIVE edges AS .* SELECT id, MIN\(depth\) FROM impacted WHERE id <> \$1 GROUP BY id`).
+		WithArgs(1, maxImpactDepth).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "min"}).AddRow(2, 1).AddRow(4, 2))
+
+	got, err := NewRelationshipRepo(db).Impacted(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Impacted: %v", err)
+	}
+	if len(got) != 2 || got[2] != 1 || got[4] != 2 {
+		t.Errorf("Impacted: got %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
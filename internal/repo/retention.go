@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+// ErrRetentionBusy is returned by PruneBatch while another instance is pruning.
+var ErrRetentionBusy = errors.New("another instance is pruning")
+
+// retentionTable is where a data type lives: cond selects the rows older than $1; for scans, $2 is the number of
+// newest jobs kept per target.
+type retentionTable struct {
+	table  string
+	column string // time the row was written, for reports
+	cond   string
+	keep   bool
+}
+
+var retentionTables = map[string]retentionTable{
+	models.RetentionScans: {table: "scan_jobs", column: "started_at", keep: true, cond: `status <> 'running' AND started_at < $1
+		AND id NOT IN (SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY site_id, target ORDER BY id DESC) AS n
+		FROM scan_jobs) newest WHERE n <= $2)`},
+	// Audit entries are deleted strictly oldest first so the hash chain keeps verifying from its new first entry.
+	models.RetentionAudit: {table: "audit_log", column: "created_at",
+		cond: `id < COALESCE((SELECT MIN(id) FROM audit_log WHERE created_at >= $1), 2147483647)`},
+	models.RetentionLoginEvents: {table: "login_events", column: "created_at", cond: `created_at < $1`},
+	models.RetentionLifecycle:   {table: "asset_lifecycle_events", column: "created_at", cond: `created_at < $1`},
+	models.RetentionTrash:       {table: "assets", column: "deleted_at", cond: `deleted_at < $1`},
+}
+
+// RetentionRepo deletes rows past their retention period.
+type RetentionRepo struct {
+	DB *sql.DB
+}
+
+// NewRetentionRepo returns a new RetentionRepo.
+func NewRetentionRepo(db *sql.DB) *RetentionRepo {
+	return &RetentionRepo{DB: db}
+}
+
+func retentionQuery(dataType string, cutoff time.Time, keep int) (retentionTable, []interface{}, error) {
+	t, ok := retentionTables[dataType]
+	if !ok {
+		return t, nil, fmt.Errorf("unknown retention data type %q", dataType)
+	}
+	args := []interface{}{cutoff}
+	if t.keep {
+		args = append(args, keep)
+	}
+	return t, args, nil
+}
+
+// Due returns how many rows of dataType are older than cutoff (keeping the newest keep scans per target) and
+// when the oldest of them was written.
+func (r *RetentionRepo) Due(ctx context.Context, dataType string, cutoff time.Time, keep int) (int, *time.Time, error) {
+	t, args, err := retentionQuery(dataType, cutoff, keep)
+	if err != nil {
+		return 0, nil, err
+	}
+	var n int
+	var oldest sql.NullTime
+	err = r.DB.QueryRowContext(ctx, `SELECT COUNT(*), MIN(`+t.column+`) FROM `+t.table+` WHERE `+t.cond, args...).Scan(&n, &oldest)
+	if err != nil || !oldest.Valid {
+		return n, nil, err
+	}
+	return n, &oldest.Time, nil
+}
+
+// PruneBatch deletes up to limit of the rows Due counts, oldest first, in one transaction and returns how many
+// it deleted. archive, when not nil, first receives the rows as JSON objects; the rows are only deleted when it
+// succeeds. Deleting assets also deletes their services, relationships and history. Batches run under an
+// advisory lock so instances do not prune (and archive) the same rows; while another holds it, PruneBatch
+// returns ErrRetentionBusy.
+func (r *RetentionRepo) PruneBatch(ctx context.Context, dataType string, cutoff time.Time, keep, limit int, archive func([]string) error) (int, error) {
+	t, args, err := retentionQuery(dataType, cutoff, keep)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext('retention'))`).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, ErrRetentionBusy
+	}
+
+	args = append(args, limit)
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, row_to_json(t)::text FROM `+t.table+` t WHERE `+t.cond+fmt.Sprintf(` ORDER BY id LIMIT $%d`, len(args)),
+		args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	var lines []string
+	for rows.Next() {
+		var id int64
+		var line string
+		if err := rows.Scan(&id, &line); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(lines); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+t.table+` WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleBuiltin is returned when changing or deleting a built-in role.
+	ErrRoleBuiltin = errors.New("built-in roles cannot be changed")
+	// ErrRoleInUse is returned when deleting a role that is still assigned to users.
+	ErrRoleInUse = errors.New("role is assigned to users")
+)
+
+// RoleRepo persists roles and resolves their permissions for the auth middleware.
+type RoleRepo struct {
+	DB *sql.DB
+}
+
+// NewRoleRepo returns a new RoleRepo.
+func NewRoleRepo(db *sql.DB) *RoleRepo {
+	return &RoleRepo{DB: db}
+}
+
+const roleSelect = `SELECT id, name, description, permissions, builtin, created_at, updated_at FROM roles`
+
+func scanRole(row rowScanner) (*models.Role, error) {
+	var role models.Role
+	if err := row.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions), &role.Builtin, &role.CreatedAt, &role.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	return &role, nil
+}
+
+// List returns all roles, built-in roles first.
+func (r *RoleRepo) List(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx, roleSelect+` ORDER BY builtin DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, rows.Err()
+}
+
+// GetByID returns one role or ErrRoleNotFound.
+func (r *RoleRepo) GetByID(ctx context.Context, id int) (*models.Role, error) {
+	role, err := scanRole(r.DB.QueryRowContext(ctx, roleSelect+` WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	return role, err
+}
+
+// Create adds a custom role. A duplicate name returns the driver's unique violation (23505).
+func (r *RoleRepo) Create(ctx context.Context, name, description string, permissions []string) (*models.Role, error) {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return scanRole(r.DB.QueryRowContext(ctx,
+		`INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3) RETURNING id, name, description, permissions, builtin, created_at, updated_at`,
+		name, description, pq.Array(permissions),
+	))
+}
+
+// Update replaces a custom role's description and permissions. The name cannot change because users
+// reference it. Returns ErrRoleBuiltin for built-in roles and ErrRoleNotFound for unknown IDs.
+func (r *RoleRepo) Update(ctx context.Context, id int, description string, permissions []string) (*models.Role, error) {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	role, err := scanRole(r.DB.QueryRowContext(ctx,
+		`UPDATE roles SET description = $2, permissions = $3, updated_at = NOW() WHERE id = $1 AND NOT builtin RETURNING id, name, description, permissions, builtin, created_at, updated_at`,
+		id, description, pq.Array(permissions),
+	))
+	if err == sql.ErrNoRows {
+		return nil, r.notUpdatable(ctx, id)
+	}
+	return role, err
+}
+
+// Delete removes a custom role. Returns ErrRoleInUse while users or service accounts still have it.
+func (r *RoleRepo) Delete(ctx context.Context, id int) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM roles WHERE id = $1 AND NOT builtin`, id)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == "23503" {
+			return ErrRoleInUse
+		}
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return r.notUpdatable(ctx, id)
+	}
+	return nil
+}
+
+// notUpdatable tells a built-in role apart from a missing one after an update or delete matched no row.
+func (r *RoleRepo) notUpdatable(ctx context.Context, id int) error {
+	var builtin bool
+	err := r.DB.QueryRowContext(ctx, `SELECT builtin FROM roles WHERE id = $1`, id).Scan(&builtin)
+	if err == sql.ErrNoRows {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrRoleBuiltin
+}
+
+// Permissions returns the permissions of the named role (implements middleware.PermissionResolver).
+// Unknown roles have none.
+func (r *RoleRepo) Permissions(ctx context.Context, name string) ([]string, error) {
+	var permissions []string
+	err := r.DB.QueryRowContext(ctx, `SELECT permissions FROM roles WHERE name = $1`, name).Scan(pq.Array(&permissions))
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+	return permissions, err
+}
@@ -5,13 +5,19 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// ErrSavedScanInUse is returned when deleting a saved scan that schedules still run.
+var ErrSavedScanInUse = errors.New("saved scan is used by a schedule")
+
 // SavedScan is a named scan target that can be re-run.
 type SavedScan struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
 	Target    string    `json:"target"`
+	SiteID    int       `json:"site_id"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -25,23 +31,27 @@ func NewSavedScanRepo(db *sql.DB) *SavedScanRepo {
 	return &SavedScanRepo{DB: db}
 }
 
-// Create inserts a saved scan and returns it.
+// Create inserts a saved scan in ctx's site (see WithSite) and returns it.
 func (r *SavedScanRepo) Create(ctx context.Context, name, target string) (*SavedScan, error) {
+	query := `INSERT INTO saved_scans (name, target) VALUES ($1, $2) RETURNING id, name, target, site_id, created_at`
+	args := []interface{}{name, target}
+	if siteID, ok := SiteID(ctx); ok {
+		query = `INSERT INTO saved_scans (name, target, site_id) VALUES ($1, $2, $3) RETURNING id, name, target, site_id, created_at`
+		args = append(args, siteID)
+	}
 	var s SavedScan
-	err := r.DB.QueryRowContext(ctx,
-		`INSERT INTO saved_scans (name, target) VALUES ($1, $2) RETURNING id, name, target, created_at`,
-		name, target,
-	).Scan(&s.ID, &s.Name, &s.Target, &s.CreatedAt)
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&s.ID, &s.Name, &s.Target, &s.SiteID, &s.CreatedAt)
 	return &s, err
 }
 
-// GetByID returns a saved scan by id, or nil if not found.
+// GetByID returns a saved scan by id, or nil if not found in ctx's site.
 func (r *SavedScanRepo) GetByID(ctx context.Context, id int) (*SavedScan, error) {
 	var s SavedScan
+	where, args := siteScoped(ctx, "id = $1", id)
 	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, name, target, created_at FROM saved_scans WHERE id = $1`,
-		id,
-	).Scan(&s.ID, &s.Name, &s.Target, &s.CreatedAt)
+		`SELECT id, name, target, site_id, created_at FROM saved_scans`+where,
+		args...,
+	).Scan(&s.ID, &s.Name, &s.Target, &s.SiteID, &s.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -51,10 +61,11 @@ func (r *SavedScanRepo) GetByID(ctx context.Context, id int) (*SavedScan, error)
 	return &s, nil
 }
 
-// List returns all saved scans ordered by name.
+// List returns the saved scans in ctx's site ordered by name.
 func (r *SavedScanRepo) List(ctx context.Context) ([]SavedScan, error) {
+	where, args := siteScoped(ctx, "")
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT id, name, target, created_at FROM saved_scans ORDER BY name`)
+		`SELECT id, name, target, site_id, created_at FROM saved_scans`+where+` ORDER BY name`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +73,7 @@ func (r *SavedScanRepo) List(ctx context.Context) ([]SavedScan, error) {
 	var list []SavedScan
 	for rows.Next() {
 		var s SavedScan
-		if err := rows.Scan(&s.ID, &s.Name, &s.Target, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Name, &s.Target, &s.SiteID, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		list = append(list, s)
@@ -70,22 +81,27 @@ func (r *SavedScanRepo) List(ctx context.Context) ([]SavedScan, error) {
 	return list, rows.Err()
 }
 
-// Update updates name and target for a saved scan.
+// Update updates name and target for a saved scan in ctx's site.
 func (r *SavedScanRepo) Update(ctx context.Context, id int, name, target string) (*SavedScan, error) {
 	var s SavedScan
+	where, args := siteScoped(ctx, "id = $3", name, target, id)
 	err := r.DB.QueryRowContext(ctx,
-		`UPDATE saved_scans SET name = $1, target = $2 WHERE id = $3 RETURNING id, name, target, created_at`,
-		name, target, id,
-	).Scan(&s.ID, &s.Name, &s.Target, &s.CreatedAt)
+		`UPDATE saved_scans SET name = $1, target = $2`+where+` RETURNING id, name, target, site_id, created_at`,
+		args...,
+	).Scan(&s.ID, &s.Name, &s.Target, &s.SiteID, &s.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return &s, err
 }
 
-// Delete removes a saved scan by id.
+// Delete removes a saved scan by id in ctx's site. Returns ErrSavedScanInUse while schedules reference it.
 func (r *SavedScanRepo) Delete(ctx context.Context, id int) error {
-	res, err := r.DB.ExecContext(ctx, `DELETE FROM saved_scans WHERE id = $1`, id)
+	where, args := siteScoped(ctx, "id = $1", id)
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM saved_scans`+where, args...)
+	if e, ok := err.(*pq.Error); ok && e.Code == "23503" {
+		return ErrSavedScanInUse
+	}
 	if err != nil {
 		return err
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/crucial707/hci-asset/internal/models"
@@ -17,6 +18,7 @@ type ScanJobRow struct {
 	StartedAt   time.Time      `json:"started_at"`
 	CompletedAt *time.Time     `json:"completed_at,omitempty"`
 	Error       string         `json:"error,omitempty"`
+	SkipReason  string         `json:"skip_reason,omitempty"` // why a scheduled run was skipped (status skipped)
 	Assets      []models.Asset `json:"assets,omitempty"`
 }
 
@@ -30,13 +32,29 @@ func NewScanJobRepo(db *sql.DB) *ScanJobRepo {
 	return &ScanJobRepo{DB: db}
 }
 
-// Create inserts a new scan job with status=running and returns its id.
+// Create inserts a new scan job with status=running in ctx's site (see WithSite) and returns its id.
 func (r *ScanJobRepo) Create(ctx context.Context, target string) (int, error) {
+	query := `INSERT INTO scan_jobs (target, status) VALUES ($1, 'running') RETURNING id`
+	args := []interface{}{target}
+	if siteID, ok := SiteID(ctx); ok {
+		query = `INSERT INTO scan_jobs (target, status, site_id) VALUES ($1, 'running', $2) RETURNING id`
+		args = append(args, siteID)
+	}
 	var id int
-	err := r.DB.QueryRowContext(ctx,
-		`INSERT INTO scan_jobs (target, status) VALUES ($1, 'running') RETURNING id`,
-		target,
-	).Scan(&id)
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&id)
+	return id, err
+}
+
+// CreateForSchedule is Create for a scan started by schedule scheduleID, recorded in the schedule's runs.
+func (r *ScanJobRepo) CreateForSchedule(ctx context.Context, target string, scheduleID int) (int, error) {
+	query := `INSERT INTO scan_jobs (target, status, schedule_id) VALUES ($1, 'running', $2) RETURNING id`
+	args := []interface{}{target, scheduleID}
+	if siteID, ok := SiteID(ctx); ok {
+		query = `INSERT INTO scan_jobs (target, status, schedule_id, site_id) VALUES ($1, 'running', $2, $3) RETURNING id`
+		args = append(args, siteID)
+	}
+	var id int
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&id)
 	return id, err
 }
 
@@ -71,16 +89,17 @@ func nullJSON(b []byte) interface{} {
 	return b
 }
 
-// GetByID returns a scan job by id, or nil if not found.
+// GetByID returns a scan job by id, or nil if not found in ctx's site.
 func (r *ScanJobRepo) GetByID(ctx context.Context, id int) (*ScanJobRow, error) {
 	var row ScanJobRow
 	var completedAt sql.NullTime
-	var errMsg sql.NullString
+	var errMsg, skipReason sql.NullString
 	var assetsJSON []byte
+	where, args := siteScoped(ctx, "id = $1", id)
 	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, target, status, started_at, completed_at, error, assets FROM scan_jobs WHERE id = $1`,
-		id,
-	).Scan(&row.ID, &row.Target, &row.Status, &row.StartedAt, &completedAt, &errMsg, &assetsJSON)
+		`SELECT id, target, status, started_at, completed_at, error, assets, skip_reason FROM scan_jobs`+where,
+		args...,
+	).Scan(&row.ID, &row.Target, &row.Status, &row.StartedAt, &completedAt, &errMsg, &assetsJSON, &skipReason)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -93,6 +112,7 @@ func (r *ScanJobRepo) GetByID(ctx context.Context, id int) (*ScanJobRow, error)
 	if errMsg.Valid {
 		row.Error = errMsg.String
 	}
+	row.SkipReason = skipReason.String
 	if len(assetsJSON) > 0 {
 		_ = json.Unmarshal(assetsJSON, &row.Assets)
 	}
@@ -107,18 +127,21 @@ type ListEntry struct {
 	StartedAt time.Time `json:"started_at"`
 }
 
-// Count returns the total number of scan jobs.
+// Count returns the total number of scan jobs in ctx's site.
 func (r *ScanJobRepo) Count(ctx context.Context) (int, error) {
+	where, args := siteScoped(ctx, "")
 	var n int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM scan_jobs").Scan(&n)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM scan_jobs"+where, args...).Scan(&n)
 	return n, err
 }
 
-// List returns recent scan jobs, ordered by id DESC.
+// List returns recent scan jobs in ctx's site, ordered by id DESC.
 func (r *ScanJobRepo) List(ctx context.Context, limit, offset int) ([]ListEntry, error) {
+	where, args := siteScoped(ctx, "")
+	args = append(args, limit, offset)
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT id, target, status, started_at FROM scan_jobs ORDER BY id DESC LIMIT $1 OFFSET $2`,
-		limit, offset,
+		`SELECT id, target, status, started_at FROM scan_jobs`+where+fmt.Sprintf(` ORDER BY id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args)),
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -136,8 +159,45 @@ func (r *ScanJobRepo) List(ctx context.Context, limit, offset int) ([]ListEntry,
 	return list, rows.Err()
 }
 
-// DeleteAll removes all scan job records (used to clear the active scans list).
+// DeleteAll removes all scan job records in ctx's site (used to clear the active scans list).
 func (r *ScanJobRepo) DeleteAll(ctx context.Context) error {
-	_, err := r.DB.ExecContext(ctx, "DELETE FROM scan_jobs")
+	where, args := siteScoped(ctx, "")
+	_, err := r.DB.ExecContext(ctx, "DELETE FROM scan_jobs"+where, args...)
 	return err
 }
+
+// CountForSchedule returns the number of scan jobs started by schedule scheduleID.
+func (r *ScanJobRepo) CountForSchedule(ctx context.Context, scheduleID int) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM scan_jobs WHERE schedule_id = $1`, scheduleID).Scan(&n)
+	return n, err
+}
+
+// ListForSchedule returns the scan jobs started by schedule scheduleID, most recent first, without their assets.
+func (r *ScanJobRepo) ListForSchedule(ctx context.Context, scheduleID, limit, offset int) ([]ScanJobRow, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, target, status, started_at, completed_at, error, skip_reason FROM scan_jobs WHERE schedule_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3`,
+		scheduleID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ScanJobRow{}
+	for rows.Next() {
+		var row ScanJobRow
+		var completedAt sql.NullTime
+		var errMsg, skipReason sql.NullString
+		if err := rows.Scan(&row.ID, &row.Target, &row.Status, &row.StartedAt, &completedAt, &errMsg, &skipReason); err != nil {
+			return nil, err
+		}
+		if completedAt.Valid {
+			row.CompletedAt = &completedAt.Time
+		}
+		row.Error = errMsg.String
+		row.SkipReason = skipReason.String
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
@@ -3,10 +3,18 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/crucial707/hci-asset/internal/models"
 )
 
+// ScheduleChangesChannel is the Postgres NOTIFY channel announcing a created, edited or deleted schedule; the
+// payload is the schedule ID.
+const ScheduleChangesChannel = "schedule_changes"
+
 // ScheduleRepo persists scan schedules.
 type ScheduleRepo struct {
 	DB *sql.DB
@@ -17,42 +25,90 @@ func NewScheduleRepo(db *sql.DB) *ScheduleRepo {
 	return &ScheduleRepo{DB: db}
 }
 
-// Count returns the total number of schedules.
-func (r *ScheduleRepo) Count(ctx context.Context) (int, error) {
-	var n int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM scan_schedules").Scan(&n)
-	return n, err
+const scheduleColumns = `id, target, cron_expr, enabled, created_at, site_id, last_run_at, last_status, consecutive_failures,
+	timezone, blackout_windows, blackout_action, jitter_seconds, overlap_policy, saved_scan_id, asset_tag`
+
+func scanSchedule(row rowScanner) (*models.Schedule, error) {
+	s := &models.Schedule{}
+	var lastRunAt sql.NullTime
+	var windows []byte
+	var savedScanID sql.NullInt64
+	if err := row.Scan(&s.ID, &s.Target, &s.CronExpr, &s.Enabled, &s.CreatedAt, &s.SiteID, &lastRunAt, &s.LastStatus, &s.ConsecutiveFailures,
+		&s.Timezone, &windows, &s.BlackoutAction, &s.JitterSeconds, &s.OverlapPolicy, &savedScanID, &s.AssetTag); err != nil {
+		return nil, err
+	}
+	if savedScanID.Valid {
+		id := int(savedScanID.Int64)
+		s.SavedScanID = &id
+	}
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+	s.BlackoutWindows = []models.BlackoutWindow{}
+	if len(windows) > 0 {
+		if err := json.Unmarshal(windows, &s.BlackoutWindows); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
-// List returns schedules, most recent first. limit/offset for pagination.
-func (r *ScheduleRepo) List(ctx context.Context, limit, offset int) ([]models.Schedule, error) {
-	query := `
-		SELECT id, target, cron_expr, enabled, created_at
-		FROM scan_schedules
-		ORDER BY id DESC
-		LIMIT $1 OFFSET $2
-	`
-	rows, err := r.DB.QueryContext(ctx, query, limit, offset)
+// schedulePolicyArgs returns the run policy and source columns of s (timezone through asset_tag) as query
+// arguments.
+func schedulePolicyArgs(s models.Schedule) ([]interface{}, error) {
+	windows := s.BlackoutWindows
+	if windows == nil {
+		windows = []models.BlackoutWindow{}
+	}
+	windowsJSON, err := json.Marshal(windows)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return []interface{}{s.Timezone, windowsJSON, s.BlackoutAction, s.JitterSeconds, s.OverlapPolicy, s.SavedScanID, s.AssetTag}, nil
+}
 
+func scanScheduleRows(rows *sql.Rows) ([]models.Schedule, error) {
 	var list []models.Schedule
 	for rows.Next() {
-		var s models.Schedule
-		if err := rows.Scan(&s.ID, &s.Target, &s.CronExpr, &s.Enabled, &s.CreatedAt); err != nil {
+		s, err := scanSchedule(rows)
+		if err != nil {
 			return nil, err
 		}
-		list = append(list, s)
+		list = append(list, *s)
 	}
 	return list, rows.Err()
 }
 
-// ListEnabled returns all enabled schedules (for the cron runner).
+// Count returns the total number of schedules in ctx's site (see WithSite).
+func (r *ScheduleRepo) Count(ctx context.Context) (int, error) {
+	where, args := siteScoped(ctx, "")
+	var n int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM scan_schedules"+where, args...).Scan(&n)
+	return n, err
+}
+
+// List returns schedules in ctx's site, most recent first. limit/offset for pagination.
+func (r *ScheduleRepo) List(ctx context.Context, limit, offset int) ([]models.Schedule, error) {
+	where, args := siteScoped(ctx, "")
+	args = append(args, limit, offset)
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM scan_schedules` + where + fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d
+	`, len(args)-1, len(args))
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanScheduleRows(rows)
+}
+
+// ListEnabled returns all enabled schedules across every site (for the cron runner).
 func (r *ScheduleRepo) ListEnabled(ctx context.Context) ([]models.Schedule, error) {
 	query := `
-		SELECT id, target, cron_expr, enabled, created_at
+		SELECT ` + scheduleColumns + `
 		FROM scan_schedules
 		WHERE enabled = true
 		ORDER BY id
@@ -62,27 +118,16 @@ func (r *ScheduleRepo) ListEnabled(ctx context.Context) ([]models.Schedule, erro
 		return nil, err
 	}
 	defer rows.Close()
-
-	var list []models.Schedule
-	for rows.Next() {
-		var s models.Schedule
-		if err := rows.Scan(&s.ID, &s.Target, &s.CronExpr, &s.Enabled, &s.CreatedAt); err != nil {
-			return nil, err
-		}
-		list = append(list, s)
-	}
-	return list, rows.Err()
+	return scanScheduleRows(rows)
 }
 
-// GetByID returns one schedule by id.
+// GetByID returns one schedule by id, or nil if not found in ctx's site.
 func (r *ScheduleRepo) GetByID(ctx context.Context, id int) (*models.Schedule, error) {
+	where, args := siteScoped(ctx, "id = $1", id)
 	query := `
-		SELECT id, target, cron_expr, enabled, created_at
-		FROM scan_schedules
-		WHERE id = $1
-	`
-	s := &models.Schedule{}
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(&s.ID, &s.Target, &s.CronExpr, &s.Enabled, &s.CreatedAt)
+		SELECT ` + scheduleColumns + `
+		FROM scan_schedules` + where
+	s, err := scanSchedule(r.DB.QueryRowContext(ctx, query, args...))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -92,33 +137,105 @@ func (r *ScheduleRepo) GetByID(ctx context.Context, id int) (*models.Schedule, e
 	return s, nil
 }
 
-// Create inserts a new schedule and returns it with id set.
-func (r *ScheduleRepo) Create(ctx context.Context, target, cronExpr string, enabled bool) (*models.Schedule, error) {
+// Create inserts a new schedule (source, cron_expr, enabled and run policies of s) in ctx's site and returns
+// it with id set.
+func (r *ScheduleRepo) Create(ctx context.Context, s models.Schedule) (*models.Schedule, error) {
+	policy, err := schedulePolicyArgs(s)
+	if err != nil {
+		return nil, err
+	}
 	query := `
-		INSERT INTO scan_schedules (target, cron_expr, enabled)
-		VALUES ($1, $2, $3)
-		RETURNING id, target, cron_expr, enabled, created_at
+		INSERT INTO scan_schedules (target, cron_expr, enabled, timezone, blackout_windows, blackout_action, jitter_seconds, overlap_policy, saved_scan_id, asset_tag)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + scheduleColumns + `
 	`
-	s := &models.Schedule{}
-	err := r.DB.QueryRowContext(ctx, query, target, cronExpr, enabled).
-		Scan(&s.ID, &s.Target, &s.CronExpr, &s.Enabled, &s.CreatedAt)
+	args := append([]interface{}{s.Target, s.CronExpr, s.Enabled}, policy...)
+	if siteID, ok := SiteID(ctx); ok {
+		query = `
+		INSERT INTO scan_schedules (target, cron_expr, enabled, timezone, blackout_windows, blackout_action, jitter_seconds, overlap_policy, saved_scan_id, asset_tag, site_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING ` + scheduleColumns + `
+	`
+		args = append(args, siteID)
+	}
+	return scanSchedule(r.DB.QueryRowContext(ctx, query, args...))
+}
+
+// Update updates the source, cron_expr, enabled and the run policies of the given id in ctx's site from s.
+// Re-enabling a schedule resets its consecutive failures.
+func (r *ScheduleRepo) Update(ctx context.Context, id int, s models.Schedule) error {
+	policy, err := schedulePolicyArgs(s)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return s, nil
+	args := append([]interface{}{s.Target, s.CronExpr, s.Enabled}, policy...)
+	where, args := siteScoped(ctx, "id = $11", append(args, id)...)
+	_, err = r.DB.ExecContext(ctx,
+		`UPDATE scan_schedules SET target = $1, cron_expr = $2, enabled = $3,
+		timezone = $4, blackout_windows = $5, blackout_action = $6, jitter_seconds = $7, overlap_policy = $8,
+		saved_scan_id = $9, asset_tag = $10,
+		consecutive_failures = CASE WHEN $3 AND NOT enabled THEN 0 ELSE consecutive_failures END`+where,
+		args...,
+	)
+	return err
 }
 
-// Update updates target, cron_expr, and enabled for the given id.
-func (r *ScheduleRepo) Update(ctx context.Context, id int, target, cronExpr string, enabled bool) error {
+// Delete removes a schedule by id in ctx's site.
+func (r *ScheduleRepo) Delete(ctx context.Context, id int) error {
+	where, args := siteScoped(ctx, "id = $1", id)
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM scan_schedules`+where, args...)
+	return err
+}
+
+// RecordStart marks a run of schedule id as started at startedAt.
+func (r *ScheduleRepo) RecordStart(ctx context.Context, id int, startedAt time.Time) error {
 	_, err := r.DB.ExecContext(ctx,
-		`UPDATE scan_schedules SET target = $1, cron_expr = $2, enabled = $3 WHERE id = $4`,
-		target, cronExpr, enabled, id,
+		`UPDATE scan_schedules SET last_run_at = $1, last_status = 'running' WHERE id = $2`,
+		startedAt, id,
 	)
 	return err
 }
 
-// Delete removes a schedule by id.
-func (r *ScheduleRepo) Delete(ctx context.Context, id int) error {
-	_, err := r.DB.ExecContext(ctx, `DELETE FROM scan_schedules WHERE id = $1`, id)
+// RecordSkip records a run of s that did not start, with reason, in the schedule's runs (a scan job with status
+// skipped) and as its last run.
+func (r *ScheduleRepo) RecordSkip(ctx context.Context, s models.Schedule, reason string) error {
+	_, err := r.DB.ExecContext(ctx, `
+		WITH job AS (
+			INSERT INTO scan_jobs (target, status, started_at, completed_at, skip_reason, schedule_id, site_id)
+			VALUES ($1, 'skipped', NOW(), NOW(), $2, $3, $4)
+		)
+		UPDATE scan_schedules SET last_run_at = NOW(), last_status = 'skipped' WHERE id = $3`,
+		s.TargetLabel(), reason, s.ID, s.SiteID,
+	)
+	return err
+}
+
+// RecordResult stores the final status of the latest run of schedule id. A run ending in error adds a
+// consecutive failure and a complete one resets them; when maxFailures > 0 and the failures reach it, the
+// schedule is disabled. disabled reports whether this call disabled it.
+func (r *ScheduleRepo) RecordResult(ctx context.Context, id int, status string, maxFailures int) (disabled bool, err error) {
+	var enabled, wasEnabled bool
+	err = r.DB.QueryRowContext(ctx, `
+		UPDATE scan_schedules s SET
+			last_status = $1,
+			consecutive_failures = CASE WHEN $1 = 'error' THEN s.consecutive_failures + 1 WHEN $1 = 'complete' THEN 0 ELSE s.consecutive_failures END,
+			enabled = s.enabled AND NOT ($1 = 'error' AND $3 > 0 AND s.consecutive_failures + 1 >= $3)
+		FROM scan_schedules prev
+		WHERE s.id = $2 AND prev.id = s.id
+		RETURNING s.enabled, prev.enabled`,
+		status, id, maxFailures,
+	).Scan(&enabled, &wasEnabled)
+	if err == sql.ErrNoRows {
+		return false, nil // schedule deleted while the scan ran
+	}
+	if err != nil {
+		return false, err
+	}
+	return wasEnabled && !enabled, nil
+}
+
+// Notify announces a change to schedule id on ScheduleChangesChannel so the scheduler applies it at once.
+func (r *ScheduleRepo) Notify(ctx context.Context, id int) error {
+	_, err := r.DB.ExecContext(ctx, `SELECT pg_notify($1, $2)`, ScheduleChangesChannel, strconv.Itoa(id))
 	return err
 }
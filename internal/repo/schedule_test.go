@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
 )
 
 func TestScheduleRepo_List(t *testing.T) {
@@ -19,9 +20,9 @@ func TestScheduleRepo_List(t *testing.T) {
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(50, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(2, "10.0.0.0/24", "0 * * * *", true, now).
-			AddRow(1, "192.168.1.0/24", "*/5 * * * *", false, now.Add(-time.Hour)))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(2, "10.0.0.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "").
+			AddRow(1, "192.168.1.0/24", "*/5 * * * *", false, now.Add(-time.Hour), 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	r := NewScheduleRepo(db)
 	list, err := r.List(context.Background(), 50, 0)
@@ -51,7 +52,7 @@ func TestScheduleRepo_List_Empty(t *testing.T) {
 
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(10, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}))
 
 	r := NewScheduleRepo(db)
 	list, err := r.List(context.Background(), 10, 0)
@@ -75,8 +76,8 @@ func TestScheduleRepo_ListEnabled(t *testing.T) {
 
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	r := NewScheduleRepo(db)
 	list, err := r.ListEnabled(context.Background())
@@ -104,8 +105,8 @@ func TestScheduleRepo_GetByID(t *testing.T) {
 	now := time.Now()
 	mock.ExpectQuery(`SELECT id, target, cron_expr, enabled, created_at`).
 		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	r := NewScheduleRepo(db)
 	s, err := r.GetByID(context.Background(), 1)
@@ -156,12 +157,16 @@ func TestScheduleRepo_Create(t *testing.T) {
 
 	now := time.Now()
 	mock.ExpectQuery(`INSERT INTO scan_schedules`).
-		WithArgs("192.168.1.0/24", "0 * * * *", true).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at"}).
-			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now))
+		WithArgs("192.168.1.0/24", "0 * * * *", true, "Europe/Paris", []byte(`[{"days":["sat","sun"],"start":"22:00","end":"06:00"}]`), "defer", 30, "queue", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "192.168.1.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
 
 	r := NewScheduleRepo(db)
-	s, err := r.Create(context.Background(), "192.168.1.0/24", "0 * * * *", true)
+	s, err := r.Create(context.Background(), models.Schedule{
+		Target: "192.168.1.0/24", CronExpr: "0 * * * *", Enabled: true, Timezone: "Europe/Paris",
+		BlackoutWindows: []models.BlackoutWindow{{Days: []string{"sat", "sun"}, Start: "22:00", End: "06:00"}},
+		BlackoutAction:  models.BlackoutDefer, JitterSeconds: 30, OverlapPolicy: models.OverlapQueue,
+	})
 	if err != nil {
 		t.Fatalf("Create: %v", err)
 	}
@@ -181,11 +186,13 @@ func TestScheduleRepo_Update(t *testing.T) {
 	defer db.Close()
 
 	mock.ExpectExec(`UPDATE scan_schedules SET target`).
-		WithArgs("10.0.0.0/24", "*/15 * * * *", false, 1).
+		WithArgs("10.0.0.0/24", "*/15 * * * *", false, "", []byte("[]"), "skip", 0, "skip", nil, "", 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	r := NewScheduleRepo(db)
-	err = r.Update(context.Background(), 1, "10.0.0.0/24", "*/15 * * * *", false)
+	err = r.Update(context.Background(), 1, models.Schedule{
+		Target: "10.0.0.0/24", CronExpr: "*/15 * * * *", BlackoutAction: models.BlackoutSkip, OverlapPolicy: models.OverlapSkip,
+	})
 	if err != nil {
 		t.Fatalf("Update: %v", err)
 	}
@@ -214,3 +221,52 @@ func TestScheduleRepo_Delete(t *testing.T) {
 		t.Errorf("expectations: %v", err)
 	}
 }
+
+func TestScheduleRepo_RecordResult_DisablesAfterMaxFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`UPDATE scan_schedules s SET\s+last_status = \$1`).
+		WithArgs("error", 3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled", "enabled"}).AddRow(false, true))
+	mock.ExpectQuery(`UPDATE scan_schedules s SET`).
+		WithArgs("complete", 4, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled", "enabled"}).AddRow(true, true))
+
+	r := NewScheduleRepo(db)
+	disabled, err := r.RecordResult(context.Background(), 3, "error", 5)
+	if err != nil || !disabled {
+		t.Errorf("RecordResult error run: disabled=%v err=%v, want disabled", disabled, err)
+	}
+	disabled, err = r.RecordResult(context.Background(), 4, "complete", 5)
+	if err != nil || disabled {
+		t.Errorf("RecordResult complete run: disabled=%v err=%v, want still enabled", disabled, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleRepo_RecordSkip(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`INSERT INTO scan_jobs .*'skipped'.*UPDATE scan_schedules SET last_run_at = NOW\(\), last_status = 'skipped'`).
+		WithArgs("10.0.0.0/24", "blackout window sat 08:00-18:00", 3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := NewScheduleRepo(db)
+	err = r.RecordSkip(context.Background(), models.Schedule{ID: 3, Target: "10.0.0.0/24", SiteID: 2}, "blackout window sat 08:00-18:00")
+	if err != nil {
+		t.Fatalf("RecordSkip: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
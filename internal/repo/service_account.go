@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+// ServiceAccountRepo manages service accounts: users without a password that authenticate only with API keys.
+type ServiceAccountRepo struct {
+	DB *sql.DB
+}
+
+// NewServiceAccountRepo returns a new ServiceAccountRepo.
+func NewServiceAccountRepo(db *sql.DB) *ServiceAccountRepo {
+	return &ServiceAccountRepo{DB: db}
+}
+
+// Create inserts the backing user (no password) and marks it as a service account.
+func (r *ServiceAccountRepo) Create(ctx context.Context, username, role, description string, createdBy int) (*models.ServiceAccount, error) {
+	if role == "" {
+		role = models.RoleViewer
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sa := &models.ServiceAccount{Username: username, Role: role, Description: description}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO users (username, password_hash, role) VALUES ($1, NULL, $2) RETURNING id`,
+		username, role,
+	).Scan(&sa.ID); err != nil {
+		return nil, err
+	}
+	var creator interface{}
+	if createdBy > 0 {
+		creator = createdBy
+	}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO service_accounts (user_id, description, created_by) VALUES ($1, $2, $3) RETURNING created_at`,
+		sa.ID, description, creator,
+	).Scan(&sa.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// List returns all service accounts ordered by ID.
+func (r *ServiceAccountRepo) List(ctx context.Context) ([]models.ServiceAccount, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT u.id, u.username, u.role, s.description, s.created_at FROM service_accounts s JOIN users u ON u.id = s.user_id ORDER BY u.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.ServiceAccount{}
+	for rows.Next() {
+		var sa models.ServiceAccount
+		if err := rows.Scan(&sa.ID, &sa.Username, &sa.Role, &sa.Description, &sa.CreatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, sa)
+	}
+	return accounts, rows.Err()
+}
+
+// IsServiceAccount reports whether userID belongs to a service account.
+func (r *ServiceAccountRepo) IsServiceAccount(ctx context.Context, userID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM service_accounts WHERE user_id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,214 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens.
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole token family is revoked, since either the client or an attacker holds a stolen copy.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionRevoked is returned by ValidateSession for denylisted, revoked or orphaned access tokens.
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+// SessionRepo stores server-side session state: rotating refresh tokens, the access token (jti)
+// denylist and each user's tokens_valid_after cut-off.
+type SessionRepo struct {
+	DB *sql.DB
+}
+
+// NewSessionRepo returns a new SessionRepo.
+func NewSessionRepo(db *sql.DB) *SessionRepo {
+	return &SessionRepo{DB: db}
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewTokenID returns a random access token ID (jti).
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateRefreshToken starts a new token family for userID (one per login) and returns the raw token.
+func (r *SessionRepo) CreateRefreshToken(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	family, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.DB.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, hashToken(raw), family, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same family and returns the owner's user ID.
+// Presenting a token that was already rotated revokes the family and returns ErrRefreshTokenReused.
+func (r *SessionRepo) RotateRefreshToken(ctx context.Context, raw string, ttl time.Duration) (userID int, newRaw string, err error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var family string
+	var expires time.Time
+	var revoked sql.NullTime
+	var replacedBy sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id, family_id, expires_at, revoked_at, replaced_by FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		hashToken(raw),
+	).Scan(&id, &userID, &family, &expires, &revoked, &replacedBy)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if revoked.Valid {
+		if replacedBy.Valid {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, family,
+			); err != nil {
+				return 0, "", err
+			}
+			if err := tx.Commit(); err != nil {
+				return 0, "", err
+			}
+			return 0, "", ErrRefreshTokenReused
+		}
+		return 0, "", ErrRefreshTokenInvalid
+	}
+	if !expires.After(time.Now()) {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+
+	newRaw, err = randomToken(32)
+	if err != nil {
+		return 0, "", err
+	}
+	var newID int
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		userID, hashToken(newRaw), family, time.Now().Add(ttl),
+	).Scan(&newID); err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`, newID, id,
+	); err != nil {
+		return 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return userID, newRaw, nil
+}
+
+// RevokeRefreshToken revokes the family of the given refresh token (logout). Unknown tokens are ignored.
+func (r *SessionRepo) RevokeRefreshToken(ctx context.Context, raw string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE revoked_at IS NULL AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)`,
+		hashToken(raw),
+	)
+	return err
+}
+
+// RevokeAccessToken adds a jti to the denylist until the token would have expired anyway.
+func (r *SessionRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	return err
+}
+
+// RevokeAllForUser invalidates every access token issued to userID so far and revokes all their refresh tokens.
+// The cut-off is taken from this host's clock, the one that stamps the tokens' iat, not the database's.
+func (r *SessionRepo) RevokeAllForUser(ctx context.Context, userID int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET tokens_valid_after = $2 WHERE id = $1`, userID, time.Now()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ValidateSession checks that an access token is still valid server-side and returns the user's current role,
+// so role changes apply immediately instead of when the token expires. It fails with ErrSessionRevoked if the
+// user no longer exists, the jti is denylisted, or the token was not issued after the user's last revoke-all.
+func (r *SessionRepo) ValidateSession(ctx context.Context, userID int, jti string, issuedAt time.Time) (string, error) {
+	var role string
+	var validAfter time.Time
+	var denied bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT role, tokens_valid_after, EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $2) FROM users WHERE id = $1`,
+		userID, jti,
+	).Scan(&role, &validAfter, &denied)
+	if err == sql.ErrNoRows {
+		return "", ErrSessionRevoked
+	}
+	if err != nil {
+		return "", err
+	}
+	// Access tokens carry iat in microseconds, so a token issued just before a revoke-all is rejected while one
+	// issued just after it (e.g. by an SSO login that re-synced the role) is accepted.
+	if denied || !issuedAt.After(validAfter) {
+		return "", ErrSessionRevoked
+	}
+	return role, nil
+}
+
+// PruneExpired deletes denylist entries and refresh tokens that have expired. Returns the number of rows removed.
+func (r *SessionRepo) PruneExpired(ctx context.Context) (int64, error) {
+	var total int64
+	for _, q := range []string{
+		`DELETE FROM revoked_tokens WHERE expires_at < NOW()`,
+		`DELETE FROM refresh_tokens WHERE expires_at < NOW()`,
+	} {
+		result, err := r.DB.ExecContext(ctx, q)
+		if err != nil {
+			return total, err
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSessionRepo_RotateRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, user_id, family_id, expires_at, revoked_at, replaced_by FROM refresh_tokens WHERE token_hash = \$1 FOR UPDATE`).
+		WithArgs(hashToken("old")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at", "replaced_by"}).
+			AddRow(10, 3, "fam", time.Now().Add(time.Hour), nil, nil))
+	mock.ExpectQuery(`INSERT INTO refresh_tokens .* RETURNING id`).
+		WithArgs(3, sqlmock.AnyArg(), "fam", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = NOW\(\), replaced_by = \$1 WHERE id = \$2`).
+		WithArgs(11, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	userID, newRaw, err := NewSessionRepo(db).RotateRefreshToken(context.Background(), "old", time.Hour)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if userID != 3 || newRaw == "" || newRaw == "old" {
+		t.Errorf("unexpected result: user %d, token %q", userID, newRaw)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestSessionRepo_RotateRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, user_id, family_id`).
+		WithArgs(hashToken("stolen")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at", "replaced_by"}).
+			AddRow(10, 3, "fam", time.Now().Add(time.Hour), time.Now(), 11))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = NOW\(\) WHERE family_id = \$1 AND revoked_at IS NULL`).
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, _, err := NewSessionRepo(db).RotateRefreshToken(context.Background(), "stolen", time.Hour); err != ErrRefreshTokenReused {
+		t.Fatalf("RotateRefreshToken: got %v, want ErrRefreshTokenReused", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestSessionRepo_ValidateSession(t *testing.T) {
+	revokedAt := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		denied   bool
+		wantErr  bool
+	}{
+		{"valid", revokedAt.Add(time.Minute), false, false},
+		{"just after revoke-all", revokedAt.Add(time.Microsecond), false, false},
+		{"same second before revoke-all", revokedAt.Truncate(time.Second), false, true},
+		{"at revoke-all", revokedAt, false, true},
+		{"issued before revoke-all", revokedAt.Add(-time.Minute), false, true},
+		{"denylisted jti", revokedAt.Add(time.Minute), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT role, tokens_valid_after, EXISTS`).
+				WithArgs(4, "jti-1").
+				WillReturnRows(sqlmock.NewRows([]string{"role", "tokens_valid_after", "exists"}).AddRow("admin", revokedAt, tt.denied))
+
+			role, err := NewSessionRepo(db).ValidateSession(context.Background(), 4, "jti-1", tt.issuedAt)
+			if tt.wantErr {
+				if err != ErrSessionRevoked {
+					t.Fatalf("got %v, want ErrSessionRevoked", err)
+				}
+				return
+			}
+			if err != nil || role != "admin" {
+				t.Fatalf("got role %q, err %v", role, err)
+			}
+		})
+	}
+}
+
+func TestSessionRepo_ValidateSession_DeletedUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT role, tokens_valid_after`).
+		WithArgs(4, "jti-1").
+		WillReturnRows(sqlmock.NewRows([]string{"role", "tokens_valid_after", "exists"}))
+
+	if _, err := NewSessionRepo(db).ValidateSession(context.Background(), 4, "jti-1", time.Now()); err != ErrSessionRevoked {
+		t.Fatalf("got %v, want ErrSessionRevoked", err)
+	}
+}
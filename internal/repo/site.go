@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrSiteDefault is returned when deleting the default site.
+	ErrSiteDefault = errors.New("the default site cannot be deleted")
+	// ErrSiteInUse is returned when deleting a site that still has assets, scans, schedules, subnets or access
+	// policies confining users to it.
+	ErrSiteInUse = errors.New("site still has assets, scans, schedules, subnets or access policies")
+)
+
+type siteKey struct{}
+type allowedSitesKey struct{}
+
+// WithSite limits the asset, scan job, saved scan and schedule calls made with the returned context to one
+// site, and makes new rows belong to it.
+func WithSite(ctx context.Context, siteID int) context.Context {
+	return context.WithValue(ctx, siteKey{}, siteID)
+}
+
+// SiteID returns the site selected in ctx. ok is false when calls span every site and new rows go to the
+// default site.
+func SiteID(ctx context.Context) (siteID int, ok bool) {
+	siteID, ok = ctx.Value(siteKey{}).(int)
+	return siteID, ok
+}
+
+// withAllowedSites records the sites ctx's access policies allow (see SiteRepo.SelectSite).
+func withAllowedSites(ctx context.Context, ids []int) context.Context {
+	return context.WithValue(ctx, allowedSitesKey{}, ids)
+}
+
+// AllowedSites returns the sites ctx's access policies allow. ok is false when every site is allowed.
+func AllowedSites(ctx context.Context) (ids []int, ok bool) {
+	ids, ok = ctx.Value(allowedSitesKey{}).([]int)
+	return ids, ok
+}
+
+// SiteVisible reports whether rows of siteID are visible in ctx: it is the selected site or, with none
+// selected, one the access policies allow.
+func SiteVisible(ctx context.Context, siteID int) bool {
+	if selected, ok := SiteID(ctx); ok {
+		return selected == siteID
+	}
+	allowed, restricted := AllowedSites(ctx)
+	return !restricted || containsInt(allowed, siteID)
+}
+
+// siteFilter returns the condition limiting rows to ctx's site using placeholder $n, and its argument. With
+// no site selected it limits rows to the sites the access policies allow; cond is empty when they allow all.
+func siteFilter(ctx context.Context, n int) (cond string, args []interface{}) {
+	if siteID, ok := SiteID(ctx); ok {
+		return fmt.Sprintf("site_id = $%d", n), []interface{}{siteID}
+	}
+	if allowed, ok := AllowedSites(ctx); ok {
+		return fmt.Sprintf("site_id = ANY($%d)", n), []interface{}{pq.Array(allowed)}
+	}
+	return "", nil
+}
+
+// siteScoped appends ctx's site (see WithSite) to a query whose WHERE clause is where (may be empty) and whose
+// arguments are args.
+func siteScoped(ctx context.Context, where string, args ...interface{}) (string, []interface{}) {
+	cond, siteArgs := siteFilter(ctx, len(args)+1)
+	switch {
+	case cond == "" && where == "":
+		return "", args
+	case cond == "":
+		return " WHERE " + where, args
+	case where == "":
+		return " WHERE " + cond, append(args, siteArgs...)
+	default:
+		return " WHERE " + where + " AND " + cond, append(args, siteArgs...)
+	}
+}
+
+// SiteRepo persists sites and resolves the site a request selects.
+type SiteRepo struct {
+	DB *sql.DB
+}
+
+// NewSiteRepo returns a new SiteRepo.
+func NewSiteRepo(db *sql.DB) *SiteRepo {
+	return &SiteRepo{DB: db}
+}
+
+const siteColumns = `id, name, description, created_at, updated_at`
+
+func scanSite(row rowScanner) (*models.Site, error) {
+	var s models.Site
+	if err := row.Scan(&s.ID, &s.Name, &s.Description, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List returns the sites visible in ctx (see AllowedSites) ordered by name, with their dashboard counts.
+func (r *SiteRepo) List(ctx context.Context) ([]models.SiteSummary, error) {
+	query := `SELECT s.id, s.name, s.description, s.created_at, s.updated_at,
+		(SELECT COUNT(*) FROM assets a WHERE a.site_id = s.id AND a.deleted_at IS NULL),
+		(SELECT COUNT(*) FROM scan_jobs j WHERE j.site_id = s.id),
+		(SELECT COUNT(*) FROM scan_schedules sc WHERE sc.site_id = s.id)
+		FROM sites s`
+	var args []interface{}
+	if ids, ok := AllowedSites(ctx); ok {
+		query += ` WHERE s.id = ANY($1)`
+		args = append(args, pq.Array(ids))
+	}
+	rows, err := r.DB.QueryContext(ctx, query+` ORDER BY s.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []models.SiteSummary{}
+	for rows.Next() {
+		var s models.SiteSummary
+		if err := rows.Scan(&s.ID, &s.Name, &s.Description, &s.CreatedAt, &s.UpdatedAt, &s.Assets, &s.Scans, &s.Schedules); err != nil {
+			return nil, err
+		}
+		sites = append(sites, s)
+	}
+	return sites, rows.Err()
+}
+
+// GetByID returns one site or models.ErrSiteNotFound.
+func (r *SiteRepo) GetByID(ctx context.Context, id int) (*models.Site, error) {
+	s, err := scanSite(r.DB.QueryRowContext(ctx, `SELECT `+siteColumns+` FROM sites WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrSiteNotFound
+	}
+	return s, err
+}
+
+// Create stores a new site.
+func (r *SiteRepo) Create(ctx context.Context, name, description string) (*models.Site, error) {
+	return scanSite(r.DB.QueryRowContext(ctx,
+		`INSERT INTO sites (name, description) VALUES ($1, $2) RETURNING `+siteColumns,
+		name, description,
+	))
+}
+
+// Update renames a site or changes its description. Returns models.ErrSiteNotFound for unknown IDs.
+func (r *SiteRepo) Update(ctx context.Context, id int, name, description string) (*models.Site, error) {
+	s, err := scanSite(r.DB.QueryRowContext(ctx,
+		`UPDATE sites SET name = $2, description = $3, updated_at = NOW() WHERE id = $1 RETURNING `+siteColumns,
+		id, name, description,
+	))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrSiteNotFound
+	}
+	return s, err
+}
+
+// Delete removes an empty site. The default site and sites that still own rows, including access policies
+// confining users to them, cannot be deleted.
+func (r *SiteRepo) Delete(ctx context.Context, id int) error {
+	if id == models.DefaultSiteID {
+		return ErrSiteDefault
+	}
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM sites WHERE id = $1`, id)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == "23503" {
+			return ErrSiteInUse
+		}
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrSiteNotFound
+	}
+	return nil
+}
+
+// SelectSite limits ctx to the site named or, when ref is a number, numbered by ref (implements
+// middleware.SiteSelector). An empty ref selects no site: reads span every site the access policies allow
+// (see siteFilter), and a caller allowed exactly one site works in it. Returns models.ErrSiteNotFound for
+// unknown sites and models.ErrSiteNotAllowed for sites outside the policies.
+func (r *SiteRepo) SelectSite(ctx context.Context, ref string) (context.Context, error) {
+	allowed, restricted := AllowedSites(ctx)
+	if ref == "" {
+		switch {
+		case !restricted:
+			return ctx, nil
+		case len(allowed) == 0:
+			return ctx, models.ErrSiteNotAllowed
+		case len(allowed) == 1:
+			return WithSite(ctx, allowed[0]), nil
+		}
+		return ctx, nil
+	}
+
+	var id int
+	var err error
+	if n, convErr := strconv.Atoi(ref); convErr == nil {
+		err = r.DB.QueryRowContext(ctx, `SELECT id FROM sites WHERE id = $1`, n).Scan(&id)
+	} else {
+		err = r.DB.QueryRowContext(ctx, `SELECT id FROM sites WHERE name = $1`, ref).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return ctx, models.ErrSiteNotFound
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if restricted && !containsInt(allowed, id) {
+		return ctx, models.ErrSiteNotAllowed
+	}
+	return WithSite(ctx, id), nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
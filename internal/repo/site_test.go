@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+)
+
+func TestSiteRepo_SelectSite(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+	r := NewSiteRepo(db)
+	restricted := withAllowedSites(context.Background(), []int{2, 3})
+
+	// Numbers are IDs and anything else is a name, so the two never match the same reference.
+	mock.ExpectQuery(`SELECT id FROM sites WHERE id = \$1`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	ctx, err := r.SelectSite(restricted, "3")
+	if id, ok := SiteID(ctx); err != nil || !ok || id != 3 {
+		t.Errorf("SelectSite(3): got site %d %v, err %v", id, ok, err)
+	}
+	mock.ExpectQuery(`SELECT id FROM sites WHERE name = \$1`).WithArgs("lab-east").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	if _, err := r.SelectSite(restricted, "lab-east"); !errors.Is(err, models.ErrSiteNotAllowed) {
+		t.Errorf("SelectSite(lab-east): got %v, want ErrSiteNotAllowed", err)
+	}
+
+	// Without a reference, a caller allowed several sites sees all of them rather than the first.
+	ctx, err = r.SelectSite(restricted, "")
+	if _, ok := SiteID(ctx); err != nil || ok {
+		t.Errorf("SelectSite(\"\") with two sites: got a site selected, err %v", err)
+	}
+	if cond, _ := siteFilter(ctx, 1); cond != "site_id = ANY($1)" {
+		t.Errorf("siteFilter: got %q", cond)
+	}
+	if !SiteVisible(ctx, 3) || SiteVisible(ctx, 1) {
+		t.Error("SiteVisible: expected sites 2 and 3 only")
+	}
+	ctx, err = r.SelectSite(withAllowedSites(context.Background(), []int{2}), "")
+	if id, ok := SiteID(ctx); err != nil || !ok || id != 2 {
+		t.Errorf("SelectSite(\"\") with one site: got site %d %v, err %v", id, ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
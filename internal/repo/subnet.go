@@ -0,0 +1,388 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/netip"
+
+	"github.com/crucial707/hci-asset/internal/ipam"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/lib/pq"
+)
+
+// ErrIPReserved is returned when reserving an address that already has a reservation.
+var ErrIPReserved = errors.New("address already reserved")
+
+// allocateAttempts bounds Allocate's retries when concurrent allocations pick the same address.
+const allocateAttempts = 3
+
+// SubnetRepo persists subnets and IP reservations and computes address utilization.
+type SubnetRepo struct {
+	DB *sql.DB
+}
+
+// NewSubnetRepo returns a new SubnetRepo.
+func NewSubnetRepo(db *sql.DB) *SubnetRepo {
+	return &SubnetRepo{DB: db}
+}
+
+const subnetColumns = `id, site_id, cidr::text, vlan_id, COALESCE(host(gateway), ''), description, created_at, updated_at`
+
+func scanSubnet(row rowScanner) (*models.Subnet, error) {
+	var s models.Subnet
+	var vlan sql.NullInt64
+	if err := row.Scan(&s.ID, &s.SiteID, &s.CIDR, &vlan, &s.Gateway, &s.Description, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if vlan.Valid {
+		v := int(vlan.Int64)
+		s.VLANID = &v
+	}
+	return &s, nil
+}
+
+// subnets returns the subnets in ctx's site (see WithSite) ordered by site and address.
+func (r *SubnetRepo) subnets(ctx context.Context) ([]models.Subnet, error) {
+	where, args := siteScoped(ctx, "")
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+subnetColumns+` FROM subnets`+where+` ORDER BY site_id, cidr`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Subnet{}
+	for rows.Next() {
+		s, err := scanSubnet(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *s)
+	}
+	return list, rows.Err()
+}
+
+// List returns the subnets in ctx's site with their utilization.
+func (r *SubnetRepo) List(ctx context.Context) ([]models.SubnetSummary, error) {
+	list, err := r.subnets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.utilization(ctx, list)
+}
+
+// GetByID returns one subnet of ctx's site, or models.ErrSubnetNotFound.
+func (r *SubnetRepo) GetByID(ctx context.Context, id int) (*models.Subnet, error) {
+	where, args := siteScoped(ctx, "id = $1", id)
+	s, err := scanSubnet(r.DB.QueryRowContext(ctx, `SELECT `+subnetColumns+` FROM subnets`+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrSubnetNotFound
+	}
+	return s, err
+}
+
+// Summary returns one subnet of ctx's site with its utilization, or models.ErrSubnetNotFound.
+func (r *SubnetRepo) Summary(ctx context.Context, id int) (*models.SubnetSummary, error) {
+	s, err := r.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	list, err := r.utilization(ctx, []models.Subnet{*s})
+	if err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// Create stores a subnet in ctx's site (the default site when none is selected).
+func (r *SubnetRepo) Create(ctx context.Context, s models.Subnet) (*models.Subnet, error) {
+	query := `INSERT INTO subnets (cidr, vlan_id, gateway, description) VALUES ($1, $2, $3, $4) RETURNING ` + subnetColumns
+	args := []interface{}{s.CIDR, s.VLANID, nullString(s.Gateway), s.Description}
+	if siteID, ok := SiteID(ctx); ok {
+		query = `INSERT INTO subnets (cidr, vlan_id, gateway, description, site_id) VALUES ($1, $2, $3, $4, $5) RETURNING ` + subnetColumns
+		args = append(args, siteID)
+	}
+	return scanSubnet(r.DB.QueryRowContext(ctx, query, args...))
+}
+
+// Update changes a subnet of ctx's site. Returns models.ErrSubnetNotFound for unknown IDs.
+func (r *SubnetRepo) Update(ctx context.Context, id int, s models.Subnet) (*models.Subnet, error) {
+	where, args := siteScoped(ctx, "id = $5", s.CIDR, s.VLANID, nullString(s.Gateway), s.Description, id)
+	updated, err := scanSubnet(r.DB.QueryRowContext(ctx,
+		`UPDATE subnets SET cidr = $1, vlan_id = $2, gateway = $3, description = $4, updated_at = NOW()`+where+` RETURNING `+subnetColumns,
+		args...,
+	))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrSubnetNotFound
+	}
+	return updated, err
+}
+
+// Delete removes a subnet of ctx's site and its reservations. Returns models.ErrSubnetNotFound for unknown IDs.
+func (r *SubnetRepo) Delete(ctx context.Context, id int) error {
+	where, args := siteScoped(ctx, "id = $1", id)
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM subnets`+where, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrSubnetNotFound
+	}
+	return nil
+}
+
+// Reservations returns the reservations of a subnet ordered by address. Check the subnet with GetByID first.
+func (r *SubnetRepo) Reservations(ctx context.Context, subnetID int) ([]models.IPReservation, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, subnet_id, host(ip), description, created_at FROM ip_reservations WHERE subnet_id = $1 ORDER BY ip`,
+		subnetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.IPReservation{}
+	for rows.Next() {
+		var res models.IPReservation
+		if err := rows.Scan(&res.ID, &res.SubnetID, &res.IP, &res.Description, &res.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, res)
+	}
+	return list, rows.Err()
+}
+
+// Reserve stores a reservation for ip in a subnet. Returns ErrIPReserved if ip is already reserved.
+func (r *SubnetRepo) Reserve(ctx context.Context, subnetID int, ip, description string) (*models.IPReservation, error) {
+	res := models.IPReservation{SubnetID: subnetID, Description: description}
+	err := r.DB.QueryRowContext(ctx,
+		`INSERT INTO ip_reservations (subnet_id, ip, description) VALUES ($1, $2, $3) RETURNING id, host(ip), created_at`,
+		subnetID, ip, description,
+	).Scan(&res.ID, &res.IP, &res.CreatedAt)
+	if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+		return nil, ErrIPReserved
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DeleteReservation removes a reservation of a subnet. Returns models.ErrReservationNotFound for unknown IDs.
+func (r *SubnetRepo) DeleteReservation(ctx context.Context, subnetID, id int) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM ip_reservations WHERE id = $1 AND subnet_id = $2`, id, subnetID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrReservationNotFound
+	}
+	return nil
+}
+
+// NextFree returns the lowest usable address of s not held by an asset, a reservation or the gateway.
+// Returns models.ErrSubnetFull when there is none.
+func (r *SubnetRepo) NextFree(ctx context.Context, s models.Subnet) (string, error) {
+	p, err := ipam.ParsePrefix(s.CIDR)
+	if err != nil {
+		return "", err
+	}
+	used, err := r.assetAddrs(ctx, []int{s.SiteID})
+	if err != nil {
+		return "", err
+	}
+	reserved, err := r.reservedAddrs(ctx, []int{s.ID})
+	if err != nil {
+		return "", err
+	}
+	taken := make(map[netip.Addr]bool)
+	for _, addr := range used[s.SiteID] {
+		taken[addr] = true
+	}
+	for _, addr := range reserved[s.ID] {
+		taken[addr] = true
+	}
+	if gw, ok := ipam.ParseAddr(s.Gateway); ok {
+		taken[gw] = true
+	}
+	addr, ok := ipam.NextFree(p, taken)
+	if !ok {
+		return "", models.ErrSubnetFull
+	}
+	return addr.String(), nil
+}
+
+// Allocate reserves the next free address of s (see NextFree) with description and returns the reservation.
+func (r *SubnetRepo) Allocate(ctx context.Context, s models.Subnet, description string) (*models.IPReservation, error) {
+	for attempt := 1; ; attempt++ {
+		ip, err := r.NextFree(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		res, err := r.Reserve(ctx, s.ID, ip, description)
+		// Another request reserved the same address in the meantime: pick again.
+		if err == ErrIPReserved && attempt < allocateAttempts {
+			continue
+		}
+		return res, err
+	}
+}
+
+// AssetSubnets assigns the assets of ctx's site to the most specific subnet of their site containing their
+// network name, keyed by asset ID. Assets without an IP or outside every subnet are left out.
+func (r *SubnetRepo) AssetSubnets(ctx context.Context) (map[int]models.Subnet, error) {
+	assigned := make(map[int]models.Subnet)
+	list, err := r.subnets(ctx)
+	if err != nil || len(list) == 0 {
+		return assigned, err
+	}
+	bySite := make(map[int][]models.Subnet)
+	prefixes := make(map[int][]netip.Prefix)
+	for _, s := range list {
+		p, err := ipam.ParsePrefix(s.CIDR)
+		if err != nil {
+			continue
+		}
+		bySite[s.SiteID] = append(bySite[s.SiteID], s)
+		prefixes[s.SiteID] = append(prefixes[s.SiteID], p)
+	}
+
+	where, args := siteScoped(ctx, "network_name <> ''")
+	rows, err := r.DB.QueryContext(ctx, `SELECT id, site_id, network_name FROM assets`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, siteID int
+		var networkName string
+		if err := rows.Scan(&id, &siteID, &networkName); err != nil {
+			return nil, err
+		}
+		addr, ok := ipam.ParseAddr(networkName)
+		if !ok {
+			continue
+		}
+		if i := ipam.MostSpecific(prefixes[siteID], addr); i >= 0 {
+			assigned[id] = bySite[siteID][i]
+		}
+	}
+	return assigned, rows.Err()
+}
+
+// utilization counts the used, reserved and free addresses of each subnet.
+func (r *SubnetRepo) utilization(ctx context.Context, list []models.Subnet) ([]models.SubnetSummary, error) {
+	summaries := make([]models.SubnetSummary, 0, len(list))
+	if len(list) == 0 {
+		return summaries, nil
+	}
+	var siteIDs, subnetIDs []int
+	for _, s := range list {
+		if !containsInt(siteIDs, s.SiteID) {
+			siteIDs = append(siteIDs, s.SiteID)
+		}
+		subnetIDs = append(subnetIDs, s.ID)
+	}
+	used, err := r.assetAddrs(ctx, siteIDs)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := r.reservedAddrs(ctx, subnetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range list {
+		summary := models.SubnetSummary{Subnet: s}
+		p, err := ipam.ParsePrefix(s.CIDR)
+		if err != nil {
+			summaries = append(summaries, summary)
+			continue
+		}
+		held := make(map[netip.Addr]bool)
+		for _, addr := range used[s.SiteID] {
+			if ipam.IsUsable(p, addr) && !held[addr] {
+				held[addr] = true
+				summary.Utilization.Used++
+			}
+		}
+		holds := reserved[s.ID]
+		if gw, ok := ipam.ParseAddr(s.Gateway); ok {
+			holds = append(holds, gw)
+		}
+		for _, addr := range holds {
+			if ipam.IsUsable(p, addr) && !held[addr] {
+				held[addr] = true
+				summary.Utilization.Reserved++
+			}
+		}
+
+		u := &summary.Utilization
+		u.Size = ipam.Size(p)
+		if taken := uint64(len(held)); u.Size > taken {
+			u.Free = u.Size - taken
+		}
+		u.PercentUsed = ipam.Percent(uint64(u.Used+u.Reserved), u.Size)
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// assetAddrs returns the IP addresses of the assets of each site. Network names that are not IPs are skipped.
+func (r *SubnetRepo) assetAddrs(ctx context.Context, siteIDs []int) (map[int][]netip.Addr, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT site_id, network_name FROM assets WHERE site_id = ANY($1) AND network_name <> ''`,
+		pq.Array(siteIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addrs := make(map[int][]netip.Addr)
+	for rows.Next() {
+		var siteID int
+		var networkName string
+		if err := rows.Scan(&siteID, &networkName); err != nil {
+			return nil, err
+		}
+		if addr, ok := ipam.ParseAddr(networkName); ok {
+			addrs[siteID] = append(addrs[siteID], addr)
+		}
+	}
+	return addrs, rows.Err()
+}
+
+// reservedAddrs returns the reserved addresses of each subnet.
+func (r *SubnetRepo) reservedAddrs(ctx context.Context, subnetIDs []int) (map[int][]netip.Addr, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT subnet_id, host(ip) FROM ip_reservations WHERE subnet_id = ANY($1)`,
+		pq.Array(subnetIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addrs := make(map[int][]netip.Addr)
+	for rows.Next() {
+		var subnetID int
+		var ip string
+		if err := rows.Scan(&subnetID, &ip); err != nil {
+			return nil, err
+		}
+		if addr, ok := ipam.ParseAddr(ip); ok {
+			addrs[subnetID] = append(addrs[subnetID], addr)
+		}
+	}
+	return addrs, rows.Err()
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var subnetRowColumns = []string{"id", "site_id", "cidr", "vlan_id", "gateway", "description", "created_at", "updated_at"}
+
+func TestSubnetRepo_ListUtilization(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, site_id, cidr::text, vlan_id, COALESCE\(host\(gateway\), ''\), description, created_at, updated_at FROM subnets WHERE site_id = \$1 ORDER BY site_id, cidr`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(subnetRowColumns).
+			AddRow(1, 2, "10.0.0.0/22", 20, "10.0.0.1", "servers", now, now).
+			AddRow(2, 2, "10.0.1.0/28", nil, "", "mgmt", now, now))
+	mock.ExpectQuery(`SELECT site_id, network_name FROM assets WHERE site_id = ANY\(\$1\) AND network_name <> ''`).
+		WillReturnRows(sqlmock.NewRows([]string{"site_id", "network_name"}).
+			AddRow(2, "10.0.0.10").
+			AddRow(2, "10.0.0.10").
+			AddRow(2, "10.0.1.5").
+			AddRow(2, "10.0.3.255").
+			AddRow(2, "db01.lab"))
+	mock.ExpectQuery(`SELECT subnet_id, host\(ip\) FROM ip_reservations WHERE subnet_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"subnet_id", "ip"}).
+			AddRow(1, "10.0.0.10").
+			AddRow(1, "10.0.0.20").
+			AddRow(2, "10.0.1.1"))
+
+	list, err := NewSubnetRepo(db).List(WithSite(context.Background(), 2))
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("len: got %d, want 2", len(list))
+	}
+	// /22: 1022 usable; used 10.0.0.10 and 10.0.1.5 (the broadcast address is not usable); reserved the
+	// gateway and 10.0.0.20 (10.0.0.10 already counts as used).
+	u := list[0].Utilization
+	if u.Size != 1022 || u.Used != 2 || u.Reserved != 2 || u.Free != 1018 {
+		t.Errorf("/22 utilization: got %+v", u)
+	}
+	if list[0].VLANID == nil || *list[0].VLANID != 20 || list[0].Gateway != "10.0.0.1" {
+		t.Errorf("/22 vlan/gateway: got %v %q", list[0].VLANID, list[0].Gateway)
+	}
+	u = list[1].Utilization
+	if u.Size != 14 || u.Used != 1 || u.Reserved != 1 || u.Free != 12 || u.PercentUsed != 14.3 {
+		t.Errorf("/28 utilization: got %+v", u)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestSubnetRepo_AssetSubnets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT .+ FROM subnets ORDER BY site_id, cidr`).
+		WillReturnRows(sqlmock.NewRows(subnetRowColumns).
+			AddRow(1, 1, "10.0.0.0/22", nil, "", "", now, now).
+			AddRow(2, 1, "10.0.1.0/28", nil, "", "", now, now).
+			AddRow(3, 2, "10.0.0.0/24", nil, "", "", now, now))
+	mock.ExpectQuery(`SELECT id, site_id, network_name FROM assets WHERE network_name <> ''`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "site_id", "network_name"}).
+			AddRow(10, 1, "10.0.1.5").
+			AddRow(11, 1, "10.0.2.9").
+			AddRow(12, 2, "10.0.0.9").
+			AddRow(13, 2, "10.0.2.9"))
+
+	assigned, err := NewSubnetRepo(db).AssetSubnets(context.Background())
+	if err != nil {
+		t.Fatalf("AssetSubnets: %v", err)
+	}
+	// Most specific subnet wins, and overlapping ranges only match subnets of the asset's own site.
+	want := map[int]int{10: 2, 11: 1, 12: 3}
+	if len(assigned) != len(want) {
+		t.Errorf("assigned: got %d assets, want %d", len(assigned), len(want))
+	}
+	for assetID, subnetID := range want {
+		if got := assigned[assetID].ID; got != subnetID {
+			t.Errorf("asset %d: got subnet %d, want %d", assetID, got, subnetID)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
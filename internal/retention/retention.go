@@ -0,0 +1,216 @@
+// Package retention deletes rows past their retention period, optionally archiving them to compressed files
+// first.
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/metrics"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+const (
+	defaultInterval  = time.Hour
+	defaultBatchSize = 1000
+)
+
+// Pruner applies retention policies: every Interval it deletes the rows each policy no longer keeps, in batches
+// of BatchSize, and records an audit entry per data type.
+type Pruner struct {
+	Repo   *repo.RetentionRepo
+	Audits *repo.AuditRepo
+	// Policies with MaxAgeDays 0 keep their data forever.
+	Policies []models.RetentionPolicy
+	// BatchSize is the number of rows deleted per transaction (default 1000).
+	BatchSize int
+	// ArchiveDir, when set, receives the deleted rows of each run and data type as a gzipped NDJSON file
+	// (<data type>-<time>.ndjson.gz), written before they are deleted.
+	ArchiveDir string
+	// DryRun makes the background runs only log what they would delete.
+	DryRun bool
+	// Interval between runs (default 1h).
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+// NewPruner returns a Pruner applying policies to the database behind retention and audits.
+func NewPruner(retention *repo.RetentionRepo, audits *repo.AuditRepo, policies []models.RetentionPolicy, batchSize int, archiveDir string, dryRun bool, interval time.Duration) *Pruner {
+	return &Pruner{
+		Repo: retention, Audits: audits, Policies: policies, BatchSize: batchSize, ArchiveDir: archiveDir, DryRun: dryRun,
+		Interval: interval,
+	}
+}
+
+// Enabled reports whether any policy deletes data.
+func (p *Pruner) Enabled() bool {
+	for _, pol := range p.Policies {
+		if pol.MaxAgeDays > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Run prunes now and then every Interval, until ctx is done.
+func (p *Pruner) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, res := range p.Prune(ctx, p.DryRun) {
+			switch {
+			case res.Error != "":
+				log.Printf("retention %s: %s", res.DataType, res.Error)
+			case res.DryRun && res.Rows > 0:
+				log.Printf("retention %s (dry run): %d rows older than %s would be deleted", res.DataType, res.Rows, res.Cutoff.Format(time.RFC3339))
+			case res.Rows > 0:
+				log.Printf("retention %s: deleted %d rows older than %s", res.DataType, res.Rows, res.Cutoff.Format(time.RFC3339))
+			}
+			if !res.DryRun && res.Rows > 0 {
+				p.audit(ctx, res)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune applies every policy and returns one result per policy, in order. In a dry run nothing is deleted and
+// each result reports the rows due instead. A policy that fails reports its error (and the rows deleted before
+// it) without stopping the others; one skipped because another instance is pruning reports Skipped.
+func (p *Pruner) Prune(ctx context.Context, dryRun bool) []models.RetentionResult {
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	start := now().UTC()
+	results := make([]models.RetentionResult, 0, len(p.Policies))
+	for _, pol := range p.Policies {
+		res := models.RetentionResult{RetentionPolicy: pol, DryRun: dryRun}
+		if pol.MaxAgeDays <= 0 {
+			results = append(results, res)
+			continue
+		}
+		res.Cutoff = start.AddDate(0, 0, -pol.MaxAgeDays)
+		var err error
+		if dryRun {
+			res.Rows, res.Oldest, err = p.Repo.Due(ctx, pol.DataType, res.Cutoff, pol.Keep)
+		} else {
+			err = p.prune(ctx, &res, start)
+		}
+		if errors.Is(err, repo.ErrRetentionBusy) {
+			res.Skipped = true
+		} else if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// prune deletes the rows res's policy no longer keeps, batch by batch, archiving them first when ArchiveDir is
+// set.
+func (p *Pruner) prune(ctx context.Context, res *models.RetentionResult, start time.Time) error {
+	batch := p.BatchSize
+	if batch <= 0 {
+		batch = defaultBatchSize
+	}
+	var arc *archive
+	defer func() {
+		if arc != nil {
+			if err := arc.close(); err != nil {
+				log.Printf("retention %s: archive %s: %v", res.DataType, arc.path, err)
+			}
+		}
+	}()
+	var write func([]string) error
+	if p.ArchiveDir != "" {
+		write = func(lines []string) error {
+			if arc == nil {
+				var err error
+				path := filepath.Join(p.ArchiveDir, fmt.Sprintf("%s-%s.ndjson.gz", res.DataType, start.Format("20060102T150405Z")))
+				if arc, err = createArchive(path); err != nil {
+					return err
+				}
+				res.Archive = path
+			}
+			return arc.write(lines)
+		}
+	}
+
+	for {
+		n, err := p.Repo.PruneBatch(ctx, res.DataType, res.Cutoff, res.Keep, batch, write)
+		res.Rows += n
+		metrics.AddRetentionDeleted(res.DataType, n)
+		if err != nil {
+			return err
+		}
+		if n < batch {
+			return nil
+		}
+	}
+}
+
+// audit records res as a system audit entry.
+func (p *Pruner) audit(ctx context.Context, res models.RetentionResult) {
+	if p.Audits == nil {
+		return
+	}
+	details, _ := json.Marshal(map[string]interface{}{
+		"data_type": res.DataType, "rows": res.Rows, "cutoff": res.Cutoff, "archive": res.Archive,
+	})
+	if err := p.Audits.LogSystem(ctx, models.AuditSystemRetention, "prune", "retention", 0, string(details)); err != nil {
+		log.Printf("retention %s: audit: %v", res.DataType, err)
+	}
+}
+
+// archive is a gzipped NDJSON file of deleted rows.
+type archive struct {
+	path string
+	f    *os.File
+	gz   *gzip.Writer
+}
+
+func createArchive(path string) (*archive, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &archive{path: path, f: f, gz: gzip.NewWriter(f)}, nil
+}
+
+// write appends lines and syncs them to disk, so rows are only deleted once they are archived.
+func (a *archive) write(lines []string) error {
+	for _, line := range lines {
+		if _, err := a.gz.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *archive) close() error {
+	return errors.Join(a.gz.Close(), a.f.Close())
+}
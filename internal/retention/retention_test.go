@@ -0,0 +1,145 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+var testNow = time.Date(2026, 3, 22, 12, 0, 0, 0, time.UTC)
+
+func newMockPruner(t *testing.T, policies ...models.RetentionPolicy) (*Pruner, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	p := NewPruner(repo.NewRetentionRepo(db), repo.NewAuditRepo(db), policies, 2, "", false, time.Hour)
+	p.now = func() time.Time { return testNow }
+	return p, mock
+}
+
+// expectBatch expects one PruneBatch transaction on table selecting rows (id, JSON); deleted rows are committed.
+func expectBatch(mock sqlmock.Sqlmock, table string, args []driver.Value, rows ...string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(hashtext\('retention'\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	result := sqlmock.NewRows([]string{"id", "row_to_json"})
+	for i, row := range rows {
+		result.AddRow(i+1, row)
+	}
+	mock.ExpectQuery(`SELECT id, row_to_json\(t\)::text FROM ` + table + ` t WHERE .* ORDER BY id LIMIT`).
+		WithArgs(args...).WillReturnRows(result)
+	if len(rows) == 0 {
+		mock.ExpectRollback()
+		return
+	}
+	mock.ExpectExec(`DELETE FROM ` + table + ` WHERE id = ANY\(\$1\)`).WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(rows))))
+	mock.ExpectCommit()
+}
+
+func TestPruner_DryRun(t *testing.T) {
+	p, mock := newMockPruner(t,
+		models.RetentionPolicy{DataType: models.RetentionScans, MaxAgeDays: 90, Keep: 5},
+		models.RetentionPolicy{DataType: models.RetentionLoginEvents},
+	)
+	cutoff := testNow.AddDate(0, 0, -90)
+	oldest := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT COUNT\(\*\), MIN\(started_at\) FROM scan_jobs WHERE status <> 'running' AND started_at < \$1`).
+		WithArgs(cutoff, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min"}).AddRow(12, oldest))
+
+	results := p.Prune(context.Background(), true)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	scans := results[0]
+	if !scans.DryRun || scans.Rows != 12 || !scans.Cutoff.Equal(cutoff) || scans.Oldest == nil || !scans.Oldest.Equal(oldest) {
+		t.Errorf("scans = %+v", scans)
+	}
+	// Login events are kept forever: nothing is queried.
+	if results[1].Rows != 0 || !results[1].Cutoff.IsZero() || results[1].Error != "" {
+		t.Errorf("login events = %+v", results[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestPruner_ArchivesBeforeDeleting(t *testing.T) {
+	p, mock := newMockPruner(t, models.RetentionPolicy{DataType: models.RetentionAudit, MaxAgeDays: 365})
+	p.ArchiveDir = t.TempDir()
+	cutoff := testNow.AddDate(-1, 0, 0)
+	// Batches of 2 until one comes back short.
+	expectBatch(mock, "audit_log", []driver.Value{cutoff, 2}, `{"id":1}`, `{"id":2}`)
+	expectBatch(mock, "audit_log", []driver.Value{cutoff, 2}, `{"id":3}`)
+
+	results := p.Prune(context.Background(), false)
+	res := results[0]
+	if res.Error != "" || res.Rows != 3 || res.DryRun {
+		t.Fatalf("result = %+v", res)
+	}
+	if want := p.ArchiveDir + "/audit-20260322T120000Z.ndjson.gz"; res.Archive != want {
+		t.Errorf("archive = %q, want %q", res.Archive, want)
+	}
+	f, err := os.Open(res.Archive)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	var lines []string
+	sc := bufio.NewScanner(gz)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) != 3 || lines[0] != `{"id":1}` || lines[2] != `{"id":3}` {
+		t.Errorf("archived %q", lines)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestPruner_AnotherInstancePruning(t *testing.T) {
+	p, mock := newMockPruner(t, models.RetentionPolicy{DataType: models.RetentionTrash, MaxAgeDays: 30})
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectRollback()
+
+	// Another instance holding the lock is not an error: the policy is skipped until the next run.
+	res := p.Prune(context.Background(), false)[0]
+	if res.Rows != 0 || !res.Skipped || res.Error != "" {
+		t.Errorf("result = %+v", res)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
+
+func TestPruner_AuditsRuns(t *testing.T) {
+	p, mock := newMockPruner(t)
+	mock.ExpectExec(`INSERT INTO audit_log`).
+		WithArgs(nil, "prune", "retention", 0, sqlmock.AnyArg(), models.AuditActorSystem, models.AuditSystemRetention, "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	p.audit(context.Background(), models.RetentionResult{
+		RetentionPolicy: models.RetentionPolicy{DataType: models.RetentionScans, MaxAgeDays: 90}, Rows: 4,
+	})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+)
+
+// fire returns the cron job of schedule id. It delays the run by the schedule's jitter, then starts it.
+func (s *Scheduler) fire(id int) func() {
+	return func() {
+		s.mu.Lock()
+		sc, ok := s.schedules[id]
+		term := s.term
+		s.mu.Unlock()
+		if !ok {
+			return
+		}
+		if sc.JitterSeconds > 0 {
+			delay := time.Duration(rand.Int64N(int64(sc.JitterSeconds) * int64(time.Second)))
+			time.AfterFunc(delay, func() { s.trigger(id, term) })
+			return
+		}
+		s.trigger(id, term)
+	}
+}
+
+// trigger starts a due run of schedule id, applying its blackout windows and overlap policy, unless leadership
+// changed since term or the schedule was removed. Overlap is checked against any running scan of the same
+// target in the schedule's site, whether another schedule or a user started it.
+func (s *Scheduler) trigger(id, term int) {
+	s.triggerMu.Lock()
+	defer s.triggerMu.Unlock()
+	sc, ok := s.current(id, term)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if w, until, ok := sc.Blackout(now); ok {
+		if sc.BlackoutAction == models.BlackoutDefer {
+			log.Printf("scheduler: schedule id=%d in blackout window %s, deferring to %s", id, w, until.Format(time.RFC3339))
+			time.AfterFunc(until.Sub(now), func() { s.trigger(id, term) })
+			return
+		}
+		s.skip(sc, "blackout window "+w.String())
+		return
+	}
+
+	ctx := repo.WithSite(context.Background(), sc.SiteID)
+	targets, resolveErr := s.Runner.ScheduleTarget(ctx, sc)
+	if resolveErr == nil {
+		target := strings.Join(targets, " ")
+		if jobID, done, running := s.Runner.RunningScan(ctx, target); running {
+			switch sc.OverlapPolicy {
+			case models.OverlapQueue:
+				key := fmt.Sprintf("%d/%s", sc.SiteID, target)
+				s.mu.Lock()
+				queued := s.queued[key]
+				if !queued && s.leading && s.term == term {
+					s.queued[key] = true
+				}
+				s.mu.Unlock()
+				if queued {
+					s.skip(sc, fmt.Sprintf("scan %s of the same target still running and another run already queued", jobID))
+					return
+				}
+				go func() {
+					<-done
+					s.mu.Lock()
+					delete(s.queued, key)
+					s.mu.Unlock()
+					s.trigger(id, term)
+				}()
+				return
+			case models.OverlapCancelPrevious:
+				s.Runner.CancelJob(jobID)
+				<-done
+			default:
+				s.skip(sc, fmt.Sprintf("scan %s of the same target still running", jobID))
+				return
+			}
+		}
+	}
+
+	if _, ok := s.current(id, term); !ok {
+		return
+	}
+	s.Runner.StartScheduledScan(ctx, sc, targets, resolveErr)
+}
+
+// current returns schedule id while this instance leads in term.
+func (s *Scheduler) current(id, term int) (models.Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.leading || s.term != term {
+		return models.Schedule{}, false
+	}
+	sc, ok := s.schedules[id]
+	return sc, ok
+}
+
+// skip records a run of sc that did not start.
+func (s *Scheduler) skip(sc models.Schedule, reason string) {
+	log.Printf("scheduler: skipped run of schedule id=%d: %s", sc.ID, reason)
+	if err := s.Schedules.RecordSkip(context.Background(), sc, reason); err != nil {
+		log.Printf("scheduler: record skipped run of schedule id=%d: %v", sc.ID, err)
+	}
+}
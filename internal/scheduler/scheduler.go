@@ -2,57 +2,364 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/crucial707/hci-asset/internal/models"
 	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/lib/pq"
 	"github.com/robfig/cron/v3"
 )
 
-// Run starts a background scheduler that loads enabled scan schedules from the DB
-// and runs runScan(target) at each schedule's cron time. It reloads schedules every 60 seconds.
-func Run(scheduleRepo *repo.ScheduleRepo, runScan func(target string)) {
-	c := cron.New()
-	var mu sync.Mutex
-	entryByID := make(map[int]cron.EntryID) // schedule ID -> cron entry
+// LeaseName is the lease held by the API instance that runs the scan schedules.
+const LeaseName = "scheduler"
 
-	syncSchedules := func() {
-		mu.Lock()
-		defer mu.Unlock()
+const (
+	defaultLeaseTTL       = 30 * time.Second
+	defaultReloadInterval = 60 * time.Second
+)
+
+// Runner starts and cancels the scans of schedules (handlers.ScanHandler).
+type Runner interface {
+	// ScheduleTarget resolves the targets schedule s scans now in ctx's site.
+	ScheduleTarget(ctx context.Context, s models.Schedule) ([]string, error)
+	// StartScheduledScan starts a scan of targets for schedule s in ctx's site and returns its job ID and a
+	// channel closed when the scan ends. When resolveErr is set the run fails without scanning.
+	StartScheduledScan(ctx context.Context, s models.Schedule, targets []string, resolveErr error) (jobID string, done <-chan struct{})
+	// RunningScan returns the running scan of target (targets joined by spaces) in ctx's site, whoever started it.
+	RunningScan(ctx context.Context, target string) (jobID string, done <-chan struct{}, ok bool)
+	// CancelJob stops a running scan, killing its process. It returns false when the job is not running.
+	CancelJob(jobID string) bool
+}
 
-		// Remove all current entries so we reflect DB (and pick up edits)
-		for _, entryID := range entryByID {
-			c.Remove(entryID)
+// Scheduler loads enabled scan schedules from the DB and starts a scan through Runner at each schedule's cron
+// time, applying the schedule's timezone, blackout windows, jitter and overlap policy. When several API
+// instances share the database, only the one holding the scheduler lease runs schedules; the others take
+// over when its lease expires.
+type Scheduler struct {
+	Schedules *repo.ScheduleRepo
+	// Leases elects the instance running schedules. When nil, this process always runs them (single instance).
+	Leases *repo.LeaseRepo
+	Runner Runner
+	// Instance identifies this process in the lease (default hostname-pid).
+	Instance string
+	// LeaseTTL is how long the lease lasts without renewal (default 30s). It is renewed every third of that.
+	LeaseTTL time.Duration
+	// Listener receives change notifications for single schedules (see NewListener) so edits apply at once.
+	// When nil, edits are only picked up by the periodic reconcile.
+	Listener *pq.Listener
+	// ReloadInterval is how often the leader reconciles all schedules with the DB (default 60s).
+	ReloadInterval time.Duration
+
+	// triggerMu serializes runs so the overlap check and the start are atomic. It is held across database
+	// calls, so it is separate from mu, which lease renewal and reloads need promptly.
+	triggerMu sync.Mutex
+
+	mu        sync.Mutex
+	cron      *cron.Cron
+	entries   map[int]cron.EntryID // schedule ID -> cron entry
+	schedules map[int]models.Schedule
+	queued    map[string]bool // site and target with a run waiting for the running scan (overlap policy queue)
+	leading   bool
+	term      int // incremented on every leadership change, to drop delayed runs of a previous term
+	renewedAt time.Time
+}
+
+// New returns a Scheduler running the schedules in schedules through runner, elected through leases (may be nil).
+func New(schedules *repo.ScheduleRepo, leases *repo.LeaseRepo, runner Runner) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{Schedules: schedules, Leases: leases, Runner: runner, Instance: fmt.Sprintf("%s-%d", host, os.Getpid())}
+}
+
+func (s *Scheduler) leaseTTL() time.Duration {
+	if s.LeaseTTL <= 0 {
+		return defaultLeaseTTL
+	}
+	return s.LeaseTTL
+}
+
+// Run campaigns for the scheduler lease and runs schedules while holding it, until ctx is done. The lease is
+// released on return so another instance can take over immediately.
+func (s *Scheduler) Run(ctx context.Context) {
+	reloadInterval := s.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+	renew := time.NewTicker(s.leaseTTL() / 3)
+	defer renew.Stop()
+	reload := time.NewTicker(reloadInterval)
+	defer reload.Stop()
+	var changes <-chan *pq.Notification
+	if s.Listener != nil {
+		changes = s.Listener.NotificationChannel()
+	}
+
+	s.elect(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			s.stepDown()
+			if s.Leases != nil {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := s.Leases.Release(releaseCtx, LeaseName, s.Instance); err != nil {
+					log.Printf("scheduler: release lease: %v", err)
+				}
+				cancel()
+			}
+			return
+		case <-renew.C:
+			s.elect(ctx)
+		case <-reload.C:
+			s.reconcile(ctx)
+		case n := <-changes:
+			if n == nil {
+				// The listener reconnected and may have missed notifications.
+				s.reconcile(ctx)
+				continue
+			}
+			id, err := strconv.Atoi(n.Extra)
+			if err != nil {
+				log.Printf("scheduler: invalid change notification %q", n.Extra)
+				continue
+			}
+			s.apply(ctx, id)
+		}
+	}
+}
+
+func (s *Scheduler) reconcile(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leading {
+		s.sync(ctx)
+	}
+}
+
+// elect acquires or renews the lease and starts or stops running schedules accordingly.
+func (s *Scheduler) elect(ctx context.Context) {
+	if s.Leases == nil {
+		s.mu.Lock()
+		leading := s.leading
+		s.mu.Unlock()
+		if !leading {
+			s.lead(ctx)
+		}
+		return
+	}
+
+	ok, err := s.Leases.Acquire(ctx, LeaseName, s.Instance, s.leaseTTL())
+	s.mu.Lock()
+	leading, renewedAt := s.leading, s.renewedAt
+	s.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Printf("scheduler: acquire lease: %v", err)
+		// Stop before the lease can expire, since another instance may then take it over.
+		if leading && time.Since(renewedAt) > s.leaseTTL()*2/3 {
+			log.Printf("scheduler: could not renew lease, stepping down")
+			s.stepDown()
+		}
+	case ok:
+		s.mu.Lock()
+		s.renewedAt = time.Now()
+		s.mu.Unlock()
+		if !leading {
+			log.Printf("scheduler: instance %s is now the leader", s.Instance)
+			s.lead(ctx)
 		}
-		entryByID = make(map[int]cron.EntryID)
+	case leading:
+		log.Printf("scheduler: instance %s lost the lease, stepping down", s.Instance)
+		s.stepDown()
+	}
+}
+
+func (s *Scheduler) lead(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cron = cron.New()
+	s.entries = make(map[int]cron.EntryID)
+	s.schedules = make(map[int]models.Schedule)
+	s.queued = make(map[string]bool)
+	s.leading = true
+	s.term++
+	s.sync(ctx)
+	s.cron.Start()
+}
 
-		list, err := scheduleRepo.ListEnabled(context.Background())
+func (s *Scheduler) stepDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.leading {
+		return
+	}
+	s.cron.Stop()
+	s.cron = nil
+	s.entries = nil
+	s.schedules = nil
+	s.queued = nil
+	s.leading = false
+	s.term++
+}
+
+// sync reconciles the cron entries with the enabled schedules in the DB, as a fallback for missed change
+// notifications. Unchanged entries are kept so their timing is not disturbed. s.mu must be held.
+func (s *Scheduler) sync(ctx context.Context) {
+	list, err := s.Schedules.ListEnabled(ctx)
+	if err != nil {
+		log.Printf("scheduler: list enabled schedules: %v", err)
+		return
+	}
+
+	enabled := make(map[int]bool, len(list))
+	for _, sc := range list {
+		enabled[sc.ID] = true
+		s.register(sc)
+	}
+	for id := range s.schedules {
+		if !enabled[id] {
+			s.unregister(id)
+		}
+	}
+	log.Printf("scheduler: %d schedules registered", len(s.entries))
+}
+
+// apply updates the cron entry of schedule id after it was created, edited or deleted.
+func (s *Scheduler) apply(ctx context.Context, id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.leading {
+		return
+	}
+	sc, err := s.Schedules.GetByID(ctx, id)
+	if err != nil {
+		log.Printf("scheduler: load schedule id=%d: %v", id, err)
+		return // the next reconcile picks it up
+	}
+	if sc == nil || !sc.Enabled {
+		s.unregister(id)
+		return
+	}
+	s.register(*sc)
+}
+
+// register adds or updates the cron entry of sc. The entry is only replaced when the cron expression or
+// timezone changes; other edits apply from the next run. s.mu must be held.
+func (s *Scheduler) register(sc models.Schedule) {
+	if old, ok := s.schedules[sc.ID]; ok && spec(old) == spec(sc) {
+		s.schedules[sc.ID] = sc
+		return
+	}
+	s.unregister(sc.ID)
+	entryID, err := s.cron.AddFunc(spec(sc), s.fire(sc.ID))
+	if err != nil {
+		log.Printf("scheduler: invalid cron_expr %q for schedule id=%d: %v", sc.CronExpr, sc.ID, err)
+		return
+	}
+	s.entries[sc.ID] = entryID
+	s.schedules[sc.ID] = sc
+}
+
+// unregister removes the cron entry of schedule id, if any. s.mu must be held.
+func (s *Scheduler) unregister(id int) {
+	if entryID, ok := s.entries[id]; ok {
+		s.cron.Remove(entryID)
+		delete(s.entries, id)
+		delete(s.schedules, id)
+	}
+}
+
+// Status returns the current leader and the registered entries in the sites visible in ctx. Entries
+// come from the cron runner on the leader; other instances compute them from the enabled schedules, so the
+// answer is the same whichever instance serves it.
+func (s *Scheduler) Status(ctx context.Context) (*models.SchedulerStatus, error) {
+	st := &models.SchedulerStatus{Instance: s.Instance, Entries: []models.SchedulerEntry{}}
+	if s.Leases != nil {
+		lease, err := s.Leases.Get(ctx, LeaseName)
 		if err != nil {
-			log.Printf("scheduler: list enabled schedules: %v", err)
-			return
+			return nil, err
+		}
+		st.Lease = lease
+	}
+	s.mu.Lock()
+	st.IsLeader = s.leading
+	if s.leading {
+		for id, entryID := range s.entries {
+			sc := s.schedules[id]
+			if !repo.SiteVisible(ctx, sc.SiteID) {
+				continue
+			}
+			e := s.cron.Entry(entryID)
+			st.Entries = append(st.Entries, entry(sc, e.Next, e.Prev))
 		}
+	}
+	s.mu.Unlock()
 
-		for _, s := range list {
-			target := s.Target
-			expr := s.CronExpr
-			entryID, err := c.AddFunc(expr, func() { runScan(target) })
+	if !st.IsLeader {
+		list, err := s.Schedules.ListEnabled(ctx)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, sc := range list {
+			if !repo.SiteVisible(ctx, sc.SiteID) {
+				continue
+			}
+			next, err := NextRun(sc, now)
 			if err != nil {
-				log.Printf("scheduler: invalid cron_expr %q for schedule id=%d: %v", expr, s.ID, err)
 				continue
 			}
-			entryByID[s.ID] = entryID
-			log.Printf("scheduler: added schedule id=%d target=%q cron=%q", s.ID, target, expr)
+			st.Entries = append(st.Entries, entry(sc, next, time.Time{}))
 		}
 	}
+	sort.Slice(st.Entries, func(i, j int) bool { return st.Entries[i].ScheduleID < st.Entries[j].ScheduleID })
+	return st, nil
+}
+
+func entry(sc models.Schedule, next, prev time.Time) models.SchedulerEntry {
+	e := models.SchedulerEntry{ScheduleID: sc.ID, SiteID: sc.SiteID, Target: sc.TargetLabel(), CronExpr: sc.CronExpr}
+	if !next.IsZero() {
+		e.Next = &next
+	}
+	if !prev.IsZero() {
+		e.Prev = &prev
+	}
+	return e
+}
+
+// spec returns the cron spec of s: its 5-field expression, evaluated in its timezone.
+func spec(s models.Schedule) string {
+	if s.Timezone == "" {
+		return s.CronExpr
+	}
+	return "CRON_TZ=" + s.Timezone + " " + s.CronExpr
+}
 
-	// Initial load
-	syncSchedules()
-	c.Start()
+// NextRun returns the first time after after that schedule s is due (before jitter and blackout windows). It
+// fails when the cron expression or timezone is invalid.
+func NextRun(s models.Schedule, after time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(spec(s))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(after), nil
+}
 
-	ticker := time.NewTicker(60 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		syncSchedules()
+// NewListener listens for schedule change notifications (see repo.ScheduleChangesChannel) on a dedicated
+// connection to dsn, reconnecting as needed.
+func NewListener(dsn string) (*pq.Listener, error) {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("scheduler: change listener: %v", err)
+		}
+	})
+	if err := l.Listen(repo.ScheduleChangesChannel); err != nil {
+		l.Close()
+		return nil, err
 	}
+	return l, nil
 }
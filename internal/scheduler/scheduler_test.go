@@ -0,0 +1,377 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/crucial707/hci-asset/internal/models"
+	"github.com/crucial707/hci-asset/internal/repo"
+	"github.com/robfig/cron/v3"
+)
+
+func TestScheduler_Elect(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	s := New(repo.NewScheduleRepo(db), repo.NewLeaseRepo(db), &fakeRunner{})
+	s.Instance = "api-1"
+
+	// Another instance holds the lease: stay a follower.
+	mock.ExpectQuery(`INSERT INTO leases`).WithArgs(LeaseName, "api-1", float64(30)).WillReturnError(sql.ErrNoRows)
+	s.elect(context.Background())
+	if s.leading {
+		t.Fatal("expected follower while the lease is held elsewhere")
+	}
+
+	// Lease expired: take over and load the schedules.
+	mock.ExpectQuery(`INSERT INTO leases`).WithArgs(LeaseName, "api-1", float64(30)).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("api-1"))
+	mock.ExpectQuery(`FROM scan_schedules\s+WHERE enabled = true`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "10.0.0.0/24", "0 * * * *", true, time.Now(), 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "").
+			AddRow(2, "10.0.1.0/24", "not a cron", true, time.Now(), 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.elect(context.Background())
+	if !s.leading {
+		t.Fatal("expected to lead after acquiring the lease")
+	}
+	if len(s.entries) != 1 {
+		t.Errorf("expected 1 registered entry (invalid cron skipped), got %d", len(s.entries))
+	}
+
+	// Lease taken over by another instance: step down.
+	mock.ExpectQuery(`INSERT INTO leases`).WithArgs(LeaseName, "api-1", float64(30)).WillReturnError(sql.ErrNoRows)
+	s.elect(context.Background())
+	if s.leading || s.entries != nil {
+		t.Error("expected to step down after losing the lease")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduler_Status_Follower(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT name, holder, acquired_at, renewed_at, expires_at FROM leases`).
+		WithArgs(LeaseName).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "holder", "acquired_at", "renewed_at", "expires_at"}).
+			AddRow(LeaseName, "api-2", now.Add(-time.Hour), now, now.Add(30*time.Second)))
+	mock.ExpectQuery(`FROM scan_schedules\s+WHERE enabled = true`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"}).
+			AddRow(1, "10.0.0.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "").
+			AddRow(2, "10.0.1.0/24", "*/5 * * * *", true, now, 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+
+	s := New(repo.NewScheduleRepo(db), repo.NewLeaseRepo(db), &fakeRunner{})
+	s.Instance = "api-1"
+	st, err := s.Status(repo.WithSite(context.Background(), 2))
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if st.IsLeader || st.Lease == nil || st.Lease.Holder != "api-2" {
+		t.Errorf("unexpected leader: is_leader=%v lease=%+v", st.IsLeader, st.Lease)
+	}
+	if len(st.Entries) != 1 || st.Entries[0].ScheduleID != 2 || st.Entries[0].Next == nil {
+		t.Errorf("expected the site 2 schedule with its next run, got %+v", st.Entries)
+	}
+}
+
+// fakeRunner records the scans started and canceled by a Scheduler; they run until finished or canceled.
+type fakeRunner struct {
+	mu       sync.Mutex
+	started  []models.Schedule
+	canceled []string
+	targets  map[string]string // job ID -> target
+	done     map[string]chan struct{}
+}
+
+func (f *fakeRunner) ScheduleTarget(ctx context.Context, s models.Schedule) ([]string, error) {
+	return []string{s.Target}, nil
+}
+
+func (f *fakeRunner) StartScheduledScan(ctx context.Context, s models.Schedule, targets []string, resolveErr error) (string, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, s)
+	jobID := strconv.Itoa(len(f.started))
+	if f.done == nil {
+		f.done = make(map[string]chan struct{})
+		f.targets = make(map[string]string)
+	}
+	f.done[jobID] = make(chan struct{})
+	f.targets[jobID] = strings.Join(targets, " ")
+	return jobID, f.done[jobID]
+}
+
+func (f *fakeRunner) RunningScan(ctx context.Context, target string) (string, <-chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for jobID, done := range f.done {
+		select {
+		case <-done:
+		default:
+			if f.targets[jobID] == target {
+				return jobID, done, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func (f *fakeRunner) CancelJob(jobID string) bool {
+	f.mu.Lock()
+	f.canceled = append(f.canceled, jobID)
+	f.mu.Unlock()
+	f.finish(jobID)
+	return true
+}
+
+func (f *fakeRunner) finish(jobID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.done[jobID]:
+	default:
+		close(f.done[jobID])
+	}
+}
+
+func (f *fakeRunner) startedCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.started)
+}
+
+func scheduleRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "target", "cron_expr", "enabled", "created_at", "site_id", "last_run_at", "last_status", "consecutive_failures", "timezone", "blackout_windows", "blackout_action", "jitter_seconds", "overlap_policy", "saved_scan_id", "asset_tag"})
+}
+
+func TestScheduler_ApplyIncremental(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	s := New(repo.NewScheduleRepo(db), nil, &fakeRunner{})
+	mock.ExpectQuery(`WHERE enabled = true`).
+		WillReturnRows(scheduleRows().
+			AddRow(1, "10.0.0.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, "").
+			AddRow(2, "10.0.1.0/24", "*/5 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.elect(context.Background())
+	defer s.stepDown()
+	entry1 := s.entries[1]
+
+	// New target, same cron: the entry is kept and runs the new target.
+	mock.ExpectQuery(`FROM scan_schedules`).WithArgs(1).
+		WillReturnRows(scheduleRows().AddRow(1, "10.0.9.0/24", "0 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.apply(context.Background(), 1)
+	if s.entries[1] != entry1 || s.schedules[1].Target != "10.0.9.0/24" {
+		t.Errorf("expected entry %d kept with new target, got entry %d target %q", entry1, s.entries[1], s.schedules[1].Target)
+	}
+
+	// New cron: the entry is replaced.
+	mock.ExpectQuery(`FROM scan_schedules`).WithArgs(1).
+		WillReturnRows(scheduleRows().AddRow(1, "10.0.9.0/24", "30 * * * *", true, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.apply(context.Background(), 1)
+	if s.entries[1] == entry1 {
+		t.Error("expected a new entry after the cron expression changed")
+	}
+
+	// Disabled and deleted schedules are removed; new ones are added.
+	mock.ExpectQuery(`FROM scan_schedules`).WithArgs(2).
+		WillReturnRows(scheduleRows().AddRow(2, "10.0.1.0/24", "*/5 * * * *", false, now, 1, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.apply(context.Background(), 2)
+	mock.ExpectQuery(`FROM scan_schedules`).WithArgs(3).
+		WillReturnRows(scheduleRows().AddRow(3, "10.0.2.0/24", "0 0 * * *", true, now, 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.apply(context.Background(), 3)
+	mock.ExpectQuery(`FROM scan_schedules`).WithArgs(4).WillReturnRows(scheduleRows())
+	s.apply(context.Background(), 4)
+	if _, ok := s.entries[2]; ok {
+		t.Error("expected disabled schedule 2 removed")
+	}
+	if _, ok := s.entries[3]; !ok || len(s.entries) != 2 {
+		t.Errorf("expected schedules 1 and 3 registered, got %v", s.entries)
+	}
+
+	// The periodic reconcile keeps unchanged entries.
+	entry3 := s.entries[3]
+	mock.ExpectQuery(`WHERE enabled = true`).
+		WillReturnRows(scheduleRows().AddRow(3, "10.0.2.0/24", "0 0 * * *", true, now, 2, nil, "", 0, "", []byte("[]"), "skip", 0, "skip", nil, ""))
+	s.reconcile(context.Background())
+	if s.entries[3] != entry3 || len(s.entries) != 1 {
+		t.Errorf("expected only schedule 3 with its entry kept, got %v", s.entries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// leadWith makes s lead with the given schedules, without a database round trip or cron entries.
+func leadWith(s *Scheduler, schedules ...models.Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leading = true
+	s.term++
+	s.cron = cron.New()
+	s.entries = make(map[int]cron.EntryID)
+	s.schedules = make(map[int]models.Schedule)
+	s.queued = make(map[string]bool)
+	for _, sc := range schedules {
+		s.schedules[sc.ID] = sc
+	}
+}
+
+func TestScheduler_TriggerOverlap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	runner := &fakeRunner{}
+	s := New(repo.NewScheduleRepo(db), nil, runner)
+	leadWith(s,
+		models.Schedule{ID: 1, Target: "10.0.1.0/24", SiteID: 1, OverlapPolicy: models.OverlapSkip},
+		models.Schedule{ID: 2, Target: "10.0.2.0/24", SiteID: 1, OverlapPolicy: models.OverlapCancelPrevious},
+		models.Schedule{ID: 3, Target: "10.0.3.0/24", SiteID: 1, OverlapPolicy: models.OverlapQueue},
+		models.Schedule{ID: 4, Target: "10.0.1.0/24", SiteID: 1, OverlapPolicy: models.OverlapSkip},
+	)
+
+	// skip: the second run is recorded as skipped while the first is running, also for another schedule of
+	// the same target.
+	mock.ExpectExec(`INSERT INTO scan_jobs`).
+		WithArgs("10.0.1.0/24", "scan 1 of the same target still running", 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO scan_jobs`).
+		WithArgs("10.0.1.0/24", "scan 1 of the same target still running", 4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.trigger(1, s.term)
+	s.trigger(1, s.term)
+	s.trigger(4, s.term)
+	if len(runner.started) != 1 {
+		t.Fatalf("skip: expected 1 scan started, got %d", len(runner.started))
+	}
+	// Once it ends, the next run starts.
+	runner.finish("1")
+	s.trigger(1, s.term)
+	if len(runner.started) != 2 {
+		t.Fatalf("skip: expected a new scan after the previous one ended, got %d", len(runner.started))
+	}
+
+	// cancel_previous: the running scan is canceled and a new one starts once it has ended.
+	s.trigger(2, s.term)
+	s.trigger(2, s.term)
+	if len(runner.started) != 4 || len(runner.canceled) != 1 || runner.canceled[0] != "3" {
+		t.Fatalf("cancel_previous: started %d, canceled %v", len(runner.started), runner.canceled)
+	}
+
+	// queue: one run waits for the running one; another is skipped.
+	mock.ExpectExec(`INSERT INTO scan_jobs`).
+		WithArgs("10.0.3.0/24", "scan 5 of the same target still running and another run already queued", 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.trigger(3, s.term)
+	s.trigger(3, s.term)
+	s.trigger(3, s.term)
+	if runner.startedCount() != 5 {
+		t.Fatalf("queue: expected the second run to wait, got %d scans", runner.startedCount())
+	}
+	runner.finish("5")
+	deadline := time.Now().Add(time.Second)
+	for runner.startedCount() != 6 {
+		if time.Now().After(deadline) {
+			t.Fatal("queue: queued run did not start after the previous one ended")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Runs of a previous leadership term are dropped.
+	term := s.term
+	s.stepDown()
+	s.trigger(1, term)
+	if runner.startedCount() != 6 {
+		t.Error("expected no scan after stepping down")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduler_TriggerBlackout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	// A two-hour window around the current time in the schedule's zone (wrapping past midnight if needed).
+	now := time.Now().In(loc)
+	window := models.BlackoutWindow{Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04")}
+
+	runner := &fakeRunner{}
+	s := New(repo.NewScheduleRepo(db), nil, runner)
+	leadWith(s,
+		models.Schedule{ID: 1, Target: "10.0.1.0/24", SiteID: 1, Timezone: "Asia/Tokyo", BlackoutWindows: []models.BlackoutWindow{window}, BlackoutAction: models.BlackoutSkip},
+		models.Schedule{ID: 2, Target: "10.0.2.0/24", SiteID: 1, Timezone: "Asia/Tokyo", BlackoutWindows: []models.BlackoutWindow{window}, BlackoutAction: models.BlackoutDefer},
+		models.Schedule{ID: 3, Target: "10.0.3.0/24", SiteID: 1, Timezone: "Asia/Tokyo", BlackoutWindows: []models.BlackoutWindow{{
+			Days: []string{"mon"}, Start: window.Start, End: window.End,
+		}}},
+	)
+
+	mock.ExpectExec(`INSERT INTO scan_jobs`).
+		WithArgs("10.0.1.0/24", "blackout window "+window.String(), 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.trigger(1, s.term)
+	s.trigger(2, s.term) // deferred to the end of the window: nothing recorded yet
+	if len(runner.started) != 0 {
+		t.Errorf("expected no scan inside the blackout window, got %d", len(runner.started))
+	}
+
+	// A window limited to another day does not apply (the window started today or yesterday).
+	startDay := now
+	if window.End < window.Start && now.Format("15:04") < window.End {
+		startDay = now.AddDate(0, 0, -1)
+	}
+	if startDay.Weekday() != time.Monday {
+		s.trigger(3, s.term)
+		if len(runner.started) != 1 {
+			t.Errorf("expected a scan outside the window's days, got %d", len(runner.started))
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestNextRun_Timezone(t *testing.T) {
+	after := time.Date(2026, 3, 16, 12, 30, 0, 0, time.UTC)
+	next, err := NextRun(models.Schedule{CronExpr: "0 9 * * *", Timezone: "America/New_York"}, after)
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	// 09:00 in New York (EDT, UTC-4) is 13:00 UTC.
+	if want := time.Date(2026, 3, 16, 13, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("NextRun: got %v, want %v", next.UTC(), want)
+	}
+	if _, err := NextRun(models.Schedule{CronExpr: "0 9 * * *", Timezone: "Not/AZone"}, after); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+}